@@ -67,19 +67,23 @@ to the request.  It is added to the password portion of the HTTP Basic request,
 also to the string which is used to create the signature.  Nonce values must be changed
 on every request, or the request will fail.
 
+The current UNIX timestamp must also be added to the request and to the signature.  Requests
+whose timestamp differs from the server's clock by more than NonceWindow seconds are rejected,
+so a request cannot be replayed once its nonce is forgotten.
+
 The current pseudocode format of the HMAC-SHA1 signature is as follows:
 
-	signString = UserID-Nonce-HTTPMethod-HTTPResource
-	ex: 1-0123abc-GET-/api/status
+	signString = UserID-Nonce-Timestamp-HTTPMethod-HTTPResource
+	ex: 1-0123abc-1400000000-GET-/api/status
 
 	signature = hmac_sha1(signString, apiSecret)
 
 The proper format for a HTTP Basic request is as follows:
 
-	Authorization: Basic base64(pubkey:nonce/signature)
-	ex: Authorization: Basic base64(abcdef0123456789:0123abc/0123abcd4567ef89)
+	Authorization: Basic base64(pubkey:nonce/timestamp/signature)
+	ex: Authorization: Basic base64(abcdef0123456789:0123abc/1400000000/0123abcd4567ef89)
 
-When the public key, nonce, and API signature are sent via HTTP Basic, the server will
+When the public key, nonce, timestamp, and API signature are sent via HTTP Basic, the server will
 verify the signature.  Successful authentication will allow access to the API.
 
 API Calls
@@ -101,31 +105,108 @@ Request an API public key and secret key for this user.  The public key, user ID
 and secret key are used to authenticate further API calls.  The expire time indicates
 when this key is set to expire.  Further API calls will extend the expiration time.
 
-	GET /api/files
+	POST /api/key
+
+	$ curl -X POST --user username:password http://localhost:8080/api/key
+	{
+		"userId": 1,
+		"pubkey": "abcdef0123456789",
+		"secret": "0123456789abcdef",
+		"expire": 1389737644
+	}
+
+Generate an additional API key for this user, so that keys may be rotated.  The secret key
+is only returned in this response, and cannot be retrieved again.
+
+	DELETE /api/key/{pubkey}
 
-	$ curl --user pubkey:nonce/signature http://localhost:8080/api/files
+	$ curl -X DELETE --user username:password http://localhost:8080/api/key/abcdef0123456789
+
+Revoke one of this user's API keys, so it may no longer be used to authenticate API calls.
+
+	PUT /api/user/password
+
+	$ curl -X PUT --user username:password -d '{"oldPassword":"password","newPassword":"newpassword","revokeKeys":true}' http://localhost:8080/api/user/password
+
+Change this user's password.  The old password must match the current password, and the new
+password must meet the configured minimum length.  If revokeKeys is set, all of this user's
+API keys are revoked, and a new key must be requested using the new password.
+
+	GET /api/admin/config
+
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/admin/config
+	{
+		"Port": 8080,
+		"Passkey": true,
+		...
+		"DB": {
+			"Host": "localhost:3306",
+			"Database": "goat",
+			"Username": "goat",
+			"Password": "[redacted]"
+		},
+		...
+	}
+
+Retrieve the configuration goat is currently running with.  Sensitive values, such as
+passwords and the credentials in a database DSN, are redacted.  This call may only be made
+by an administrator.
+
+	POST /api/admin/ban
+
+	$ curl -X POST --user pubkey:nonce/timestamp/signature -d '{"id":1,"banned":true}' http://localhost:8080/api/admin/ban
+
+Disable or re-enable a user's account.  Disabled users' announces and API calls are
+rejected with the reason "Account disabled".  This call may only be made by an administrator.
+
+	POST /api/admin/maintenance
+
+	$ curl -X POST --user pubkey:nonce/timestamp/signature -d '{"enabled":true,"message":"Upgrading"}' http://localhost:8080/api/admin/maintenance
+
+Enable or disable maintenance mode, with an optional status message.  During maintenance,
+announces receive no peers, an extended interval, and over HTTP, a warning message containing
+the status message, and nothing is written to the database.  Maintenance mode may also be
+toggled by sending goat a SIGUSR1 signal.  This call may only be made by an administrator.
+
+	GET /api/files?limit=50&offset=0&sort=completed
+
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/files?limit=50
 	[
 		{
 			"id": 1,
 			"infoHash": "abcdef0123456789",
 			"verified": true,
 			"createTime": 1389737644,
-			"updateTime": 1389737644
+			"updateTime": 1389737644,
+			"announceInterval": 0,
+			"peerLimit": 0,
+			"seeders": 10,
+			"leechers": 5,
+			"completed": 42
 		}
 	]
 
-Retrieve a list of all files tracked by goat.  Some extended attributes are not added
-to reduce strain on database, and to provide a more general overview.
+Retrieve a page of files tracked by goat, with their live seeder, leecher, and completion
+counts.  Up to limit files are returned (50 by default, and at most 500), skipping the first
+offset files.  The total number of files is reported in the X-Total-Count header.  Files are
+ordered by ID, unless sort is set to create_time, update_time, or completed, which order files
+from newest or most completed first.  Any other sort field returns HTTP 400.
+
+Each file may override the global announce interval with its own announceInterval, and cap
+the number of peers returned per announce with peerLimit.  These are set directly in the
+files table, and a value of 0 uses the global configuration.
 
 	GET /api/files/:id
 
-	$ curl --user pubkey:nonce/signature http://localhost:8080/api/files/1
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/files/1
 	{
 		"id": 1,
 		"infoHash": "abcdef0123456789",
 		"verified": true,
 		"createTime": 1389737644,
 		"updateTime": 1389737644,
+		"announceInterval": 0,
+		"peerLimit": 0,
 		"completed": 0,
 		"seeders": 0,
 		"leechers": 0,
@@ -140,18 +221,118 @@ to reduce strain on database, and to provide a more general overview.
 				"uploaded": 0,
 				"downloaded": 0,
 				"left": 0,
-				"time": 1389983002
+				"time": 1389983002,
+				"lastEvent": "completed",
+				"lastEventTime": 1389982402
 			}
 		]
 	}
 
 Retrieve extended attributes about a specific file with matching ID.  This provides
 counts for number of completions, seeders, leechers, and a list of fileUser relationships
-associated with a given file.
+associated with a given file.  Each fileUser relationship includes the last event reported
+by that peer (started, completed, stopped, or update), and the time it was reported.
+
+	GET /api/file/:info_hash
+
+	$ curl --user username:password http://localhost:8080/api/file/6465616462656566303030303030303030303030
+	{
+		"id": 1,
+		"infoHash": "6465616462656566303030303030303030303030",
+		"verified": true,
+		"createTime": 1389737644,
+		"updateTime": 1389737644,
+		"announceInterval": 0,
+		"peerLimit": 0,
+		"seeders": 10,
+		"leechers": 5,
+		"completed": 42
+	}
+
+Retrieve a file with matching info_hash, along with its live seeder, leecher, and completion
+counts, for use by web frontends.  This call accepts either HMAC or HTTP Basic authentication.
+An unknown info_hash returns HTTP 404, with a JSON error body.
+
+	GET /api/files/:info_hash/stats
+
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/files/6465616462656566303030303030303030303030/stats
+	{
+		"infoHash": "6465616462656566303030303030303030303030",
+		"seeders": 10,
+		"leechers": 5,
+		"snatches": 42,
+		"activePeers": 15,
+		"announceRate": 2.4,
+		"cached": true
+	}
+
+Retrieve the swarm statistics of a file with matching info_hash, in a single call.  Snatches
+is the number of distinct users who have completed the file, and announce rate is the average
+number of announces per minute over the last five minutes.  Cached indicates whether counts
+were served from the scrape stats cache, or loaded live because no cached counts were available.
+
+	GET /api/files/:info_hash/announces?page=1
+
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/files/6465616462656566303030303030303030303030/announces
+	[
+		{
+			"id": 1024,
+			"peerId": "2d5452323832302d6162636465666768696a6b6c",
+			"ip": "[redacted]",
+			"port": 6881,
+			"udp": false,
+			"uploaded": 0,
+			"downloaded": 1048576,
+			"left": 5242880,
+			"event": "",
+			"client": "Transmission/2.82",
+			"time": 1400000000
+		}
+	]
+
+Retrieve the most recent announces on a file with matching info_hash, newest first, 50 per
+page.  Pages are numbered from 1, and the first page is returned if none is specified.  Peer
+IP addresses are redacted if configured.  This call may only be made by an administrator.
+
+	POST /api/files/:info_hash/peers
+
+	$ curl -X POST --user pubkey:nonce/timestamp/signature -d '{"ip":"10.0.0.1","port":6881,"seeder":true}' http://localhost:8080/api/files/6465616462656566303030303030303030303030/peers
+
+Inject a synthetic peer into the swarm of a file with matching info_hash, as either a seeder
+or a leecher.  The peer is recorded as if it announced on behalf of the calling user, so it
+is returned in peer lists until it expires, which is useful for smoke-testing clients.  Only
+IPv4 peers are supported.  This call may only be made by an administrator.
+
+	POST /api/passkeys
+
+	$ curl -X POST --user pubkey:nonce/timestamp/signature http://localhost:8080/api/passkeys
+
+Issue an additional passkey to the calling user, so the user may announce from several devices
+using separate passkeys.  Announces made using any of a user's passkeys are attributed to that
+user.  Up to 10 additional passkeys may be issued to each user.
+
+	GET /api/passkeys
+
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/passkeys
+	[
+		{
+			"id": 1,
+			"passkey": "0123456789abcdef0123456789abcdef01234567",
+			"createTime": 1400000000
+		}
+	]
+
+Retrieve the additional passkeys issued to the calling user.
+
+	POST /api/passkeys/revoke
+
+	$ curl -X POST --user pubkey:nonce/timestamp/signature -d '{"passkey":"0123456789abcdef0123456789abcdef01234567"}' http://localhost:8080/api/passkeys/revoke
+
+Revoke one of the calling user's additional passkeys, without affecting the user's other passkeys.
 
 	GET /api/status
 
-	$ curl --user pubkey:nonce/signature http://localhost:8080/api/status
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/status
 	{
 		"pid": 27796,
 		"hostname": "goat",
@@ -187,16 +368,31 @@ hostname, memory usage, number of HTTP/UDP hits, etc.
 
 	POST /api/users
 
-	$ curl -X POST --user pubkey:nonce/signature \
-		-d '{"username": "test", "password": "test", "torrentLimit": 10}' \
+	$ curl -X POST --user pubkey:nonce/timestamp/signature \
+		-d '{"username": "test", "password": "password", "torrentLimit": 10}' \
 		http://localhost:8080/api/users
 	HTTP/1.1 204 No Content
 
-Create a user with the specified username, password, and torrent limit.
+Create a user with the specified username, password, and torrent limit.  This call may only be
+made by an administrator.
+
+	POST /api/user
+
+	$ curl -X POST -d '{"username": "test", "password": "password"}' http://localhost:8080/api/user
+	HTTP/1.1 201 Created
+	{
+		"id": 1,
+		"passkey": "0123456789abcdef0123456789abcdef01234567"
+	}
+
+Register a user with the specified username and password, returning the new user's ID and
+passkey.  No authentication is required, but registration must be enabled in configuration.
+Registered users receive the configured torrent limit.  A username which is already taken
+results in HTTP 409.
 
 	GET /api/users
 
-	$ curl --user pubkey:nonce/signature http://localhost:8080/api/users
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/users
 	[
 		{
 			"id": 1,
@@ -210,7 +406,7 @@ and username.
 
 	GET /api/users/:id
 
-	$ curl --user pubkey:nonce/signature http://localhost:8080/api/users
+	$ curl --user pubkey:nonce/timestamp/signature http://localhost:8080/api/users
 	{
 		"id": 1,
 		"torrentLimit": 10,
@@ -223,25 +419,98 @@ limit, and username.
 Configuration
 
 goat is configured using a JSON file, which will be created under
-'~/.config/goat/config.json' on UNIX systems.  Here is an example configuration,
-describing the settings available to the user.
+'~/.config/goat/config.json' on UNIX systems.  Settings omitted from the file use the
+defaults shown below, and unknown settings are logged and ignored.
+
+Any setting may be overridden using an environment variable, named GOAT_ followed by the
+setting's name in upper case, with sections separated by underscores.  For example,
+GOAT_PORT=9090 overrides Port, and GOAT_DB_HOST overrides the Host setting of DB.
+
+Here is an example configuration, describing the settings available to the user.
 
 	{
 		// Port: the port number on which goat will listen using both HTTP and UDP
 		"Port": 8080,
 
+		// Listen: address on which the HTTP listener (tracker and API) will listen, instead of Port
+		// note: supports Unix domain sockets for use behind a local proxy, and TCP addresses
+		// ex: unix:///var/run/goat.sock, tcp://127.0.0.1:8080
+		"Listen": "",
+
 		// Passkey: require that a valid passkey is present in HTTP tracker requests
 		// note: this setting is typically used only for private trackers
-		// ex: http://localhost:8080/0123456789ABCDEF/announce
+		// ex: http://localhost:8080/0123456789ABCDEF/announce, http://localhost:8080/announce/0123456789ABCDEF
 		"Passkey": true,
 
 		// Whitelist: require clients to be whitelisted for use with the tracker
 		// note: this setting is typically used only for private trackers
 		"Whitelist": true,
 
+		// StrictInfoHash: reject requests with a malformed percent-encoding in info_hash,
+		// rather than keeping the malformed characters literally
+		// note: info_hash is always decoded byte for byte, so binary values are preserved
+		"StrictInfoHash": true,
+
+		// StrictEvent: reject announces with an unknown event, rather than treating them as
+		// periodic announces
+		// note: events are always matched regardless of case, so "Started" is accepted
+		"StrictEvent": true,
+
+		// StrictPort: reject announces with a privileged port, below 1024, in addition to port 0
+		// and ports above 65535, which are always rejected
+		// note: stopping clients are not checked, as their port is not added to the peer list
+		"StrictPort": false,
+
+		// RequireStarted: reject announces from peers which are not yet in the swarm, unless they
+		// report a started event.  If false, a first periodic announce with no event, such as from a
+		// client which lost its state during a restart, is treated as an implicit start.
+		"RequireStarted": false,
+
+		// MinClientVersions: comma-separated list of minimum versions for clients identified by
+		// their peer_id, such as "TR=2.8.4,UT=3.4", so announces from older clients are rejected.
+		// Versions are compared component-wise against the version digits in the peer_id.
+		// note: clients which cannot be identified, or have no minimum, are always allowed
+		"MinClientVersions": "",
+
+		// IPPolicy: policy for trusting the "ip" and "ipv6" parameters supplied by clients, in place
+		// of the address from which they connected
+		//   - "reject-all": never trust client-supplied addresses
+		//   - "allow-public-only": trust only publicly routable addresses, forbidding private ranges
+		//   - "allow-all": trust all valid addresses, allowing local swarms on private ranges
+		// note: unspecified and multicast addresses are never trusted, under any policy
+		"IPPolicy": "allow-all",
+
+		// TrustProxy: take each client's address from the X-Forwarded-For header, or X-Real-IP if
+		// it is not present, rather than the address from which they connected.  Only enable when
+		// goat is behind a reverse proxy which sets these headers, as otherwise clients may spoof them.
+		"TrustProxy": false,
+
+		// ServeRobots: serve a disallow-all robots.txt and an empty favicon.ico, so browsers and
+		// crawlers do not generate tracker errors
+		"ServeRobots": true,
+
 		// Interval: number of seconds clients should wait between announces
 		"Interval": 3600,
 
+		// IntervalJitter: maximum number of seconds by which each peer's announce interval is
+		// offset from Interval, to avoid announce stampedes.  Each peer receives a consistent
+		// offset, derived from its peer_id, so its own interval is stable across announces.
+		// Peer lists and peer timeouts are widened by this amount, so late peers are not dropped.
+		// note: a value of 0 disables jitter
+		"IntervalJitter": 0,
+
+		// JitterPercent: maximum percentage of Interval which is randomly added to the interval in
+		// each announce response, so clients which start together drift apart.  Unlike IntervalJitter,
+		// a new amount is drawn for every response.  Peer lists and peer timeouts are widened by
+		// this amount.
+		// note: a value of 0 disables random jitter
+		"JitterPercent": 0,
+
+		// MinInterval: minimum number of seconds clients must wait between announces, which is
+		// reported to clients along with Interval
+		// note: a value of 0 uses half of the announce interval
+		"MinInterval": 0,
+
 		// HTTP: enable listening for client connections via HTTP
 		"HTTP": true,
 
@@ -249,6 +518,15 @@ describing the settings available to the user.
 		// note: only enabled when HTTP/HTTPS is enabled
 		"API": true,
 
+		// NonceWindow: number of seconds by which an API request's timestamp may differ from the
+		// current time.  Nonces used to authenticate API requests are remembered, and may not be
+		// reused, for twice this period.
+		"NonceWindow": 900,
+
+		// APIKeyExpiry: number of seconds after which an API key expires, if it is not used.  Each
+		// use of an API key extends its expiration by this period.
+		"APIKeyExpiry": 604800,
+
 		// UDP: enable listening for client connections via UDP
 		// note: it is not possible to use a passkey with this listener, so this
 		// listener should only be used for public trackers
@@ -256,7 +534,8 @@ describing the settings available to the user.
 
 		// SSL: HTTPS configuration
 		"SSL": {
-			// Enabled: enable listening for client connections via HTTPS
+			// Enabled: enable listening for client connections via HTTPS, in addition to HTTP if
+			// it is enabled.  Clients must use TLS 1.2 or newer.
 			"Enabled": false,
 
 			// Port: the port number on which goat will listen using HTTPS
@@ -281,7 +560,294 @@ describing the settings available to the user.
 			"Username": "goat",
 
 			// Password: the password used to access goat's database
-			"Password": "goat"
+			"Password": "goat",
+
+			// DSN: a complete MySQL DSN, which overrides Host, Database, Username, and Password
+			// for advanced setups such as Unix sockets or TLS connections
+			"DSN": "",
+
+			// Retries: number of times to retry saving a record which failed due to a deadlock
+			// or lock wait timeout, with a small, increasing delay between attempts
+			"Retries": 3,
+
+			// MaxOpenConns: maximum number of open connections in the shared MySQL connection
+			// pool, where 0 is unlimited
+			"MaxOpenConns": 32,
+
+			// MaxIdleConns: maximum number of idle connections kept open in the shared MySQL
+			// connection pool, for reuse by later queries
+			"MaxIdleConns": 8,
+
+			// QueryTimeout: number of seconds a single MySQL query may run before it is cancelled
+			// and its connection released, where 0 disables the timeout.  Schema migrations are not
+			// bounded by this timeout, and announce log pruning deletes in batches which each are.
+			"QueryTimeout": 10
+		},
+
+		// Redis: Redis swarm state configuration
+		// note: when enabled, announces are recorded in Redis and peer lists are read from it,
+		// while durable records are still stored in the database.  Peers are kept in sorted sets
+		// keyed by info_hash, and expire after 1.5 announce intervals without an announce, and
+		// are then excluded from seeder and leecher counts and peer lists.
+		// If Redis state is lost, it is rebuilt as peers announce again.
+		"Redis": {
+			// Enabled: store active peers in Redis, to share low-latency swarm state between instances
+			"Enabled": false,
+
+			// Host: the host and port of the Redis server
+			"Host": "localhost:6379",
+
+			// Password: the password used to authenticate with Redis, if any
+			"Password": "",
+
+			// MaxActive: maximum number of open connections in the shared Redis connection
+			// pool, where 0 is unlimited
+			"MaxActive": 32,
+
+			// MaxIdle: maximum number of idle connections kept open in the shared Redis
+			// connection pool, for reuse by later announces
+			"MaxIdle": 8
+		},
+
+		// Announce: announce response configuration
+		"Announce": {
+			// DictGzipThreshold: size in bytes above which non-compact (dictionary) announce
+			// responses are compressed using gzip, for clients which accept it
+			// note: compact responses are never compressed; a value of 0 disables compression
+			"DictGzipThreshold": 4096,
+
+			// TrackerID: issue a tracker id in HTTP announce responses, which clients echo back in
+			// later announces as the trackerid parameter
+			//   - "": no tracker id is issued (default)
+			//   - "tracker": one tracker id is issued for all files
+			//   - "file": a different tracker id is issued for each file
+			// note: tracker ids are regenerated when goat restarts
+			"TrackerID": "",
+
+			// LowRatioWarning: send a warning message in HTTP announce responses to users whose share
+			// ratio is below this value, once they have downloaded any data
+			// note: a value of 0 disables the warning
+			"LowRatioWarning": 0
+		},
+
+		// AnnounceLog: announce log batching configuration
+		// note: when batching is enabled, announces are buffered in memory and saved using a single
+		// multi-row INSERT.  Any pending announces are saved on graceful shutdown.
+		"AnnounceLog": {
+			// BatchSize: number of announces buffered before they are saved, where 0 saves each
+			// announce as it arrives
+			"BatchSize": 0,
+
+			// FlushInterval: maximum number of milliseconds an announce is buffered before it is saved
+			"FlushInterval": 1000
+		},
+
+		// Maintenance: maintenance mode configuration
+		// note: maintenance mode is toggled using SIGUSR1, or the /api/admin/maintenance API call.
+		// While enabled, announces receive no peers and a warning message, and nothing is written
+		// to the database.
+		"Maintenance": {
+			// Interval: number of seconds clients should wait between announces during maintenance
+			// note: if unset, twice the announce interval is used
+			"Interval": 7200
+		},
+
+		// Reaper: periodic cleanup of peers which have stopped announcing
+		"Reaper": {
+			// Interval: number of seconds between cleanups
+			// note: if unset, the announce interval is used
+			"Interval": 0,
+
+			// TimeoutMultiplier: peers which have not announced within this multiple of the
+			// announce interval are marked inactive, and no longer appear in peer lists
+			"TimeoutMultiplier": 1.5,
+
+			// AnnounceLogRetention: number of seconds announce log entries are kept before they
+			// are deleted, where 0 keeps them forever
+			"AnnounceLogRetention": 604800
+		},
+
+		// Metrics: Prometheus metrics, served at /metrics on a separate listener
+		"Metrics": {
+			// Enabled: whether or not the metrics listener is started
+			"Enabled": false,
+
+			// Listen: the address of the metrics listener
+			"Listen": "localhost:9100"
+		},
+
+		// Health: health and readiness checks, served at /health and /ready
+		"Health": {
+			// CacheInterval: number of seconds the result of a database check is reused by
+			// health checks, so frequent probes do not overload the database.  0 checks the
+			// database on every probe.
+			"CacheInterval": 5
+		},
+
+		// Shutdown: graceful shutdown configuration
+		"Shutdown": {
+			// GracePeriod: number of seconds each listener waits for in-flight HTTP requests and
+			// UDP packets to complete on shutdown, before closing any which remain
+			"GracePeriod": 5
+		},
+
+		// RateLimit: API rate limiting, using a token bucket for each API key, or each user for calls
+		// authenticated by password.  Unauthenticated calls and failed authentication are instead
+		// charged to a token bucket for each client IP address, which is checked before authentication.
+		// note: clients which exceed the limit receive HTTP 429, with a Retry-After header
+		"RateLimit": {
+			// Enabled: whether or not API calls are rate limited
+			"Enabled": false,
+
+			// Rate: number of API calls per second each client may sustain
+			"Rate": 5,
+
+			// Burst: number of API calls each client may make at once, before being limited to Rate
+			"Burst": 20
+		},
+
+		// StatCheck: detection of clients reporting impossible statistics, by comparing the
+		// increase in uploaded and downloaded bytes against the time since their last announce
+		"StatCheck": {
+			// Enabled: whether or not to check statistics reported on each announce.  Offenders
+			// are flagged in the log.
+			"Enabled": false,
+
+			// MaxRate: maximum plausible transfer rate, in bytes per second
+			"MaxRate": 104857600,
+
+			// Ban: also disable the accounts of offenders, rejecting their announces
+			"Ban": false
+		},
+
+		// PeerList: peer list selection configuration
+		"PeerList": {
+			// Seeded: select peers using a PRNG seeded by info_hash and the current time
+			// window, so that the same swarm state yields the same peer list within a window
+			"Seeded": false,
+
+			// SeedWindow: number of seconds for which a seeded peer selection is stable
+			// note: if unset, the announce interval is used
+			"SeedWindow": 0,
+
+			// Rotate: rotate through the swarm for each peer, so that consecutive announces
+			// from the same peer return different peers, letting it learn most of a large swarm
+			// note: takes precedence over Seeded
+			"Rotate": false,
+
+			// SeederRatio: ratio of seeders to include in a peer list returned to a leecher,
+			// with the remainder filled by leechers for swarm connectivity
+			// note: a value of 0 disables seeder preference
+			"SeederRatio": 0.8,
+
+			// StatOnlySeeders: return only counts, with no peer list, to seeders which
+			// request no peers (left=0, numwant=0, and no event or a started event)
+			"StatOnlySeeders": true,
+
+			// Stream: build peer lists as rows are read from the database, rather than loading
+			// all peers first, bounding memory used on very large swarms
+			// note: only applies when no selection strategy above is in use
+			"Stream": false,
+
+			// MaxNumWant: maximum number of peers returned to a client, regardless of numwant
+			// note: a value of 0 disables the cap
+			"MaxNumWant": 200
+		},
+
+		// Users: user account configuration
+		"Users": {
+			// UsernamePattern: regular expression which usernames must match, after being
+			// trimmed and lowercased
+			// note: existing usernames are lowercased by a migration on upgrade, which fails if
+			// two usernames differ only by case, until one of those users is renamed
+			"UsernamePattern": "^[a-z0-9_.-]+$",
+
+			// UsernameMinLength: minimum number of characters in a username
+			"UsernameMinLength": 2,
+
+			// UsernameMaxLength: maximum number of characters in a username
+			// note: may not exceed 20, the size of the username column
+			"UsernameMaxLength": 20,
+
+			// PasswordAlgorithm: algorithm used to hash new passwords, "bcrypt" or "scrypt"
+			// note: existing passwords hashed with either algorithm may always be used to log in
+			"PasswordAlgorithm": "bcrypt",
+
+			// BcryptCost: cost used to hash new passwords with bcrypt, from 4 to 31
+			// note: bcrypt passwords hashed with another cost are rehashed on the user's next login
+			"BcryptCost": 12,
+
+			// PasswordMinLength: minimum number of characters in a password set via the API
+			"PasswordMinLength": 8,
+
+			// Registration: allow anyone to register a user account via the API, without
+			// authentication
+			"Registration": false,
+
+			// TorrentLimit: maximum number of torrents which may be active at once for users
+			// who register via the API
+			"TorrentLimit": 10
+		},
+
+		// Capture: announce capture configuration, used to reproduce bugs by replaying
+		// captured announces against a test instance using the -replay flag
+		"Capture": {
+			// Enabled: whether or not to capture announce requests.  Passkeys are redacted.
+			"Enabled": false,
+
+			// Path: file to which captured announces are written
+			"Path": "/tmp/goat_capture.log",
+
+			// SampleRate: ratio of announces to capture, between 0 and 1
+			"SampleRate": 0.01,
+
+			// MaxSize: size in bytes at which the capture file is rotated
+			"MaxSize": 10485760,
+
+			// Rotations: number of rotated capture files to keep
+			"Rotations": 3
+		},
+
+		// Scrape: scrape configuration
+		"Scrape": {
+			// CacheTTL: number of seconds for which a file's scrape counts are cached, so
+			// repeated scrapes of popular files avoid database queries.  Counts up to 10 times
+			// this age are served if the database cannot be reached.
+			// note: a value of 0 disables caching
+			"CacheTTL": 30,
+
+			// GzipThreshold: size in bytes above which scrape responses are compressed using
+			// gzip, for clients which accept it
+			// note: a value of 0 disables compression
+			"GzipThreshold": 4096,
+
+			// MinRequestInterval: minimum number of seconds between scrapes, advertised to HTTP
+			// clients in a "flags" dictionary in scrape responses, so well-behaved clients throttle
+			// their scrapes
+			// note: a value of 0 omits the flags dictionary
+			"MinRequestInterval": 0
+		},
+
+		// Privacy: privacy configuration
+		"Privacy": {
+			// RedactIP: whether or not to redact peer IP addresses from announce logs returned
+			// by the API
+			"RedactIP": true
+		},
+
+		// KeepAlive: HTTP connection keep-alive configuration
+		"KeepAlive": {
+			// Enabled: whether or not to enable TCP keep-alive on accepted HTTP(S) connections, so
+			// connections from clients which have disappeared are eventually closed
+			"Enabled": true,
+
+			// Period: number of seconds between TCP keep-alive probes
+			"Period": 180,
+
+			// IdleTimeout: number of seconds an idle keep-alive connection is held open, waiting
+			// for another request, before it is closed
+			// note: a value of 0 holds idle connections open indefinitely
+			"IdleTimeout": 120
 		}
 	}
 
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// redacted replaces the value of sensitive configuration fields in API output
+const redacted = "[redacted]"
+
+// redactDSN redacts the credentials from a MySQL DSN, of the form user:password@tcp(host)/database.
+// As when the DSN is parsed, credentials end at the last '@' before the last '/'.
+func redactDSN(dsn string) string {
+	at := strings.LastIndex(dsn[:strings.LastIndex(dsn, "/")+1], "@")
+	if at == -1 {
+		return dsn
+	}
+
+	return redacted + dsn[at:]
+}
+
+// getAdminConfigJSON returns a JSON representation of the effective configuration, with secrets redacted
+func getAdminConfigJSON() ([]byte, error) {
+	// Copy current configuration, so the running configuration is not modified
+	config := common.Static.Config
+
+	// Redact database password
+	if config.DB.Password != "" {
+		config.DB.Password = redacted
+	}
+
+	// Redact database credentials from DSN
+	config.DB.DSN = redactDSN(config.DB.DSN)
+
+	// Redact Redis password
+	if config.Redis.Password != "" {
+		config.Redis.Password = redacted
+	}
+
+	// Marshal into JSON
+	res, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// adminMaintenance represents the input JSON used to enable or disable maintenance mode
+type adminMaintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// postAdminMaintenanceJSON enables or disables maintenance mode from a JSON body, returning a client string/server error pair
+func postAdminMaintenanceJSON(body []byte) (string, error) {
+	// Unmarshal JSON from body
+	var maintenance adminMaintenance
+	if err := json.Unmarshal(body, &maintenance); err != nil {
+		return "Malformed request JSON", nil
+	}
+
+	// Update maintenance mode and status message
+	common.Static.Maintenance.Set(maintenance.Enabled, maintenance.Message)
+	return "", nil
+}
+
+// adminBan represents the input JSON used to enable or disable a user
+type adminBan struct {
+	ID     int  `json:"id"`
+	Banned bool `json:"banned"`
+}
+
+// postAdminBanJSON enables or disables a user from a JSON body, returning a client string/server error pair
+func postAdminBanJSON(body []byte) (string, error) {
+	// Unmarshal JSON from body
+	var ban adminBan
+	if err := json.Unmarshal(body, &ban); err != nil {
+		return "Malformed request JSON", nil
+	}
+
+	// Check for valid input
+	if ban.ID < 1 {
+		return "Missing required parameters: id", nil
+	}
+
+	// Load user
+	user, err := new(data.UserRecord).Load(ban.ID, "id")
+	if err == data.ErrNotFound {
+		return "No such user", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Update and save user
+	user.Banned = ban.Banned
+	if err := user.Save(); err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
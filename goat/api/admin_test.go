@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestGetAdminConfigJSON verifies that /api/admin/config returns proper JSON output, with secrets redacted
+func TestGetAdminConfigJSON(t *testing.T) {
+	log.Println("TestGetAdminConfigJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+
+	// Set mock secrets
+	config.DB.Password = "dbsecret"
+	config.Redis.Password = "redissecret"
+	config.DB.DSN = "goat:dsnsecret@tcp(localhost:3306)/goat"
+	common.Static.Config = config
+
+	// Request output JSON from API for configuration
+	res, err := getAdminConfigJSON()
+	if err != nil {
+		t.Fatalf("Failed to retrieve config JSON: %s", err.Error())
+	}
+
+	// Verify secrets do not appear anywhere in output
+	if bytes.Contains(res, []byte("dbsecret")) || bytes.Contains(res, []byte("redissecret")) || bytes.Contains(res, []byte("dsnsecret")) {
+		t.Fatalf("Secrets found in config JSON: %s", string(res))
+	}
+
+	// Unmarshal output JSON
+	var config2 common.Conf
+	if err := json.Unmarshal(res, &config2); err != nil {
+		t.Fatalf("Failed to unmarshal config JSON: %s", err.Error())
+	}
+
+	// Verify sensitive fields are redacted
+	if config2.DB.Password != redacted {
+		t.Fatalf("DB.Password, expected %s, got %s", redacted, config2.DB.Password)
+	}
+
+	if config2.Redis.Password != redacted {
+		t.Fatalf("Redis.Password, expected %s, got %s", redacted, config2.Redis.Password)
+	}
+
+	if expected := redacted + "@tcp(localhost:3306)/goat"; config2.DB.DSN != expected {
+		t.Fatalf("DB.DSN, expected %s, got %s", expected, config2.DB.DSN)
+	}
+
+	// Verify other fields are intact
+	if config2.Port != config.Port || config2.DB.Username != config.DB.Username {
+		t.Fatalf("Non-sensitive configuration fields do not match")
+	}
+
+	// Verify running configuration was not modified
+	if common.Static.Config.DB.Password != "dbsecret" {
+		t.Fatalf("Running configuration was modified")
+	}
+}
+
+// redactDSNTests contains MySQL DSNs, and their expected redacted forms
+var redactDSNTests = []struct {
+	dsn      string
+	redacted string
+}{
+	{"", ""},
+	{"tcp(localhost:3306)/goat", "tcp(localhost:3306)/goat"},
+	{"goat:secret@tcp(localhost:3306)/goat", redacted + "@tcp(localhost:3306)/goat"},
+	{"goat:p@ss/word@unix(/var/run/mysqld.sock)/goat?parseTime=true", redacted + "@unix(/var/run/mysqld.sock)/goat?parseTime=true"},
+	{"goat@/goat", redacted + "@/goat"},
+}
+
+// TestRedactDSN verifies that credentials are redacted from MySQL DSNs
+func TestRedactDSN(t *testing.T) {
+	log.Println("TestRedactDSN()")
+
+	// Iterate all tests
+	for _, test := range redactDSNTests {
+		if dsn := redactDSN(test.dsn); dsn != test.redacted {
+			t.Fatalf("redactDSN(%q), expected %q, got %q", test.dsn, test.redacted, dsn)
+		}
+	}
+}
+
+// TestPostAdminMaintenanceJSON verifies that /api/admin/maintenance toggles maintenance mode
+func TestPostAdminMaintenanceJSON(t *testing.T) {
+	log.Println("TestPostAdminMaintenanceJSON()")
+
+	defer common.Static.Maintenance.Set(false, "")
+
+	// Enable maintenance mode
+	if clientErr, err := postAdminMaintenanceJSON([]byte(`{"enabled":true,"message":"testing"}`)); clientErr != "" || err != nil {
+		t.Fatalf("Failed to enable maintenance mode: %s %v", clientErr, err)
+	}
+	if maintenance, message := common.Static.Maintenance.Get(); !maintenance || message != "testing" {
+		t.Fatalf("Maintenance mode not enabled")
+	}
+
+	// Disable maintenance mode
+	if clientErr, err := postAdminMaintenanceJSON([]byte(`{"enabled":false}`)); clientErr != "" || err != nil {
+		t.Fatalf("Failed to disable maintenance mode: %s %v", clientErr, err)
+	}
+	if maintenance, _ := common.Static.Maintenance.Get(); maintenance {
+		t.Fatalf("Maintenance mode not disabled")
+	}
+
+	// Verify malformed JSON is a client error
+	if clientErr, _ := postAdminMaintenanceJSON([]byte(`{`)); clientErr == "" {
+		t.Fatalf("Expected client error for malformed JSON")
+	}
+}
@@ -8,16 +8,72 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
-	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
-	"github.com/willf/bloom"
 )
 
-// nonceFilter is a bloom filter containing nonce values we have seen previously
-var nonceFilter = bloom.New(20000, 5)
+// nonceCache stores nonce values we have seen previously, and the time at which each may be reused,
+// so that API requests may not be replayed within the nonce window
+type nonceCache struct {
+	sync.Mutex
+	expires map[string]int64
+	pruned  int64
+}
+
+// apiNonces stores nonce values used for HMAC authentication
+var apiNonces = &nonceCache{
+	expires: map[string]int64{},
+}
+
+// testAndAdd reports whether a nonce was already used within the last window seconds, and records
+// it as used if it was not.  Expired nonces are discarded, at most once per window.
+func (c *nonceCache) testAndAdd(nonce string, window int64, now int64) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if now-c.pruned >= window {
+		for k, v := range c.expires {
+			if now >= v {
+				delete(c.expires, k)
+			}
+		}
+
+		c.pruned = now
+	}
+
+	if expires, ok := c.expires[nonce]; ok && now < expires {
+		return true
+	}
+
+	c.expires[nonce] = now + window
+	return false
+}
+
+// dummyHash is a password hash compared against when a user does not exist, so that login
+// attempts for unknown users take as long as those with an incorrect password
+var dummyHash []byte
+
+// dummyHashOnce ensures dummyHash is only generated once
+var dummyHashOnce sync.Once
+
+// loadDummyHash generates the dummy password hash if needed, using the same algorithm and cost as user passwords
+func loadDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, err := data.HashPassword("goat")
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+
+		dummyHash = []byte(hash)
+	})
+
+	return dummyHash
+}
 
 // APIAuthenticator interface which defines methods required to implement an authentication method
 type APIAuthenticator interface {
@@ -26,9 +82,9 @@ type APIAuthenticator interface {
 }
 
 // apiSignature generates a HMAC-SHA1 signature for use with the API
-func apiSignature(userID int, nonce string, method string, resource string, secret string) (string, error) {
+func apiSignature(userID int, nonce string, timestamp int64, method string, resource string, secret string) (string, error) {
 	// Generate API signature string
-	signString := fmt.Sprintf("%d-%s-%s-%s", userID, nonce, method, resource)
+	signString := fmt.Sprintf("%d-%s-%d-%s-%s", userID, nonce, timestamp, method, resource)
 
 	// Calculate HMAC-SHA1 signature from string, using API secret
 	mac := hmac.New(sha1.New, []byte(secret))
@@ -77,23 +133,59 @@ func (a *BasicAuthenticator) Auth(r *http.Request) (error, error) {
 		return err, nil
 	}
 
-	// Load user by username
-	user, err := new(data.UserRecord).Load(username, "username")
-	if err != nil || user == (data.UserRecord{}) {
+	// Load user by username, which is stored normalized
+	user, err := new(data.UserRecord).Load(data.NormalizeUsername(username), "username")
+	if err != nil {
+		// Compare against a dummy hash anyway, so unknown users cannot be detected by response time
+		_ = data.ComparePassword(string(loadDummyHash()), password)
+
+		if err == data.ErrNotFound {
+			return errors.New("no such user"), nil
+		}
+
 		return errors.New("no such user"), err
 	}
 
-	// Compare input password with bcrypt password, checking for errors
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil && err != bcrypt.ErrMismatchedHashAndPassword {
+	// Compare input password with stored password hash, checking for errors
+	// note: comparison is constant-time for all supported algorithms
+	err = data.ComparePassword(user.Password, password)
+	if err == data.ErrPasswordMismatch {
+		return errors.New("invalid password"), nil
+	} else if err != nil {
 		return errors.New("invalid password"), err
 	}
 
+	// If password hash uses an outdated algorithm or cost, transparently upgrade it using the
+	// current parameters, now that the plaintext password is known to be correct
+	if data.PasswordNeedsRehash(user.Password) {
+		if err := rehashPassword(&user, password); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
 	// Store user for session
 	a.session = user
 	return nil, nil
 }
 
+// rehashPassword regenerates a user's password hash using the current algorithm and cost, and saves
+// the user.  The user is only modified if the new hash is saved successfully.
+func rehashPassword(user *data.UserRecord, password string) error {
+	hash, err := data.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	rehashed := *user
+	rehashed.Password = hash
+	if err := rehashed.Save(); err != nil {
+		return err
+	}
+
+	*user = rehashed
+	return nil
+}
+
 // Session attempts to return the user whose session was authenticated via this authenticator
 func (a BasicAuthenticator) Session() (data.UserRecord, error) {
 	if a.session == (data.UserRecord{}) {
@@ -106,6 +198,7 @@ func (a BasicAuthenticator) Session() (data.UserRecord, error) {
 // HMACAuthenticator uses the HMAC-SHA1 authentication scheme, used for API authentication
 type HMACAuthenticator struct {
 	session data.UserRecord
+	pubkey  string
 }
 
 // Auth handles validation of HMAC-SHA1 authentication
@@ -123,29 +216,38 @@ func (a *HMACAuthenticator) Auth(r *http.Request) (error, error) {
 		return err, nil
 	}
 
-	// Split credentials into nonce and API signature
+	// Split credentials into nonce, timestamp, and API signature
 	pair := strings.Split(credentials, "/")
-	if len(pair) < 2 {
-		return errors.New("no nonce value"), nil
+	if len(pair) < 3 {
+		return errors.New("no nonce or timestamp value"), nil
 	}
 
 	nonce := pair[0]
-	signature := pair[1]
+	signature := pair[2]
 
-	// Check if nonce previously used, add it if it is not, to prevent replay attacks
-	// note: bloom filter may report false positives, but better safe than sorry
-	if nonceFilter.TestAndAdd([]byte(nonce)) {
-		return errors.New("repeated API request"), nil
+	// Reject requests whose timestamp is outside the nonce window, so that a request cannot be
+	// replayed once its nonce has been discarded
+	timestamp, err := strconv.ParseInt(pair[1], 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp"), nil
+	}
+
+	window := int64(common.Static.Config.NonceWindow)
+	if skew := common.Now().Unix() - timestamp; skew > window || skew < -window {
+		return errors.New("expired API request"), nil
 	}
 
 	// Load API key by pubkey
 	key, err := new(data.APIKey).Load(pubkey, "pubkey")
-	if err != nil || key == (data.APIKey{}) {
+	if err == data.ErrNotFound {
+		return errors.New("no such public key"), nil
+	}
+	if err != nil {
 		return errors.New("no such public key"), err
 	}
 
 	// Check if key is expired, delete it if it is
-	if key.Expire <= time.Now().Unix() {
+	if key.Expire <= common.Now().Unix() {
 		go func(key data.APIKey) {
 			if err := key.Delete(); err != nil {
 				log.Println(err.Error())
@@ -156,7 +258,7 @@ func (a *HMACAuthenticator) Auth(r *http.Request) (error, error) {
 	}
 
 	// Generate API signature
-	expected, err := apiSignature(key.UserID, nonce, r.Method, r.URL.Path, key.Secret)
+	expected, err := apiSignature(key.UserID, nonce, timestamp, r.Method, r.URL.Path, key.Secret)
 	if err != nil {
 		return nil, errors.New("failed to generate API signature")
 	}
@@ -166,8 +268,16 @@ func (a *HMACAuthenticator) Auth(r *http.Request) (error, error) {
 		return errors.New("invalid API signature"), nil
 	}
 
+	// Check if nonce previously used with this key, add it if it is not, to prevent replay attacks.
+	// Only nonces with valid signatures are stored, so unauthenticated clients cannot fill the cache.
+	// A timestamp is accepted for up to twice the nonce window after its nonce is first seen, so
+	// nonces are remembered for that long.
+	if apiNonces.testAndAdd(pubkey+":"+nonce, 2*window, common.Now().Unix()) {
+		return errors.New("repeated API request"), nil
+	}
+
 	// Update API key expiration time
-	key.Expire = time.Now().Add(7 * 24 * time.Hour).Unix()
+	key.Expire = data.APIKeyExpiry().Unix()
 	go func(key data.APIKey) {
 		if err := key.Save(); err != nil {
 			log.Println(err.Error())
@@ -176,15 +286,24 @@ func (a *HMACAuthenticator) Auth(r *http.Request) (error, error) {
 
 	// Load user by user ID
 	user, err := new(data.UserRecord).Load(key.UserID, "id")
-	if err != nil || user == (data.UserRecord{}) {
+	if err == data.ErrNotFound {
+		return errors.New("no such user"), nil
+	}
+	if err != nil {
 		return errors.New("no such user"), err
 	}
 
-	// Store user for session
+	// Store user and API key for session
 	a.session = user
+	a.pubkey = pubkey
 	return nil, nil
 }
 
+// Pubkey returns the public key of the API key which was authenticated via this authenticator
+func (a HMACAuthenticator) Pubkey() string {
+	return a.pubkey
+}
+
 // Session attempts to return the user whose session was authenticated via this authenticator
 func (a HMACAuthenticator) Session() (data.UserRecord, error) {
 	if a.session == (data.UserRecord{}) {
@@ -193,3 +312,69 @@ func (a HMACAuthenticator) Session() (data.UserRecord, error) {
 
 	return a.session, nil
 }
+
+// HMACOrBasicAuthenticator accepts either HMAC or HTTP Basic with bcrypt authentication, and is used
+// for read-only API calls made by web frontends, which may hold either an API key or a password
+type HMACOrBasicAuthenticator struct {
+	session data.UserRecord
+	pubkey  string
+}
+
+// Auth attempts HMAC authentication, falling back to HTTP Basic with bcrypt if the credentials are
+// not a valid API signature
+func (a *HMACOrBasicAuthenticator) Auth(r *http.Request) (error, error) {
+	// Attempt HMAC authentication, reporting server errors immediately
+	hmacAuth := new(HMACAuthenticator)
+	clientErr, serverErr := hmacAuth.Auth(r)
+	if serverErr != nil {
+		return clientErr, serverErr
+	}
+	if clientErr == nil {
+		a.session = hmacAuth.session
+		a.pubkey = hmacAuth.pubkey
+		return nil, nil
+	}
+
+	// Attempt HTTP Basic authentication
+	basicAuth := new(BasicAuthenticator)
+	clientErr, serverErr = basicAuth.Auth(r)
+	if serverErr != nil {
+		return clientErr, serverErr
+	}
+	if clientErr != nil {
+		return errors.New("invalid API signature or password"), nil
+	}
+
+	a.session = basicAuth.session
+	return nil, nil
+}
+
+// Pubkey returns the public key of the API key which was authenticated via this authenticator, or an
+// empty string if HTTP Basic authentication was used
+func (a HMACOrBasicAuthenticator) Pubkey() string {
+	return a.pubkey
+}
+
+// Session attempts to return the user whose session was authenticated via this authenticator
+func (a HMACOrBasicAuthenticator) Session() (data.UserRecord, error) {
+	if a.session == (data.UserRecord{}) {
+		return data.UserRecord{}, errors.New("session: no session found")
+	}
+
+	return a.session, nil
+}
+
+// NoAuthenticator performs no authentication, and is only used for API calls which may be made
+// anonymously, such as registration
+type NoAuthenticator struct {
+}
+
+// Auth always succeeds, as no credentials are required
+func (a *NoAuthenticator) Auth(r *http.Request) (error, error) {
+	return nil, nil
+}
+
+// Session returns an empty user, as there is no authenticated user
+func (a NoAuthenticator) Session() (data.UserRecord, error) {
+	return data.UserRecord{}, nil
+}
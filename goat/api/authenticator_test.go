@@ -3,12 +3,15 @@ package api
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 )
@@ -18,7 +21,7 @@ func TestAuthenticator(t *testing.T) {
 	log.Println("TestBasicAuthenticator()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -68,6 +71,19 @@ func TestAuthenticator(t *testing.T) {
 		t.Fatalf("Failed to authenticate: server: %s", serverErr.Error())
 	}
 
+	// Verify incorrect password and unknown user are both rejected
+	for _, credentials := range []string{"test:wrong", "nobody:test"} {
+		bad, err := http.NewRequest("POST", "http://localhost:8080/api/login", nil)
+		if err != nil {
+			t.Fatalf("Failed to generate HTTP request: %s", err.Error())
+		}
+		bad.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte(credentials)))
+
+		if clientErr, _ := new(BasicAuthenticator).Auth(bad); clientErr == nil {
+			t.Fatalf("Authenticated with invalid credentials: %s", credentials)
+		}
+	}
+
 	// Invoke API router
 	Router(w, r, user2)
 
@@ -88,7 +104,9 @@ func TestAuthenticator(t *testing.T) {
 	method := "GET"
 	resource := "/api/status"
 
-	signature, err := apiSignature(login.UserID, nonce, method, resource, login.Secret)
+	timestamp := common.Now().Unix()
+
+	signature, err := apiSignature(login.UserID, nonce, timestamp, method, resource, login.Secret)
 	if err != nil {
 		t.Fatalf("Failed to generate API signature: %s", err.Error())
 	}
@@ -101,7 +119,7 @@ func TestAuthenticator(t *testing.T) {
 	}
 
 	headers = map[string][]string{
-		"Authorization": {"Basic " + base64.URLEncoding.EncodeToString([]byte(login.Pubkey+":"+nonce+"/"+signature))},
+		"Authorization": {"Basic " + base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s/%d/%s", login.Pubkey, nonce, timestamp, signature)))},
 	}
 	r.Header = headers
 
@@ -133,3 +151,325 @@ func TestAuthenticator(t *testing.T) {
 		t.Fatalf("Failed to delete mock user: %s", err.Error())
 	}
 }
+
+// TestDummyHash verifies that unknown users are compared against a hash of the same cost as real users,
+// so that the unknown user and incorrect password paths take similar time
+func TestDummyHash(t *testing.T) {
+	log.Println("TestDummyHash()")
+
+	// Generate a real user password hash
+	user := new(data.UserRecord)
+	if err := user.Create("test", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+
+	// Verify costs match
+	userCost, err := bcrypt.Cost([]byte(user.Password))
+	if err != nil {
+		t.Fatalf("Failed to determine user password cost: %s", err.Error())
+	}
+
+	dummyCost, err := bcrypt.Cost(loadDummyHash())
+	if err != nil {
+		t.Fatalf("Failed to determine dummy hash cost: %s", err.Error())
+	}
+
+	if userCost != dummyCost {
+		t.Fatalf("Dummy hash cost, expected %d, got %d", userCost, dummyCost)
+	}
+}
+
+// TestBasicAuthenticatorRehash verifies that a login with a password hashed at a lower cost than configured
+// transparently rehashes the password at the configured cost
+func TestBasicAuthenticatorRehash(t *testing.T) {
+	log.Println("TestBasicAuthenticatorRehash()")
+
+	// Load config, using a low bcrypt cost
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Users.PasswordAlgorithm = "bcrypt"
+	config.Users.BcryptCost = bcrypt.MinCost
+	common.Static.Config = config
+
+	// Generate and save mock user with a low cost hash
+	user := new(data.UserRecord)
+	if err := user.Create("rehash", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	// Increase configured cost
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost + 1
+
+	// Log in twice, verifying the password is accepted both before and after rehashing
+	for i := 0; i < 2; i++ {
+		r, err := http.NewRequest("POST", "http://localhost:8080/api/login", nil)
+		if err != nil {
+			t.Fatalf("Failed to generate HTTP request: %s", err.Error())
+		}
+		r.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte("rehash:test")))
+
+		clientErr, serverErr := new(BasicAuthenticator).Auth(r)
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("Failed to authenticate: %v %v", clientErr, serverErr)
+		}
+	}
+
+	// Verify stored hash was upgraded to the configured cost
+	user2, err := user.Load("rehash", "username")
+	if err != nil || (user2 == data.UserRecord{}) {
+		t.Fatalf("Failed to load mock user: %v", err)
+	}
+	cost, err := bcrypt.Cost([]byte(user2.Password))
+	if err != nil {
+		t.Fatalf("Failed to determine user password cost: %s", err.Error())
+	}
+	if cost != bcrypt.MinCost+1 {
+		t.Fatalf("Password cost after login, expected %d, got %d", bcrypt.MinCost+1, cost)
+	}
+
+	// Delete mock user
+	if err := user2.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
+
+// TestHMACAuthenticatorExpiry verifies that an API key is rejected once the clock advances past its expiration
+func TestHMACAuthenticatorExpiry(t *testing.T) {
+	log.Println("TestHMACAuthenticatorExpiry()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Fix the clock, restoring the real clock when finished
+	now := time.Unix(1400000000, 0)
+	common.Now = func() time.Time {
+		return now
+	}
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Generate and save mock API key, which expires one week from now
+	key := new(data.APIKey)
+	if err := key.Create(1); err != nil {
+		t.Fatalf("Failed to create mock API key: %s", err.Error())
+	}
+	if err := key.Save(); err != nil {
+		t.Fatalf("Failed to save mock API key: %s", err.Error())
+	}
+
+	// authenticate attempts HMAC authentication with the mock API key, using a unique nonce
+	authenticate := func(nonce string) error {
+		timestamp := common.Now().Unix()
+		signature, err := apiSignature(key.UserID, nonce, timestamp, "GET", "/api/status", key.Secret)
+		if err != nil {
+			t.Fatalf("Failed to generate API signature: %s", err.Error())
+		}
+
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to generate HTTP request: %s", err.Error())
+		}
+		r.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s/%d/%s", key.Pubkey, nonce, timestamp, signature))))
+
+		clientErr, _ := new(HMACAuthenticator).Auth(r)
+		return clientErr
+	}
+
+	// Verify key is accepted before expiration
+	if clientErr := authenticate("expiry1"); clientErr != nil && clientErr.Error() == "expired API key" {
+		t.Fatalf("API key expired before its expiration time")
+	}
+
+	// Advance clock past expiration, and verify key is rejected
+	now = now.Add(8 * 24 * time.Hour)
+	if clientErr := authenticate("expiry2"); clientErr == nil || clientErr.Error() != "expired API key" {
+		t.Fatalf("Expected expired API key, got: %v", clientErr)
+	}
+
+	// Delete mock API key
+	if err := key.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock API key: %s", err.Error())
+	}
+}
+
+// TestHMACAuthenticatorNonce verifies that only nonces with valid signatures are recorded, and that
+// a recorded nonce cannot be replayed
+func TestHMACAuthenticatorNonce(t *testing.T) {
+	log.Println("TestHMACAuthenticatorNonce()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock API key
+	key := new(data.APIKey)
+	if err := key.Create(1); err != nil {
+		t.Fatalf("Failed to create mock API key: %s", err.Error())
+	}
+	if err := key.Save(); err != nil {
+		t.Fatalf("Failed to save mock API key: %s", err.Error())
+	}
+
+	// authenticate attempts HMAC authentication with the mock API key, signing with the secret
+	authenticate := func(nonce string, secret string) error {
+		timestamp := common.Now().Unix()
+		signature, err := apiSignature(key.UserID, nonce, timestamp, "GET", "/api/status", secret)
+		if err != nil {
+			t.Fatalf("Failed to generate API signature: %s", err.Error())
+		}
+
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to generate HTTP request: %s", err.Error())
+		}
+		r.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s/%d/%s", key.Pubkey, nonce, timestamp, signature))))
+
+		clientErr, _ := new(HMACAuthenticator).Auth(r)
+		return clientErr
+	}
+
+	// Verify a nonce with an invalid signature is not recorded
+	if clientErr := authenticate("nonce_invalid", "badsecret"); clientErr == nil || clientErr.Error() != "invalid API signature" {
+		t.Fatalf("Expected invalid API signature, got: %v", clientErr)
+	}
+	if _, ok := apiNonces.expires[key.Pubkey+":nonce_invalid"]; ok {
+		t.Fatalf("Nonce with invalid signature was recorded")
+	}
+
+	// Verify a nonce with a valid signature is recorded, and cannot be replayed
+	if clientErr := authenticate("nonce_valid", key.Secret); clientErr != nil && clientErr.Error() == "repeated API request" {
+		t.Fatalf("Unused nonce was rejected")
+	}
+	if clientErr := authenticate("nonce_valid", key.Secret); clientErr == nil || clientErr.Error() != "repeated API request" {
+		t.Fatalf("Expected repeated API request, got: %v", clientErr)
+	}
+
+	// Delete mock API key
+	if err := key.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock API key: %s", err.Error())
+	}
+}
+
+// TestHMACAuthenticatorTimestamp verifies that requests whose timestamp is outside the nonce window
+// are rejected before their nonce is recorded
+func TestHMACAuthenticatorTimestamp(t *testing.T) {
+	log.Println("TestHMACAuthenticatorTimestamp()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.NonceWindow = 900
+	common.Static.Config = config
+
+	// Fix the clock, restoring the real clock when finished
+	now := time.Now()
+	common.Now = func() time.Time {
+		return now
+	}
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Generate and save mock API key
+	key := new(data.APIKey)
+	if err := key.Create(1); err != nil {
+		t.Fatalf("Failed to create mock API key: %s", err.Error())
+	}
+	if err := key.Save(); err != nil {
+		t.Fatalf("Failed to save mock API key: %s", err.Error())
+	}
+
+	// authenticate attempts HMAC authentication with the mock API key, signing the timestamp
+	authenticate := func(nonce string, timestamp int64) error {
+		signature, err := apiSignature(key.UserID, nonce, timestamp, "GET", "/api/status", key.Secret)
+		if err != nil {
+			t.Fatalf("Failed to generate API signature: %s", err.Error())
+		}
+
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to generate HTTP request: %s", err.Error())
+		}
+		r.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s/%d/%s", key.Pubkey, nonce, timestamp, signature))))
+
+		clientErr, _ := new(HMACAuthenticator).Auth(r)
+		return clientErr
+	}
+
+	// Verify timestamps outside the window are rejected, and their nonces are not recorded
+	var tests = []struct {
+		nonce     string
+		timestamp int64
+		expired   bool
+	}{
+		{"timestamp_past", now.Unix() - 901, true},
+		{"timestamp_future", now.Unix() + 901, true},
+		{"timestamp_early", now.Unix() - 900, false},
+		{"timestamp_late", now.Unix() + 900, false},
+	}
+
+	for _, test := range tests {
+		clientErr := authenticate(test.nonce, test.timestamp)
+		if expired := clientErr != nil && clientErr.Error() == "expired API request"; expired != test.expired {
+			t.Fatalf("Timestamp %d, expected expired %v, got: %v", test.timestamp, test.expired, clientErr)
+		}
+
+		if _, ok := apiNonces.expires[key.Pubkey+":"+test.nonce]; ok == test.expired {
+			t.Fatalf("Timestamp %d, expected nonce recorded %v, got %v", test.timestamp, !test.expired, ok)
+		}
+	}
+
+	// Delete mock API key
+	if err := key.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock API key: %s", err.Error())
+	}
+}
+
+// TestNonceCache verifies that a nonce is rejected if reused within the nonce window, and accepted
+// again once the window elapses
+func TestNonceCache(t *testing.T) {
+	log.Println("TestNonceCache()")
+
+	cache := &nonceCache{expires: map[string]int64{}}
+	const window = 900
+	now := int64(1400000000)
+
+	// Verify nonce is accepted on first use, and rejected on reuse within the window
+	if cache.testAndAdd("nonce1", window, now) {
+		t.Fatalf("Unused nonce was rejected")
+	}
+	if !cache.testAndAdd("nonce1", window, now+window-1) {
+		t.Fatalf("Nonce reused within window was accepted")
+	}
+
+	// Verify other nonces are unaffected
+	if cache.testAndAdd("nonce2", window, now) {
+		t.Fatalf("Unused nonce was rejected")
+	}
+
+	// Verify nonce is accepted once the window elapses, and expired nonces are discarded
+	if cache.testAndAdd("nonce1", window, now+window) {
+		t.Fatalf("Nonce reused after window was rejected")
+	}
+	if _, ok := cache.expires["nonce2"]; ok {
+		t.Fatalf("Expired nonce was not discarded")
+	}
+	if !cache.testAndAdd("nonce1", window, now+window+1) {
+		t.Fatalf("Nonce reused within new window was accepted")
+	}
+}
@@ -2,46 +2,278 @@ package api
 
 import (
 	"encoding/json"
+	"log"
+	"net"
 
+	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 )
 
-// getFilesJSON returns a JSON representation of one or more data.FileRecords
+// getFilesJSON returns a JSON representation of a single data.FileRecord, or no output if no such
+// file exists
 func getFilesJSON(ID int) ([]byte, error) {
-	// Check for a valid integer ID
-	if ID > 0 {
-		// Load file
-		file, err := new(data.FileRecord).Load(ID, "id")
-		if err != nil {
-			return nil, err
-		}
+	// Load file
+	file, err := new(data.FileRecord).Load(ID, "id")
+	if err == data.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		// Create JSON represenation
-		jsonFile, err := file.ToJSON()
-		if err != nil {
-			return nil, err
-		}
+	// Create JSON represenation
+	jsonFile, err := file.ToJSON()
+	if err != nil {
+		return nil, err
+	}
 
-		// Marshal into JSON
-		res, err := json.Marshal(jsonFile)
+	// Marshal into JSON
+	return json.Marshal(jsonFile)
+}
+
+// FileResponse represents the output JSON for a single file, containing its stored fields and live
+// swarm counts
+type FileResponse struct {
+	ID               int    `json:"id"`
+	InfoHash         string `json:"infoHash"`
+	Verified         bool   `json:"verified"`
+	CreateTime       int64  `json:"createTime"`
+	UpdateTime       int64  `json:"updateTime"`
+	AnnounceInterval int    `json:"announceInterval"`
+	PeerLimit        int    `json:"peerLimit"`
+	Seeders          int    `json:"seeders"`
+	Leechers         int    `json:"leechers"`
+	Completed        int    `json:"completed"`
+}
+
+// newFileResponse creates a FileResponse from a file, loading its live counts
+func newFileResponse(file data.FileRecord) (FileResponse, error) {
+	// Copy stored fields
+	res := FileResponse{
+		ID:               file.ID,
+		InfoHash:         file.InfoHash,
+		Verified:         file.Verified,
+		CreateTime:       file.CreateTime,
+		UpdateTime:       file.UpdateTime,
+		AnnounceInterval: file.AnnounceInterval,
+		PeerLimit:        file.PeerLimit,
+	}
+
+	// Load live counts for seeding, leeching, completions
+	var err error
+	res.Seeders, res.Leechers, err = file.PeerCounts()
+	if err != nil {
+		return FileResponse{}, err
+	}
+
+	res.Completed, err = file.Completed()
+	if err != nil {
+		return FileResponse{}, err
+	}
+
+	return res, nil
+}
+
+// getFileJSON returns a FileResponse JSON representation of a file with the specified info_hash, or
+// no output if no such file exists
+func getFileJSON(infoHash string) ([]byte, error) {
+	// Load file
+	file, err := new(data.FileRecord).Load(infoHash, "info_hash")
+	if err == data.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := newFileResponse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marshal into JSON
+	return json.Marshal(res)
+}
+
+const (
+	// fileListDefaultLimit is the number of files returned by getFileListJSON when no limit is specified
+	fileListDefaultLimit = 50
+	// fileListMaxLimit is the maximum number of files returned by getFileListJSON
+	fileListMaxLimit = 500
+)
+
+// getFileListJSON returns a FileResponse JSON representation of a page of files, sorted by the specified
+// field, along with the total number of files.  data.ErrInvalidSort is returned for unknown sort fields.
+func getFileListJSON(sort string, limit int, offset int) ([]byte, int, error) {
+	// Load requested page of files
+	files, total, err := new(data.FileRecordRepository).Page(sort, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Create JSON representations
+	jsonFiles := make([]FileResponse, 0)
+	for _, f := range files {
+		j, err := newFileResponse(f)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
-		return res, nil
+		jsonFiles = append(jsonFiles[:], j)
+	}
+
+	// Marshal into JSON
+	res, err := json.Marshal(jsonFiles)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return res, total, nil
+}
+
+// getFileStatsJSON returns a JSON representation of the swarm statistics of a file with the
+// specified info_hash, or no output if no such file exists
+func getFileStatsJSON(infoHash string) ([]byte, error) {
+	// Load file
+	file, err := new(data.FileRecord).Load(infoHash, "info_hash")
+	if err == data.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Load all files
-	files, err := new(data.FileRecordRepository).All()
+	// Retrieve swarm statistics
+	stats, err := file.Stats()
 	if err != nil {
 		return nil, err
 	}
 
 	// Marshal into JSON
-	res, err := json.Marshal(files)
+	return json.Marshal(stats)
+}
+
+// announcePageSize is the number of announces returned per page by getFileAnnouncesJSON
+const announcePageSize = 50
+
+// getFileAnnouncesJSON returns a JSON representation of a page of the most recent announces on a file
+// with the specified info_hash, newest first, or no output if no such file exists.  Peer IP addresses
+// are redacted, if configured.
+func getFileAnnouncesJSON(infoHash string, page int) ([]byte, error) {
+	// Load file
+	file, err := new(data.FileRecord).Load(infoHash, "info_hash")
+	if err == data.ErrNotFound {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return res, err
+	// Load requested page of announces
+	announces, err := new(data.AnnounceLogRepository).Recent(file.InfoHash, announcePageSize, (page-1)*announcePageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create JSON representations
+	jsonAnnounces := make([]data.JSONAnnounceLog, 0)
+	for _, a := range announces {
+		j, err := a.ToJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		// Redact IP address, if configured
+		if common.Static.Config.Privacy.RedactIP {
+			j.IP = redacted
+		}
+
+		jsonAnnounces = append(jsonAnnounces[:], j)
+	}
+
+	// Marshal into JSON
+	return json.Marshal(jsonAnnounces)
+}
+
+// filePeer represents the input JSON used to inject a synthetic peer into a file's swarm
+type filePeer struct {
+	IP     string `json:"ip"`
+	Port   int    `json:"port"`
+	Seeder bool   `json:"seeder"`
+}
+
+// postFilePeersJSON injects a synthetic peer into the swarm of a file with the specified info_hash,
+// from a JSON body, returning a client string/server error pair.  The peer is recorded on behalf of
+// the session user, exactly as if it had announced, so it appears in subsequent peer lists.
+func postFilePeersJSON(infoHash string, session data.UserRecord, body []byte) (string, error) {
+	// Unmarshal JSON from body
+	var peer filePeer
+	if err := json.Unmarshal(body, &peer); err != nil {
+		return "Malformed request JSON", nil
+	}
+
+	// Check for valid input; peers must be IPv4 to appear in compact peer lists
+	if ip := net.ParseIP(peer.IP); ip == nil || ip.To4() == nil {
+		return "Invalid parameter: ip", nil
+	}
+	if peer.Port < 1 || peer.Port > 65535 {
+		return "Invalid parameter: port", nil
+	}
+
+	// Load file
+	file, err := new(data.FileRecord).Load(infoHash, "info_hash")
+	if err == data.ErrNotFound {
+		return "No such file", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Seeders have nothing left to download, leechers have some left
+	var left int64 = 1
+	if peer.Seeder {
+		left = 0
+	}
+	now := common.Now().Unix()
+
+	// Log a synthetic announce, which places the peer in the peer list
+	announce := data.AnnounceLog{
+		InfoHash: file.InfoHash,
+		Passkey:  session.Passkey,
+		IP:       peer.IP,
+		Port:     peer.Port,
+		Left:     left,
+		Event:    "started",
+		Client:   "goat-api",
+		Time:     now,
+	}
+	if err := announce.Save(); err != nil {
+		return "", err
+	}
+
+	// Store an active file/user relationship, which determines the peer's seeding status
+	fileUser := data.FileUserRecord{
+		FileID:    file.ID,
+		UserID:    session.ID,
+		IP:        peer.IP,
+		Active:    true,
+		Completed: peer.Seeder,
+		Announced: 1,
+		Left:      left,
+		Time:      now,
+	}
+	fileUser.RecordEvent("started", now)
+	if err := fileUser.Save(); err != nil {
+		return "", err
+	}
+
+	// If enabled, add peer to Redis swarm state
+	if common.Static.Config.Redis.Enabled {
+		redisPeer := data.Peer{IP: peer.IP, Port: uint16(peer.Port), Seeder: peer.Seeder}
+		if err := data.RedisAnnounce(file.InfoHash, redisPeer, false, now); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return "", nil
 }
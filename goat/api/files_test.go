@@ -1,9 +1,14 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
@@ -14,7 +19,7 @@ func TestGetFilesJSON(t *testing.T) {
 	log.Println("TestGetFilesJSON()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -55,32 +60,518 @@ func TestGetFilesJSON(t *testing.T) {
 		t.Fatalf("ID, expected %d, got %d", file.ID, file2.ID)
 	}
 
-	// Request output JSON from API for all files
-	res, err = getFilesJSON(-1)
+	// Request output JSON from API for the last page of files, sorted by ID, which contains this file
+	_, total, err := getFileListJSON("", 1, 0)
 	if err != nil {
-		t.Fatalf("Failed to retrieve all files JSON: %s", err.Error())
+		t.Fatalf("Failed to retrieve file list JSON: %s", err.Error())
+	}
+
+	res, _, err = getFileListJSON("", 1, total-1)
+	if err != nil {
+		t.Fatalf("Failed to retrieve file list JSON: %s", err.Error())
 	}
 
 	// Unmarshal all output JSON
-	var allFiles []data.FileRecord
+	var allFiles []FileResponse
 	err = json.Unmarshal(res, &allFiles)
 	if err != nil {
-		t.Fatalf("Failed to unmarshal result JSON for all files: %s", err.Error())
+		t.Fatalf("Failed to unmarshal result JSON for file list: %s", err.Error())
 	}
 
 	// Verify known file is in result set
-	found := false
-	for _, f := range allFiles {
-		if f.ID == file.ID {
-			found = true
+	if len(allFiles) != 1 || allFiles[0].ID != file.ID {
+		t.Fatalf("Expected file not found in last page of file list: %+v", allFiles)
+	}
+
+	// Delete mock file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestGetFileListJSON verifies that /api/files returns pages of files within the requested bounds,
+// in the requested order
+func TestGetFileListJSON(t *testing.T) {
+	log.Println("TestGetFileListJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock files
+	files := []data.FileRecord{
+		{InfoHash: "676f61745f66696c655f6c6973745f3030303031", Verified: true},
+		{InfoHash: "676f61745f66696c655f6c6973745f3030303032", Verified: true},
+		{InfoHash: "676f61745f66696c655f6c6973745f3030303033", Verified: true},
+	}
+	for i, f := range files {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file: %s", err.Error())
+		}
+
+		if files[i], err = f.Load(f.InfoHash, "info_hash"); err != nil {
+			t.Fatalf("Failed to load mock file: %s", err.Error())
 		}
 	}
 
-	if !found {
-		t.Fatalf("Expected file not found in all files result set")
+	// page requests a page of files, returning them along with the total number of files
+	page := func(sort string, limit int, offset int) ([]FileResponse, int) {
+		res, total, err := getFileListJSON(sort, limit, offset)
+		if err != nil {
+			t.Fatalf("Failed to retrieve file list JSON: %s", err.Error())
+		}
+
+		var list []FileResponse
+		if err := json.Unmarshal(res, &list); err != nil {
+			t.Fatalf("Failed to unmarshal result JSON for file list: %s", err.Error())
+		}
+
+		return list, total
 	}
 
-	// Delete mock file
+	// Verify the limit is respected
+	list, total := page("", 2, 0)
+	if total < len(files) || len(list) != 2 {
+		t.Fatalf("Limit 2, expected 2 of at least %d files, got %d of %d", len(files), len(list), total)
+	}
+
+	// Verify the last page is partial, and pages beyond the end are empty
+	if list, _ := page("", 2, total-1); len(list) != 1 || list[0].ID != files[2].ID {
+		t.Fatalf("Last page, expected mock file %d, got %+v", files[2].ID, list)
+	}
+	if list, _ := page("", 2, total); len(list) != 0 {
+		t.Fatalf("Page beyond end, expected no files, got %+v", list)
+	}
+
+	// Verify each sort field orders files in descending order
+	for _, sort := range []string{"create_time", "update_time", "completed"} {
+		list, _ := page(sort, fileListMaxLimit, 0)
+		for i := 1; i < len(list); i++ {
+			a, b := list[i-1], list[i]
+			if (sort == "create_time" && a.CreateTime < b.CreateTime) ||
+				(sort == "update_time" && a.UpdateTime < b.UpdateTime) ||
+				(sort == "completed" && a.Completed < b.Completed) {
+				t.Fatalf("Sort %s, files out of order: %+v before %+v", sort, a, b)
+			}
+		}
+	}
+
+	// Verify unknown sort fields are rejected
+	if _, _, err := getFileListJSON("info_hash", 1, 0); err != data.ErrInvalidSort {
+		t.Fatalf("Unknown sort field, expected ErrInvalidSort, got: %v", err)
+	}
+
+	// Verify the router caps the limit, and reports the total number of files
+	r, err := http.NewRequest("GET", "http://localhost:8080/api/files?limit=100000", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	w := httptest.NewRecorder()
+	Router(w, r, data.UserRecord{})
+
+	var capped []FileResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &capped); err != nil || len(capped) > fileListMaxLimit {
+		t.Fatalf("Limit above maximum, expected at most %d files, got %d: %v", fileListMaxLimit, len(capped), err)
+	}
+	if w.Header().Get("X-Total-Count") != strconv.Itoa(total) {
+		t.Fatalf("X-Total-Count, expected %d, got %s", total, w.Header().Get("X-Total-Count"))
+	}
+
+	// Delete mock files
+	for _, f := range files {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file: %s", err.Error())
+		}
+	}
+}
+
+// TestGetFileStatsJSON verifies that /api/files/:info_hash/stats returns all swarm statistics, consistent with stored data
+func TestGetFileStatsJSON(t *testing.T) {
+	log.Println("TestGetFileStatsJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (data.FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Generate mock peers: one seeder and one leecher
+	fileUsers := []data.FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Snatched: true, Left: 0},
+		{FileID: file.ID, UserID: 2, IP: "127.0.0.1", Active: true, Completed: false, Left: 100},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Generate mock recent announce
+	announce := data.AnnounceLog{
+		InfoHash: file.InfoHash,
+		IP:       "127.0.0.1",
+		Port:     5000,
+		Time:     time.Now().Unix(),
+	}
+	if err := announce.Save(); err != nil {
+		t.Fatalf("Failed to save mock announce: %s", err.Error())
+	}
+
+	// Request output JSON from API for this file
+	res, err := getFileStatsJSON(file.InfoHash)
+	if err != nil || res == nil {
+		t.Fatalf("Failed to retrieve file stats JSON: %v", err)
+	}
+
+	// Verify all fields are present
+	var fields map[string]interface{}
+	if err := json.Unmarshal(res, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal result JSON: %s", err.Error())
+	}
+	for _, f := range []string{"infoHash", "seeders", "leechers", "snatches", "activePeers", "announceRate"} {
+		if _, ok := fields[f]; !ok {
+			t.Fatalf("Missing field in file stats JSON: %s", f)
+		}
+	}
+
+	// Verify statistics are consistent with stored data
+	var stats data.FileStats
+	if err := json.Unmarshal(res, &stats); err != nil {
+		t.Fatalf("Failed to unmarshal result JSON: %s", err.Error())
+	}
+
+	seeders, leechers, err := file.PeerCounts()
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	completed, err := file.Completed()
+	if err != nil {
+		t.Fatalf("Failed to count completions: %s", err.Error())
+	}
+
+	if stats.Seeders != seeders || stats.Leechers != leechers || stats.Snatches != completed {
+		t.Fatalf("Inconsistent stats: %+v, expected %d seeders, %d leechers, %d snatches", stats, seeders, leechers, completed)
+	}
+	if stats.ActivePeers != seeders+leechers {
+		t.Fatalf("ActivePeers, expected %d, got %d", seeders+leechers, stats.ActivePeers)
+	}
+	if stats.AnnounceRate <= 0 {
+		t.Fatalf("AnnounceRate, expected recent announces, got %f", stats.AnnounceRate)
+	}
+
+	// Verify unknown file returns no output
+	if res, err := getFileStatsJSON("0000000000000000000000000000000000000000"); res != nil || err != nil {
+		t.Fatalf("Expected no output for unknown file, got %s %v", res, err)
+	}
+
+	// Delete mock data
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	announce, err = announce.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock announce: %s", err.Error())
+	}
+	if err := announce.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock announce: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestGetFileJSON verifies that /api/file/:info_hash returns a file with live counts, and that an
+// unknown info_hash returns HTTP 404 with a JSON error body
+func TestGetFileJSON(t *testing.T) {
+	log.Println("TestGetFileJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "676f61745f66696c655f6a736f6e5f3030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (data.FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Generate mock peers: one seeder which completed the file, and one leecher
+	fileUsers := []data.FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Snatched: true, Left: 0},
+		{FileID: file.ID, UserID: 2, IP: "127.0.0.1", Active: true, Completed: false, Left: 100},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Request output JSON from API for this file
+	res, err := getFileJSON(file.InfoHash)
+	if err != nil || res == nil {
+		t.Fatalf("Failed to retrieve file JSON: %v", err)
+	}
+
+	var fileRes FileResponse
+	if err := json.Unmarshal(res, &fileRes); err != nil {
+		t.Fatalf("Failed to unmarshal result JSON: %s", err.Error())
+	}
+
+	// Verify stored fields and live counts are consistent with stored data
+	seeders, leechers, err := file.PeerCounts()
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	completed, err := file.Completed()
+	if err != nil {
+		t.Fatalf("Failed to count completions: %s", err.Error())
+	}
+
+	if fileRes.ID != file.ID || fileRes.InfoHash != file.InfoHash || !fileRes.Verified {
+		t.Fatalf("Mismatched file fields: %+v, expected %+v", fileRes, file)
+	}
+	if fileRes.Seeders != seeders || fileRes.Leechers != leechers || fileRes.Completed != completed {
+		t.Fatalf("Inconsistent counts: %+v, expected %d seeders, %d leechers, %d completed", fileRes, seeders, leechers, completed)
+	}
+
+	// Verify unknown file returns no output
+	unknown := "0000000000000000000000000000000000000000"
+	if res, err := getFileJSON(unknown); res != nil || err != nil {
+		t.Fatalf("Expected no output for unknown file, got %s %v", res, err)
+	}
+
+	// Verify unknown file is reported by the router as HTTP 404, with a JSON error body
+	r, err := http.NewRequest("GET", "http://localhost:8080/api/file/"+unknown, nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	w := httptest.NewRecorder()
+	Router(w, r, data.UserRecord{})
+
+	var errRes map[string]interface{}
+	if w.Code != 404 || json.Unmarshal(w.Body.Bytes(), &errRes) != nil {
+		t.Fatalf("Unknown file, expected HTTP 404 with JSON error, got HTTP %d: %s", w.Code, w.Body.String())
+	}
+
+	// Delete mock data
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestGetFileAnnouncesJSON verifies that /api/files/:info_hash/announces returns recent announces newest-first,
+// with IP addresses redacted
+func TestGetFileAnnouncesJSON(t *testing.T) {
+	log.Println("TestGetFileAnnouncesJSON()")
+
+	// Load config, redacting IP addresses
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Privacy.RedactIP = true
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "616e6e6f756e6365733030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Generate mock announces, oldest first
+	peerIDs := []string{"7065657231", "7065657232", "7065657233"}
+	for _, p := range peerIDs {
+		announce := data.AnnounceLog{
+			InfoHash: file.InfoHash,
+			PeerID:   p,
+			IP:       "127.0.0.1",
+			Port:     5000,
+			Event:    "started",
+		}
+		if err := announce.Save(); err != nil {
+			t.Fatalf("Failed to save mock announce: %s", err.Error())
+		}
+	}
+
+	// Request output JSON from API for this file
+	res, err := getFileAnnouncesJSON(file.InfoHash, 1)
+	if err != nil || res == nil {
+		t.Fatalf("Failed to retrieve file announces JSON: %v", err)
+	}
+
+	var announces []data.JSONAnnounceLog
+	if err := json.Unmarshal(res, &announces); err != nil {
+		t.Fatalf("Failed to unmarshal result JSON: %s", err.Error())
+	}
+	if len(announces) != len(peerIDs) {
+		t.Fatalf("Mismatched announce count, expected %d, got %d", len(peerIDs), len(announces))
+	}
+
+	// Verify announces are newest-first, and redacted
+	for i, a := range announces {
+		if expected := peerIDs[len(peerIDs)-1-i]; a.PeerID != expected {
+			t.Fatalf("Announce %d, expected peer ID %s, got %s", i, expected, a.PeerID)
+		}
+		if i > 0 && (a.Time > announces[i-1].Time || a.ID > announces[i-1].ID) {
+			t.Fatalf("Announces not ordered newest-first: %+v", announces)
+		}
+		if a.IP != redacted {
+			t.Fatalf("Announce IP not redacted: %s", a.IP)
+		}
+	}
+
+	// Verify page beyond the last announce is empty
+	res, err = getFileAnnouncesJSON(file.InfoHash, 2)
+	if err != nil || string(res) != "[]" {
+		t.Fatalf("Expected empty second page, got %s %v", res, err)
+	}
+
+	// Verify unknown file returns no output
+	if res, err := getFileAnnouncesJSON("0000000000000000000000000000000000000000", 1); res != nil || err != nil {
+		t.Fatalf("Expected no output for unknown file, got %s %v", res, err)
+	}
+
+	// Delete mock data
+	for _, a := range announces {
+		if err := (data.AnnounceLog{ID: a.ID}).Delete(); err != nil {
+			t.Fatalf("Failed to delete mock announce: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestPostFilePeersJSON verifies that a peer injected via /api/files/:info_hash/peers appears in the compact peer list
+func TestPostFilePeersJSON(t *testing.T) {
+	log.Println("TestPostFilePeersJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6265656664656164303030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (data.FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	session := data.UserRecord{ID: 1, Admin: true}
+
+	// Verify invalid input is rejected
+	for _, body := range []string{
+		`{"ip":"10.0.0.1"`,
+		`{"ip":"abc","port":6881}`,
+		`{"ip":"::1","port":6881}`,
+		`{"ip":"10.0.0.1","port":0}`,
+		`{"ip":"10.0.0.1","port":65536}`,
+	} {
+		if clientErr, serverErr := postFilePeersJSON(file.InfoHash, session, []byte(body)); clientErr == "" || serverErr != nil {
+			t.Fatalf("Expected client error for %s, got %q %v", body, clientErr, serverErr)
+		}
+	}
+
+	// Verify unknown file is rejected
+	body := []byte(`{"ip":"10.0.0.1","port":6881,"seeder":true}`)
+	if clientErr, _ := postFilePeersJSON("0000000000000000000000000000000000000000", session, body); clientErr != "No such file" {
+		t.Fatalf("Expected no such file, got %q", clientErr)
+	}
+
+	// Inject mock peer
+	if clientErr, serverErr := postFilePeersJSON(file.InfoHash, session, body); clientErr != "" || serverErr != nil {
+		t.Fatalf("Failed to inject peer: %q %v", clientErr, serverErr)
+	}
+
+	// Verify injected peer appears in compact peer list
+	peers, _, err := file.CompactPeerList("", true, 50, true)
+	if err != nil {
+		t.Fatalf("Failed to retrieve compact peer list: %s", err.Error())
+	}
+	peer, err := data.Peer{IP: "10.0.0.1", Port: 6881}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal peer: %s", err.Error())
+	}
+	if !bytes.Contains(peers, peer) {
+		t.Fatalf("Injected peer not found in compact peer list: %v", peers)
+	}
+
+	// Verify injected peer is a seeder
+	seeders, _, err := file.PeerCounts()
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 1 {
+		t.Fatalf("Seeders, expected 1, got %d", seeders)
+	}
+
+	// Delete mock data
+	fileUser, err := new(data.FileUserRecord).Load(file.ID, session.ID, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to load mock file user: %s", err.Error())
+	}
+	if err := fileUser.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file user: %s", err.Error())
+	}
+	announce, err := new(data.AnnounceLog).Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock announce: %s", err.Error())
+	}
+	if err := announce.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock announce: %s", err.Error())
+	}
 	if err := file.Delete(); err != nil {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
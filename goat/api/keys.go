@@ -0,0 +1,26 @@
+package api
+
+import (
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// postKey generates a new API key for the session user, returning it as JSON, exactly as a login does.
+// The secret is only ever returned in this response.
+func postKey(session data.UserRecord) ([]byte, error) {
+	return postLogin(session)
+}
+
+// deleteKey revokes one of the session user's API keys by pubkey, returning a client string/server
+// error pair
+func deleteKey(session data.UserRecord, pubkey string) (string, error) {
+	// Load key, which may only be revoked by the user to whom it was issued
+	key, err := new(data.APIKey).Load(pubkey, "pubkey")
+	if err == data.ErrNotFound || (err == nil && key.UserID != session.ID) {
+		return "No such API key", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "", key.Delete()
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// TestKey verifies that API keys may be created via POST /api/key, and revoked only by their owner
+// via DELETE /api/key/{pubkey}
+func TestKey(t *testing.T) {
+	log.Println("TestKey()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// request performs an API request as a user, returning the response
+	request := func(method string, url string, session data.UserRecord) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "http://localhost:8080"+url, nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		Router(w, r, session)
+		return w
+	}
+
+	// Create two API keys for a mock user, verifying each is returned with its secret
+	session := data.UserRecord{ID: 1, Username: "test"}
+	keys := make([]data.JSONAPIKey, 2)
+	for i := range keys {
+		w := request("POST", "/api/key", session)
+		if w.Code != 200 {
+			t.Fatalf("POST /api/key, expected HTTP 200, got HTTP %d: %s", w.Code, w.Body.String())
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &keys[i]); err != nil {
+			t.Fatalf("Failed to unmarshal key JSON: %s", err.Error())
+		}
+		if keys[i].UserID != session.ID || keys[i].Pubkey == "" || keys[i].Secret == "" {
+			t.Fatalf("Invalid API key: %+v", keys[i])
+		}
+	}
+
+	// Verify each key is unique
+	if keys[0].Pubkey == keys[1].Pubkey || keys[0].Secret == keys[1].Secret {
+		t.Fatalf("Duplicate API keys issued: %+v", keys)
+	}
+
+	// Verify a key may not be revoked by another user
+	if w := request("DELETE", "/api/key/"+keys[0].Pubkey, data.UserRecord{ID: 2}); w.Code != 404 {
+		t.Fatalf("DELETE /api/key by another user, expected HTTP 404, got HTTP %d", w.Code)
+	}
+
+	// Verify keys may be revoked by their owner, only once
+	for _, k := range keys {
+		if w := request("DELETE", "/api/key/"+k.Pubkey, session); w.Code != 204 {
+			t.Fatalf("DELETE /api/key, expected HTTP 204, got HTTP %d: %s", w.Code, w.Body.String())
+		}
+
+		if _, err := new(data.APIKey).Load(k.Pubkey, "pubkey"); err != data.ErrNotFound {
+			t.Fatalf("Revoked API key, expected ErrNotFound, got: %v", err)
+		}
+
+		if w := request("DELETE", "/api/key/"+k.Pubkey, session); w.Code != 404 {
+			t.Fatalf("Repeated DELETE /api/key, expected HTTP 404, got HTTP %d", w.Code)
+		}
+	}
+}
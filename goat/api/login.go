@@ -8,14 +8,9 @@ import (
 
 // postLogin generates a new API key for this user
 func postLogin(session data.UserRecord) ([]byte, error) {
-	// Create key for this user's session
-	key := new(data.APIKey)
-	if err := key.Create(session.ID); err != nil {
-		return nil, err
-	}
-
-	// Store key in database
-	if err := key.Save(); err != nil {
+	// Create and store key for this user's session
+	key, err := data.NewAPIKey(session.ID)
+	if err != nil {
 		return nil, err
 	}
 
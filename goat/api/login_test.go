@@ -14,7 +14,7 @@ func TestPostLogin(t *testing.T) {
 	log.Println("TestPostLogin()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// maxPasskeys is the maximum number of additional passkeys which may be issued to a single user
+const maxPasskeys = 10
+
+// getPasskeysJSON returns a JSON representation of the additional passkeys issued to the session user
+func getPasskeysJSON(session data.UserRecord) ([]byte, error) {
+	// Load user's passkeys
+	passkeys, err := session.Passkeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create JSON representations
+	jsonPasskeys := make([]data.JSONPasskeyRecord, 0)
+	for _, p := range passkeys {
+		j, err := p.ToJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		jsonPasskeys = append(jsonPasskeys[:], j)
+	}
+
+	// Marshal into JSON
+	return json.Marshal(jsonPasskeys)
+}
+
+// postPasskeys issues an additional passkey to the session user, returning a client string/server error pair
+func postPasskeys(session data.UserRecord) (string, error) {
+	// Enforce limit on number of passkeys per user
+	passkeys, err := session.Passkeys()
+	if err != nil {
+		return "", err
+	}
+	if len(passkeys) >= maxPasskeys {
+		return "Passkey limit reached", nil
+	}
+
+	// Generate and save new passkey
+	passkey := new(data.PasskeyRecord)
+	if err := passkey.Create(session.ID); err != nil {
+		return "", err
+	}
+
+	return "", passkey.Save()
+}
+
+// revokePasskey represents the input JSON used to revoke an additional passkey
+type revokePasskey struct {
+	Passkey string `json:"passkey"`
+}
+
+// postPasskeysRevokeJSON revokes one of the session user's additional passkeys from a JSON body, returning
+// a client string/server error pair
+func postPasskeysRevokeJSON(session data.UserRecord, body []byte) (string, error) {
+	// Unmarshal JSON from body
+	var revoke revokePasskey
+	if err := json.Unmarshal(body, &revoke); err != nil {
+		return "Malformed request JSON", nil
+	}
+
+	// Check for valid input
+	if revoke.Passkey == "" {
+		return "Missing required parameters: passkey", nil
+	}
+
+	// Load passkey, which may only be revoked by the user to whom it was issued
+	passkey, err := new(data.PasskeyRecord).Load(revoke.Passkey, "passkey")
+	if err == data.ErrNotFound || (err == nil && passkey.UserID != session.ID) {
+		return "No such passkey", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "", passkey.Delete()
+}
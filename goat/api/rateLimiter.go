@@ -0,0 +1,122 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// rateLimitPruneInterval is the minimum time between removals of idle token buckets
+const rateLimitPruneInterval = time.Minute
+
+// tokenBucket contains the tokens available to a single client, as of the last time it was refilled
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits API calls using a token bucket for each client, refilled at the configured
+// rate, up to the configured burst.  Buckets are stored in memory, and idle buckets are discarded.
+type RateLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// Limiter is the rate limiter used for all API calls
+var Limiter = NewRateLimiter()
+
+// NewRateLimiter creates a RateLimiter with no buckets
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow reports whether a call by the client with the specified key may proceed, consuming a token
+// if so.  If not, the time until a token is available is also returned.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	return l.take(key, true)
+}
+
+// Peek reports whether a call by the client with the specified key may proceed, without consuming
+// a token.  If not, the time until a token is available is also returned.
+func (l *RateLimiter) Peek(key string) (bool, time.Duration) {
+	return l.take(key, false)
+}
+
+// take refills the bucket for the client with the specified key, and reports whether a token is
+// available, consuming it if requested
+func (l *RateLimiter) take(key string, consume bool) (bool, time.Duration) {
+	conf := common.Static.Config.RateLimit
+	if !conf.Enabled {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := common.Now()
+	burst := float64(conf.Burst)
+
+	// Discard buckets which have been idle long enough to refill completely, as they are
+	// equivalent to new buckets
+	if now.Sub(l.pruned) >= rateLimitPruneInterval {
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*conf.Rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+
+		l.pruned = now
+	}
+
+	// New clients start with a full bucket, and existing buckets are refilled for the time elapsed
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*conf.Rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		if consume {
+			b.tokens--
+		}
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / conf.Rate * float64(time.Second))
+}
+
+// RateLimited checks the rate limit for the client with the specified key, and if it is exceeded,
+// writes HTTP 429 with a Retry-After header and returns true
+func RateLimited(w http.ResponseWriter, key string) bool {
+	ok, wait := Limiter.Allow(key)
+	return rateLimitError(w, ok, wait)
+}
+
+// RateLimitExhausted checks the rate limit for the client with the specified key without consuming a
+// token, and if no token is available, writes HTTP 429 with a Retry-After header and returns true
+func RateLimitExhausted(w http.ResponseWriter, key string) bool {
+	ok, wait := Limiter.Peek(key)
+	return rateLimitError(w, ok, wait)
+}
+
+// rateLimitError writes HTTP 429 with a Retry-After header and returns true, if a call is not allowed
+func rateLimitError(w http.ResponseWriter, ok bool, wait time.Duration) bool {
+	if ok {
+		return false
+	}
+
+	// Retry-After is specified in whole seconds, so round up
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, ErrorResponse("Rate limit exceeded"), 429)
+	return true
+}
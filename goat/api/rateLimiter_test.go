@@ -0,0 +1,94 @@
+package api
+
+import (
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestRateLimiter verifies that API calls are limited to the configured burst, refilled at the configured
+// rate, and that clients exceeding the limit receive HTTP 429 with a Retry-After header
+func TestRateLimiter(t *testing.T) {
+	log.Println("TestRateLimiter()")
+
+	// Use a fake clock, restoring the default afterwards
+	now := time.Unix(1400000000, 0)
+	common.Now = func() time.Time {
+		return now
+	}
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Allow bursts of 2 calls, refilled at 1 call per second, using a fresh limiter
+	common.Static.Config.RateLimit.Enabled = true
+	common.Static.Config.RateLimit.Rate = 1
+	common.Static.Config.RateLimit.Burst = 2
+	defer func() {
+		common.Static.Config.RateLimit.Enabled = false
+	}()
+
+	limiter := Limiter
+	Limiter = NewRateLimiter()
+	defer func() {
+		Limiter = limiter
+	}()
+
+	// Verify a full burst is allowed, and the following call is not
+	for i := 0; i < 2; i++ {
+		if ok, _ := Limiter.Allow("pubkey:test"); !ok {
+			t.Fatalf("Call %d within burst was limited", i)
+		}
+	}
+	if ok, wait := Limiter.Allow("pubkey:test"); ok || wait != time.Second {
+		t.Fatalf("Call exceeding burst, expected limited for 1s, got allowed %v, wait %s", ok, wait)
+	}
+
+	// Verify peeking reports an exhausted bucket, without consuming a token
+	if ok, _ := Limiter.Peek("pubkey:test"); ok {
+		t.Fatalf("Peek at exhausted bucket reported a token available")
+	}
+	if ok, _ := Limiter.Peek("ip:127.0.0.1"); !ok {
+		t.Fatalf("Peek at new bucket reported no token available")
+	}
+
+	// Verify other clients have their own bucket
+	if ok, _ := Limiter.Allow("ip:127.0.0.1"); !ok {
+		t.Fatalf("Call from another client was limited")
+	}
+
+	// Verify HTTP 429 is returned with a Retry-After header, rounded up to whole seconds
+	now = now.Add(500 * time.Millisecond)
+	w := httptest.NewRecorder()
+	if !RateLimited(w, "pubkey:test") {
+		t.Fatalf("Call exceeding rate was not limited")
+	}
+	if w.Code != 429 || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Limited call, expected HTTP 429 with Retry-After 1, got HTTP %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Verify a token is available once the bucket has refilled
+	now = now.Add(time.Second)
+	w = httptest.NewRecorder()
+	if RateLimited(w, "pubkey:test") {
+		t.Fatalf("Call after refill was limited: HTTP %d", w.Code)
+	}
+
+	// Verify idle buckets are discarded
+	now = now.Add(2 * rateLimitPruneInterval)
+	Limiter.Allow("ip:127.0.0.2")
+	if _, ok := Limiter.buckets["pubkey:test"]; ok {
+		t.Fatalf("Idle bucket was not discarded")
+	}
+
+	// Verify calls are never limited when rate limiting is disabled
+	common.Static.Config.RateLimit.Enabled = false
+	for i := 0; i < 10; i++ {
+		if ok, _ := Limiter.Allow("pubkey:test"); !ok {
+			t.Fatalf("Call was limited with rate limiting disabled")
+		}
+	}
+}
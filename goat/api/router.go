@@ -21,8 +21,12 @@ type Error struct {
 func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 	// API allows the following HTTP methods:
 	//   - GET: read-only access to data
+	//   - HEAD: same as GET, but only headers are sent to the client
 	//   - POST: create a new item via an API endpoint
-	if r.Method != "GET" && r.Method != "POST" {
+	//   - PUT: replace an item via an API endpoint
+	//   - DELETE: remove an item via an API endpoint
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "POST" && r.Method != "PUT" && r.Method != "DELETE" {
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT, DELETE")
 		http.Error(w, ErrorResponse("Method not allowed"), 405)
 		return
 	}
@@ -42,16 +46,29 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 	// API method
 	apiMethod := urlArr[2]
 
-	// Response buffer
+	// Disabled users may not make API calls
+	if session.Banned {
+		http.Error(w, ErrorResponse("Account disabled"), 403)
+		return
+	}
+
+	// Administrative API calls may only be made by administrators
+	if apiMethod == "admin" && !session.Admin {
+		http.Error(w, ErrorResponse("Administrator access required"), 403)
+		return
+	}
+
+	// Response buffer and HTTP status code
 	res := make([]byte, 0)
+	code := 200
 
-	// HTTP GET
-	if r.Method == "GET" {
+	// HTTP GET (or HEAD, which is treated as GET)
+	if r.Method == "GET" || r.Method == "HEAD" {
 		// Default value retrieves all records
 		ID := -1
 
-		// Check for an ID
-		if len(urlArr) == 4 {
+		// Check for an ID, except on administrative calls and those which use an info_hash
+		if len(urlArr) == 4 && apiMethod != "admin" && apiMethod != "file" {
 			i, err := strconv.Atoi(urlArr[3])
 			if err != nil || i < 1 {
 				http.Error(w, ErrorResponse("Invalid integer ID"), 400)
@@ -66,15 +83,123 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 
 		// Choose API method
 		switch apiMethod {
+		// Administrative calls
+		case "admin":
+			var adminCall string
+			if len(urlArr) == 4 {
+				adminCall = urlArr[3]
+			}
+
+			switch adminCall {
+			// Effective runtime configuration
+			case "config":
+				res, err = getAdminConfigJSON()
+			// Return error response
+			default:
+				http.Error(w, ErrorResponse("Undefined API call: GET /api/admin/"+adminCall), 404)
+				return
+			}
+		// Single file on tracker, by info_hash
+		case "file":
+			if len(urlArr) != 4 || urlArr[3] == "" {
+				http.Error(w, ErrorResponse("No info_hash"), 404)
+				return
+			}
+
+			res, err = getFileJSON(urlArr[3])
+			if err == nil && res == nil {
+				http.Error(w, ErrorResponse("No such file"), 404)
+				return
+			}
 		// Files on tracker
 		case "files":
-			res, err = getFilesJSON(ID)
+			// Swarm statistics for a file, by info_hash
+			if len(urlArr) == 5 && urlArr[4] == "stats" {
+				res, err = getFileStatsJSON(urlArr[3])
+				if err == nil && res == nil {
+					http.Error(w, ErrorResponse("No such file"), 404)
+					return
+				}
+			} else if len(urlArr) == 5 && urlArr[4] == "announces" {
+				// Announce logs expose peer activity, so administrator access is required
+				if !session.Admin {
+					http.Error(w, ErrorResponse("Administrator access required"), 403)
+					return
+				}
+
+				// Check for a valid page number, defaulting to the first page
+				page := 1
+				if p := r.URL.Query().Get("page"); p != "" {
+					i, err := strconv.Atoi(p)
+					if err != nil || i < 1 {
+						http.Error(w, ErrorResponse("Invalid integer page"), 400)
+						return
+					}
+
+					page = i
+				}
+
+				res, err = getFileAnnouncesJSON(urlArr[3], page)
+				if err == nil && res == nil {
+					http.Error(w, ErrorResponse("No such file"), 404)
+					return
+				}
+			} else if ID > 0 {
+				res, err = getFilesJSON(ID)
+				if err == nil && res == nil {
+					http.Error(w, ErrorResponse("No such file"), 404)
+					return
+				}
+			} else {
+				// Check for a valid limit, capped at the maximum, and offset
+				query := r.URL.Query()
+				limit, offset := fileListDefaultLimit, 0
+				if l := query.Get("limit"); l != "" {
+					i, err := strconv.Atoi(l)
+					if err != nil || i < 1 {
+						http.Error(w, ErrorResponse("Invalid integer limit"), 400)
+						return
+					}
+
+					limit = i
+				}
+				if limit > fileListMaxLimit {
+					limit = fileListMaxLimit
+				}
+				if o := query.Get("offset"); o != "" {
+					i, err := strconv.Atoi(o)
+					if err != nil || i < 0 {
+						http.Error(w, ErrorResponse("Invalid integer offset"), 400)
+						return
+					}
+
+					offset = i
+				}
+
+				// Report total number of files in a header, so clients may paginate
+				var total int
+				res, total, err = getFileListJSON(query.Get("sort"), limit, offset)
+				if err == data.ErrInvalidSort {
+					http.Error(w, ErrorResponse("Invalid sort field"), 400)
+					return
+				}
+				if err == nil {
+					w.Header().Set("X-Total-Count", strconv.Itoa(total))
+				}
+			}
+		// Additional passkeys issued to this user
+		case "passkeys":
+			res, err = getPasskeysJSON(session)
 		// Server status
 		case "status":
 			res, err = getStatusJSON()
 		// Users registered to tracker
 		case "users":
 			res, err = getUsersJSON(ID)
+			if err == nil && res == nil {
+				http.Error(w, ErrorResponse("No such user"), 404)
+				return
+			}
 		// Return error response
 		default:
 			http.Error(w, ErrorResponse("Undefined API call: GET /api/"+apiMethod), 404)
@@ -99,6 +224,107 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 			http.Error(w, ErrorResponse("API failure: POST /api/login"), 500)
 			return
 		}
+	} else if r.Method == "POST" && apiMethod == "user" {
+		// Special case: POST /api/user
+		// Register a new user, returning its ID and passkey
+		body, readErr := ioutil.ReadAll(r.Body)
+		if readErr != nil {
+			http.Error(w, ErrorResponse("Malformed request body"), 400)
+			return
+		}
+
+		var err error
+		code, res, err = postUserJSON(body)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, ErrorResponse("API failure: POST /api/user"), 500)
+			return
+		}
+
+		// Check for client error
+		if code >= 400 {
+			http.Error(w, string(res), code)
+			return
+		}
+	} else if r.Method == "POST" && apiMethod == "key" {
+		// Special case: POST /api/key
+		// Generate a new API key for this user, returning its secret
+		var err error
+		res, err = postKey(session)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, ErrorResponse("API failure: POST /api/key"), 500)
+			return
+		}
+	} else if r.Method == "PUT" {
+		// HTTP PUT
+		// Only changing the session user's password is permitted
+		if apiMethod != "user" || len(urlArr) != 4 || urlArr[3] != "password" {
+			http.Error(w, ErrorResponse("Undefined API call: PUT "+r.URL.Path), 404)
+			return
+		}
+
+		body, readErr := ioutil.ReadAll(r.Body)
+		if readErr != nil {
+			http.Error(w, ErrorResponse("Malformed request body"), 400)
+			return
+		}
+
+		var err error
+		code, res, err = putUserPasswordJSON(session, body)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, ErrorResponse("API failure: PUT /api/user/password"), 500)
+			return
+		}
+
+		// Check for client error
+		if code >= 400 {
+			http.Error(w, string(res), code)
+			return
+		}
+
+		// Return HTTP 204 on success
+		http.Error(w, "", 204)
+		return
+	} else if r.Method == "DELETE" {
+		// HTTP DELETE
+		var clientErr string
+		var serverErr error
+
+		// Choose API method
+		switch apiMethod {
+		// API keys issued to this user
+		case "key":
+			// Revoke an API key, by pubkey
+			if len(urlArr) != 4 || urlArr[3] == "" {
+				http.Error(w, ErrorResponse("No API key"), 404)
+				return
+			}
+
+			clientErr, serverErr = deleteKey(session, urlArr[3])
+		// Return error response
+		default:
+			http.Error(w, ErrorResponse("Undefined API call: DELETE /api/"+apiMethod), 404)
+			return
+		}
+
+		// Check for client string error, which only occurs for missing items
+		if clientErr != "" {
+			http.Error(w, ErrorResponse(clientErr), 404)
+			return
+		}
+
+		// Check for server error
+		if serverErr != nil {
+			log.Println(serverErr.Error())
+			http.Error(w, ErrorResponse("API failure: DELETE /api/"+apiMethod), 500)
+			return
+		}
+
+		// Return HTTP 204 on success
+		http.Error(w, "", 204)
+		return
 	} else if r.Method == "POST" {
 		// HTTP POST
 		// Attempt to read the request body
@@ -114,8 +340,67 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 
 		// Choose API method
 		switch apiMethod {
+		// Administrative calls
+		case "admin":
+			var adminCall string
+			if len(urlArr) == 4 {
+				adminCall = urlArr[3]
+			}
+
+			switch adminCall {
+			// Enable or disable a user
+			case "ban":
+				clientErr, serverErr = postAdminBanJSON(body)
+			// Enable or disable maintenance mode
+			case "maintenance":
+				clientErr, serverErr = postAdminMaintenanceJSON(body)
+			// Return error response
+			default:
+				http.Error(w, ErrorResponse("Undefined API call: POST /api/admin/"+adminCall), 404)
+				return
+			}
+		// Files on tracker
+		case "files":
+			// Only injecting peers into a file's swarm is permitted
+			if len(urlArr) != 5 || urlArr[4] != "peers" {
+				http.Error(w, ErrorResponse("Undefined API call: POST /api/files"), 404)
+				return
+			}
+
+			// Injected peers are visible to all clients, so administrator access is required
+			if !session.Admin {
+				http.Error(w, ErrorResponse("Administrator access required"), 403)
+				return
+			}
+
+			clientErr, serverErr = postFilePeersJSON(urlArr[3], session, body)
+		// Additional passkeys issued to this user
+		case "passkeys":
+			var passkeyCall string
+			if len(urlArr) == 4 {
+				passkeyCall = urlArr[3]
+			}
+
+			switch passkeyCall {
+			// Issue a new passkey
+			case "":
+				clientErr, serverErr = postPasskeys(session)
+			// Revoke an existing passkey
+			case "revoke":
+				clientErr, serverErr = postPasskeysRevokeJSON(session, body)
+			// Return error response
+			default:
+				http.Error(w, ErrorResponse("Undefined API call: POST /api/passkeys/"+passkeyCall), 404)
+				return
+			}
 		// Users registered to tracker
 		case "users":
+			// Users created here bypass the registration policy, so administrator access is required
+			if !session.Admin {
+				http.Error(w, ErrorResponse("Administrator access required"), 403)
+				return
+			}
+
 			// Attempt to create user from JSON
 			clientErr, serverErr = postUsersJSON(body)
 		// Return error response
@@ -145,6 +430,7 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 	// If requested, compress response using gzip
 	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 		w.Header().Add("Content-Encoding", "gzip")
+		w.WriteHeader(code)
 
 		// Write gzip'd response
 		gz := gzip.NewWriter(w)
@@ -160,6 +446,7 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 		return
 	}
 
+	w.WriteHeader(code)
 	if _, err := w.Write(res); err != nil {
 		log.Println(err.Error())
 	}
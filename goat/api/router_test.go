@@ -19,11 +19,24 @@ var apiTests = []struct {
 	{"GET", "/api/files/a", 400},
 	{"GET", "/api/abcdef", 404},
 	{"GET", "/api/files", 200},
+	{"GET", "/api/files?limit=0", 400},
+	{"GET", "/api/files?limit=a", 400},
+	{"GET", "/api/files?offset=-1", 400},
+	{"GET", "/api/files?sort=info_hash", 400},
+	{"GET", "/api/files?sort=completed&limit=10&offset=0", 200},
 	{"GET", "/api/files/1", 200},
+	{"GET", "/api/file/0000000000000000000000000000000000000000", 404},
 	{"GET", "/api/status", 200},
 	{"GET", "/api/users", 200},
 	{"GET", "/api/users/1", 200},
-	{"PUT", "/api/", 405},
+	{"GET", "/api/admin/config", 403},
+	{"POST", "/api/users", 403},
+	{"HEAD", "/api/status", 200},
+	{"DELETE", "/api/abcdef", 404},
+	{"DELETE", "/api/key", 404},
+	{"PUT", "/api/abcdef", 404},
+	{"PUT", "/api/user", 404},
+	{"PATCH", "/api/", 405},
 }
 
 // TestRouter verifies that the API router is working properly
@@ -49,7 +62,42 @@ func TestRouter(t *testing.T) {
 			t.Fatalf("Test %s %s, expected HTTP %d, got HTTP %d", test.method, test.url, test.code, w.Code)
 		}
 
+		// Verify allowed methods are reported when method is not allowed
+		if w.Code == 405 && w.Header().Get("Allow") == "" {
+			t.Fatalf("Test %s %s, expected Allow header with HTTP 405", test.method, test.url)
+		}
+
 		log.Printf("OK - %s %s -> HTTP %d", test.method, test.url, w.Code)
 		log.Printf(w.Body.String())
 	}
 }
+
+// TestRouterBanned verifies that API calls from disabled users are rejected
+func TestRouterBanned(t *testing.T) {
+	log.Println("TestRouterBanned()")
+
+	// Iterate enabled and disabled users
+	for _, test := range []struct {
+		banned bool
+		code   int
+	}{
+		{false, 200},
+		{true, 403},
+	} {
+		// Generate mock HTTP request
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+
+		// Capture HTTP writer response with recorder
+		w := httptest.NewRecorder()
+
+		// Invoke API router
+		Router(w, r, data.UserRecord{ID: 1, Banned: test.banned})
+
+		if w.Code != test.code {
+			t.Fatalf("Banned %t, expected HTTP %d, got HTTP %d", test.banned, test.code, w.Code)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 
+	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 )
 
-// postUsersJSON creates a user from a JSON body, returning a client string/server error pair
+// postUsersJSON creates a user from a JSON body, with any torrent limit, returning a client string/server
+// error pair.  Only administrators may make this call.
 func postUsersJSON(body []byte) (string, error) {
 	// Unmarshal JSON from body
 	var jsonUser data.UserRecord
@@ -19,6 +21,16 @@ func postUsersJSON(body []byte) (string, error) {
 		return "Missing required parameters: username, password, torrentLimit", nil
 	}
 
+	// Validate username, reporting invalid usernames to client
+	if err := data.ValidateUsername(jsonUser.Username); err != nil {
+		return "Invalid username: " + err.Error(), nil
+	}
+
+	// Validate password, reporting invalid passwords to client
+	if err := data.ValidatePassword(jsonUser.Password); err != nil {
+		return "Invalid password: " + err.Error(), nil
+	}
+
 	// Create user from input
 	user := new(data.UserRecord)
 	if err := user.Create(jsonUser.Username, jsonUser.Password, jsonUser.TorrentLimit); err != nil {
@@ -27,18 +39,168 @@ func postUsersJSON(body []byte) (string, error) {
 
 	// Save user to database
 	if err := user.Save(); err != nil {
+		// Report duplicate usernames to client
+		if err == data.ErrUsernameTaken {
+			return "Invalid username: " + err.Error(), nil
+		}
+
 		return "", err
 	}
 
 	return "", nil
 }
 
-// getUsersJSON returns a JSON representation of one or more data.UserRecords
+// registerUser represents the input JSON used to register a user
+type registerUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// registeredUser represents the output JSON returned to a newly registered user
+type registeredUser struct {
+	ID      int    `json:"id"`
+	Passkey string `json:"passkey"`
+}
+
+// postUserJSON registers a user from a JSON body, if registration is enabled, returning an HTTP status
+// code and response.  Client errors are returned as an error response with a client error status code.
+func postUserJSON(body []byte) (int, []byte, error) {
+	// clientError generates an error response for the client
+	clientError := func(code int, msg string) (int, []byte, error) {
+		return code, []byte(ErrorResponse(msg)), nil
+	}
+
+	// Check if registration enabled
+	if !common.Static.Config.Users.Registration {
+		return clientError(403, "Registration is disabled")
+	}
+
+	// Unmarshal JSON from body
+	var input registerUser
+	if err := json.Unmarshal(body, &input); err != nil {
+		return clientError(400, "Malformed request JSON")
+	}
+
+	// Check for valid input
+	if input.Username == "" || input.Password == "" {
+		return clientError(400, "Missing required parameters: username, password")
+	}
+
+	// Validate username and password, reporting invalid input to client
+	if err := data.ValidateUsername(input.Username); err != nil {
+		return clientError(400, "Invalid username: "+err.Error())
+	}
+	if err := data.ValidatePassword(input.Password); err != nil {
+		return clientError(400, "Invalid password: "+err.Error())
+	}
+
+	// Create user from input, with the configured torrent limit
+	user := new(data.UserRecord)
+	if err := user.Create(input.Username, input.Password, common.Static.Config.Users.TorrentLimit); err != nil {
+		return 0, nil, err
+	}
+
+	// Save user to database, reporting duplicate usernames to client
+	if err := user.Save(); err != nil {
+		if err == data.ErrUsernameTaken {
+			return clientError(409, "Invalid username: "+err.Error())
+		}
+
+		return 0, nil, err
+	}
+
+	// Load user to fetch ID
+	saved, err := user.Load(user.Username, "username")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Marshal into JSON
+	res, err := json.Marshal(registeredUser{
+		ID:      saved.ID,
+		Passkey: saved.Passkey,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return 201, res, nil
+}
+
+// changePassword represents the input JSON used to change a user's password
+type changePassword struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+	RevokeKeys  bool   `json:"revokeKeys"`
+}
+
+// putUserPasswordJSON changes the session user's password from a JSON body, returning an HTTP status
+// code and response.  The old password must match the stored hash, and if requested, all of the user's
+// API keys are revoked once the new password is saved.  Client errors are returned as an error
+// response with a client error status code.
+func putUserPasswordJSON(session data.UserRecord, body []byte) (int, []byte, error) {
+	// clientError generates an error response for the client
+	clientError := func(code int, msg string) (int, []byte, error) {
+		return code, []byte(ErrorResponse(msg)), nil
+	}
+
+	// Unmarshal JSON from body
+	var input changePassword
+	if err := json.Unmarshal(body, &input); err != nil {
+		return clientError(400, "Malformed request JSON")
+	}
+
+	// Check for valid input
+	if input.OldPassword == "" || input.NewPassword == "" {
+		return clientError(400, "Missing required parameters: oldPassword, newPassword")
+	}
+
+	// Verify old password against the stored hash
+	if err := data.ComparePassword(session.Password, input.OldPassword); err != nil {
+		if err == data.ErrPasswordMismatch {
+			return clientError(403, "Incorrect password")
+		}
+
+		return 0, nil, err
+	}
+
+	// Validate new password, reporting invalid passwords to client
+	if err := data.ValidatePassword(input.NewPassword); err != nil {
+		return clientError(400, "Invalid password: "+err.Error())
+	}
+
+	// Store hash of new password
+	hash, err := data.HashPassword(input.NewPassword)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	user := session
+	user.Password = hash
+	if err := user.Save(); err != nil {
+		return 0, nil, err
+	}
+
+	// If requested, revoke API keys issued using the old password
+	if input.RevokeKeys {
+		if err := user.DeleteAPIKeys(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return 204, nil, nil
+}
+
+// getUsersJSON returns a JSON representation of one or more data.UserRecords, or no output if a
+// single user is requested, and no such user exists
 func getUsersJSON(ID int) ([]byte, error) {
 	// Check for a valid integer ID
 	if ID > 0 {
 		// Load user
 		user, err := new(data.UserRecord).Load(ID, "id")
+		if err == data.ErrNotFound {
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
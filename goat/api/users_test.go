@@ -5,6 +5,7 @@ import (
 	"log"
 	"testing"
 
+	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 )
@@ -14,7 +15,7 @@ func TestGetUsersJSON(t *testing.T) {
 	log.Println("TestGetUsersJSON()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -85,3 +86,200 @@ func TestGetUsersJSON(t *testing.T) {
 		t.Fatalf("Failed to delete mock user: %s", err.Error())
 	}
 }
+
+// postUserTests contains registration request bodies which must be rejected before reaching the
+// database, and the expected HTTP status code
+var postUserTests = []struct {
+	description string
+	body        string
+	code        int
+}{
+	{"malformed JSON", `{"username":`, 400},
+	{"missing username", `{"password":"password"}`, 400},
+	{"missing password", `{"username":"test"}`, 400},
+	{"short username", `{"username":"a","password":"password"}`, 400},
+	{"invalid username", `{"username":"test user","password":"password"}`, 400},
+	{"short password", `{"username":"test","password":"pass"}`, 400},
+}
+
+// TestPostUserJSONRejected verifies that registration is refused when disabled, and that invalid
+// usernames and passwords are rejected
+func TestPostUserJSONRejected(t *testing.T) {
+	log.Println("TestPostUserJSONRejected()")
+
+	// Verify registration is refused when disabled
+	common.Static.Config = common.DefaultConfig()
+	if code, _, err := postUserJSON([]byte(`{"username":"test","password":"password"}`)); code != 403 || err != nil {
+		t.Fatalf("Registration while disabled, expected HTTP 403, got HTTP %d: %v", code, err)
+	}
+
+	// Iterate all tests, with registration enabled
+	common.Static.Config.Users.Registration = true
+	for _, test := range postUserTests {
+		code, res, err := postUserJSON([]byte(test.body))
+		if err != nil {
+			t.Fatalf("Registration with %s, unexpected server error: %s", test.description, err.Error())
+		}
+		if code != test.code {
+			t.Fatalf("Registration with %s, expected HTTP %d, got HTTP %d: %s", test.description, test.code, code, string(res))
+		}
+	}
+}
+
+// TestPostUserJSON verifies that a user may register, receiving their ID and passkey, and that
+// duplicate usernames are rejected
+func TestPostUserJSON(t *testing.T) {
+	log.Println("TestPostUserJSON()")
+
+	// Load config, with registration enabled
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Users.Registration = true
+	common.Static.Config = config
+
+	// Register mock user
+	code, res, err := postUserJSON([]byte(`{"username":"Register","password":"password"}`))
+	if err != nil {
+		t.Fatalf("Failed to register user: %s", err.Error())
+	}
+	if code != 201 {
+		t.Fatalf("Registration, expected HTTP 201, got HTTP %d: %s", code, string(res))
+	}
+
+	var registered registeredUser
+	if err := json.Unmarshal(res, &registered); err != nil {
+		t.Fatalf("Failed to unmarshal registration JSON: %s", err.Error())
+	}
+
+	// Verify user was stored with the returned ID and passkey, the configured torrent limit, and a
+	// hashed password
+	user, err := new(data.UserRecord).Load("register", "username")
+	if err != nil {
+		t.Fatalf("Failed to load registered user: %s", err.Error())
+	}
+	if user.ID != registered.ID || user.Passkey != registered.Passkey || len(registered.Passkey) != 40 {
+		t.Fatalf("Registered user, expected %+v, got %+v", registered, user)
+	}
+	if user.TorrentLimit != config.Users.TorrentLimit {
+		t.Fatalf("user.TorrentLimit, expected %d, got %d", config.Users.TorrentLimit, user.TorrentLimit)
+	}
+	if err := data.ComparePassword(user.Password, "password"); err != nil {
+		t.Fatalf("Registered password does not match: %v", err)
+	}
+
+	// Verify duplicate usernames are rejected, ignoring case
+	if code, res, err := postUserJSON([]byte(`{"username":"register","password":"password2"}`)); code != 409 || err != nil {
+		t.Fatalf("Duplicate registration, expected HTTP 409, got HTTP %d: %s %v", code, string(res), err)
+	}
+
+	// Delete mock user
+	if err := user.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
+
+// putUserPasswordTests contains password change request bodies which must be rejected before
+// reaching the database, and the expected HTTP status code
+var putUserPasswordTests = []struct {
+	description string
+	body        string
+	code        int
+}{
+	{"malformed JSON", `{"oldPassword":`, 400},
+	{"missing old password", `{"newPassword":"newpassword"}`, 400},
+	{"missing new password", `{"oldPassword":"password"}`, 400},
+	{"wrong old password", `{"oldPassword":"wrongpassword","newPassword":"newpassword"}`, 403},
+	{"weak new password", `{"oldPassword":"password","newPassword":"new"}`, 400},
+}
+
+// TestPutUserPasswordJSONRejected verifies that password changes are rejected if the old password
+// is wrong, or the new password is invalid
+func TestPutUserPasswordJSONRejected(t *testing.T) {
+	log.Println("TestPutUserPasswordJSONRejected()")
+
+	// Use default validation settings, hashing quickly
+	common.Static.Config = common.DefaultConfig()
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost
+
+	// Generate mock session user, which is never saved
+	hash, err := data.HashPassword("password")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %s", err.Error())
+	}
+	session := data.UserRecord{ID: 1, Username: "test", Password: hash}
+
+	// Iterate all tests
+	for _, test := range putUserPasswordTests {
+		code, res, err := putUserPasswordJSON(session, []byte(test.body))
+		if err != nil {
+			t.Fatalf("Password change with %s, unexpected server error: %s", test.description, err.Error())
+		}
+		if code != test.code {
+			t.Fatalf("Password change with %s, expected HTTP %d, got HTTP %d: %s", test.description, test.code, code, string(res))
+		}
+	}
+}
+
+// TestPutUserPasswordJSON verifies that a user may change their password, optionally revoking
+// their API keys
+func TestPutUserPasswordJSON(t *testing.T) {
+	log.Println("TestPutUserPasswordJSON()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Users.BcryptCost = bcrypt.MinCost
+	common.Static.Config = config
+
+	// Generate, save, and load mock user to fetch ID
+	mockUser := new(data.UserRecord)
+	if err := mockUser.Create("password", "password", 100); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := mockUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	user, err := mockUser.Load(mockUser.Username, "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// Issue an API key to the mock user
+	key, err := data.NewAPIKey(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to save mock API key: %s", err.Error())
+	}
+
+	// Change password, keeping API keys
+	if code, res, err := putUserPasswordJSON(user, []byte(`{"oldPassword":"password","newPassword":"newpassword"}`)); code != 204 || err != nil {
+		t.Fatalf("Password change, expected HTTP 204, got HTTP %d: %s %v", code, string(res), err)
+	}
+
+	user, err = user.Load(user.ID, "id")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+	if err := data.ComparePassword(user.Password, "newpassword"); err != nil {
+		t.Fatalf("Changed password does not match: %v", err)
+	}
+	if _, err := key.Load(key.Pubkey, "pubkey"); err != nil {
+		t.Fatalf("API key was revoked without revokeKeys: %v", err)
+	}
+
+	// Change password again, revoking API keys
+	if code, res, err := putUserPasswordJSON(user, []byte(`{"oldPassword":"newpassword","newPassword":"password","revokeKeys":true}`)); code != 204 || err != nil {
+		t.Fatalf("Password change revoking keys, expected HTTP 204, got HTTP %d: %s %v", code, string(res), err)
+	}
+	if _, err := key.Load(key.Pubkey, "pubkey"); err != data.ErrNotFound {
+		t.Fatalf("API key after revokeKeys, expected ErrNotFound, got: %v", err)
+	}
+
+	// Delete mock user
+	if err := user.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
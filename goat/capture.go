@@ -0,0 +1,265 @@
+package goat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// capturePasskey replaces passkeys in captured announce requests
+const capturePasskey = "[redacted]"
+
+// announceCapture captures announce requests for later replay, if configured
+var announceCapture *captureWriter
+
+// CaptureEntry represents a single captured announce request
+type CaptureEntry struct {
+	Time   int64       `json:"time"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+}
+
+// captureWriter writes a sample of announce requests to a file, rotating it once it
+// exceeds a maximum size
+type captureWriter struct {
+	sync.Mutex
+	path      string
+	maxSize   int64
+	rotations int
+	rate      float64
+	file      *os.File
+	size      int64
+}
+
+// newCaptureWriter opens a capture file at path, which captures the specified ratio of
+// requests, and keeps up to rotations old files of up to maxSize bytes
+func newCaptureWriter(path string, maxSize int64, rotations int, rate float64) (*captureWriter, error) {
+	c := &captureWriter{
+		path:      path,
+		maxSize:   maxSize,
+		rotations: rotations,
+		rate:      rate,
+	}
+
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// open opens the capture file for appending, and records its current size
+func (c *captureWriter) open() error {
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		if err2 := file.Close(); err2 != nil {
+			log.Println(err2.Error())
+		}
+
+		return err
+	}
+
+	c.file = file
+	c.size = stat.Size()
+	return nil
+}
+
+// rotate closes the capture file, shifts old capture files up by one, and opens a new file
+func (c *captureWriter) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+
+	// Shift old files, discarding the oldest
+	for i := c.rotations - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", c.path, i), fmt.Sprintf("%s.%d", c.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Move current file, or discard it if no old files are kept
+	if c.rotations > 0 {
+		if err := os.Rename(c.path, c.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(c.path); err != nil {
+		return err
+	}
+
+	return c.open()
+}
+
+// replacePathSegment replaces each segment of a request URI's path which matches old with new, or
+// removes it if new is empty.  The query string is left intact, so the passkey may appear anywhere in
+// the path, as in /{passkey}/announce or /announce/{passkey}.
+func replacePathSegment(uri string, old string, new string) string {
+	if old == "" {
+		return uri
+	}
+
+	// Split query string from path
+	path, query := uri, ""
+	if i := strings.Index(uri, "?"); i != -1 {
+		path, query = uri[:i], uri[i:]
+	}
+
+	segments := make([]string, 0)
+	for _, s := range strings.Split(path, "/") {
+		if s == old {
+			if new == "" {
+				continue
+			}
+
+			s = new
+		}
+
+		segments = append(segments[:], s)
+	}
+
+	return strings.Join(segments, "/") + query
+}
+
+// Capture records an announce request, if selected by the sampling rate
+// note: announce parameters sent in a POST body are not captured
+func (c *captureWriter) Capture(r *http.Request, passkey string) {
+	// Check sampling rate
+	if c.rate <= 0 || (c.rate < 1 && rand.Float64() >= c.rate) {
+		return
+	}
+
+	// Generate entry, redacting passkey from URL
+	entry := CaptureEntry{
+		Time:   common.Now().Unix(),
+		Method: r.Method,
+		URL:    r.URL.RequestURI(),
+		Header: http.Header{},
+	}
+	if passkey != "" {
+		entry.URL = replacePathSegment(entry.URL, passkey, capturePasskey)
+	}
+
+	// Copy headers, redacting credentials
+	for k, v := range r.Header {
+		if k == "Authorization" || k == "Cookie" {
+			v = []string{capturePasskey}
+		}
+
+		entry.Header[k] = v
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	buf = append(buf, '\n')
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Rotate file if this entry would exceed maximum size
+	if c.maxSize > 0 && c.size > 0 && c.size+int64(len(buf)) > c.maxSize {
+		if err := c.rotate(); err != nil {
+			log.Println(err.Error())
+			return
+		}
+	}
+
+	// Write entry
+	n, err := c.file.Write(buf)
+	c.size += int64(n)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Close closes the capture file
+func (c *captureWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.file.Close()
+}
+
+// ReadCapture parses all captured announce requests from a capture file
+func ReadCapture(r io.Reader) ([]CaptureEntry, error) {
+	entries := make([]CaptureEntry, 0)
+
+	// Decode one entry per line
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry CaptureEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, err
+		}
+
+		entries = append(entries[:], entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// ReplayCapture replays captured announce requests against the tracker at target, substituting
+// passkey for redacted passkeys, and returns the number of requests which were replayed
+func ReplayCapture(entries []CaptureEntry, target string, passkey string, client *http.Client) (int, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	count := 0
+	for _, entry := range entries {
+		// Restore passkey in URL, or remove it if none is set
+		uri := replacePathSegment(entry.URL, capturePasskey, passkey)
+
+		req, err := http.NewRequest(entry.Method, strings.TrimRight(target, "/")+uri, nil)
+		if err != nil {
+			return count, err
+		}
+
+		// Restore headers, skipping redacted credentials
+		for k, v := range entry.Header {
+			if len(v) == 1 && v[0] == capturePasskey {
+				continue
+			}
+
+			req.Header[k] = v
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return count, err
+		}
+
+		// Discard response
+		if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+			log.Println(err.Error())
+		}
+		if err := res.Body.Close(); err != nil {
+			log.Println(err.Error())
+		}
+
+		count++
+	}
+
+	return count, nil
+}
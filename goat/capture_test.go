@@ -0,0 +1,183 @@
+package goat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureURLTests contains announce request URIs containing a passkey, and the URIs they should be
+// replayed with
+var captureURLTests = []struct {
+	uri    string
+	replay string
+}{
+	{"/%s/announce?info_hash=deadbeef&port=5000", "/replay/announce?info_hash=deadbeef&port=5000"},
+	{"/announce/%s?info_hash=deadbeef&port=5000", "/announce/replay?info_hash=deadbeef&port=5000"},
+	{"/announce/%s", "/announce/replay"},
+}
+
+// TestCapture verifies that captured announces are redacted, and can be parsed and replayed
+func TestCapture(t *testing.T) {
+	log.Println("TestCapture()")
+
+	// Create temporary capture directory
+	dir, err := ioutil.TempDir("", "goat_capture")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.log")
+	passkey := "deadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+
+	// Capture all announces
+	capture, err := newCaptureWriter(path, 0, 0, 1)
+	if err != nil {
+		t.Fatalf("Failed to open capture file: %s", err.Error())
+	}
+
+	for _, test := range captureURLTests {
+		r, err := http.NewRequest("GET", "http://localhost"+fmt.Sprintf(test.uri, passkey), nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+		r.Header.Set("User-Agent", "goat_test")
+
+		capture.Capture(r, passkey)
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Failed to close capture file: %s", err.Error())
+	}
+
+	// Verify passkey was redacted
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read capture file: %s", err.Error())
+	}
+
+	if strings.Contains(string(buf), passkey) {
+		t.Fatalf("Capture file contains unredacted passkey")
+	}
+
+	// Verify entries can be parsed
+	entries, err := ReadCapture(strings.NewReader(string(buf)))
+	if err != nil {
+		t.Fatalf("Failed to parse capture file: %s", err.Error())
+	}
+
+	if len(entries) != len(captureURLTests) {
+		t.Fatalf("Expected %d captured announces, got %d", len(captureURLTests), len(entries))
+	}
+
+	// Replay entries against a mock tracker, recording the URIs with valid headers
+	replayed := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == "goat_test" {
+			replayed = append(replayed[:], r.URL.RequestURI())
+		}
+	}))
+	defer server.Close()
+
+	count, err := ReplayCapture(entries, server.URL, "replay", nil)
+	if err != nil {
+		t.Fatalf("Failed to replay captured announces: %s", err.Error())
+	}
+
+	if count != len(captureURLTests) || len(replayed) != len(captureURLTests) {
+		t.Fatalf("Expected %d replayed announces, got %d (%d valid)", len(captureURLTests), count, len(replayed))
+	}
+
+	// Verify passkey was restored in each URL form
+	for i, test := range captureURLTests {
+		if replayed[i] != test.replay {
+			t.Fatalf("Replayed announce, expected %s, got %s", test.replay, replayed[i])
+		}
+	}
+}
+
+// replacePathSegmentTests contains request URIs, a path segment to replace, its replacement, and the
+// expected result
+var replacePathSegmentTests = []struct {
+	uri    string
+	old    string
+	new    string
+	result string
+}{
+	{"/abc/announce?x=abc", "abc", "xyz", "/xyz/announce?x=abc"},
+	{"/announce/abc", "abc", "xyz", "/announce/xyz"},
+	{"/announce/abcd", "abc", "xyz", "/announce/abcd"},
+	{"/announce/abc?x=1", "abc", "", "/announce?x=1"},
+	{"/abc/announce", "abc", "", "/announce"},
+	{"/announce", "", "xyz", "/announce"},
+}
+
+// TestReplacePathSegment verifies that only whole path segments are replaced or removed
+func TestReplacePathSegment(t *testing.T) {
+	log.Println("TestReplacePathSegment()")
+
+	// Iterate all tests
+	for _, test := range replacePathSegmentTests {
+		if result := replacePathSegment(test.uri, test.old, test.new); result != test.result {
+			t.Fatalf("replacePathSegment(%q, %q, %q), expected %q, got %q", test.uri, test.old, test.new, test.result, result)
+		}
+	}
+}
+
+// TestCaptureSampling verifies that the capture sampling rate and rotation are respected
+func TestCaptureSampling(t *testing.T) {
+	log.Println("TestCaptureSampling()")
+
+	// Create temporary capture directory
+	dir, err := ioutil.TempDir("", "goat_capture")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.log")
+
+	r, err := http.NewRequest("GET", "http://localhost/announce?info_hash=deadbeef", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %s", err.Error())
+	}
+
+	// Verify nothing is captured with a zero sampling rate
+	capture, err := newCaptureWriter(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to open capture file: %s", err.Error())
+	}
+
+	capture.Capture(r, "")
+	if capture.size != 0 {
+		t.Fatalf("Captured announce with zero sampling rate")
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Failed to close capture file: %s", err.Error())
+	}
+
+	// Verify capture file is rotated once it exceeds maximum size
+	capture, err = newCaptureWriter(path, 100, 1, 1)
+	if err != nil {
+		t.Fatalf("Failed to open capture file: %s", err.Error())
+	}
+
+	capture.Capture(r, "")
+	capture.Capture(r, "")
+
+	if err := capture.Close(); err != nil {
+		t.Fatalf("Failed to close capture file: %s", err.Error())
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("Capture file was not rotated: %s", err.Error())
+	}
+}
@@ -2,11 +2,18 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
 	ospath "path"
+	"reflect"
+	"regexp"
+
+	"code.google.com/p/go.crypto/bcrypt"
 )
 
 // ConfigPath is set via command-line, and can be used to override config file path location
@@ -14,10 +21,15 @@ var ConfigPath *string
 
 // dbConf represents database configuration
 type dbConf struct {
-	Host     string
-	Database string
-	Username string
-	Password string
+	Host         string
+	Database     string
+	Username     string
+	Password     string
+	DSN          string
+	Retries      int
+	MaxOpenConns int
+	MaxIdleConns int
+	QueryTimeout int
 }
 
 // sslConf represents SSL configuration
@@ -28,29 +40,357 @@ type sslConf struct {
 	Key         string
 }
 
+// peerListConf represents peer list configuration
+type peerListConf struct {
+	Seeded          bool
+	SeedWindow      int
+	Rotate          bool
+	SeederRatio     float64
+	StatOnlySeeders bool
+	Stream          bool
+	MaxNumWant      int
+}
+
+// usersConf represents user account configuration
+type usersConf struct {
+	UsernamePattern   string
+	UsernameMinLength int
+	UsernameMaxLength int
+	PasswordAlgorithm string
+	BcryptCost        int
+	PasswordMinLength int
+	Registration      bool
+	TorrentLimit      int
+}
+
+// captureConf represents announce capture configuration
+type captureConf struct {
+	Enabled    bool
+	Path       string
+	SampleRate float64
+	MaxSize    int64
+	Rotations  int
+}
+
+// scrapeConf represents scrape configuration
+type scrapeConf struct {
+	CacheTTL           int
+	GzipThreshold      int
+	MinRequestInterval int
+}
+
+// privacyConf represents privacy configuration
+type privacyConf struct {
+	RedactIP bool
+}
+
+// announceConf represents announce response configuration
+type announceConf struct {
+	DictGzipThreshold int
+	TrackerID         string
+	LowRatioWarning   float64
+}
+
+// announceLogConf represents announce log batching configuration
+type announceLogConf struct {
+	BatchSize     int
+	FlushInterval int
+}
+
+// statCheckConf represents configuration for detecting clients reporting impossible statistics
+type statCheckConf struct {
+	Enabled bool
+	MaxRate int64
+	Ban     bool
+}
+
+// maintenanceConf represents maintenance mode configuration
+type maintenanceConf struct {
+	Interval int
+}
+
+// reaperConf represents stale peer cleanup configuration
+type reaperConf struct {
+	Interval             int
+	TimeoutMultiplier    float64
+	AnnounceLogRetention int
+}
+
+// metricsConf represents Prometheus metrics configuration
+type metricsConf struct {
+	Enabled bool
+	Listen  string
+}
+
+// healthConf represents health and readiness check configuration
+type healthConf struct {
+	CacheInterval int
+}
+
+// shutdownConf represents graceful shutdown configuration
+type shutdownConf struct {
+	GracePeriod int
+}
+
+// rateLimitConf represents API rate limiting configuration
+type rateLimitConf struct {
+	Enabled bool
+	Rate    float64
+	Burst   int
+}
+
+// keepAliveConf represents HTTP connection keep-alive configuration
+type keepAliveConf struct {
+	Enabled     bool
+	Period      int
+	IdleTimeout int
+}
+
 // redisConf represents Redis configuration
 type redisConf struct {
-	Enabled  bool
-	Host     string
-	Password string
+	Enabled   bool
+	Host      string
+	Password  string
+	MaxActive int
+	MaxIdle   int
 }
 
 // Conf represents server configuration
 type Conf struct {
-	Port      int
-	Passkey   bool
-	Whitelist bool
-	Interval  int
-	HTTP      bool
-	API       bool
-	UDP       bool
-	SSL       sslConf
-	DB        dbConf
-	Redis     redisConf
-}
-
-// LoadConfig loads configuration
-func LoadConfig() (Conf, error) {
+	Port              int
+	Listen            string
+	Passkey           bool
+	Whitelist         bool
+	StrictInfoHash    bool
+	StrictEvent       bool
+	StrictPort        bool
+	RequireStarted    bool
+	MinClientVersions string
+	IPPolicy          string
+	TrustProxy        bool
+	ServeRobots       bool
+	Interval          int
+	IntervalJitter    int
+	JitterPercent     int
+	MinInterval       int
+	HTTP              bool
+	API               bool
+	NonceWindow       int
+	APIKeyExpiry      int
+	UDP               bool
+	SSL               sslConf
+	KeepAlive         keepAliveConf
+	DB                dbConf
+	Redis             redisConf
+	Announce          announceConf
+	AnnounceLog       announceLogConf
+	Maintenance       maintenanceConf
+	Reaper            reaperConf
+	Metrics           metricsConf
+	Health            healthConf
+	Shutdown          shutdownConf
+	RateLimit         rateLimitConf
+	StatCheck         statCheckConf
+	PeerList          peerListConf
+	Users             usersConf
+	Capture           captureConf
+	Scrape            scrapeConf
+	Privacy           privacyConf
+}
+
+// DefaultConfig returns the default configuration, matching the sample config.json.  Settings
+// omitted from a configuration file take these values.
+func DefaultConfig() Conf {
+	return Conf{
+		Port:           8080,
+		Passkey:        true,
+		Whitelist:      true,
+		StrictInfoHash: true,
+		StrictEvent:    true,
+		IPPolicy:       "allow-all",
+		ServeRobots:    true,
+		Interval:       3600,
+		HTTP:           true,
+		API:            true,
+		NonceWindow:    900,
+		APIKeyExpiry:   604800,
+		SSL: sslConf{
+			Port:        8443,
+			Certificate: "goat.crt",
+			Key:         "goat.key",
+		},
+		DB: dbConf{
+			Host:         "localhost:3306",
+			Database:     "goat",
+			Username:     "goat",
+			Password:     "goat",
+			Retries:      3,
+			MaxOpenConns: 32,
+			MaxIdleConns: 8,
+			QueryTimeout: 10,
+		},
+		Redis: redisConf{
+			Host:      "localhost:6379",
+			MaxActive: 32,
+			MaxIdle:   8,
+		},
+		Announce: announceConf{
+			DictGzipThreshold: 4096,
+		},
+		AnnounceLog: announceLogConf{
+			FlushInterval: 1000,
+		},
+		Maintenance: maintenanceConf{
+			Interval: 7200,
+		},
+		Reaper: reaperConf{
+			TimeoutMultiplier:    1.5,
+			AnnounceLogRetention: 604800,
+		},
+		Metrics: metricsConf{
+			Listen: "localhost:9100",
+		},
+		Health: healthConf{
+			CacheInterval: 5,
+		},
+		Shutdown: shutdownConf{
+			GracePeriod: 5,
+		},
+		RateLimit: rateLimitConf{
+			Rate:  5,
+			Burst: 20,
+		},
+		StatCheck: statCheckConf{
+			MaxRate: 104857600,
+		},
+		PeerList: peerListConf{
+			SeederRatio:     0.8,
+			StatOnlySeeders: true,
+			MaxNumWant:      200,
+		},
+		Users: usersConf{
+			UsernamePattern:   "^[a-z0-9_.-]+$",
+			UsernameMinLength: 2,
+			UsernameMaxLength: 20,
+			PasswordAlgorithm: "bcrypt",
+			BcryptCost:        12,
+			PasswordMinLength: 8,
+			TorrentLimit:      10,
+		},
+		Capture: captureConf{
+			Path:       "/tmp/goat_capture.log",
+			SampleRate: 0.01,
+			MaxSize:    10485760,
+			Rotations:  3,
+		},
+		Scrape: scrapeConf{
+			CacheTTL:      30,
+			GzipThreshold: 4096,
+		},
+		Privacy: privacyConf{
+			RedactIP: true,
+		},
+		KeepAlive: keepAliveConf{
+			Enabled:     true,
+			Period:      180,
+			IdleTimeout: 120,
+		},
+	}
+}
+
+// Validate checks that configuration settings are within their allowed ranges, returning an error
+// describing the first invalid setting
+func (c Conf) Validate() error {
+	switch {
+	case c.Port < 1 || c.Port > 65535:
+		return fmt.Errorf("config: Port must be between 1 and 65535, got %d", c.Port)
+	case !c.HTTP && !c.UDP:
+		return errors.New("config: at least one of HTTP or UDP must be enabled")
+	case c.Interval <= 600:
+		return fmt.Errorf("config: Interval must be greater than 600 seconds, got %d", c.Interval)
+	case c.IntervalJitter < 0 || c.IntervalJitter >= c.Interval:
+		return fmt.Errorf("config: IntervalJitter must be at least 0 and less than Interval, got %d", c.IntervalJitter)
+	case c.JitterPercent < 0 || c.JitterPercent > 100:
+		return fmt.Errorf("config: JitterPercent must be between 0 and 100, got %d", c.JitterPercent)
+	case c.MinInterval < 0 || c.MinInterval > c.Interval:
+		return fmt.Errorf("config: MinInterval must be at least 0 and no greater than Interval, got %d", c.MinInterval)
+	case c.NonceWindow < 1:
+		return fmt.Errorf("config: NonceWindow must be at least 1 second, got %d", c.NonceWindow)
+	case c.APIKeyExpiry < 1:
+		return fmt.Errorf("config: APIKeyExpiry must be at least 1 second, got %d", c.APIKeyExpiry)
+	case c.IPPolicy != "allow-all" && c.IPPolicy != "allow-public-only" && c.IPPolicy != "reject-all":
+		return fmt.Errorf("config: IPPolicy must be allow-all, allow-public-only, or reject-all, got %q", c.IPPolicy)
+	case c.SSL.Enabled && (c.SSL.Port < 1 || c.SSL.Port > 65535):
+		return fmt.Errorf("config: SSL.Port must be between 1 and 65535, got %d", c.SSL.Port)
+	case c.SSL.Enabled && (c.SSL.Certificate == "" || c.SSL.Key == ""):
+		return errors.New("config: SSL.Certificate and SSL.Key are required when SSL is enabled")
+	case c.DB.DSN == "" && (c.DB.Host == "" || c.DB.Database == "" || c.DB.Username == ""):
+		return errors.New("config: DB.Host, DB.Database, and DB.Username are required when DB.DSN is not set")
+	case c.DB.Retries < 0 || c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 || c.DB.QueryTimeout < 0:
+		return errors.New("config: DB.Retries, DB.MaxOpenConns, DB.MaxIdleConns, and DB.QueryTimeout must not be negative")
+	case c.Redis.MaxActive < 0 || c.Redis.MaxIdle < 0:
+		return errors.New("config: Redis.MaxActive and Redis.MaxIdle must not be negative")
+	case c.AnnounceLog.BatchSize < 0 || c.AnnounceLog.FlushInterval < 0:
+		return errors.New("config: AnnounceLog.BatchSize and AnnounceLog.FlushInterval must not be negative")
+	case c.AnnounceLog.BatchSize > 0 && c.AnnounceLog.FlushInterval == 0:
+		return errors.New("config: AnnounceLog.FlushInterval is required when AnnounceLog.BatchSize is set")
+	case c.Reaper.Interval < 0 || c.Reaper.AnnounceLogRetention < 0:
+		return errors.New("config: Reaper.Interval and Reaper.AnnounceLogRetention must not be negative")
+	case c.Reaper.TimeoutMultiplier < 1:
+		return fmt.Errorf("config: Reaper.TimeoutMultiplier must be at least 1, got %g", c.Reaper.TimeoutMultiplier)
+	case c.Metrics.Enabled && c.Metrics.Listen == "":
+		return errors.New("config: Metrics.Listen is required when metrics are enabled")
+	case c.Announce.TrackerID != "" && c.Announce.TrackerID != "tracker" && c.Announce.TrackerID != "file":
+		return fmt.Errorf("config: Announce.TrackerID must be empty, tracker, or file, got %q", c.Announce.TrackerID)
+	case c.Announce.LowRatioWarning < 0:
+		return fmt.Errorf("config: Announce.LowRatioWarning must not be negative, got %g", c.Announce.LowRatioWarning)
+	case c.Health.CacheInterval < 0:
+		return fmt.Errorf("config: Health.CacheInterval must not be negative, got %d", c.Health.CacheInterval)
+	case c.Shutdown.GracePeriod < 0:
+		return fmt.Errorf("config: Shutdown.GracePeriod must not be negative, got %d", c.Shutdown.GracePeriod)
+	case c.RateLimit.Enabled && (c.RateLimit.Rate <= 0 || c.RateLimit.Burst < 1):
+		return errors.New("config: RateLimit.Rate must be positive and RateLimit.Burst at least 1 when rate limiting is enabled")
+	case c.PeerList.SeederRatio < 0 || c.PeerList.SeederRatio > 1:
+		return fmt.Errorf("config: PeerList.SeederRatio must be between 0 and 1, got %g", c.PeerList.SeederRatio)
+	case c.PeerList.MaxNumWant < 0:
+		return fmt.Errorf("config: PeerList.MaxNumWant must not be negative, got %d", c.PeerList.MaxNumWant)
+	case c.PeerList.SeedWindow < 0:
+		return fmt.Errorf("config: PeerList.SeedWindow must not be negative, got %d", c.PeerList.SeedWindow)
+	case c.Users.PasswordAlgorithm != "" && c.Users.PasswordAlgorithm != "bcrypt" && c.Users.PasswordAlgorithm != "scrypt":
+		return fmt.Errorf("config: Users.PasswordAlgorithm must be bcrypt or scrypt, got %q", c.Users.PasswordAlgorithm)
+	case c.Users.BcryptCost != 0 && (c.Users.BcryptCost < bcrypt.MinCost || c.Users.BcryptCost > bcrypt.MaxCost):
+		return fmt.Errorf("config: Users.BcryptCost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Users.BcryptCost)
+	case c.Users.PasswordMinLength < 0:
+		return fmt.Errorf("config: Users.PasswordMinLength must be at least 0, got %d", c.Users.PasswordMinLength)
+	case c.Users.Registration && c.Users.TorrentLimit < 1:
+		return fmt.Errorf("config: Users.TorrentLimit must be at least 1 when Users.Registration is enabled, got %d", c.Users.TorrentLimit)
+	case c.Users.UsernameMaxLength > 0 && c.Users.UsernameMinLength > c.Users.UsernameMaxLength:
+		return errors.New("config: Users.UsernameMinLength must not be greater than Users.UsernameMaxLength")
+	case c.StatCheck.Enabled && c.StatCheck.MaxRate <= 0:
+		return errors.New("config: StatCheck.MaxRate must be greater than 0 when StatCheck is enabled")
+	case c.Capture.Enabled && c.Capture.Path == "":
+		return errors.New("config: Capture.Path is required when Capture is enabled")
+	case c.Capture.SampleRate < 0 || c.Capture.SampleRate > 1:
+		return fmt.Errorf("config: Capture.SampleRate must be between 0 and 1, got %g", c.Capture.SampleRate)
+	case c.Scrape.GzipThreshold < 0:
+		return fmt.Errorf("config: Scrape.GzipThreshold must be at least 0, got %d", c.Scrape.GzipThreshold)
+	case c.Scrape.CacheTTL < 0 || c.Scrape.MinRequestInterval < 0:
+		return errors.New("config: Scrape.CacheTTL and Scrape.MinRequestInterval must not be negative")
+	case c.KeepAlive.Period < 0 || c.KeepAlive.IdleTimeout < 0:
+		return errors.New("config: KeepAlive.Period and KeepAlive.IdleTimeout must not be negative")
+	}
+
+	// Verify username pattern compiles
+	if _, err := regexp.Compile(c.Users.UsernamePattern); err != nil {
+		return fmt.Errorf("config: Users.UsernamePattern is not a valid regular expression: %s", err.Error())
+	}
+
+	return nil
+}
+
+// LoadConfig loads configuration from the specified path, or from the standard location if path is
+// empty.  If no configuration exists at that location, the default configuration is copied there.
+func LoadConfig(configPath string) (Conf, error) {
 	// Configuration path
 	var path string
 	config := "config.json"
@@ -70,11 +410,11 @@ func LoadConfig() (Conf, error) {
 		path = user.HomeDir + "/.config/goat/"
 	}
 
-	// Allow manual override of config path, if flag is set
-	if ConfigPath != nil && *ConfigPath != "" {
+	// Allow manual override of config path
+	if configPath != "" {
 		// Split config path into path and filename
-		path = ospath.Dir(*ConfigPath) + "/"
-		config = ospath.Base(*ConfigPath)
+		path = ospath.Dir(configPath) + "/"
+		config = ospath.Base(configPath)
 	}
 
 	log.Println("Loading configuration: " + path + config)
@@ -118,17 +458,38 @@ func LoadConfig() (Conf, error) {
 	}
 
 	// Load configuration file
-	c := Conf{}
 	configFile, err := os.Open(path + config)
 	if err != nil {
 		return Conf{}, err
 	}
+	defer configFile.Close()
 
-	// Decode JSON
-	err = json.NewDecoder(configFile).Decode(&c)
+	return decodeConfig(configFile, os.Environ())
+}
+
+// decodeConfig decodes a JSON configuration, using defaults for any omitted settings, and then
+// applies overrides from environment variables.  Unknown configuration keys are logged and ignored.
+func decodeConfig(r io.Reader, environ []string) (Conf, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return Conf{}, err
 	}
 
+	// Warn about settings which will have no effect
+	for _, key := range unknownKeys(data, reflect.TypeOf(Conf{}), "") {
+		log.Println("config: ignoring unknown setting: " + key)
+	}
+
+	// Decode JSON over defaults
+	c := DefaultConfig()
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Conf{}, err
+	}
+
+	// Environment variables take precedence over the configuration file
+	if err := applyEnv(&c, environ); err != nil {
+		return Conf{}, err
+	}
+
 	return c, nil
 }
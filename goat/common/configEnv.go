@@ -0,0 +1,118 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables which override configuration settings
+const envPrefix = "GOAT_"
+
+// envFields maps the environment variable names for all settings in a configuration struct to their
+// values.  Names are the upper case setting path, separated by underscores, ex: GOAT_DB_HOST
+func envFields(v reflect.Value, prefix string, fields map[string]reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		name := prefix + strings.ToUpper(v.Type().Field(i).Name)
+
+		// Recurse into configuration sections
+		if v.Field(i).Kind() == reflect.Struct {
+			envFields(v.Field(i), name+"_", fields)
+			continue
+		}
+
+		fields[name] = v.Field(i)
+	}
+}
+
+// applyEnv overrides configuration settings using environment variables, given in the same
+// key=value form as os.Environ().  Unknown variables with the goat prefix are logged and ignored.
+func applyEnv(c *Conf, environ []string) error {
+	fields := map[string]reflect.Value{}
+	envFields(reflect.ValueOf(c).Elem(), envPrefix, fields)
+
+	for _, env := range environ {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], envPrefix) {
+			continue
+		}
+
+		field, ok := fields[pair[0]]
+		if !ok {
+			log.Println("config: ignoring unknown environment variable: " + pair[0])
+			continue
+		}
+
+		if err := setField(field, pair[1]); err != nil {
+			return fmt.Errorf("config: invalid value for %s: %s", pair[0], err.Error())
+		}
+	}
+
+	return nil
+}
+
+// setField parses a string value into a configuration setting of its type
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Kind())
+	}
+
+	return nil
+}
+
+// unknownKeys returns the paths of keys in a JSON configuration object which do not match any
+// setting in a configuration struct.  As with JSON decoding, keys are matched without case.
+func unknownKeys(data []byte, t reflect.Type, prefix string) []string {
+	// Settings which are not JSON objects have no keys to check
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil
+	}
+
+	unknown := make([]string, 0)
+	for key, value := range object {
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !strings.EqualFold(field.Name, key) {
+				continue
+			}
+
+			found = true
+			if field.Type.Kind() == reflect.Struct {
+				unknown = append(unknown, unknownKeys(value, field.Type, prefix+field.Name+".")...)
+			}
+			break
+		}
+
+		if !found {
+			unknown = append(unknown, prefix+key)
+		}
+	}
+
+	return unknown
+}
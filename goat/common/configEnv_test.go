@@ -0,0 +1,84 @@
+package common
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestDecodeConfigPrecedence verifies that environment variables take precedence over the
+// configuration file, which takes precedence over defaults
+func TestDecodeConfigPrecedence(t *testing.T) {
+	log.Println("TestDecodeConfigPrecedence()")
+
+	file := `{"Port": 9090, "UDP": false, "DB": {"Host": "db.example.com"}}`
+	environ := []string{
+		"GOAT_PORT=7070",
+		"GOAT_DB_USERNAME=envuser",
+		"GOAT_PEERLIST_SEEDERRATIO=0.5",
+		"GOAT_UNKNOWN=1",
+		"PATH=/usr/bin",
+	}
+
+	c, err := decodeConfig(strings.NewReader(file), environ)
+	if err != nil {
+		t.Fatalf("Failed to decode configuration: %s", err.Error())
+	}
+
+	// Verify environment overrides file and defaults
+	if c.Port != 7070 || c.DB.Username != "envuser" || c.PeerList.SeederRatio != 0.5 {
+		t.Fatalf("Environment overrides not applied: %+v", c)
+	}
+
+	// Verify file overrides defaults
+	if c.UDP || c.DB.Host != "db.example.com" {
+		t.Fatalf("File settings not applied: %+v", c)
+	}
+
+	// Verify omitted settings keep their defaults, including within a section set in the file
+	defaults := DefaultConfig()
+	if c.Interval != defaults.Interval || c.DB.Database != defaults.DB.Database || c.Users != defaults.Users {
+		t.Fatalf("Omitted settings did not use defaults: %+v", c)
+	}
+}
+
+// TestDecodeConfigDefaults verifies that an empty configuration uses all defaults
+func TestDecodeConfigDefaults(t *testing.T) {
+	log.Println("TestDecodeConfigDefaults()")
+
+	c, err := decodeConfig(strings.NewReader("{}"), nil)
+	if err != nil {
+		t.Fatalf("Failed to decode configuration: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(c, DefaultConfig()) {
+		t.Fatalf("Empty configuration, expected defaults, got: %+v", c)
+	}
+}
+
+// TestDecodeConfigInvalidEnv verifies that unparseable environment variables are rejected
+func TestDecodeConfigInvalidEnv(t *testing.T) {
+	log.Println("TestDecodeConfigInvalidEnv()")
+
+	for _, env := range []string{"GOAT_PORT=http", "GOAT_UDP=maybe", "GOAT_RATELIMIT_RATE=fast"} {
+		if _, err := decodeConfig(strings.NewReader("{}"), []string{env}); err == nil {
+			t.Fatalf("Invalid environment variable %s was accepted", env)
+		}
+	}
+}
+
+// TestUnknownKeys verifies that unknown configuration keys are found, including within sections,
+// and that known keys match without case
+func TestUnknownKeys(t *testing.T) {
+	log.Println("TestUnknownKeys()")
+
+	file := `{"port": 8080, "Bogus": true, "DB": {"Host": "localhost", "Hots": "typo"}, "Redis": null}`
+	unknown := unknownKeys([]byte(file), reflect.TypeOf(Conf{}), "")
+	sort.Strings(unknown)
+
+	if expected := []string{"Bogus", "DB.Hots"}; !reflect.DeepEqual(unknown, expected) {
+		t.Fatalf("Unknown keys, expected %v, got %v", expected, unknown)
+	}
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDefaultConfig verifies that the default configuration is valid, and matches the sample configuration
+func TestDefaultConfig(t *testing.T) {
+	log.Println("TestDefaultConfig()")
+
+	// Verify defaults are valid
+	defaults := DefaultConfig()
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("Default configuration is invalid: %s", err.Error())
+	}
+
+	// Load sample configuration, verify defaults match
+	file, err := os.Open("../../config.json")
+	if err != nil {
+		t.Fatalf("Could not open sample configuration: %s", err.Error())
+	}
+	defer file.Close()
+
+	sample := Conf{}
+	if err := json.NewDecoder(file).Decode(&sample); err != nil {
+		t.Fatalf("Could not decode sample configuration: %s", err.Error())
+	}
+	if sample != defaults {
+		t.Fatalf("Default configuration does not match sample:\n%+v\n%+v", defaults, sample)
+	}
+
+	// Verify omitted settings take default values
+	c := DefaultConfig()
+	if err := json.Unmarshal([]byte(`{"Port":9000,"DB":{"Host":"db:3306"}}`), &c); err != nil {
+		t.Fatalf("Could not decode partial configuration: %s", err.Error())
+	}
+	if c.Port != 9000 || c.DB.Host != "db:3306" {
+		t.Fatalf("Partial configuration settings not applied: %+v", c)
+	}
+	if c.Interval != defaults.Interval || c.DB.Database != defaults.DB.Database {
+		t.Fatalf("Omitted settings did not take default values: %+v", c)
+	}
+}
+
+// validateTests contains modifications to the default configuration, and whether the result is valid
+var validateTests = []struct {
+	description string
+	modify      func(c *Conf)
+	valid       bool
+}{
+	{"defaults", func(c *Conf) {}, true},
+	{"port zero", func(c *Conf) { c.Port = 0 }, false},
+	{"port too large", func(c *Conf) { c.Port = 70000 }, false},
+	{"no listeners", func(c *Conf) { c.HTTP, c.UDP = false, false }, false},
+	{"UDP only", func(c *Conf) { c.HTTP, c.UDP = false, true }, true},
+	{"short interval", func(c *Conf) { c.Interval = 600 }, false},
+	{"negative jitter", func(c *Conf) { c.IntervalJitter = -1 }, false},
+	{"jitter exceeds interval", func(c *Conf) { c.IntervalJitter = c.Interval }, false},
+	{"negative jitter percent", func(c *Conf) { c.JitterPercent = -1 }, false},
+	{"jitter percent above 100", func(c *Conf) { c.JitterPercent = 101 }, false},
+	{"jitter percent", func(c *Conf) { c.JitterPercent = 10 }, true},
+	{"negative min interval", func(c *Conf) { c.MinInterval = -1 }, false},
+	{"min interval exceeds interval", func(c *Conf) { c.MinInterval = c.Interval + 1 }, false},
+	{"min interval", func(c *Conf) { c.MinInterval = 900 }, true},
+	{"zero nonce window", func(c *Conf) { c.NonceWindow = 0 }, false},
+	{"zero API key expiry", func(c *Conf) { c.APIKeyExpiry = 0 }, false},
+	{"unknown IP policy", func(c *Conf) { c.IPPolicy = "allow-some" }, false},
+	{"public IP policy", func(c *Conf) { c.IPPolicy = "allow-public-only" }, true},
+	{"SSL without key", func(c *Conf) { c.SSL.Enabled, c.SSL.Key = true, "" }, false},
+	{"SSL invalid port", func(c *Conf) { c.SSL.Enabled, c.SSL.Port = true, 0 }, false},
+	{"DB host missing", func(c *Conf) { c.DB.Host = "" }, false},
+	{"DB host missing with DSN", func(c *Conf) { c.DB.Host, c.DB.DSN = "", "goat:goat@/goat" }, true},
+	{"negative retries", func(c *Conf) { c.DB.Retries = -1 }, false},
+	{"negative query timeout", func(c *Conf) { c.DB.QueryTimeout = -1 }, false},
+	{"query timeout disabled", func(c *Conf) { c.DB.QueryTimeout = 0 }, true},
+	{"negative Redis pool size", func(c *Conf) { c.Redis.MaxActive = -1 }, false},
+	{"seeder ratio too large", func(c *Conf) { c.PeerList.SeederRatio = 1.5 }, false},
+	{"unknown password algorithm", func(c *Conf) { c.Users.PasswordAlgorithm = "md5" }, false},
+	{"scrypt password algorithm", func(c *Conf) { c.Users.PasswordAlgorithm = "scrypt" }, true},
+	{"username lengths reversed", func(c *Conf) { c.Users.UsernameMinLength = 30 }, false},
+	{"bcrypt cost below minimum", func(c *Conf) { c.Users.BcryptCost = 3 }, false},
+	{"bcrypt cost above maximum", func(c *Conf) { c.Users.BcryptCost = 32 }, false},
+	{"minimum bcrypt cost", func(c *Conf) { c.Users.BcryptCost = 4 }, true},
+	{"negative password length", func(c *Conf) { c.Users.PasswordMinLength = -1 }, false},
+	{"registration without torrent limit", func(c *Conf) { c.Users.Registration, c.Users.TorrentLimit = true, 0 }, false},
+	{"registration", func(c *Conf) { c.Users.Registration = true }, true},
+	{"invalid username pattern", func(c *Conf) { c.Users.UsernamePattern = "[a-z" }, false},
+	{"stat check without rate", func(c *Conf) { c.StatCheck.Enabled, c.StatCheck.MaxRate = true, 0 }, false},
+	{"capture without path", func(c *Conf) { c.Capture.Enabled, c.Capture.Path = true, "" }, false},
+	{"negative scrape gzip threshold", func(c *Conf) { c.Scrape.GzipThreshold = -1 }, false},
+	{"negative cache TTL", func(c *Conf) { c.Scrape.CacheTTL = -1 }, false},
+	{"negative announce log batch size", func(c *Conf) { c.AnnounceLog.BatchSize = -1 }, false},
+	{"announce log batch without interval", func(c *Conf) { c.AnnounceLog.BatchSize, c.AnnounceLog.FlushInterval = 100, 0 }, false},
+	{"announce log batch", func(c *Conf) { c.AnnounceLog.BatchSize = 100 }, true},
+	{"negative reaper interval", func(c *Conf) { c.Reaper.Interval = -1 }, false},
+	{"reaper timeout below interval", func(c *Conf) { c.Reaper.TimeoutMultiplier = 0.5 }, false},
+	{"metrics without listen address", func(c *Conf) { c.Metrics.Enabled, c.Metrics.Listen = true, "" }, false},
+	{"unknown tracker id mode", func(c *Conf) { c.Announce.TrackerID = "peer" }, false},
+	{"per-file tracker id", func(c *Conf) { c.Announce.TrackerID = "file" }, true},
+	{"negative low ratio warning", func(c *Conf) { c.Announce.LowRatioWarning = -0.5 }, false},
+	{"low ratio warning", func(c *Conf) { c.Announce.LowRatioWarning = 0.5 }, true},
+	{"negative health cache interval", func(c *Conf) { c.Health.CacheInterval = -1 }, false},
+	{"negative shutdown grace period", func(c *Conf) { c.Shutdown.GracePeriod = -1 }, false},
+	{"rate limit without rate", func(c *Conf) { c.RateLimit.Enabled, c.RateLimit.Rate = true, 0 }, false},
+	{"rate limit without burst", func(c *Conf) { c.RateLimit.Enabled, c.RateLimit.Burst = true, 0 }, false},
+	{"rate limit", func(c *Conf) { c.RateLimit.Enabled = true }, true},
+	{"negative keep-alive period", func(c *Conf) { c.KeepAlive.Period = -1 }, false},
+}
+
+// TestLoadConfigPath verifies that configuration is loaded from the specified path
+func TestLoadConfigPath(t *testing.T) {
+	log.Println("TestLoadConfigPath()")
+
+	// Write configuration to a temporary directory
+	dir, err := ioutil.TempDir("", "goat")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "goat.json")
+	if err := ioutil.WriteFile(path, []byte(`{"Port":9090}`), 0644); err != nil {
+		t.Fatalf("Could not write configuration: %s", err.Error())
+	}
+
+	// Verify file settings are applied over defaults
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	if c.Port != 9090 || c.Interval != DefaultConfig().Interval {
+		t.Fatalf("Configuration not loaded from %s: %+v", path, c)
+	}
+}
+
+// TestConfigValidate verifies that invalid configuration settings are rejected
+func TestConfigValidate(t *testing.T) {
+	log.Println("TestConfigValidate()")
+
+	// Iterate all tests
+	for _, test := range validateTests {
+		c := DefaultConfig()
+		test.modify(&c)
+
+		if err := c.Validate(); (err == nil) != test.valid {
+			t.Fatalf("Validate(%s), expected valid %v, got error: %v", test.description, test.valid, err)
+		}
+	}
+}
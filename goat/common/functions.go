@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+// Now returns the current time, and is used wherever the tracker reads the clock.  Tests may
+// replace it to advance time deterministically.
+var Now = time.Now
+
 // RandRange generates a random announce interval in the specified range
 func RandRange(min int, max int) int {
 	mrand.Seed(time.Now().Unix())
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"sync"
+)
+
+// MaintenanceMode stores whether maintenance mode is enabled, and the status message reported to
+// clients, guarded so they may be changed while requests are being served
+type MaintenanceMode struct {
+	mutex   sync.RWMutex
+	enabled bool
+	message string
+}
+
+// Get returns whether maintenance mode is enabled, and the current status message
+func (m *MaintenanceMode) Get() (bool, string) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.enabled, m.message
+}
+
+// Set enables or disables maintenance mode, and sets the status message
+func (m *MaintenanceMode) Set(enabled bool, message string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.enabled = enabled
+	m.message = message
+}
+
+// Toggle enables or disables maintenance mode, keeping the current status message, and returns
+// whether maintenance mode is now enabled
+func (m *MaintenanceMode) Toggle() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.enabled = !m.enabled
+	return m.enabled
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// TrackerMetrics contains counters and gauges describing tracker activity, exported in Prometheus
+// text exposition format
+type TrackerMetrics struct {
+	// Counters, incremented as requests are handled
+	Announces      int64
+	AnnounceErrors int64
+	Scrapes        int64
+
+	// Gauges, refreshed periodically from storage
+	Seeders  int64
+	Leechers int64
+	Files    int64
+}
+
+// metric describes a single metric, and the value it reports
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value *int64
+}
+
+// metrics returns all tracker metrics, in the order they are exported
+func (m *TrackerMetrics) metrics() []metric {
+	return []metric{
+		{"goat_announces_total", "counter", "Total number of announces received.", &m.Announces},
+		{"goat_announce_errors_total", "counter", "Total number of announces which received an error response.", &m.AnnounceErrors},
+		{"goat_scrapes_total", "counter", "Total number of scrapes received.", &m.Scrapes},
+		{"goat_seeders", "gauge", "Current number of active seeders on all files.", &m.Seeders},
+		{"goat_leechers", "gauge", "Current number of active leechers on all files.", &m.Leechers},
+		{"goat_files", "gauge", "Current number of files tracked.", &m.Files},
+	}
+}
+
+// WriteMetrics writes all tracker metrics to the output stream, in Prometheus text exposition format
+func WriteMetrics(w io.Writer) error {
+	for _, m := range Static.Metrics.metrics() {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, atomic.LoadInt64(m.value))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
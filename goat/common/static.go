@@ -11,15 +11,15 @@ var Static struct {
 	// Stats about HTTP server
 	HTTP TimedStats
 
-	// Maintenance
-	Maintenance bool
+	// Maintenance mode and status message
+	Maintenance MaintenanceMode
+
+	// Tracker metrics, exported for Prometheus
+	Metrics TrackerMetrics
 
 	// Startup time
 	StartTime int64
 
-	// Status message
-	StatusMessage string
-
 	// Stats about UDP server
 	UDP TimedStats
 }
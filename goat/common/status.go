@@ -4,7 +4,6 @@ import (
 	"os"
 	"runtime"
 	"sync/atomic"
-	"time"
 )
 
 // ServerStatus represents a struct to be serialized, containing information about the system running goat
@@ -41,7 +40,7 @@ func GetServerStatus() (ServerStatus, error) {
 	memMb := float64((float64(mem.Alloc) / 1000) / 1000)
 
 	// Current uptime
-	uptime := time.Now().Unix() - Static.StartTime
+	uptime := Now().Unix() - Static.StartTime
 
 	// API status
 	apiStatus := TimedStats{
@@ -67,6 +66,9 @@ func GetServerStatus() (ServerStatus, error) {
 		atomic.LoadInt64(&Static.UDP.Total),
 	}
 
+	// Maintenance mode and status message
+	maintenance, message := Static.Maintenance.Get()
+
 	// Build status struct
 	status := ServerStatus{
 		os.Getpid(),
@@ -76,8 +78,8 @@ func GetServerStatus() (ServerStatus, error) {
 		runtime.NumCPU(),
 		runtime.NumGoroutine(),
 		memMb,
-		Static.Maintenance,
-		Static.StatusMessage,
+		maintenance,
+		message,
 		uptime,
 		apiStatus,
 		httpStatus,
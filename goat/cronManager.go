@@ -15,16 +15,24 @@ func cronManager() {
 	// Run on startup
 	go cronAPIKeyReaper()
 	go cronPeerReaper()
+	go cronMetricsRefresh()
 
 	// cronAPIKeyReaper - run once per hour
 	apiKeyReaper := time.NewTicker(1 * time.Hour)
 
-	// cronPeerReaper - run at regular announce interval
-	peerReaper := time.NewTicker(time.Duration(common.Static.Config.Interval) * time.Second)
+	// cronPeerReaper - run at configured reaper interval, or regular announce interval
+	reaperInterval := common.Static.Config.Reaper.Interval
+	if reaperInterval == 0 {
+		reaperInterval = common.Static.Config.Interval
+	}
+	peerReaper := time.NewTicker(time.Duration(reaperInterval) * time.Second)
 
 	// cronPrintCurrentStatus - run every 5 minutes
 	status := time.NewTicker(5 * time.Minute)
 
+	// cronMetricsRefresh - run every minute
+	metrics := time.NewTicker(1 * time.Minute)
+
 	// Start cronStatsReset, which maintains its own timers
 	go cronStatsReset()
 
@@ -37,6 +45,8 @@ func cronManager() {
 			go cronPeerReaper()
 		case <-status.C:
 			go cronPrintCurrentStatus()
+		case <-metrics.C:
+			go cronMetricsRefresh()
 		}
 	}
 }
@@ -70,7 +80,7 @@ func cronAPIKeyReaper() {
 	for _, k := range keys {
 		go func(k data.APIKey, count *int64, wg *sync.WaitGroup) {
 			// Check for expired key
-			if k.Expire <= time.Now().Unix() {
+			if k.Expire <= common.Now().Unix() {
 				// Delete expired keys
 				if err := k.Delete(); err != nil {
 					log.Println(err.Error())
@@ -93,18 +103,35 @@ func cronAPIKeyReaper() {
 // cronPeerReaper checks for inactive peers, and marks them as such in the database
 func cronPeerReaper() {
 	log.Println("cronPeerReaper: starting")
+	PruneStalePeers(common.Now().Unix())
+}
+
+// PruneStalePeers marks peers which have not announced within the peer timeout as of the specified
+// UNIX timestamp inactive, and deletes announce log entries older than the configured retention
+// window.  It returns the number of peers marked inactive.
+func PruneStalePeers(now int64) int {
+	// Delete old announce log entries, if retention is enabled
+	if retention := common.Static.Config.Reaper.AnnounceLogRetention; retention > 0 {
+		pruned, err := new(data.AnnounceLogRepository).Prune(now - int64(retention))
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("cronPeerReaper: failed to prune announce log")
+		} else if pruned > 0 {
+			log.Printf("cronPeerReaper: pruned %d announce log entries", pruned)
+		}
+	}
 
 	// Load all files
 	files, err := new(data.FileRecordRepository).All()
 	if err != nil {
 		log.Println(err.Error())
 		log.Println("cronPeerReaper: failed to load list of files")
-		return
+		return 0
 	}
 
 	if len(files) == 0 {
 		log.Println("cronPeerReaper: no files found")
-		return
+		return 0
 	}
 
 	// Sum of peers reaped
@@ -119,7 +146,7 @@ func cronPeerReaper() {
 	for _, f := range files {
 		go func(f data.FileRecord, count *int64, wg *sync.WaitGroup) {
 			// Reap peers on each file
-			reaped, err := f.PeerReaper()
+			reaped, err := f.PeerReaper(now)
 			if err != nil {
 				log.Println("cronPeerReaper: failed to reap peers on file ID:", f.ID)
 			}
@@ -139,6 +166,20 @@ func cronPeerReaper() {
 	// Wait for all goroutines to finish
 	wg.Wait()
 	log.Printf("cronPeerReaper: complete, reaped %d peers on %d files", total, len(files))
+
+	return int(total)
+}
+
+// cronMetricsRefresh updates the tracker metrics gauges from storage, if metrics are enabled
+func cronMetricsRefresh() {
+	if !common.Static.Config.Metrics.Enabled {
+		return
+	}
+
+	if err := refreshMetrics(); err != nil {
+		log.Println(err.Error())
+		log.Println("cronMetricsRefresh: failed to refresh metrics")
+	}
 }
 
 // cronPrintCurrentStatus logs the regular status check banner
@@ -0,0 +1,96 @@
+package goat
+
+import (
+	"encoding/hex"
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// TestPruneStalePeers verifies that peers are only marked inactive once they have not announced within
+// the peer timeout, and that old announce log entries are deleted
+func TestPruneStalePeers(t *testing.T) {
+	log.Println("TestPruneStalePeers()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock file
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte("goat_prune_stale")),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate and save mock active peer, and an announce from it
+	fileUser := data.FileUserRecord{
+		FileID: file.ID,
+		UserID: 1,
+		IP:     "10.0.0.1",
+		Active: true,
+		Left:   1,
+	}
+	if err := fileUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock file user: %s", err.Error())
+	}
+
+	announce := data.AnnounceLog{
+		InfoHash: file.InfoHash,
+		IP:       "10.0.0.1",
+		Port:     6881,
+	}
+	if err := announce.Save(); err != nil {
+		t.Fatalf("Failed to save mock announce: %s", err.Error())
+	}
+
+	// Verify a peer which recently announced is not expired
+	now := common.Now().Unix()
+	PruneStalePeers(now)
+
+	fileUser, err = fileUser.Load(file.ID, 1, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to load mock file user: %s", err.Error())
+	}
+	if !fileUser.Active {
+		t.Fatalf("Peer which recently announced was marked inactive")
+	}
+
+	// Verify the peer is expired once the peer timeout has elapsed, along with the announce once
+	// the retention window has elapsed
+	later := now + data.PeerTimeout() + int64(common.Static.Config.Reaper.AnnounceLogRetention) + 60
+	if count := PruneStalePeers(later); count < 1 {
+		t.Fatalf("Expected at least 1 peer expired, got %d", count)
+	}
+
+	fileUser, err = fileUser.Load(file.ID, 1, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to load mock file user: %s", err.Error())
+	}
+	if fileUser.Active {
+		t.Fatalf("Stale peer was not marked inactive")
+	}
+
+	if _, err := announce.Load(file.InfoHash, "info_hash"); err != data.ErrNotFound {
+		t.Fatalf("Old announce log entry, expected ErrNotFound, got: %v", err)
+	}
+
+	// Delete mock records
+	if err := fileUser.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file user: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
@@ -3,15 +3,21 @@ package data
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/mdlayher/goat/goat/common"
 )
 
 // AnnounceLog represents an announce, to be logged to storage
 type AnnounceLog struct {
 	ID         int
 	InfoHash   string `db:"info_hash"`
+	PeerID     string `db:"peer_id"`
 	Passkey    string
 	Key        string
 	IP         string
@@ -25,6 +31,43 @@ type AnnounceLog struct {
 	Time       int64
 }
 
+// AnnounceLogRepository is used to contain methods to load multiple AnnounceLog structs
+type AnnounceLogRepository struct {
+}
+
+// JSONAnnounceLog represents output AnnounceLog JSON for API
+type JSONAnnounceLog struct {
+	ID         int    `json:"id"`
+	PeerID     string `json:"peerId"`
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	UDP        bool   `json:"udp"`
+	Uploaded   int64  `json:"uploaded"`
+	Downloaded int64  `json:"downloaded"`
+	Left       int64  `json:"left"`
+	Event      string `json:"event"`
+	Client     string `json:"client"`
+	Time       int64  `json:"time"`
+}
+
+// ToJSON converts an AnnounceLog to a JSONAnnounceLog struct, omitting the passkey and key
+func (a AnnounceLog) ToJSON() (JSONAnnounceLog, error) {
+	j := JSONAnnounceLog{}
+	j.ID = a.ID
+	j.PeerID = a.PeerID
+	j.IP = a.IP
+	j.Port = a.Port
+	j.UDP = a.UDP
+	j.Uploaded = a.Uploaded
+	j.Downloaded = a.Downloaded
+	j.Left = a.Left
+	j.Event = a.Event
+	j.Client = a.Client
+	j.Time = a.Time
+
+	return j, nil
+}
+
 // Save AnnounceLog to storage
 func (a AnnounceLog) Save() error {
 	// Open database connection
@@ -34,7 +77,7 @@ func (a AnnounceLog) Save() error {
 	}
 
 	// Save AnnounceLog
-	if err := db.SaveAnnounceLog(a); err != nil {
+	if err := withRetry(func() error { return db.SaveAnnounceLog(a) }); err != nil {
 		return err
 	}
 
@@ -46,10 +89,93 @@ func (a AnnounceLog) Save() error {
 	return nil
 }
 
+// announceLogBatch contains announce logs waiting to be saved in a single batch, and the timer which
+// flushes them once the flush interval elapses
+var announceLogBatch struct {
+	sync.Mutex
+	logs  []AnnounceLog
+	timer *time.Timer
+}
+
+// QueueAnnounceLog queues an AnnounceLog to be saved in a batch, which is flushed once it contains
+// AnnounceLog.BatchSize entries, or AnnounceLog.FlushInterval milliseconds after its first entry,
+// whichever comes first.  If batching is disabled, the AnnounceLog is saved immediately.
+func QueueAnnounceLog(a AnnounceLog) error {
+	conf := common.Static.Config.AnnounceLog
+	if conf.BatchSize <= 0 {
+		return a.Save()
+	}
+
+	// Record the time of the announce, rather than the time the batch is flushed
+	if a.Time == 0 {
+		a.Time = common.Now().Unix()
+	}
+
+	announceLogBatch.Lock()
+	announceLogBatch.logs = append(announceLogBatch.logs, a)
+	full := len(announceLogBatch.logs) >= conf.BatchSize
+
+	// Start flush timer on the first entry in a batch
+	if !full && announceLogBatch.timer == nil {
+		announceLogBatch.timer = time.AfterFunc(time.Duration(conf.FlushInterval)*time.Millisecond, func() {
+			if err := FlushAnnounceLogs(); err != nil {
+				log.Println(err.Error())
+			}
+		})
+	}
+	announceLogBatch.Unlock()
+
+	if full {
+		return FlushAnnounceLogs()
+	}
+
+	return nil
+}
+
+// FlushAnnounceLogs saves all queued AnnounceLogs to storage in a single batch
+func FlushAnnounceLogs() error {
+	// Take ownership of the current batch, so new announces may be queued while it is saved
+	announceLogBatch.Lock()
+	logs := announceLogBatch.logs
+	announceLogBatch.logs = nil
+	if announceLogBatch.timer != nil {
+		announceLogBatch.timer.Stop()
+		announceLogBatch.timer = nil
+	}
+	announceLogBatch.Unlock()
+
+	if len(logs) == 0 {
+		return nil
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return fmt.Errorf("announce log: dropped %d announces: %s", len(logs), err.Error())
+	}
+
+	// Save AnnounceLogs.  The batch has already been detached, so report how many are lost on failure.
+	if err := withRetry(func() error { return db.SaveAnnounceLogs(logs) }); err != nil {
+		return fmt.Errorf("announce log: dropped %d announces: %s", len(logs), err.Error())
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Load AnnounceLog from storage
 func (a AnnounceLog) Load(ID interface{}, col string) (AnnounceLog, error) {
 	a = AnnounceLog{}
 
+	// Reject columns which may not be used to load records
+	if err := checkColumn("announce_log", col); err != nil {
+		return AnnounceLog{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -101,6 +227,9 @@ func (a *AnnounceLog) FromValues(query url.Values) error {
 		return errors.New("info_hash must be exactly 20 characters")
 	}
 
+	// peer_id (20 characters, 40 characters after hex encode), if present
+	a.PeerID = hex.EncodeToString([]byte(query.Get("peer_id")))
+
 	// passkey
 	a.Passkey = query.Get("passkey")
 
@@ -117,12 +246,20 @@ func (a *AnnounceLog) FromValues(query url.Values) error {
 		a.UDP = false
 	}
 
-	// port
-	port, err := strconv.Atoi(query.Get("port"))
-	if err != nil {
-		return errors.New("invalid integer parameter: port")
+	// port, which may be omitted by stopping clients
+	stopped := query.Get("event") == "stopped"
+	if query.Get("port") == "" && stopped {
+		a.Port = 0
+	} else {
+		port, err := strconv.Atoi(query.Get("port"))
+		if err != nil {
+			return errors.New("invalid integer parameter: port")
+		}
+		if err := ValidatePort(port, stopped); err != nil {
+			return err
+		}
+		a.Port = port
 	}
-	a.Port = port
 
 	// uploaded
 	uploaded, err := strconv.ParseInt(query.Get("uploaded"), 10, 64)
@@ -156,7 +293,55 @@ func (a *AnnounceLog) FromValues(query url.Values) error {
 	a.Client = query.Get("client")
 
 	// Current UNIX timestamp
-	a.Time = time.Now().Unix()
+	a.Time = common.Now().Unix()
 
 	return nil
 }
+
+// Recent loads up to limit of the most recent AnnounceLog structs for a file with the specified
+// info_hash from storage, newest first, skipping the first offset entries
+func (a AnnounceLogRepository) Recent(infoHash string, limit int, offset int) ([]AnnounceLog, error) {
+	announces := make([]AnnounceLog, 0)
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return announces, err
+	}
+
+	// Retrieve recent announces
+	announces, err = db.GetRecentAnnounceLogs(infoHash, limit, offset)
+	if err != nil {
+		return announces, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return announces, err
+	}
+
+	return announces, nil
+}
+
+// Prune deletes all AnnounceLog structs older than the specified UNIX timestamp from storage,
+// returning the number deleted
+func (a AnnounceLogRepository) Prune(before int64) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete old announces
+	count, err := db.DeleteAnnounceLogsBefore(before)
+	if err != nil {
+		return 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
@@ -1,9 +1,14 @@
 package data
 
 import (
+	"errors"
 	"log"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 )
@@ -13,7 +18,7 @@ func TestAnnounceLog(t *testing.T) {
 	log.Println("TestAnnounceLog()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -81,3 +86,200 @@ func TestAnnounceLog(t *testing.T) {
 		t.Fatalf("Failed to delete AnnounceLog: %s", err.Error())
 	}
 }
+
+// TestAnnounceLogStoppedPort verifies that a stopping client may omit its port
+func TestAnnounceLogStoppedPort(t *testing.T) {
+	log.Println("TestAnnounceLogStoppedPort()")
+
+	// Generate fake announce query, with no port
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef000000000000")
+	query.Set("ip", "127.0.0.1")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "0")
+
+	// Verify port is required for a periodic announce
+	announce := new(AnnounceLog)
+	if err := announce.FromValues(query); err == nil {
+		t.Fatalf("Expected error for missing port")
+	}
+
+	// Verify port is not required for a stopped announce
+	query.Set("event", "stopped")
+	if err := announce.FromValues(query); err != nil {
+		t.Fatalf("Failed to parse stopped announce: %s", err.Error())
+	}
+
+	if announce.Port != 0 {
+		t.Fatalf("Port, expected 0, got %d", announce.Port)
+	}
+}
+
+// announceLogDB is an in-memory database backend which records each save of announce logs
+type announceLogDB struct {
+	dbModel
+	sync.Mutex
+	saves [][]AnnounceLog
+}
+
+// Close does nothing, as there is no connection
+func (db *announceLogDB) Close() error {
+	return nil
+}
+
+// SaveAnnounceLog records a single announce log save
+func (db *announceLogDB) SaveAnnounceLog(a AnnounceLog) error {
+	return db.SaveAnnounceLogs([]AnnounceLog{a})
+}
+
+// SaveAnnounceLogs records a batch of announce logs saved together
+func (db *announceLogDB) SaveAnnounceLogs(logs []AnnounceLog) error {
+	db.Lock()
+	defer db.Unlock()
+
+	db.saves = append(db.saves, logs)
+	return nil
+}
+
+// rows returns all announce logs saved, in order, and the number of saves used to store them
+func (db *announceLogDB) rows() ([]AnnounceLog, int) {
+	db.Lock()
+	defer db.Unlock()
+
+	rows := []AnnounceLog{}
+	for _, logs := range db.saves {
+		rows = append(rows, logs...)
+	}
+
+	return rows, len(db.saves)
+}
+
+// TestQueueAnnounceLog verifies that queued announce logs are saved in batches once the batch size or
+// flush interval is reached, and that batching saves the same rows as saving each announce individually
+func TestQueueAnnounceLog(t *testing.T) {
+	log.Println("TestQueueAnnounceLog()")
+
+	// Serve in-memory databases, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+
+	var db *announceLogDB
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+
+	announces := make([]AnnounceLog, 5)
+	for i := range announces {
+		announces[i] = AnnounceLog{InfoHash: "6465616462656566303030303030303030303030", IP: "10.0.0.1", Port: 6881 + i, Time: 1400000000}
+	}
+
+	// Verify announces are saved individually when batching is disabled
+	db = new(announceLogDB)
+	common.Static.Config.AnnounceLog.BatchSize = 0
+	for _, a := range announces {
+		if err := QueueAnnounceLog(a); err != nil {
+			t.Fatalf("Failed to queue AnnounceLog: %s", err.Error())
+		}
+	}
+
+	individual, saves := db.rows()
+	if saves != len(announces) {
+		t.Fatalf("Individual saves, expected %d, got %d", len(announces), saves)
+	}
+
+	// Verify a full batch is saved immediately, and the remainder is saved once flushed
+	db = new(announceLogDB)
+	common.Static.Config.AnnounceLog.BatchSize = 3
+	common.Static.Config.AnnounceLog.FlushInterval = 60000
+	for _, a := range announces {
+		if err := QueueAnnounceLog(a); err != nil {
+			t.Fatalf("Failed to queue AnnounceLog: %s", err.Error())
+		}
+	}
+
+	if _, saves := db.rows(); saves != 1 {
+		t.Fatalf("Batched saves before flush, expected 1, got %d", saves)
+	}
+	if err := FlushAnnounceLogs(); err != nil {
+		t.Fatalf("Failed to flush AnnounceLogs: %s", err.Error())
+	}
+
+	batched, saves := db.rows()
+	if saves != 2 {
+		t.Fatalf("Batched saves after flush, expected 2, got %d", saves)
+	}
+	if !reflect.DeepEqual(batched, individual) {
+		t.Fatalf("Batched rows, expected %+v, got %+v", individual, batched)
+	}
+
+	// Verify flushing an empty batch saves nothing
+	if err := FlushAnnounceLogs(); err != nil {
+		t.Fatalf("Failed to flush AnnounceLogs: %s", err.Error())
+	}
+	if _, saves := db.rows(); saves != 2 {
+		t.Fatalf("Saves after empty flush, expected 2, got %d", saves)
+	}
+
+	// Verify a partial batch is saved once the flush interval elapses
+	db = new(announceLogDB)
+	common.Static.Config.AnnounceLog.FlushInterval = 10
+	if err := QueueAnnounceLog(announces[0]); err != nil {
+		t.Fatalf("Failed to queue AnnounceLog: %s", err.Error())
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, saves := db.rows(); saves == 1 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Partial batch was not saved after flush interval")
+}
+
+// failedAnnounceLogDB is a database backend which fails every save of announce logs
+type failedAnnounceLogDB struct {
+	dbModel
+}
+
+// Close does nothing, as there is no connection
+func (db failedAnnounceLogDB) Close() error {
+	return nil
+}
+
+// SaveAnnounceLogs always fails
+func (db failedAnnounceLogDB) SaveAnnounceLogs(logs []AnnounceLog) error {
+	return errors.New("save failed")
+}
+
+// TestFlushAnnounceLogsFailure verifies that a failed flush reports the number of announces dropped
+func TestFlushAnnounceLogsFailure(t *testing.T) {
+	log.Println("TestFlushAnnounceLogsFailure()")
+
+	// Serve failing database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = func() (dbModel, error) {
+		return failedAnnounceLogDB{}, nil
+	}
+
+	common.Static.Config.DB.Retries = 0
+	common.Static.Config.AnnounceLog.BatchSize = 10
+	common.Static.Config.AnnounceLog.FlushInterval = 60000
+	for i := 0; i < 3; i++ {
+		if err := QueueAnnounceLog(AnnounceLog{IP: "10.0.0.1", Port: 6881 + i}); err != nil {
+			t.Fatalf("Failed to queue AnnounceLog: %s", err.Error())
+		}
+	}
+
+	err := FlushAnnounceLogs()
+	if err == nil || !strings.Contains(err.Error(), "dropped 3 announces") {
+		t.Fatalf("Failed flush, expected dropped count, got: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// ValidatePort checks that an announced port may be added to the peer list.  Ports must be between 1 and
+// 65535, and if StrictPort is set, privileged ports below 1024 are also rejected.  A stopping client is
+// leaving the swarm, so it may report port 0 or a privileged port.
+func ValidatePort(port int, stopped bool) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %d", port)
+	}
+
+	// Stopping clients are not added to the peer list
+	if stopped {
+		return nil
+	}
+
+	if port == 0 {
+		return errors.New("invalid port: 0")
+	}
+	if common.Static.Config.StrictPort && port < 1024 {
+		return fmt.Errorf("privileged port not permitted: %d", port)
+	}
+
+	return nil
+}
+
+// AnnounceRequest represents a parsed and validated HTTP tracker announce
+type AnnounceRequest struct {
+	InfoHash   []byte
+	PeerID     []byte
+	IP         net.IP
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string
+	NumWant    int
+	Compact    bool
+	Key        string
+	Passkey    string
+}
+
+// Parse creates an AnnounceRequest from a HTTP request's querystring, using the remote address
+// if no IP is set, and the passkey from the request path, if present
+func (a AnnounceRequest) Parse(r *http.Request) (AnnounceRequest, error) {
+	query := r.URL.Query()
+
+	// If no IP set, detect it from remote address
+	if query.Get("ip") == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		query.Set("ip", host)
+	}
+
+	// Passkey announces are in the form: /passkey/announce
+	if query.Get("passkey") == "" {
+		if path := strings.Split(r.URL.Path, "/"); len(path) == 3 {
+			query.Set("passkey", path[1])
+		}
+	}
+
+	return a.FromValues(query)
+}
+
+// FromValues creates an AnnounceRequest from announce parameters, validating them
+func (a AnnounceRequest) FromValues(query url.Values) (AnnounceRequest, error) {
+	// event, which may be empty for periodic announces
+	event, err := normalizeEvent(query.Get("event"), common.Static.Config.StrictEvent)
+	if err != nil {
+		return a, err
+	}
+	a.Event = event
+
+	// A stopping client is leaving the swarm, so its port is irrelevant
+	stopped := a.Event == "stopped"
+
+	// Check for required parameters
+	for _, r := range []string{"info_hash", "ip", "port", "uploaded", "downloaded", "left"} {
+		if query.Get(r) == "" && !(r == "port" && stopped) {
+			return a, errors.New("missing required parameter: " + r)
+		}
+	}
+
+	// info_hash (20 bytes)
+	a.InfoHash = []byte(query.Get("info_hash"))
+	if len(a.InfoHash) != 20 {
+		return a, errors.New("info_hash must be exactly 20 bytes")
+	}
+
+	// peer_id (20 bytes), if present
+	if query.Get("peer_id") != "" {
+		a.PeerID = []byte(query.Get("peer_id"))
+		if len(a.PeerID) != 20 {
+			return a, errors.New("peer_id must be exactly 20 bytes")
+		}
+	}
+
+	// ip
+	if a.IP = net.ParseIP(query.Get("ip")); a.IP == nil {
+		return a, errors.New("invalid parameter: ip")
+	}
+
+	// port, which may be omitted by stopping clients
+	port := 0
+	if query.Get("port") != "" {
+		if port, err = strconv.Atoi(query.Get("port")); err != nil {
+			return a, errors.New("invalid integer parameter: port")
+		}
+	}
+	if err := ValidatePort(port, stopped); err != nil {
+		return a, err
+	}
+	a.Port = uint16(port)
+
+	// uploaded, downloaded, left
+	for _, p := range []struct {
+		name  string
+		value *int64
+	}{
+		{"uploaded", &a.Uploaded},
+		{"downloaded", &a.Downloaded},
+		{"left", &a.Left},
+	} {
+		i, err := strconv.ParseInt(query.Get(p.name), 10, 64)
+		if err != nil || i < 0 {
+			return a, errors.New("invalid integer parameter: " + p.name)
+		}
+		*p.value = i
+	}
+
+	// numwant, which defaults to 50 per protocol
+	a.NumWant = 50
+	if query.Get("numwant") != "" {
+		num, err := strconv.Atoi(query.Get("numwant"))
+		if err != nil {
+			return a, errors.New("invalid integer parameter: numwant")
+		}
+		a.NumWant = num
+	}
+
+	// compact, which is assumed unless a client explicitly disables it
+	a.Compact = query.Get("compact") != "0"
+
+	// key
+	a.Key = query.Get("key")
+
+	// passkey
+	a.Passkey = query.Get("passkey")
+
+	return a, nil
+}
+
+// normalizeEvent converts an announce event to its lowercase protocol form, regardless of the
+// casing sent by a client.  Unknown events are rejected in strict mode, and otherwise treated
+// as a periodic announce.
+func normalizeEvent(event string, strict bool) (string, error) {
+	switch e := strings.ToLower(event); e {
+	case "", "started", "completed", "stopped":
+		return e, nil
+	// Some clients send "empty" in place of no event
+	case "empty":
+		return "", nil
+	}
+
+	if strict {
+		return "", errors.New("invalid event: " + event)
+	}
+
+	return "", nil
+}
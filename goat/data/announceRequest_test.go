@@ -0,0 +1,194 @@
+package data
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// announceRequestTests contains announce URLs, and whether they should parse successfully
+var announceRequestTests = []struct {
+	url string
+	ok  bool
+}{
+	// Valid announces
+	{"/announce?info_hash=deadbeef000000000000&port=5000&uploaded=0&downloaded=0&left=10&compact=1", true},
+	{"/announce?info_hash=deadbeef000000000000&peer_id=-GO0001-000000000000&port=5000&uploaded=0&downloaded=0&left=0&event=started", true},
+	{"/announce?info_hash=deadbeef000000000000&ip=10.0.0.1&port=65535&uploaded=1&downloaded=2&left=3&numwant=10&event=empty", true},
+	{"/announce?info_hash=deadbeef000000000000&ip=::1&port=5000&uploaded=0&downloaded=0&left=10", true},
+	{"/announce?info_hash=deadbeef000000000000&uploaded=0&downloaded=0&left=10&event=stopped", true},
+	{"/0123456789abcdef/announce?info_hash=deadbeef000000000000&port=5000&uploaded=0&downloaded=0&left=10", true},
+	// Missing parameters
+	{"/announce", false},
+	{"/announce?port=5000&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=5000&downloaded=0&left=10", false},
+	// Invalid parameters
+	{"/announce?info_hash=deadbeef&port=5000&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&peer_id=abc&port=5000&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&ip=abc&port=5000&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=abc&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=0&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=65536&uploaded=0&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=5000&uploaded=-1&downloaded=0&left=10", false},
+	{"/announce?info_hash=deadbeef000000000000&port=5000&uploaded=0&downloaded=0&left=10&numwant=abc", false},
+	{"/announce?info_hash=deadbeef000000000000&port=5000&uploaded=0&downloaded=0&left=10&event=paused", false},
+}
+
+// validatePortTests contains announced ports, whether the client is stopping, whether privileged
+// ports are rejected, and whether the port should be valid
+var validatePortTests = []struct {
+	port    int
+	stopped bool
+	strict  bool
+	valid   bool
+}{
+	{-1, false, false, false},
+	{0, false, false, false},
+	{0, true, false, true},
+	{0, true, true, true},
+	{1, false, false, true},
+	{1, false, true, false},
+	{1023, false, true, false},
+	{1023, true, true, true},
+	{1024, false, true, true},
+	{65535, false, true, true},
+	{65536, false, false, false},
+	{65536, true, false, false},
+}
+
+// TestValidatePort verifies that announced ports are validated properly, including boundary ports
+func TestValidatePort(t *testing.T) {
+	log.Println("TestValidatePort()")
+
+	// Iterate all tests
+	for _, test := range validatePortTests {
+		common.Static.Config.StrictPort = test.strict
+
+		err := ValidatePort(test.port, test.stopped)
+		if (err == nil) != test.valid {
+			t.Fatalf("ValidatePort(%d, stopped %v, strict %v), expected valid %v, got error: %v",
+				test.port, test.stopped, test.strict, test.valid, err)
+		}
+	}
+
+	common.Static.Config.StrictPort = false
+}
+
+// announceEventTests contains announce events, the event they should be normalized to, and whether
+// they should be accepted in strict mode
+var announceEventTests = []struct {
+	event  string
+	result string
+	strict bool
+}{
+	{"", "", true},
+	{"started", "started", true},
+	{"Started", "started", true},
+	{"STOPPED", "stopped", true},
+	{"Completed", "completed", true},
+	{"cOmPlEtEd", "completed", true},
+	{"Empty", "", true},
+	{"paused", "", false},
+	{"Paused", "", false},
+}
+
+// TestAnnounceRequestParse verifies that announce requests are parsed and validated properly
+func TestAnnounceRequestParse(t *testing.T) {
+	log.Println("TestAnnounceRequestParse()")
+
+	// Reject unknown events
+	common.Static.Config.StrictEvent = true
+
+	// Iterate all tests
+	for _, test := range announceRequestTests {
+		r, err := http.NewRequest("GET", "http://localhost:8080"+test.url, nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.RemoteAddr = "127.0.0.1:12345"
+
+		_, err = new(AnnounceRequest).Parse(r)
+		if test.ok && err != nil {
+			t.Fatalf("Parse(%s), expected valid, got error: %s", test.url, err.Error())
+		}
+		if !test.ok && err == nil {
+			t.Fatalf("Parse(%s), expected error, got valid", test.url)
+		}
+	}
+
+	// Verify parsed fields of a complete announce
+	url := "/0123456789abcdef/announce?info_hash=deadbeef000000000000&peer_id=-GO0001-000000000000" +
+		"&port=5000&uploaded=1&downloaded=2&left=3&event=completed&numwant=10&compact=0&key=abc"
+	r, err := http.NewRequest("GET", "http://localhost:8080"+url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.RemoteAddr = "10.0.0.1:12345"
+
+	announce, err := new(AnnounceRequest).Parse(r)
+	if err != nil {
+		t.Fatalf("Failed to parse announce: %s", err.Error())
+	}
+
+	if string(announce.InfoHash) != "deadbeef000000000000" || string(announce.PeerID) != "-GO0001-000000000000" {
+		t.Fatalf("Unexpected info_hash or peer_id: %q %q", announce.InfoHash, announce.PeerID)
+	}
+	if announce.IP.String() != "10.0.0.1" || announce.Port != 5000 {
+		t.Fatalf("Unexpected IP or port: %s %d", announce.IP, announce.Port)
+	}
+	if announce.Uploaded != 1 || announce.Downloaded != 2 || announce.Left != 3 {
+		t.Fatalf("Unexpected statistics: %d %d %d", announce.Uploaded, announce.Downloaded, announce.Left)
+	}
+	if announce.Event != "completed" || announce.NumWant != 10 || announce.Compact {
+		t.Fatalf("Unexpected event, numwant, or compact: %s %d %t", announce.Event, announce.NumWant, announce.Compact)
+	}
+	if announce.Key != "abc" || announce.Passkey != "0123456789abcdef" {
+		t.Fatalf("Unexpected key or passkey: %s %s", announce.Key, announce.Passkey)
+	}
+}
+
+// TestAnnounceRequestEvent verifies that announce events are normalized regardless of case, and
+// that unknown events are only rejected in strict mode
+func TestAnnounceRequestEvent(t *testing.T) {
+	log.Println("TestAnnounceRequestEvent()")
+
+	for _, test := range announceEventTests {
+		query := url.Values{
+			"info_hash":  []string{"deadbeef000000000000"},
+			"ip":         []string{"10.0.0.1"},
+			"port":       []string{"5000"},
+			"uploaded":   []string{"0"},
+			"downloaded": []string{"0"},
+			"left":       []string{"10"},
+			"event":      []string{test.event},
+		}
+
+		// Verify handling in strict mode
+		common.Static.Config.StrictEvent = true
+		announce, err := new(AnnounceRequest).FromValues(query)
+		if test.strict && err != nil {
+			t.Fatalf("FromValues(event=%s), expected valid, got error: %s", test.event, err.Error())
+		}
+		if !test.strict && err == nil {
+			t.Fatalf("FromValues(event=%s), expected error in strict mode, got valid", test.event)
+		}
+		if test.strict && announce.Event != test.result {
+			t.Fatalf("FromValues(event=%s), expected event %q, got %q", test.event, test.result, announce.Event)
+		}
+
+		// Verify all events are accepted when not in strict mode
+		common.Static.Config.StrictEvent = false
+		if announce, err = new(AnnounceRequest).FromValues(query); err != nil {
+			t.Fatalf("FromValues(event=%s), expected valid, got error: %s", test.event, err.Error())
+		}
+		if announce.Event != test.result {
+			t.Fatalf("FromValues(event=%s), expected event %q, got %q", test.event, test.result, announce.Event)
+		}
+	}
+
+	common.Static.Config.StrictEvent = true
+}
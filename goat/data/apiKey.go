@@ -1,13 +1,21 @@
 package data
 
 import (
-	"crypto/sha1"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 )
 
+// apiKeyAttempts is the number of times generation of a new APIKey is attempted, before giving up
+// because every generated pubkey was already in use
+const apiKeyAttempts = 3
+
+// ErrAPIKeyExists is returned when a new APIKey could not be generated with a unique pubkey
+var ErrAPIKeyExists = errors.New("could not generate unique API key")
+
 // APIKey represents a user's API key
 type APIKey struct {
 	ID     int
@@ -44,26 +52,67 @@ func (a APIKey) ToJSON() (JSONAPIKey, error) {
 func (a *APIKey) Create(userID int) error {
 	a.UserID = userID
 
-	// Generate API pubkey using a random SHA1 hash
-	sha := sha1.New()
-	if _, err := sha.Write([]byte(common.RandString())); err != nil {
+	// Generate API pubkey from random bytes
+	pubkey, err := randomHex(20)
+	if err != nil {
 		return err
 	}
-	a.Pubkey = fmt.Sprintf("%x", sha.Sum(nil))
+	a.Pubkey = pubkey
 
-	// Generate API secret using a random SHA1 hash
-	sha2 := sha1.New()
-	if _, err := sha2.Write([]byte(common.RandString())); err != nil {
+	// Generate API secret from random bytes
+	secret, err := randomHex(20)
+	if err != nil {
 		return err
 	}
-	a.Secret = fmt.Sprintf("%x", sha2.Sum(nil))
+	a.Secret = secret
 
-	// Set key to expire one week from now
-	a.Expire = time.Now().Add(7 * 24 * time.Hour).Unix()
+	// Set key to expire after the configured period
+	a.Expire = APIKeyExpiry().Unix()
 
 	return nil
 }
 
+// NewAPIKey creates and saves a new APIKey for a user, ensuring its pubkey is not already in use, so
+// an existing key is never overwritten
+func NewAPIKey(userID int) (APIKey, error) {
+	for i := 0; i < apiKeyAttempts; i++ {
+		key := APIKey{}
+		if err := key.Create(userID); err != nil {
+			return APIKey{}, err
+		}
+
+		// Check for an existing key with the same pubkey
+		if _, err := key.Load(key.Pubkey, "pubkey"); err == nil {
+			continue
+		} else if err != ErrNotFound {
+			return APIKey{}, err
+		}
+
+		if err := key.Save(); err != nil {
+			return APIKey{}, err
+		}
+
+		return key, nil
+	}
+
+	return APIKey{}, ErrAPIKeyExists
+}
+
+// APIKeyExpiry returns the time at which an APIKey issued or used now expires
+func APIKeyExpiry() time.Time {
+	return common.Now().Add(time.Duration(common.Static.Config.APIKeyExpiry) * time.Second)
+}
+
+// randomHex generates a hex string from n bytes read from crypto/rand
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
 // Delete APIKey from storage
 func (a APIKey) Delete() error {
 	// Open database connection
@@ -89,6 +138,11 @@ func (a APIKey) Delete() error {
 func (a APIKey) Load(id interface{}, col string) (APIKey, error) {
 	a = APIKey{}
 
+	// Reject columns which may not be used to load records
+	if err := checkColumn("api_keys", col); err != nil {
+		return APIKey{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -118,7 +172,7 @@ func (a APIKey) Save() error {
 	}
 
 	// Save APIKey
-	if err := db.SaveAPIKey(a); err != nil {
+	if err := withRetry(func() error { return db.SaveAPIKey(a) }); err != nil {
 		return err
 	}
 
@@ -153,3 +207,24 @@ func (a APIKeyRepository) All() ([]APIKey, error) {
 
 	return keys, nil
 }
+
+// DeleteAPIKeys revokes all API keys issued to this user
+func (u UserRecord) DeleteAPIKeys() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete all of this user's APIKeys
+	if err = db.DeleteAPIKey(u.ID, "user_id"); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -12,7 +12,7 @@ func TestAPIKey(t *testing.T) {
 	log.Println("TestAPIKey()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -45,3 +45,78 @@ func TestAPIKey(t *testing.T) {
 		t.Fatalf("Failed to delete APIKey: %s", err.Error())
 	}
 }
+
+// collisionDB is a database backend in which the first collisions pubkeys loaded already exist,
+// used to verify that new API keys never overwrite existing ones
+type collisionDB struct {
+	dbModel
+	collisions int
+	loads      int
+	saved      []APIKey
+}
+
+// Close does nothing, as there is no connection
+func (db *collisionDB) Close() error {
+	return nil
+}
+
+// LoadAPIKey reports an existing key until the configured number of collisions have occurred
+func (db *collisionDB) LoadAPIKey(id interface{}, col string) (APIKey, error) {
+	db.loads++
+	if db.loads <= db.collisions {
+		return APIKey{ID: db.loads, UserID: 2, Pubkey: id.(string)}, nil
+	}
+
+	return APIKey{}, ErrNotFound
+}
+
+// SaveAPIKey stores the key in memory
+func (db *collisionDB) SaveAPIKey(key APIKey) error {
+	db.saved = append(db.saved, key)
+	return nil
+}
+
+// TestNewAPIKey verifies that new API keys are generated with random credentials, and that a key
+// whose pubkey is already in use is never saved
+func TestNewAPIKey(t *testing.T) {
+	log.Println("TestNewAPIKey()")
+
+	common.Static.Config = common.DefaultConfig()
+
+	// Serve in-memory database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+
+	// Verify a colliding pubkey is regenerated, and only the unique key is saved
+	db := &collisionDB{collisions: 1}
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+
+	key, err := NewAPIKey(1)
+	if err != nil {
+		t.Fatalf("Failed to create APIKey: %s", err.Error())
+	}
+	if db.loads != 2 || len(db.saved) != 1 || db.saved[0] != key {
+		t.Fatalf("Expected 2 loads and 1 save of %+v, got %d loads and saves %+v", key, db.loads, db.saved)
+	}
+	if key.UserID != 1 || len(key.Pubkey) != 40 || len(key.Secret) != 40 || key.Pubkey == key.Secret {
+		t.Fatalf("Invalid APIKey credentials: %+v", key)
+	}
+
+	// Verify expiration uses the configured period
+	if expire := common.Now().Unix() + int64(common.Static.Config.APIKeyExpiry); key.Expire < expire-1 || key.Expire > expire {
+		t.Fatalf("key.Expire, expected %d, got %d", expire, key.Expire)
+	}
+
+	// Verify no key is saved if every pubkey collides
+	db = &collisionDB{collisions: apiKeyAttempts}
+	if _, err := NewAPIKey(1); err != ErrAPIKeyExists {
+		t.Fatalf("Expected ErrAPIKeyExists, got: %v", err)
+	}
+	if len(db.saved) != 0 {
+		t.Fatalf("Colliding APIKey was saved: %+v", db.saved)
+	}
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"log"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// BanRecord represents a ban on an IP address or a user ID, which prevents announces and scrapes from
+// that address or user.  A ban with an Expire time of 0 is permanent, and otherwise is ignored once
+// that time has passed.
+type BanRecord struct {
+	ID         int
+	IP         string
+	UserID     int `db:"user_id"`
+	Reason     string
+	CreateTime int64 `db:"create_time"`
+	Expire     int64
+}
+
+// Create a new BanRecord for an IP address or user ID, which expires at the specified time, or never
+// if expire is 0
+func (b *BanRecord) Create(ip string, userID int, reason string, expire int64) {
+	b.IP = ip
+	b.UserID = userID
+	b.Reason = reason
+	b.CreateTime = common.Now().Unix()
+	b.Expire = expire
+}
+
+// Active determines if a ban is in effect at the specified time
+func (b BanRecord) Active(now int64) bool {
+	return b.Expire == 0 || b.Expire > now
+}
+
+// Delete BanRecord from storage, lifting the ban
+func (b BanRecord) Delete() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete BanRecord
+	if err = db.DeleteBanRecord(b.ID, "id"); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Load BanRecord from storage
+func (b BanRecord) Load(id interface{}, col string) (BanRecord, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("bans", col); err != nil {
+		return BanRecord{}, err
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return BanRecord{}, err
+	}
+
+	// Load BanRecord using specified column
+	if b, err = db.LoadBanRecord(id, col); err != nil {
+		return BanRecord{}, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return BanRecord{}, err
+	}
+
+	return b, nil
+}
+
+// Save BanRecord to storage
+func (b BanRecord) Save() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save BanRecord
+	if err := withRetry(func() error { return db.SaveBanRecord(b) }); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsBanned determines if an IP address or user ID is subject to an active ban.  Anonymous users,
+// with user ID 0, are only checked by IP address.
+// note: if bans cannot be checked due to a database error, the request is not considered banned
+func IsBanned(ip string, userID int) bool {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		log.Println(err.Error())
+		return false
+	}
+
+	// Count active bans matching IP address or user ID
+	count, err := db.CountActiveBans(ip, userID, common.Now().Unix())
+	if err != nil {
+		log.Println(err.Error())
+		if err := db.Close(); err != nil {
+			log.Println(err.Error())
+		}
+
+		return false
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		log.Println(err.Error())
+	}
+
+	return count > 0
+}
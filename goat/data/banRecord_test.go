@@ -0,0 +1,105 @@
+package data
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// banActiveTests contains ban expiration times, and whether the ban is active at time 1000
+var banActiveTests = []struct {
+	expire int64
+	active bool
+}{
+	{0, true},
+	{2000, true},
+	{1001, true},
+	{1000, false},
+	{500, false},
+}
+
+// TestBanRecordActive verifies that permanent bans are always active, and expiring bans are only
+// active until their expiration time
+func TestBanRecordActive(t *testing.T) {
+	log.Println("TestBanRecordActive()")
+
+	// Iterate all tests
+	for _, test := range banActiveTests {
+		if active := (BanRecord{Expire: test.expire}).Active(1000); active != test.active {
+			t.Fatalf("Active(expire %d), expected %t, got %t", test.expire, test.active, active)
+		}
+	}
+}
+
+// TestBanRecord verifies that BanRecord save, load, and delete work properly, and that permanent and
+// expiring bans are enforced by IsBanned
+func TestBanRecord(t *testing.T) {
+	log.Println("TestBanRecord()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Use a fixed clock, restoring the default afterwards
+	now := time.Unix(1400000000, 0)
+	common.Now = func() time.Time {
+		return now
+	}
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Generate and save a permanent IP ban, and a user ban expiring in one hour
+	ipBan := new(BanRecord)
+	ipBan.Create("10.0.0.66", 0, "test", 0)
+	userBan := new(BanRecord)
+	userBan.Create("", 66, "test", now.Add(time.Hour).Unix())
+
+	for _, b := range []*BanRecord{ipBan, userBan} {
+		if err := b.Save(); err != nil {
+			t.Fatalf("Failed to save BanRecord: %s", err.Error())
+		}
+	}
+
+	// Verify bans can be loaded
+	ipBan2, err := ipBan.Load("10.0.0.66", "ip")
+	if err != nil || ipBan2.Expire != 0 || ipBan2.Reason != "test" {
+		t.Fatalf("Failed to load BanRecord: %+v %v", ipBan2, err)
+	}
+	userBan2, err := userBan.Load(66, "user_id")
+	if err != nil || userBan2.Expire != userBan.Expire {
+		t.Fatalf("Failed to load BanRecord: %+v %v", userBan2, err)
+	}
+
+	// Verify banned IP addresses and users are reported, while others are not
+	if !IsBanned("10.0.0.66", 0) || !IsBanned("10.0.0.1", 66) {
+		t.Fatalf("Banned IP address or user was not reported as banned")
+	}
+	if IsBanned("10.0.0.1", 0) || IsBanned("10.0.0.1", 1) {
+		t.Fatalf("IP address and user without ban were reported as banned")
+	}
+
+	// Verify expiring ban is ignored once it expires, while permanent ban is not
+	now = now.Add(2 * time.Hour)
+	if IsBanned("10.0.0.1", 66) {
+		t.Fatalf("Expired user ban was reported as banned")
+	}
+	if !IsBanned("10.0.0.66", 0) {
+		t.Fatalf("Permanent IP ban was not reported as banned")
+	}
+
+	// Verify bans can be deleted
+	for _, b := range []BanRecord{ipBan2, userBan2} {
+		if err := b.Delete(); err != nil {
+			t.Fatalf("Failed to delete BanRecord: %s", err.Error())
+		}
+	}
+	if IsBanned("10.0.0.66", 0) {
+		t.Fatalf("Deleted IP ban was reported as banned")
+	}
+}
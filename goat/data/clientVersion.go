@@ -0,0 +1,128 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ParseClientVersion parses the client identifier and version encoded in a peer_id, supporting the
+// Azureus-style convention (-TR2840-) used by most clients, and the Mainline-style convention
+// (M7-4-3--).  Azureus-style version digits may be 0-9 or A-Z, representing 10-35.  If the peer_id
+// does not follow either convention, ok is false.
+func ParseClientVersion(peerID []byte) (client string, version []int, ok bool) {
+	if len(peerID) < 8 {
+		return "", nil, false
+	}
+
+	// Azureus-style: dash, two character client identifier, four version digits, dash
+	if peerID[0] == '-' && peerID[7] == '-' {
+		version = make([]int, 0, 4)
+		for _, c := range peerID[3:7] {
+			d, err := strconv.ParseInt(string(c), 36, 0)
+			if err != nil {
+				return "", nil, false
+			}
+
+			version = append(version[:], int(d))
+		}
+
+		return string(peerID[1:3]), version, true
+	}
+
+	// Mainline-style: one character client identifier, followed by dash-separated version numbers
+	if peerID[0] == 'M' {
+		parts := strings.SplitN(string(peerID[1:8]), "-", 4)
+		if len(parts) < 4 {
+			return "", nil, false
+		}
+
+		version = make([]int, 0, 3)
+		for _, p := range parts[:3] {
+			d, err := strconv.Atoi(p)
+			if err != nil {
+				return "", nil, false
+			}
+
+			version = append(version[:], d)
+		}
+
+		return "M", version, true
+	}
+
+	return "", nil, false
+}
+
+// parseVersion parses a dot-separated version string, such as 2.8.4, into its components
+func parseVersion(version string) ([]int, error) {
+	parts := strings.Split(version, ".")
+	components := make([]int, 0, len(parts))
+	for _, p := range parts {
+		d, err := strconv.Atoi(p)
+		if err != nil || d < 0 {
+			return nil, errors.New("invalid version: " + version)
+		}
+
+		components = append(components[:], d)
+	}
+
+	return components, nil
+}
+
+// compareVersions compares two versions component-wise, treating missing components as zero, and
+// returns -1, 0, or 1 if a is older than, equal to, or newer than b
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// ClientVersionAllowed reports whether the client identified by a peer_id meets the minimum version
+// configured for that client.  Minimums are a comma-separated list of client identifiers and versions,
+// such as "TR=2.8.4,UT=3.4", compared component-wise against the version digits in the peer_id.
+// Clients which cannot be identified, or have no configured minimum, are allowed.
+func ClientVersionAllowed(peerID []byte, minimums string) (bool, error) {
+	if minimums == "" {
+		return true, nil
+	}
+
+	// Identify client, allowing unknown clients
+	client, version, ok := ParseClientVersion(peerID)
+	if !ok {
+		return true, nil
+	}
+
+	// Find the minimum version for this client, if one is configured
+	for _, entry := range strings.Split(minimums, ",") {
+		pair := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(pair) != 2 {
+			return true, errors.New("invalid minimum client version: " + entry)
+		}
+		if pair[0] != client {
+			continue
+		}
+
+		minimum, err := parseVersion(pair[1])
+		if err != nil {
+			return true, err
+		}
+
+		return compareVersions(version, minimum) >= 0, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+// parseClientVersionTests contains peer_ids, and their expected client identifiers and versions
+var parseClientVersionTests = []struct {
+	peerID  string
+	client  string
+	version []int
+	ok      bool
+}{
+	{"-TR2840-abcdefghijkl", "TR", []int{2, 8, 4, 0}, true},
+	{"-qB4250-abcdefghijkl", "qB", []int{4, 2, 5, 0}, true},
+	{"-lt0D80-abcdefghijkl", "lt", []int{0, 13, 8, 0}, true},
+	{"M7-4-3--abcdefghijkl", "M", []int{7, 4, 3}, true},
+	{"M10-2-1-abcdefghijkl", "M", []int{10, 2, 1}, true},
+	{"-TR28!0-abcdefghijkl", "", nil, false},
+	{"abcdefghijklmnopqrst", "", nil, false},
+	{"-TR2", "", nil, false},
+}
+
+// TestParseClientVersion verifies that client identifiers and versions are parsed from peer_ids
+func TestParseClientVersion(t *testing.T) {
+	log.Println("TestParseClientVersion()")
+
+	for _, test := range parseClientVersionTests {
+		client, version, ok := ParseClientVersion([]byte(test.peerID))
+		if ok != test.ok || client != test.client || !reflect.DeepEqual(version, test.version) {
+			t.Fatalf("ParseClientVersion(%q), expected %s %v %t, got %s %v %t",
+				test.peerID, test.client, test.version, test.ok, client, version, ok)
+		}
+	}
+}
+
+// clientVersionAllowedTests contains peer_ids and minimum versions, and whether the client should be allowed
+var clientVersionAllowedTests = []struct {
+	peerID   string
+	minimums string
+	allowed  bool
+}{
+	// New version
+	{"-TR2840-abcdefghijkl", "TR=2.8.4", true},
+	{"-TR2930-abcdefghijkl", "UT=3.4,TR=2.8.4", true},
+	{"M7-4-3--abcdefghijkl", "M=7.4", true},
+	// Old version
+	{"-TR2820-abcdefghijkl", "TR=2.8.4", false},
+	{"-TR2820-abcdefghijkl", "UT=3.4, TR=2.8.4", false},
+	{"M6-9-0--abcdefghijkl", "M=7.4", false},
+	// Unknown client, or no minimum configured
+	{"abcdefghijklmnopqrst", "TR=2.8.4", true},
+	{"-UT3450-abcdefghijkl", "TR=2.8.4", true},
+	{"-TR1000-abcdefghijkl", "", true},
+}
+
+// TestClientVersionAllowed verifies that clients older than their configured minimum version are rejected
+func TestClientVersionAllowed(t *testing.T) {
+	log.Println("TestClientVersionAllowed()")
+
+	for _, test := range clientVersionAllowedTests {
+		allowed, err := ClientVersionAllowed([]byte(test.peerID), test.minimums)
+		if err != nil {
+			t.Fatalf("ClientVersionAllowed(%q, %q), unexpected error: %s", test.peerID, test.minimums, err.Error())
+		}
+		if allowed != test.allowed {
+			t.Fatalf("ClientVersionAllowed(%q, %q), expected %t, got %t", test.peerID, test.minimums, test.allowed, allowed)
+		}
+	}
+
+	// Verify malformed minimums are reported, and do not reject clients
+	if allowed, err := ClientVersionAllowed([]byte("-TR2820-abcdefghijkl"), "TR"); err == nil || !allowed {
+		t.Fatalf("Malformed minimum, expected error and allowed, got %v %t", err, allowed)
+	}
+}
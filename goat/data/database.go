@@ -1,7 +1,10 @@
 package data
 
 import (
+	"errors"
 	"time"
+
+	"github.com/mdlayher/goat/goat/common"
 )
 
 var (
@@ -13,8 +16,46 @@ var (
 	DBNameFunc = func() string { return "" }
 	// DBPingFunc checks connectivity to a database backend
 	DBPingFunc = func() bool { return true }
+	// DBRetriableFunc checks if an error from a database backend may succeed if retried
+	DBRetriableFunc = func(error) bool { return false }
 )
 
+// ErrNotFound is returned when loading a single record which does not exist in storage, so it may be
+// distinguished from other database errors
+var ErrNotFound = errors.New("record not found")
+
+// ErrInvalidColumn is returned when loading records by a column which is not permitted for that table
+var ErrInvalidColumn = errors.New("invalid column for query")
+
+// ErrQueryTimeout is returned when a database query does not complete within the configured timeout
+var ErrQueryTimeout = errors.New("database query timed out")
+
+// queryColumns contains the columns by which records in each table may be loaded.  Column names are
+// interpolated directly into some queries, so any column not listed here is rejected.
+var queryColumns = map[string]map[string]bool{
+	"announce_log": {"id": true, "info_hash": true, "passkey": true},
+	"api_keys":     {"id": true, "pubkey": true, "user_id": true},
+	"bans":         {"id": true, "ip": true, "user_id": true},
+	"files":        {"id": true, "info_hash": true},
+	"files_users":  {"file_id": true, "user_id": true},
+	"passkeys":     {"id": true, "passkey": true, "user_id": true},
+	"scrape_log":   {"id": true, "info_hash": true, "passkey": true, "user_id": true},
+	"users":        {"id": true, "username": true, "passkey": true},
+	"whitelist":    {"id": true, "client": true},
+}
+
+// checkColumn returns ErrInvalidColumn if records in the specified table may not be loaded by a column
+func checkColumn(table string, col string) error {
+	if !queryColumns[table][col] {
+		return ErrInvalidColumn
+	}
+
+	return nil
+}
+
+// retryBackoff is the initial delay before retrying a failed database operation, which doubles on each retry
+var retryBackoff = 10 * time.Millisecond
+
 // MySQLDSN is set via command-line, and can be used to override all MySQL configuration
 var MySQLDSN *string
 
@@ -36,6 +77,23 @@ func DBPing() bool {
 	return DBPingFunc()
 }
 
+// withRetry runs a database operation, retrying it up to the configured number of times with
+// a small backoff when it fails with an error the backend reports as retriable, such as a deadlock.
+// Other errors are returned immediately.
+func withRetry(fn func() error) error {
+	backoff := retryBackoff
+
+	err := fn()
+	for i := 0; i < common.Static.Config.DB.Retries && err != nil && DBRetriableFunc(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		err = fn()
+	}
+
+	return err
+}
+
 // dbModel represents a database interface, and defines functions which act on it
 type dbModel interface {
 	Close() error
@@ -44,6 +102,9 @@ type dbModel interface {
 	DeleteAnnounceLog(interface{}, string) error
 	LoadAnnounceLog(interface{}, string) (AnnounceLog, error)
 	SaveAnnounceLog(AnnounceLog) error
+	SaveAnnounceLogs([]AnnounceLog) error
+	GetRecentAnnounceLogs(string, int, int) ([]AnnounceLog, error)
+	DeleteAnnounceLogsBefore(int64) (int, error)
 
 	// --- APIKey.go ---
 	DeleteAPIKey(interface{}, string) error
@@ -51,37 +112,68 @@ type dbModel interface {
 	SaveAPIKey(APIKey) error
 	GetAllAPIKeys() ([]APIKey, error)
 
+	// --- BanRecord.go ---
+	DeleteBanRecord(interface{}, string) error
+	LoadBanRecord(interface{}, string) (BanRecord, error)
+	SaveBanRecord(BanRecord) error
+	CountActiveBans(string, int, int64) (int, error)
+
 	// --- FileRecord.go ---
 	DeleteFileRecord(interface{}, string) error
 	LoadFileRecord(interface{}, string) (FileRecord, error)
 	SaveFileRecord(FileRecord) error
 	CountFileRecordCompleted(int) (int, error)
-	CountFileRecordSeeders(int) (int, error)
-	CountFileRecordLeechers(int) (int, error)
+	CountFileRecordActivePeers(int) (int, int, error)
+	CountFileRecordAnnounces(string, int64) (int, error)
 	GetFileRecordPeerList(string, int, bool) ([]Peer, error)
-	GetInactiveUserInfo(int, time.Duration) ([]peerInfo, error)
+	StreamFileRecordPeerList(string, int, bool, func(Peer) error) error
+	GetInactiveUserInfo(int, int64) ([]peerInfo, error)
 	MarkFileUsersInactive(int, []peerInfo) error
 	GetAllFileRecords() ([]FileRecord, error)
+	GetFileRecordPage(string, int, int) ([]FileRecord, error)
+	CountFileRecords() (int, error)
+	CountActivePeers() (int, int, error)
+
+	// --- Migration.go ---
+	LoadSchemaMigrations() ([]int, error)
+	ApplyMigration(Migration) error
+	DeleteSchemaMigration(int) error
 
 	// --- FileUserRecord.go ---
 	DeleteFileUserRecord(int, int, string) error
 	LoadFileUserRecord(int, int, string) (FileUserRecord, error)
+	LoadFileUserRecordsKey(int, int, string) ([]FileUserRecord, error)
+	MoveFileUserRecord(int, int, string, string) error
 	SaveFileUserRecord(FileUserRecord) error
 	LoadFileUserRepository(interface{}, string) ([]FileUserRecord, error)
 
+	// --- PasskeyRecord.go ---
+	DeletePasskeyRecord(interface{}, string) error
+	LoadPasskeyRecord(interface{}, string) (PasskeyRecord, error)
+	SavePasskeyRecord(PasskeyRecord) error
+	LoadPasskeyRepository(interface{}, string) ([]PasskeyRecord, error)
+
 	// --- ScrapeLog.go ---
 	DeleteScrapeLog(interface{}, string) error
 	LoadScrapeLog(interface{}, string) (ScrapeLog, error)
 	SaveScrapeLog(ScrapeLog) error
 
+	// --- Snapshot.go ---
+	ImportSnapshot(Snapshot) error
+
 	// --- UserRecord.go ---
-	DeleteUserRecord(interface{}, string) error
+	DeleteUserRecord(int) error
+	InsertUserRecord(UserRecord) error
 	LoadUserRecord(interface{}, string) (UserRecord, error)
+	RenameUserRecord(int, string) error
 	SaveUserRecord(UserRecord) error
 	GetUserUploaded(int) (int64, error)
 	GetUserDownloaded(int) (int64, error)
+	GetUserTransfer(int) (int64, int64, error)
 	GetUserSeeding(int) (int, error)
 	GetUserLeeching(int) (int, error)
+	GetUserActive(int) (int, error)
+	GetUserFileActive(int, int) (bool, error)
 	GetAllUserRecords() ([]UserRecord, error)
 
 	// --- WhitelistRecord.go ---
@@ -3,15 +3,18 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 
 	// Bring in the MySQL driver
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -19,18 +22,40 @@ import (
 func init() {
 	// DBConnectFunc connects to MySQL database
 	DBConnectFunc = func() (dbModel, error) {
-		var conn string
-		// Generate connection string using configuration file
-		if MySQLDSN == nil || *MySQLDSN == "" {
-			conn = fmt.Sprintf("%s:%s@/%s", common.Static.Config.DB.Username, common.Static.Config.DB.Password, common.Static.Config.DB.Database)
-		} else {
-			// Use connection string passed by command line flag
-			conn = *MySQLDSN
+		conn := mysqlDSN()
+
+		// Reuse the shared connection pool, opening it on first use
+		mysqlPoolMutex.Lock()
+		defer mysqlPoolMutex.Unlock()
+
+		if mysqlPool == nil {
+			db, err := sqlx.Connect("mysql", conn)
+			if err != nil {
+				return nil, mysqlConnectError(err)
+			}
+
+			// Bound the number of connections in the pool, as configured
+			db.SetMaxOpenConns(common.Static.Config.DB.MaxOpenConns)
+			db.SetMaxIdleConns(common.Static.Config.DB.MaxIdleConns)
+
+			mysqlPool = &dbw{db}
 		}
 
-		// Return connection and associated errors
-		db, err := sqlx.Connect("mysql", conn)
-		return &dbw{db}, err
+		return mysqlPool, nil
+	}
+
+	// DBCloseFunc closes the shared connection pool
+	DBCloseFunc = func() {
+		mysqlPoolMutex.Lock()
+		defer mysqlPoolMutex.Unlock()
+
+		if mysqlPool != nil {
+			if err := mysqlPool.DB.Close(); err != nil {
+				log.Println(err.Error())
+			}
+
+			mysqlPool = nil
+		}
 	}
 
 	// DBNameFunc returns the name of this backend
@@ -46,40 +71,266 @@ func init() {
 			return false
 		}
 
-		if err = db.(*dbw).Close(); err != nil {
+		ctx, cancel := queryContext()
+		defer cancel()
+
+		if err = queryError(ctx, db.(*dbw).PingContext(ctx)); err != nil {
 			log.Println(err.Error())
 			return false
 		}
 
 		return true
 	}
+
+	// DBRetriableFunc checks for MySQL errors which may succeed if the transaction is retried
+	DBRetriableFunc = func(err error) bool {
+		if e, ok := err.(*mysql.MySQLError); ok {
+			return mysqlRetriableErrors[e.Number]
+		}
+
+		return false
+	}
+}
+
+// mysqlDSN returns the connection string used to connect to MySQL.  The command-line DSN takes
+// precedence over the DSN in the configuration file, and if neither is set, a DSN is generated
+// from the individual database settings.
+func mysqlDSN() string {
+	// Use connection string passed by command line flag
+	if MySQLDSN != nil && *MySQLDSN != "" {
+		return *MySQLDSN
+	}
+
+	// Use connection string set in configuration file
+	conf := common.Static.Config.DB
+	if conf.DSN != "" {
+		return conf.DSN
+	}
+
+	// Generate connection string using configuration file
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", conf.Username, conf.Password, conf.Host, conf.Database)
 }
 
-// dbw contains a sqlx MySQL database connection
+// mysqlConnectError annotates an error connecting to MySQL with the setting most likely to be wrong
+func mysqlConnectError(err error) error {
+	// When a DSN is in use, the individual settings are ignored
+	if (MySQLDSN != nil && *MySQLDSN != "") || common.Static.Config.DB.DSN != "" {
+		return fmt.Errorf("mysql: could not connect using DSN, check -mysqldsn or DB.DSN: %s", err.Error())
+	}
+
+	// Errors returned by the server indicate the connection succeeded, but login did not
+	setting := "DB.Host"
+	if e, ok := err.(*mysql.MySQLError); ok {
+		switch e.Number {
+		// ER_DBACCESS_DENIED_ERROR, ER_BAD_DB_ERROR: no access to database, or database does not exist
+		case 1044, 1049:
+			setting = "DB.Database"
+		// ER_ACCESS_DENIED_ERROR: invalid username or password
+		case 1045:
+			setting = "DB.Username and DB.Password"
+		default:
+			return fmt.Errorf("mysql: could not connect to %s: %s", common.Static.Config.DB.Host, err.Error())
+		}
+	}
+
+	return fmt.Errorf("mysql: could not connect to %s, check %s: %s", common.Static.Config.DB.Host, setting, err.Error())
+}
+
+// mysqlRetriableErrors contains MySQL error numbers which indicate a transaction may succeed if retried
+var mysqlRetriableErrors = map[uint16]bool{
+	// ER_LOCK_WAIT_TIMEOUT: lock wait timeout exceeded
+	1205: true,
+	// ER_LOCK_DEADLOCK: deadlock found when trying to get lock
+	1213: true,
+}
+
+// announceLogDeleteBatch is the maximum number of AnnounceLogs deleted by a single query, so that
+// pruning a large table does not hold locks for, or exceed, the query timeout
+const announceLogDeleteBatch = 10000
+
+var (
+	// mysqlPool is the connection pool shared by all MySQL operations
+	mysqlPool *dbw
+	// mysqlPoolMutex guards opening and closing of the shared connection pool
+	mysqlPoolMutex sync.Mutex
+)
+
+// dbw contains a sqlx MySQL database connection pool
 type dbw struct {
 	*sqlx.DB
 }
 
-// Close database connection
+// Close releases the database connection.  The shared connection pool remains open, so connections
+// may be reused, until it is closed using DBCloseFunc.
 func (db *dbw) Close() error {
-	return db.DB.Close()
+	return nil
+}
+
+// queryContext returns a context which expires after the configured query timeout, or never expires
+// if the timeout is disabled.  The cancel function must always be called to release its resources.
+func queryContext() (context.Context, context.CancelFunc) {
+	if timeout := common.Static.Config.DB.QueryTimeout; timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// queryError replaces an error caused by a query exceeding its deadline with ErrQueryTimeout
+func queryError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return ErrQueryTimeout
+	}
+
+	return err
+}
+
+// dbRows contains rows returned by a query, and cancels the query's context once they are closed
+type dbRows struct {
+	*sqlx.Rows
+	cancel context.CancelFunc
+}
+
+// Close closes the rows, releasing their connection, and cancels the query's context
+func (r *dbRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// dbTx contains a transaction, in which each query is bounded by the configured query timeout
+type dbTx struct {
+	*sqlx.Tx
+}
+
+// Exec executes a query in the transaction, returning ErrQueryTimeout if it does not complete in time
+func (tx *dbTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := queryContext()
+	defer cancel()
+
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	return result, queryError(ctx, err)
+}
+
+// get loads a single record into dest, returning ErrQueryTimeout if the query does not complete in time
+func (db *dbw) get(dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := queryContext()
+	defer cancel()
+
+	return queryError(ctx, db.GetContext(ctx, dest, query, args...))
+}
+
+// queryx executes a query which returns rows, returning ErrQueryTimeout if it does not complete in
+// time.  The rows must be closed to release the connection.
+func (db *dbw) queryx(query string, args ...interface{}) (*dbRows, error) {
+	ctx, cancel := queryContext()
+
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, queryError(ctx, err)
+	}
+
+	return &dbRows{rows, cancel}, nil
+}
+
+// exec executes a query without returning rows, returning ErrQueryTimeout if it does not complete in time
+func (db *dbw) exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := queryContext()
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	return result, queryError(ctx, err)
+}
+
+// begin starts a transaction, in which each query is bounded by the configured query timeout
+func (db *dbw) begin() (*dbTx, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dbTx{tx}, nil
+}
+
+// mustBegin starts a transaction, panicking if it cannot be started
+func (db *dbw) mustBegin() *dbTx {
+	tx, err := db.begin()
+	if err != nil {
+		panic(err)
+	}
+
+	return tx
+}
+
+// execTx executes a query in a transaction, rolling it back if the query fails
+func (db *dbw) execTx(query string, args ...interface{}) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			log.Println(err2.Error())
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getRecord loads a single record into dest, returning ErrNotFound if no record matches the query
+func (db *dbw) getRecord(dest interface{}, query string, args ...interface{}) error {
+	if err := db.get(dest, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+
+		return err
+	}
+
+	return nil
 }
 
 // --- AnnounceLog.go ---
 
 // DeleteAnnounceLog deletes an AnnounceLog using a defined ID and column
 func (db *dbw) DeleteAnnounceLog(id interface{}, col string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM announce_log WHERE `"+col+"` = ?", id)
 
 	return tx.Commit()
 }
 
+// DeleteAnnounceLogsBefore deletes all AnnounceLogs older than the specified UNIX timestamp,
+// returning the number deleted
+func (db *dbw) DeleteAnnounceLogsBefore(before int64) (int, error) {
+	// Delete in batches, each bounded by the query timeout, until none remain
+	total := 0
+	for {
+		result, err := db.exec("DELETE FROM announce_log WHERE `time` < ? LIMIT ?;", before, announceLogDeleteBatch)
+		if err != nil {
+			return total, err
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += int(count)
+		if count < announceLogDeleteBatch {
+			return total, nil
+		}
+	}
+}
+
 // LoadAnnounceLog loads an AnnounceLog using a defined ID and column for query
 func (db *dbw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error) {
 	data := AnnounceLog{}
 
-	if err := db.Get(&data, "SELECT * FROM announce_log WHERE `"+col+"`=?", id); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&data, "SELECT * FROM announce_log WHERE `"+col+"`=?", id); err != nil {
 		return AnnounceLog{}, err
 	}
 
@@ -89,20 +340,86 @@ func (db *dbw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error)
 // SaveAnnounceLog saves an AnnounceLog to database
 func (db *dbw) SaveAnnounceLog(a AnnounceLog) error {
 	query := "INSERT INTO announce_log " +
-		"(`info_hash`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
-		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP());"
+		"(`info_hash`, `peer_id`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP());"
+
+	return db.execTx(query, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client)
+}
+
+// mysqlAnnounceLogChunk is the maximum number of AnnounceLogs saved by a single INSERT, keeping each
+// statement well below MySQL's limit of 65535 placeholders
+const mysqlAnnounceLogChunk = 1000
+
+// SaveAnnounceLogs saves multiple AnnounceLogs to database in a single transaction, using one INSERT
+// per chunk of mysqlAnnounceLogChunk rows, preserving the time at which each was queued
+func (db *dbw) SaveAnnounceLogs(logs []AnnounceLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	for len(logs) > 0 {
+		chunk := logs
+		if len(chunk) > mysqlAnnounceLogChunk {
+			chunk = chunk[:mysqlAnnounceLogChunk]
+		}
+		logs = logs[len(chunk):]
 
-	tx := db.MustBegin()
-	tx.Exec(query, a.InfoHash, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client)
+		query := "INSERT INTO announce_log " +
+			"(`info_hash`, `peer_id`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", len(chunk)-1) + ";"
+
+		args := make([]interface{}, 0, len(chunk)*13)
+		for _, a := range chunk {
+			args = append(args, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client, a.Time)
+		}
+
+		if _, err := tx.Exec(query, args...); err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				log.Println(err2.Error())
+			}
+
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
 
+// GetRecentAnnounceLogs returns up to limit of the most recent AnnounceLogs for a file, newest first,
+// skipping the first offset entries
+func (db *dbw) GetRecentAnnounceLogs(infoHash string, limit int, offset int) ([]AnnounceLog, error) {
+	query := "SELECT * FROM announce_log WHERE `info_hash` = ? ORDER BY `time` DESC, `id` DESC LIMIT ? OFFSET ?;"
+	rows, err := db.queryx(query, infoHash, limit, offset)
+	announces, announce := []AnnounceLog{}, AnnounceLog{}
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err.Error())
+		return announces, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&announce); err != nil {
+			return announces, err
+		}
+
+		announces = append(announces[:], announce)
+	}
+
+	return announces, rows.Err()
+}
+
 // --- APIKey.go ---
 
 // DeleteAPIKey deletes an APIKey using a defined ID and column
 func (db *dbw) DeleteAPIKey(id interface{}, col string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM api_keys WHERE `"+col+"` = ?", id)
 
 	return tx.Commit()
@@ -112,8 +429,7 @@ func (db *dbw) DeleteAPIKey(id interface{}, col string) error {
 func (db *dbw) LoadAPIKey(id interface{}, col string) (APIKey, error) {
 	key := APIKey{}
 
-	err := db.Get(&key, "SELECT * FROM api_keys WHERE `"+col+"`=?", id)
-	if err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&key, "SELECT * FROM api_keys WHERE `"+col+"`=?", id); err != nil {
 		return APIKey{}, err
 	}
 
@@ -126,15 +442,12 @@ func (db *dbw) SaveAPIKey(key APIKey) error {
 		"VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE " +
 		"`expire`=values(`expire`);"
 
-	tx := db.MustBegin()
-	tx.Exec(query, key.UserID, key.Pubkey, key.Secret, key.Expire)
-
-	return tx.Commit()
+	return db.execTx(query, key.UserID, key.Pubkey, key.Secret, key.Expire)
 }
 
 // GetAllAPIKeys returns a list of all APIKeys known to the database
 func (db *dbw) GetAllAPIKeys() ([]APIKey, error) {
-	rows, err := db.Queryx("SELECT * FROM api_keys")
+	rows, err := db.queryx("SELECT * FROM api_keys")
 	keys, key := []APIKey{}, APIKey{}
 
 	if err != nil && err != sql.ErrNoRows {
@@ -142,22 +455,67 @@ func (db *dbw) GetAllAPIKeys() ([]APIKey, error) {
 		return keys, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&key); err != nil {
-			break
+			return keys, err
 		}
 
 		keys = append(keys[:], key)
 	}
 
-	return keys, nil
+	return keys, rows.Err()
+}
+
+// --- BanRecord.go ---
+
+// DeleteBanRecord deletes a BanRecord using a defined ID and column
+func (db *dbw) DeleteBanRecord(id interface{}, col string) error {
+	tx := db.mustBegin()
+	tx.Exec("DELETE FROM bans WHERE `"+col+"` = ?", id)
+
+	return tx.Commit()
+}
+
+// LoadBanRecord loads a BanRecord using a defined ID and column for query
+func (db *dbw) LoadBanRecord(id interface{}, col string) (BanRecord, error) {
+	result := BanRecord{}
+	if err := db.getRecord(&result, "SELECT * FROM bans WHERE `"+col+"`=? ORDER BY `id` DESC LIMIT 1", id); err != nil {
+		return BanRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveBanRecord saves a BanRecord to the database, inserting it if it is new, or updating it otherwise
+func (db *dbw) SaveBanRecord(b BanRecord) error {
+	if b.ID == 0 {
+		query := "INSERT INTO bans (`ip`, `user_id`, `reason`, `create_time`, `expire`) VALUES (?, ?, ?, ?, ?);"
+		return db.execTx(query, b.IP, b.UserID, b.Reason, b.CreateTime, b.Expire)
+	}
+
+	query := "UPDATE bans SET `ip`=?, `user_id`=?, `reason`=?, `expire`=? WHERE `id`=?;"
+	return db.execTx(query, b.IP, b.UserID, b.Reason, b.Expire, b.ID)
+}
+
+// CountActiveBans counts the bans on an IP address or user ID which have not expired at the specified time
+func (db *dbw) CountActiveBans(ip string, userID int, now int64) (int, error) {
+	result := struct{ Count int }{0}
+	query := "SELECT COUNT(*) AS count FROM bans " +
+		"WHERE ((`ip` != '' AND `ip` = ?) OR (`user_id` != 0 AND `user_id` = ?)) AND (`expire` = 0 OR `expire` > ?);"
+	if err := db.get(&result, query, ip, userID, now); err != nil {
+		return 0, err
+	}
+
+	return result.Count, nil
 }
 
 // --- FileRecord.go ---
 
 // DeleteFileRecord deletes an AnnounceLog using a defined ID and column
 func (db *dbw) DeleteFileRecord(id interface{}, col string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM files WHERE `"+col+"` = ?", id)
 
 	return tx.Commit()
@@ -167,7 +525,7 @@ func (db *dbw) DeleteFileRecord(id interface{}, col string) error {
 func (db *dbw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 	data := FileRecord{}
 
-	if err := db.Get(&data, "SELECT * FROM files WHERE `"+col+"`=?", id); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&data, "SELECT * FROM files WHERE `"+col+"`=?", id); err != nil {
 		return FileRecord{}, err
 	}
 
@@ -177,123 +535,167 @@ func (db *dbw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 // SaveFileRecord saves a FileRecord to the database
 func (db *dbw) SaveFileRecord(f FileRecord) error {
 	query := "INSERT INTO files " +
-		"(`info_hash`, `verified`, `create_time`, `update_time`) " +
-		"VALUES (?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP()) " +
+		"(`info_hash`, `verified`, `create_time`, `update_time`, `announce_interval`, `peer_limit`) " +
+		"VALUES (?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP(), ?, ?) " +
 		"ON DUPLICATE KEY UPDATE " +
-		"`verified`=values(`verified`), `update_time`=UNIX_TIMESTAMP();"
-
-	tx := db.MustBegin()
-	tx.Exec(query, f.InfoHash, f.Verified)
+		"`verified`=values(`verified`), `update_time`=UNIX_TIMESTAMP(), " +
+		"`announce_interval`=values(`announce_interval`), `peer_limit`=values(`peer_limit`);"
 
-	return tx.Commit()
+	return db.execTx(query, f.InfoHash, f.Verified, f.AnnounceInterval, f.PeerLimit)
 }
 
-// CountFileRecordCompleted counts the number of peers who have completed this file
+// CountFileRecordCompleted counts the number of distinct users who have completed this file
 func (db *dbw) CountFileRecordCompleted(id int) (int, error) {
-	// Calculate number of completions on this file, defined as users who are completed, and 0 left
-	query := "SELECT COUNT(user_id) AS completed FROM files_users WHERE file_id = ? AND completed = 1 AND `left` = 0;"
+	// Calculate number of completions on this file, defined as distinct users who have ever completed,
+	// so a user completing more than once, or from more than one IP, is only counted once
+	query := "SELECT COUNT(DISTINCT user_id) AS completed FROM files_users WHERE file_id = ? AND snatched = 1;"
 	result := struct{ Completed int }{0}
 
-	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+	if err := db.get(&result, query, id); err != nil && err != sql.ErrNoRows {
 		return -1, err
 	}
 
 	return result.Completed, nil
 }
 
-// CountFileRecordCompleted counts the number of peers who are actively seeding this file
-func (db *dbw) CountFileRecordSeeders(id int) (int, error) {
-	// Calculate number of seeders on this file, defined as users who are active, completed, and 0 left
-	query := "SELECT COUNT(user_id) AS seeders FROM files_users WHERE file_id = ? AND active = 1 AND completed = 1 AND `left` = 0;"
-	result := struct{ Seeders int }{0}
-
-	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
-		return -1, err
+// CountFileRecordActivePeers counts the number of peers who are actively seeding and leeching this file
+func (db *dbw) CountFileRecordActivePeers(id int) (int, int, error) {
+	// Calculate number of seeders and leechers on this file in a single pass over its active peers.
+	// Seeders are defined as users who are active, completed, and 0 left, and leechers are defined as
+	// users who are active, not completed, and some left.
+	// A dual-stack client announcing from more than one address is identified by its peer ID, and
+	// counted once.
+	query := "SELECT COUNT(DISTINCT CASE WHEN completed = 1 AND `left` = 0 THEN " +
+		"CONCAT(user_id, '/', IF(peer_id = '', ip, peer_id)) END) AS seeders, " +
+		"COUNT(DISTINCT CASE WHEN completed = 0 AND `left` > 0 THEN " +
+		"CONCAT(user_id, '/', IF(peer_id = '', ip, peer_id)) END) AS leechers " +
+		"FROM files_users WHERE file_id = ? AND active = 1;"
+	result := struct {
+		Seeders  int
+		Leechers int
+	}{0, 0}
+
+	if err := db.get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return -1, -1, err
 	}
 
-	return result.Seeders, nil
+	return result.Seeders, result.Leechers, nil
 }
 
-// CountFileRecordCompleted counts the number of peers who are actively leeching this file
-func (db *dbw) CountFileRecordLeechers(id int) (int, error) {
-	// Calculate number of leechers on this file, defined as users who are active, completed, and 0 left
-	query := "SELECT COUNT(user_id) AS leechers FROM files_users WHERE file_id = ? AND active = 1 AND completed = 0 AND `left` > 0;"
-	result := struct{ Leechers int }{0}
+// CountFileRecordAnnounces counts the number of announces on this file since the specified time
+func (db *dbw) CountFileRecordAnnounces(infoHash string, since int64) (int, error) {
+	query := "SELECT COUNT(*) AS announces FROM announce_log WHERE info_hash = ? AND `time` >= ?;"
+	result := struct{ Announces int }{0}
 
-	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+	if err := db.get(&result, query, infoHash, since); err != nil && err != sql.ErrNoRows {
 		return -1, err
 	}
 
-	return result.Leechers, nil
+	return result.Announces, nil
 }
 
 // GetFileRecordPeerList returns a list of Peers, containing IP/port pairs
 func (db *dbw) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]Peer, error) {
+	peers := make([]Peer, 0)
+	err := db.StreamFileRecordPeerList(infoHash, limit, http, func(peer Peer) error {
+		peers = append(peers[:], peer)
+		return nil
+	})
+
+	return peers, err
+}
+
+// StreamFileRecordPeerList calls fn for each Peer, containing IP/port pairs, as rows are read from the
+// database, so the peer list is never buffered in full
+func (db *dbw) StreamFileRecordPeerList(infoHash string, limit int, http bool, fn func(Peer) error) error {
 	// Get IP and port of all peers who have recently announced on this file
 
 	// Choose query depending on if client is HTTP or not
 	var query string
 	if http {
 		// For HTTP, we can intelligently select active peers using the files_users table
-		query = `SELECT DISTINCT announce_log.ip,announce_log.port FROM announce_log
+		query = `SELECT announce_log.ip,announce_log.port,MAX(files_users.left = 0) AS seeder FROM announce_log
 			JOIN files ON announce_log.info_hash = files.info_hash
 			JOIN files_users ON files.id = files_users.file_id
 			AND announce_log.ip = files_users.ip
 			WHERE files_users.active=1
 			AND files.info_hash=?
+			AND announce_log.port != 0
 			AND (UNIX_TIMESTAMP() - ?) <= announce_log.time
-			LIMIT ?;`
+			GROUP BY announce_log.ip,announce_log.port`
 	} else {
 		// Because UDP announces are anonymous, we give the client a "best guess" of peers
-		// who have been active in the current announce period.
-		query = `SELECT DISTINCT announce_log.ip,announce_log.port FROM announce_log
+		// who have been active in the current announce period.  Seeding status is known only
+		// for peers who have also announced via HTTP.
+		query = `SELECT announce_log.ip,announce_log.port,
+			COALESCE(MAX(files_users.active = 1 AND files_users.left = 0), 0) AS seeder FROM announce_log
 			JOIN files ON announce_log.info_hash = files.info_hash
+			LEFT JOIN files_users ON files.id = files_users.file_id
+			AND announce_log.ip = files_users.ip
 			WHERE files.info_hash=?
+			AND announce_log.port != 0
 			AND (UNIX_TIMESTAMP() - ?) <= announce_log.time
-			LIMIT ?;`
+			GROUP BY announce_log.ip,announce_log.port`
 	}
 
-	// Create output list
-	peers := make([]Peer, 0)
+	// When peers are selected with a seeded PRNG, the pool must be the same set of peers on every
+	// query, so order it by address before applying the limit
+	if common.Static.Config.PeerList.Seeded {
+		query += "\n\t\t\tORDER BY announce_log.ip,announce_log.port"
+	}
+	query += "\n\t\t\tLIMIT ?;"
 
 	// Perform query
-	rows, err := db.Queryx(query, infoHash, common.Static.Config.Interval, limit)
-	if err != nil && err != sql.ErrNoRows {
-		return peers, err
+	// Peers are included if they have announced within the announce interval, allowing for jitter
+	rows, err := db.queryx(query, infoHash, peerWindow(common.Static.Config.Interval), limit)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
+	// Always close rows, returning their connection to the pool
+	defer rows.Close()
+
 	// Scan each peer from database
 	peer := Peer{}
 	for rows.Next() {
 		// Check for error
 		if err = rows.StructScan(&peer); err != nil {
-			return peers, nil
+			return err
 		}
 
-		// Append peer to list
-		peers = append(peers[:], peer)
+		// Pass peer to caller
+		if err = fn(peer); err != nil {
+			return err
+		}
 	}
 
-	return peers, nil
+	return rows.Err()
 }
 
-// GetInactiveUserInfo returns a list of users who have not been active for the specified time interval
-func (db *dbw) GetInactiveUserInfo(fid int, interval time.Duration) (users []peerInfo, err error) {
+// GetInactiveUserInfo returns a list of users who have not announced since the specified UNIX timestamp
+func (db *dbw) GetInactiveUserInfo(fid int, before int64) (users []peerInfo, err error) {
 	query := `SELECT user_id, ip FROM files_users
-		WHERE time < (UNIX_TIMESTAMP() - ?)
+		WHERE time < ?
 		AND active = 1
 		AND file_id = ?;`
 
 	result := peerInfo{}
-	checkInterval := int(interval / time.Second)
 
-	var rows *sqlx.Rows
-	if rows, err = db.Queryx(query, checkInterval, fid); err == nil && err != sql.ErrNoRows {
+	var rows *dbRows
+	if rows, err = db.queryx(query, before, fid); err == nil && err != sql.ErrNoRows {
+		defer rows.Close()
+
 		for rows.Next() {
-			if err = rows.StructScan(&result); err == nil {
-				users = append(users, result)
+			if err = rows.StructScan(&result); err != nil {
+				return users, err
 			}
+
+			users = append(users, result)
 		}
+
+		err = rows.Err()
 	}
 
 	return
@@ -303,9 +705,19 @@ func (db *dbw) GetInactiveUserInfo(fid int, interval time.Duration) (users []pee
 func (db *dbw) MarkFileUsersInactive(fid int, users []peerInfo) error {
 	query := "UPDATE files_users SET active = 0 WHERE file_id = ? AND user_id = ? AND ip = ?;"
 
-	tx := db.MustBegin()
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
 	for _, u := range users {
-		tx.Exec(query, fid, u.UserID, u.IP)
+		if _, err := tx.Exec(query, fid, u.UserID, u.IP); err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				log.Println(err2.Error())
+			}
+
+			return err
+		}
 	}
 
 	return tx.Commit()
@@ -313,7 +725,7 @@ func (db *dbw) MarkFileUsersInactive(fid int, users []peerInfo) error {
 
 // GetAllFileRecords returns a list of all FileRecords known to the database
 func (db *dbw) GetAllFileRecords() ([]FileRecord, error) {
-	rows, err := db.Queryx("SELECT * FROM files")
+	rows, err := db.queryx("SELECT * FROM files")
 	files, file := []FileRecord{}, FileRecord{}
 
 	if err != nil && err != sql.ErrNoRows {
@@ -321,22 +733,91 @@ func (db *dbw) GetAllFileRecords() ([]FileRecord, error) {
 		return files, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&file); err != nil {
-			break
+			return files, err
 		}
 
 		files = append(files[:], file)
 	}
 
-	return files, nil
+	return files, rows.Err()
+}
+
+// mysqlFileRecordSorts contains the ORDER BY clause used for each permitted file sort field
+var mysqlFileRecordSorts = map[string]string{
+	"":            "f.`id`",
+	"create_time": "f.`create_time` DESC, f.`id`",
+	"update_time": "f.`update_time` DESC, f.`id`",
+	"completed":   "COALESCE(c.`completed`, 0) DESC, f.`id`",
+}
+
+// GetFileRecordPage returns up to limit FileRecords, sorted by the specified field and skipping the
+// first offset files
+func (db *dbw) GetFileRecordPage(sort string, limit int, offset int) ([]FileRecord, error) {
+	// Completions are counted as distinct users who have ever completed each file
+	query := "SELECT f.* FROM files f " +
+		"LEFT JOIN (SELECT file_id, COUNT(DISTINCT user_id) AS completed FROM files_users WHERE snatched = 1 GROUP BY file_id) c " +
+		"ON c.file_id = f.id ORDER BY " + mysqlFileRecordSorts[sort] + " LIMIT ? OFFSET ?;"
+
+	rows, err := db.queryx(query, limit, offset)
+	files, file := []FileRecord{}, FileRecord{}
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err.Error())
+		return files, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&file); err != nil {
+			return files, err
+		}
+
+		files = append(files[:], file)
+	}
+
+	return files, rows.Err()
+}
+
+// CountFileRecords counts the number of FileRecords known to the database
+func (db *dbw) CountFileRecords() (int, error) {
+	result := struct{ Total int }{0}
+	if err := db.get(&result, "SELECT COUNT(*) AS total FROM files;"); err != nil {
+		return -1, err
+	}
+
+	return result.Total, nil
+}
+
+// CountActivePeers counts the number of peers who are actively seeding and leeching all files, in a
+// single pass over all active peers, using the same definitions as CountFileRecordActivePeers
+func (db *dbw) CountActivePeers() (int, int, error) {
+	query := "SELECT COUNT(DISTINCT CASE WHEN completed = 1 AND `left` = 0 THEN " +
+		"CONCAT(file_id, '/', user_id, '/', IF(peer_id = '', ip, peer_id)) END) AS seeders, " +
+		"COUNT(DISTINCT CASE WHEN completed = 0 AND `left` > 0 THEN " +
+		"CONCAT(file_id, '/', user_id, '/', IF(peer_id = '', ip, peer_id)) END) AS leechers " +
+		"FROM files_users WHERE active = 1;"
+	result := struct {
+		Seeders  int
+		Leechers int
+	}{0, 0}
+
+	if err := db.get(&result, query); err != nil && err != sql.ErrNoRows {
+		return -1, -1, err
+	}
+
+	return result.Seeders, result.Leechers, nil
 }
 
 // --- FileUserRecord.go ---
 
 // DeleteFileUserRecord deletes a FileUserRecord using using a file ID, user ID, and IP triple
 func (db *dbw) DeleteFileUserRecord(fid, uid int, ip string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM files_users WHERE `file_id`=? AND `user_id`=? AND `ip`=?", fid, uid, ip)
 
 	return tx.Commit()
@@ -347,56 +828,219 @@ func (db *dbw) LoadFileUserRecord(fid, uid int, ip string) (FileUserRecord, erro
 	query := "SELECT * FROM files_users WHERE `file_id`=? AND `user_id`=? AND `ip`=?;"
 
 	data := FileUserRecord{}
-	if err := db.Get(&data, query, fid, uid, ip); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&data, query, fid, uid, ip); err != nil {
 		return FileUserRecord{}, err
 	}
 
 	return data, nil
 }
 
+// LoadFileUserRecordsKey loads all FileUserRecords using a file ID, user ID, and announce key triple,
+// most recently updated first
+func (db *dbw) LoadFileUserRecordsKey(fid, uid int, key string) ([]FileUserRecord, error) {
+	query := "SELECT * FROM files_users WHERE `file_id`=? AND `user_id`=? AND `peer_key`=? ORDER BY `time` DESC;"
+
+	rows, err := db.queryx(query, fid, uid, key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	records := []FileUserRecord{}
+	for rows.Next() {
+		data := FileUserRecord{}
+		if err := rows.StructScan(&data); err != nil {
+			return nil, err
+		}
+
+		records = append(records, data)
+	}
+
+	return records, rows.Err()
+}
+
+// MoveFileUserRecord changes the IP of a FileUserRecord, replacing any record already stored at the
+// new IP, in a single transaction
+func (db *dbw) MoveFileUserRecord(fid, uid int, from, to string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// exec runs a query in the transaction, stopping at the first failure
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = tx.Exec(query, args...)
+		}
+	}
+
+	exec("DELETE FROM files_users WHERE `file_id`=? AND `user_id`=? AND `ip`=?", fid, uid, to)
+	exec("UPDATE files_users SET `ip`=? WHERE `file_id`=? AND `user_id`=? AND `ip`=?", to, fid, uid, from)
+
+	if err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			log.Println(err2.Error())
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // SaveFileUserRecord saves a FileUserRecord to the database
 func (db *dbw) SaveFileUserRecord(f FileUserRecord) error {
 	// Insert or update a file/user relationship record
 	query := "INSERT INTO files_users " +
-		"(`file_id`, `user_id`, `ip`, `active`, `completed`, `announced`, `uploaded`, `downloaded`, `left`, `time`) " +
-		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP()) " +
+		"(`file_id`, `user_id`, `ip`, `active`, `completed`, `announced`, `uploaded`, `downloaded`, `left`, `time`, `last_event`, `last_event_time`, `snatched`, `peer_id`, `peer_key`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP(), ?, ?, ?, ?, ?) " +
 		"ON DUPLICATE KEY UPDATE " +
 		"`active`=values(`active`), `completed`=values(`completed`), `announced`=values(`announced`), " +
 		"`uploaded`=values(`uploaded`), `downloaded`=values(`downloaded`), `left`=values(`left`), " +
-		"`time`=UNIX_TIMESTAMP();"
-
-	tx := db.MustBegin()
-	tx.Exec(query, f.FileID, f.UserID, f.IP, f.Active, f.Completed, f.Announced, f.Uploaded, f.Downloaded, f.Left)
+		"`time`=UNIX_TIMESTAMP(), `last_event`=values(`last_event`), `last_event_time`=values(`last_event_time`), `snatched`=values(`snatched`), " +
+		"`peer_id`=values(`peer_id`), `peer_key`=values(`peer_key`);"
 
-	return tx.Commit()
+	return db.execTx(query, f.FileID, f.UserID, f.IP, f.Active, f.Completed, f.Announced, f.Uploaded, f.Downloaded, f.Left,
+		f.LastEvent, f.LastEventTime, f.Snatched, f.PeerID, f.Key)
 }
 
 // LoadFileUserRepository loads all FileUserRecords matching a defined ID and column for query
 func (db *dbw) LoadFileUserRepository(id interface{}, col string) ([]FileUserRecord, error) {
-	rows, err := db.Queryx("SELECT * FROM files_users WHERE `"+col+"`=?", id)
+	rows, err := db.queryx("SELECT * FROM files_users WHERE `"+col+"`=?", id)
 	files, user := []FileUserRecord{}, FileUserRecord{}
 
 	if err != nil && err != sql.ErrNoRows {
 		return files, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&user); err != nil {
-			log.Println(err.Error())
-			break
+			return files, err
 		}
 
 		files = append(files[:], user)
 	}
 
-	return files, nil
+	return files, rows.Err()
+}
+
+// --- Migration.go ---
+
+// LoadSchemaMigrations returns the versions of all migrations applied to the database,
+// creating the schema_migrations table if it does not yet exist
+func (db *dbw) LoadSchemaMigrations() ([]int, error) {
+	query := "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+		"`version` int(11) NOT NULL, `description` varchar(255) NOT NULL, `time` int(11) NOT NULL, " +
+		"PRIMARY KEY (`version`)) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;"
+
+	versions := make([]int, 0)
+	if _, err := db.exec(query); err != nil {
+		return versions, err
+	}
+
+	rows, err := db.queryx("SELECT version FROM schema_migrations ORDER BY version;")
+	if err != nil && err != sql.ErrNoRows {
+		return versions, err
+	}
+
+	defer rows.Close()
+
+	var version int
+	for rows.Next() {
+		if err = rows.Scan(&version); err != nil {
+			return versions, err
+		}
+
+		versions = append(versions[:], version)
+	}
+
+	return versions, rows.Err()
+}
+
+// ApplyMigration applies a schema migration, and records its version
+// note: MySQL implicitly commits schema changes, so the migration is not transactional
+func (db *dbw) ApplyMigration(m Migration) error {
+	// Migrations performed entirely by Func have no SQL.  Migrations may rebuild large tables, so
+	// they are not bounded by the query timeout.
+	if m.MySQL != "" {
+		if _, err := db.Exec(m.MySQL); err != nil {
+			return fmt.Errorf("migration %d (%s): %s", m.Version, m.Description, err.Error())
+		}
+	}
+
+	query := "INSERT INTO schema_migrations (`version`, `description`, `time`) VALUES (?, ?, UNIX_TIMESTAMP());"
+	_, err := db.exec(query, m.Version, m.Description)
+	return err
+}
+
+// DeleteSchemaMigration deletes the record of an applied migration, so that it may be applied again
+func (db *dbw) DeleteSchemaMigration(version int) error {
+	tx := db.mustBegin()
+	tx.Exec("DELETE FROM schema_migrations WHERE `version` = ?", version)
+
+	return tx.Commit()
+}
+
+// --- PasskeyRecord.go ---
+
+// DeletePasskeyRecord deletes a PasskeyRecord using a defined ID and column
+func (db *dbw) DeletePasskeyRecord(id interface{}, col string) error {
+	tx := db.mustBegin()
+	tx.Exec("DELETE FROM passkeys WHERE `"+col+"` = ?", id)
+
+	return tx.Commit()
+}
+
+// LoadPasskeyRecord loads a PasskeyRecord using a defined ID and column for query
+func (db *dbw) LoadPasskeyRecord(id interface{}, col string) (PasskeyRecord, error) {
+	result := PasskeyRecord{}
+	if err := db.getRecord(&result, "SELECT * FROM passkeys WHERE `"+col+"`=?", id); err != nil {
+		return PasskeyRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SavePasskeyRecord saves a PasskeyRecord to the database
+func (db *dbw) SavePasskeyRecord(p PasskeyRecord) error {
+	query := "INSERT INTO passkeys " +
+		"(`user_id`, `passkey`, `create_time`) " +
+		"VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE `passkey`=`passkey`;"
+
+	return db.execTx(query, p.UserID, p.Passkey, p.CreateTime)
+}
+
+// LoadPasskeyRepository loads all PasskeyRecords matching a defined ID and column for query
+func (db *dbw) LoadPasskeyRepository(id interface{}, col string) ([]PasskeyRecord, error) {
+	rows, err := db.queryx("SELECT * FROM passkeys WHERE `"+col+"`=? ORDER BY `id`", id)
+	passkeys, passkey := []PasskeyRecord{}, PasskeyRecord{}
+
+	if err != nil && err != sql.ErrNoRows {
+		return passkeys, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&passkey); err != nil {
+			return passkeys, err
+		}
+
+		passkeys = append(passkeys[:], passkey)
+	}
+
+	return passkeys, rows.Err()
 }
 
 // --- ScrapeLog.go ---
 
 // DeleteScrapeLog deletes a ScrapeLog using a defined ID and column
 func (db *dbw) DeleteScrapeLog(id interface{}, col string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM scrape_log WHERE `"+col+"` = ?", id)
 
 	return tx.Commit()
@@ -407,7 +1051,7 @@ func (db *dbw) LoadScrapeLog(id interface{}, col string) (ScrapeLog, error) {
 	query := "SELECT * FROM scrape_log WHERE `" + col + "`=?;"
 
 	data := ScrapeLog{}
-	if err := db.Get(&data, query, id); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&data, query, id); err != nil {
 		return ScrapeLog{}, err
 	}
 
@@ -417,21 +1061,94 @@ func (db *dbw) LoadScrapeLog(id interface{}, col string) (ScrapeLog, error) {
 // SaveScrapeLog saves a ScrapeLog to the database
 func (db *dbw) SaveScrapeLog(s ScrapeLog) error {
 	query := "INSERT INTO scrape_log " +
-		"(`info_hash`, `passkey`, `ip`, `time`) " +
-		"VALUES (?, ?, ?, UNIX_TIMESTAMP());"
+		"(`info_hash`, `user_id`, `passkey`, `ip`, `time`) " +
+		"VALUES (?, ?, ?, ?, UNIX_TIMESTAMP());"
+
+	return db.execTx(query, s.InfoHash, s.UserID, s.Passkey, s.IP)
+}
+
+// --- Snapshot.go ---
+
+// ImportSnapshot replaces all users, files, API keys, passkeys, and file/user relationships with those
+// contained in a snapshot, in a single transaction.  Record IDs are preserved, so relationships between
+// records remain intact.
+func (db *dbw) ImportSnapshot(s Snapshot) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// exec runs a query in the transaction, stopping at the first failure
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = tx.Exec(query, args...)
+		}
+	}
+
+	// Clear existing state
+	for _, table := range []string{"files_users", "passkeys", "api_keys", "files", "users"} {
+		exec("DELETE FROM `" + table + "`;")
+	}
+
+	// Restore snapshot records
+	for _, u := range s.Users {
+		exec("INSERT INTO users (`id`, `username`, `password`, `passkey`, `torrent_limit`, `admin`, `banned`) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?);", u.ID, u.Username, u.Password, u.Passkey, u.TorrentLimit, u.Admin, u.Banned)
+	}
+	for _, p := range s.Passkeys {
+		exec("INSERT INTO passkeys (`id`, `user_id`, `passkey`, `create_time`) VALUES (?, ?, ?, ?);",
+			p.ID, p.UserID, p.Passkey, p.CreateTime)
+	}
+	for _, k := range s.APIKeys {
+		exec("INSERT INTO api_keys (`id`, `user_id`, `pubkey`, `secret`, `expire`) VALUES (?, ?, ?, ?, ?);",
+			k.ID, k.UserID, k.Pubkey, k.Secret, k.Expire)
+	}
+	for _, f := range s.Files {
+		exec("INSERT INTO files (`id`, `info_hash`, `verified`, `create_time`, `update_time`, `announce_interval`, `peer_limit`) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?);", f.ID, f.InfoHash, f.Verified, f.CreateTime, f.UpdateTime, f.AnnounceInterval, f.PeerLimit)
+	}
+	for _, f := range s.FileUsers {
+		exec("INSERT INTO files_users "+
+			"(`file_id`, `user_id`, `ip`, `active`, `completed`, `announced`, `uploaded`, `downloaded`, `left`, `time`, `last_event`, `last_event_time`, `snatched`, `peer_id`, `peer_key`) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);", f.FileID, f.UserID, f.IP, f.Active, f.Completed, f.Announced,
+			f.Uploaded, f.Downloaded, f.Left, f.Time, f.LastEvent, f.LastEventTime, f.Snatched, f.PeerID, f.Key)
+	}
 
-	tx := db.MustBegin()
-	tx.Exec(query, s.InfoHash, s.Passkey, s.IP)
+	if err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			log.Println(err2.Error())
+		}
+
+		return err
+	}
 
 	return tx.Commit()
 }
 
 // --- UserRecord.go ---
 
-// DeleteUserRecord deletes a UserRecord using a defined ID and column
-func (db *dbw) DeleteUserRecord(id interface{}, col string) error {
-	tx := db.MustBegin()
-	tx.Exec("DELETE FROM users WHERE `"+col+"` = ?", id)
+// DeleteUserRecord deletes a UserRecord with the specified ID, and the records which belong to it,
+// in a single transaction
+func (db *dbw) DeleteUserRecord(id int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM files_users WHERE `user_id` = ?",
+		"DELETE FROM api_keys WHERE `user_id` = ?",
+		"DELETE FROM passkeys WHERE `user_id` = ?",
+		"DELETE FROM users WHERE `id` = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				log.Println(err2.Error())
+			}
+
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
@@ -441,7 +1158,7 @@ func (db *dbw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
 	query := "SELECT * FROM users WHERE `" + col + "`=?;"
 
 	data := UserRecord{}
-	if err := db.Get(&data, query, id); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&data, query, id); err != nil {
 		return UserRecord{}, err
 	}
 
@@ -451,15 +1168,35 @@ func (db *dbw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
 // SaveUserRecord saves a UserRecord to the database
 func (db *dbw) SaveUserRecord(u UserRecord) error {
 	query := "INSERT INTO users " +
-		"(`username`, `password`, `passkey`, `torrent_limit`) " +
-		"VALUES (?, ?, ?, ?) " +
+		"(`username`, `password`, `passkey`, `torrent_limit`, `admin`, `banned`) " +
+		"VALUES (?, ?, ?, ?, ?, ?) " +
 		"ON DUPLICATE KEY UPDATE " +
-		"`username`=values(`username`), `password`=values(`password`), `passkey`=values(`passkey`), `torrent_limit`=values(`torrent_limit`);"
+		"`username`=values(`username`), `password`=values(`password`), `passkey`=values(`passkey`), " +
+		"`torrent_limit`=values(`torrent_limit`), `admin`=values(`admin`), `banned`=values(`banned`);"
 
-	tx := db.MustBegin()
-	tx.Exec(query, u.Username, u.Password, u.Passkey, u.TorrentLimit)
+	return db.execTx(query, u.Username, u.Password, u.Passkey, u.TorrentLimit, u.Admin, u.Banned)
+}
 
-	return tx.Commit()
+// InsertUserRecord inserts a new UserRecord into the database, returning ErrUsernameTaken if
+// the username is already in use, rather than overwriting the existing user
+func (db *dbw) InsertUserRecord(u UserRecord) error {
+	query := "INSERT INTO users " +
+		"(`username`, `password`, `passkey`, `torrent_limit`, `admin`, `banned`) " +
+		"VALUES (?, ?, ?, ?, ?, ?);"
+
+	err := db.execTx(query, u.Username, u.Password, u.Passkey, u.TorrentLimit, u.Admin, u.Banned)
+
+	// ER_DUP_ENTRY: unique username index rejected the insert
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == 1062 {
+		return ErrUsernameTaken
+	}
+
+	return err
+}
+
+// RenameUserRecord changes the username of the user with the specified ID
+func (db *dbw) RenameUserRecord(id int, username string) error {
+	return db.execTx("UPDATE users SET `username` = ? WHERE `id` = ?;", username, id)
 }
 
 // GetUserUploaded calculates the total number of bytes this user has uploaded
@@ -468,7 +1205,7 @@ func (db *dbw) GetUserUploaded(uid int) (int64, error) {
 	query := "SELECT SUM(uploaded) AS uploaded FROM files_users WHERE user_id=?;"
 
 	result := struct{ Uploaded int64 }{0}
-	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
+	if err := db.get(&result, query, uid); err != nil && err != sql.ErrNoRows {
 		return -1, err
 	}
 
@@ -481,20 +1218,36 @@ func (db *dbw) GetUserDownloaded(uid int) (int64, error) {
 	query := "SELECT SUM(downloaded) AS downloaded FROM files_users WHERE user_id=?;"
 
 	result := struct{ Downloaded int64 }{0}
-	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
+	if err := db.get(&result, query, uid); err != nil && err != sql.ErrNoRows {
 		return -1, err
 	}
 
 	return result.Downloaded, nil
 }
 
+// GetUserTransfer calculates the total number of bytes this user has uploaded and downloaded
+func (db *dbw) GetUserTransfer(uid int) (int64, int64, error) {
+	// Calculate sums of this user's upload and download via their file/user relationship records
+	query := "SELECT COALESCE(SUM(uploaded), 0) AS uploaded, COALESCE(SUM(downloaded), 0) AS downloaded FROM files_users WHERE user_id=?;"
+
+	result := struct {
+		Uploaded   int64
+		Downloaded int64
+	}{0, 0}
+	if err := db.get(&result, query, uid); err != nil && err != sql.ErrNoRows {
+		return -1, -1, err
+	}
+
+	return result.Uploaded, result.Downloaded, nil
+}
+
 // GetUserSeeding calculates the total number of files this user is actively seeding
 func (db *dbw) GetUserSeeding(uid int) (int, error) {
 	// Calculate sum of this user's seeding torrents via their file/user relationship records
 	query := "SELECT COUNT(user_id) AS seeding FROM files_users WHERE user_id = ? AND active = 1 AND completed = 1 AND `left` = 0;"
 
 	result := struct{ Seeding int }{0}
-	if err := db.Get(&result, query, uid); err != nil {
+	if err := db.get(&result, query, uid); err != nil {
 		return -1, err
 	}
 
@@ -507,38 +1260,65 @@ func (db *dbw) GetUserLeeching(uid int) (int, error) {
 	query := "SELECT COUNT(user_id) AS leeching FROM files_users WHERE user_id = ? AND active = 1 AND completed = 0 AND `left` > 0;"
 
 	result := struct{ Leeching int }{0}
-	if err := db.Get(&result, query, uid); err != nil {
+	if err := db.get(&result, query, uid); err != nil {
 		return -1, err
 	}
 
 	return result.Leeching, nil
 }
 
+// GetUserActive calculates the total number of distinct files this user is actively seeding or leeching
+func (db *dbw) GetUserActive(uid int) (int, error) {
+	// Calculate count of this user's active torrents via their file/user relationship records
+	query := "SELECT COUNT(DISTINCT file_id) AS active FROM files_users WHERE user_id = ? AND active = 1;"
+
+	result := struct{ Active int }{0}
+	if err := db.get(&result, query, uid); err != nil {
+		return -1, err
+	}
+
+	return result.Active, nil
+}
+
+// GetUserFileActive determines if this user is actively seeding or leeching a file, from any IP
+func (db *dbw) GetUserFileActive(uid, fid int) (bool, error) {
+	query := "SELECT COUNT(*) AS active FROM files_users WHERE user_id = ? AND file_id = ? AND active = 1;"
+
+	result := struct{ Active int }{0}
+	if err := db.get(&result, query, uid, fid); err != nil {
+		return false, err
+	}
+
+	return result.Active > 0, nil
+}
+
 // GetAllUserRecords returns a list of all UserRecords known to the database
 func (db *dbw) GetAllUserRecords() ([]UserRecord, error) {
-	rows, err := db.Queryx("SELECT * FROM users")
+	rows, err := db.queryx("SELECT * FROM users")
 	users, user := []UserRecord{}, UserRecord{}
 
 	if err != nil && err != sql.ErrNoRows {
 		return users, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&user); err != nil {
-			break
+			return users, err
 		}
 
 		users = append(users[:], user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 // --- WhitelistRecord.go ---
 
 // DeleteWhitelistRecord deletes a WhitelistRecord using a defined ID and column
 func (db *dbw) DeleteWhitelistRecord(id interface{}, col string) error {
-	tx := db.MustBegin()
+	tx := db.mustBegin()
 	tx.Exec("DELETE FROM whitelist WHERE `"+col+"` = ?", id)
 
 	return tx.Commit()
@@ -549,7 +1329,7 @@ func (db *dbw) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord,
 	query := "SELECT * FROM whitelist WHERE `" + col + "`=?;"
 
 	result := WhitelistRecord{}
-	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+	if err := db.getRecord(&result, query, id); err != nil {
 		return WhitelistRecord{}, err
 	}
 
@@ -565,8 +1345,5 @@ func (db *dbw) SaveWhitelistRecord(w WhitelistRecord) error {
 		"VALUES (?, ?) " +
 		"ON DUPLICATE KEY UPDATE `client`=`client`;"
 
-	tx := db.MustBegin()
-	tx.Exec(query, w.Client, w.Approved)
-
-	return tx.Commit()
+	return db.execTx(query, w.Client, w.Approved)
 }
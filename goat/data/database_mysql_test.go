@@ -0,0 +1,325 @@
+// +build !ql
+
+package data
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestWithRetry verifies that operations failing due to deadlocks are retried, and other errors are not
+func TestWithRetry(t *testing.T) {
+	log.Println("TestWithRetry()")
+
+	// Allow retries, without delaying the test
+	common.Static.Config.DB.Retries = 3
+	retryBackoff = time.Millisecond
+
+	// Verify a deadlock is retried, and the operation succeeds
+	calls := 0
+	err := withRetry(func() error {
+		calls++
+		if calls == 1 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Operation failed after deadlock retry: %s", err.Error())
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+
+	// Verify retries are limited
+	calls = 0
+	err = withRetry(func() error {
+		calls++
+		return &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+	})
+	if err == nil {
+		t.Fatalf("Operation succeeded, but should have failed")
+	}
+	if calls != 4 {
+		t.Fatalf("Expected 4 calls, got %d", calls)
+	}
+
+	// Verify other errors are not retried
+	for _, e := range []error{
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+		errors.New("connection refused"),
+	} {
+		calls = 0
+		if err := withRetry(func() error {
+			calls++
+			return e
+		}); err != e {
+			t.Fatalf("Expected error %v, got %v", e, err)
+		}
+		if calls != 1 {
+			t.Fatalf("Non-retriable error was retried, expected 1 call, got %d", calls)
+		}
+	}
+}
+
+// deadlockDB is a database backend which fails to save a file/user relationship due to a deadlock,
+// until the configured number of failures has been reached
+type deadlockDB struct {
+	dbModel
+	failures int
+	calls    int
+}
+
+// Close does nothing, as there is no connection
+func (db *deadlockDB) Close() error {
+	return nil
+}
+
+// SaveFileUserRecord fails with a deadlock until the configured number of failures is reached
+func (db *deadlockDB) SaveFileUserRecord(f FileUserRecord) error {
+	db.calls++
+	if db.calls <= db.failures {
+		return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	}
+
+	return nil
+}
+
+// TestFileUserRecordSaveDeadlock verifies that saving a file/user relationship is retried after a
+// deadlock, and that the deadlock is reported once retries are exhausted
+func TestFileUserRecordSaveDeadlock(t *testing.T) {
+	log.Println("TestFileUserRecordSaveDeadlock()")
+
+	// Allow retries, without delaying the test
+	common.Static.Config.DB.Retries = 2
+	retryBackoff = time.Millisecond
+
+	// Serve deadlocking database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+
+	db := &deadlockDB{failures: 1}
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+
+	// Verify save succeeds once the deadlock clears
+	if err := (FileUserRecord{FileID: 1, UserID: 1, IP: "10.0.0.1"}).Save(); err != nil {
+		t.Fatalf("Save failed after deadlock retry: %s", err.Error())
+	}
+	if db.calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", db.calls)
+	}
+
+	// Verify deadlock is reported once retries are exhausted
+	db.failures, db.calls = 10, 0
+	err := (FileUserRecord{FileID: 1, UserID: 1, IP: "10.0.0.1"}).Save()
+	if e, ok := err.(*mysql.MySQLError); !ok || e.Number != 1213 {
+		t.Fatalf("Expected deadlock error, got: %v", err)
+	}
+	if db.calls != 3 {
+		t.Fatalf("Expected 3 calls, got %d", db.calls)
+	}
+}
+
+// TestMySQLDSN verifies that the MySQL connection string is generated from configuration, and that
+// DSN overrides take precedence
+func TestMySQLDSN(t *testing.T) {
+	log.Println("TestMySQLDSN()")
+
+	// Restore configuration and command-line DSN afterwards
+	config := common.Static.Config
+	flagDSN := MySQLDSN
+	defer func() {
+		common.Static.Config = config
+		MySQLDSN = flagDSN
+	}()
+
+	// Verify DSN is generated from individual settings
+	MySQLDSN = nil
+	common.Static.Config.DB = common.DefaultConfig().DB
+	common.Static.Config.DB.Host = "db.example.com:3307"
+	if dsn := mysqlDSN(); dsn != "goat:goat@tcp(db.example.com:3307)/goat" {
+		t.Fatalf("Generated DSN, got %q", dsn)
+	}
+
+	// Verify configuration DSN overrides individual settings
+	common.Static.Config.DB.DSN = "goat:goat@unix(/tmp/mysql.sock)/goat"
+	if dsn := mysqlDSN(); dsn != common.Static.Config.DB.DSN {
+		t.Fatalf("Configuration DSN, expected %q, got %q", common.Static.Config.DB.DSN, dsn)
+	}
+
+	// Verify command-line DSN overrides configuration
+	flag := "root:root@/goat"
+	MySQLDSN = &flag
+	if dsn := mysqlDSN(); dsn != flag {
+		t.Fatalf("Command-line DSN, expected %q, got %q", flag, dsn)
+	}
+
+	// Verify connection errors name the DSN when in use
+	if err := mysqlConnectError(errors.New("dial failed")); !strings.Contains(err.Error(), "DSN") {
+		t.Fatalf("Connection error does not mention DSN: %s", err.Error())
+	}
+}
+
+// mysqlConnectErrorTests contains connection errors, and the setting which should be named as likely wrong
+var mysqlConnectErrorTests = []struct {
+	err     error
+	setting string
+}{
+	{errors.New("dial tcp 127.0.0.1:3306: connection refused"), "DB.Host"},
+	{&mysql.MySQLError{Number: 1045, Message: "Access denied for user 'goat'@'localhost'"}, "DB.Username and DB.Password"},
+	{&mysql.MySQLError{Number: 1049, Message: "Unknown database 'goat'"}, "DB.Database"},
+}
+
+// TestMySQLConnectError verifies that connection errors name the setting most likely to be wrong
+func TestMySQLConnectError(t *testing.T) {
+	log.Println("TestMySQLConnectError()")
+
+	// Restore configuration and command-line DSN afterwards
+	config := common.Static.Config
+	flagDSN := MySQLDSN
+	defer func() {
+		common.Static.Config = config
+		MySQLDSN = flagDSN
+	}()
+
+	MySQLDSN = nil
+	common.Static.Config.DB = common.DefaultConfig().DB
+
+	// Iterate all tests
+	for _, test := range mysqlConnectErrorTests {
+		err := mysqlConnectError(test.err)
+		if !strings.Contains(err.Error(), "check "+test.setting+":") {
+			t.Fatalf("mysqlConnectError(%q), expected %s, got: %s", test.err.Error(), test.setting, err.Error())
+		}
+	}
+}
+
+// TestQueryTimeout verifies that queries exceeding the configured timeout are cancelled, and that the
+// connection remains usable afterwards
+func TestQueryTimeout(t *testing.T) {
+	log.Println("TestQueryTimeout()")
+
+	// Load config, with a short query timeout
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.DB.QueryTimeout = 1
+
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	defer db.Close()
+
+	// Verify a slow query times out
+	var result int
+	if err := db.(*dbw).get(&result, "SELECT SLEEP(3);"); err != ErrQueryTimeout {
+		t.Fatalf("Slow query, expected ErrQueryTimeout, got: %v", err)
+	}
+
+	// Verify slow queries which return rows and transactions also time out
+	if _, err := db.(*dbw).queryx("SELECT SLEEP(3);"); err != ErrQueryTimeout {
+		t.Fatalf("Slow rows query, expected ErrQueryTimeout, got: %v", err)
+	}
+
+	tx, err := db.(*dbw).begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %s", err.Error())
+	}
+	if _, err := tx.Exec("SELECT SLEEP(3);"); err != ErrQueryTimeout {
+		t.Fatalf("Slow transaction query, expected ErrQueryTimeout, got: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		log.Println(err.Error())
+	}
+
+	// Verify queries which complete in time still succeed
+	if err := db.(*dbw).get(&result, "SELECT 1;"); err != nil || result != 1 {
+		t.Fatalf("Fast query, expected 1, got: %d %v", result, err)
+	}
+}
+
+// unpooledDB is a MySQL backend which owns its own connection, closing it once the caller is done
+type unpooledDB struct {
+	*dbw
+}
+
+// Close closes the connection opened for this backend
+func (db unpooledDB) Close() error {
+	return db.DB.Close()
+}
+
+// benchmarkUserLoad benchmarks loading a user, using the specified function to connect to MySQL
+func benchmarkUserLoad(b *testing.B, connect func() (dbModel, error)) {
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		b.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Save mock user
+	user := new(UserRecord)
+	if err := user.Create("goat_benchmark", "test", 100); err != nil {
+		b.Fatalf("Failed to create UserRecord: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		b.Fatalf("Failed to save UserRecord: %s", err.Error())
+	}
+
+	// Restore the default connection afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = connect
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := user.Load("goat_benchmark", "username"); err != nil {
+			b.Fatalf("Failed to load UserRecord: %s", err.Error())
+		}
+	}
+	b.StopTimer()
+
+	// Delete mock user
+	DBConnectFunc = dbConnect
+	user2, err := user.Load("goat_benchmark", "username")
+	if err != nil {
+		b.Fatalf("Failed to load UserRecord: %s", err.Error())
+	}
+	if err := user2.Delete(); err != nil {
+		b.Fatalf("Failed to delete UserRecord: %s", err.Error())
+	}
+}
+
+// BenchmarkUserLoadUnpooled benchmarks loading a user, opening a new connection for each load
+func BenchmarkUserLoadUnpooled(b *testing.B) {
+	benchmarkUserLoad(b, func() (dbModel, error) {
+		db, err := sqlx.Connect("mysql", mysqlDSN())
+		if err != nil {
+			return nil, mysqlConnectError(err)
+		}
+
+		return unpooledDB{&dbw{db}}, nil
+	})
+}
+
+// BenchmarkUserLoadPooled benchmarks loading a user, reusing connections from the shared pool
+func BenchmarkUserLoadPooled(b *testing.B) {
+	benchmarkUserLoad(b, DBConnectFunc)
+}
@@ -1,13 +1,16 @@
+//go:build ql
 // +build ql
 
 package data
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/user"
 	ospath "path"
+	"sort"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
@@ -29,79 +32,123 @@ var (
 	// Map of all queries available to ql
 	qlq = map[string]string{
 		// AnnounceLog
-		"announcelog_delete_id":       "DELETE FROM announce_log WHERE id()==$1",
-		"announcelog_load_id":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE id()==$1 ORDER BY id()",
-		"announcelog_load_info_hash":  "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE info_hash==$1 ORDER BY id()",
-		"announcelog_load_passkey":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE passkey==$1 ORDER BY id()",
-		"announcelog_load_key":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE key==$1 ORDER BY id()",
-		"announcelog_load_ip":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE ip==$1 ORDER BY id()",
-		"announcelog_load_port":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE port==$1 ORDER BY id()",
-		"announcelog_load_udp":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE udp==$1 ORDER BY id()",
-		"announcelog_load_uploaded":   "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE uploaded==$1 ORDER BY id()",
-		"announcelog_load_downloaded": "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE downloaded==$1 ORDER BY id()",
-		"announcelog_load_left":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE left==$1 ORDER BY id()",
-		"announcelog_load_event":      "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE event==$1 ORDER BY id()",
-		"announcelog_load_client":     "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE client==$1 ORDER BY id()",
-		"announcelog_load_time":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE time==$1 ORDER BY id()",
-		"announcelog_save":            "INSERT INTO announce_log VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,now());",
+		"announcelog_delete_id":        "DELETE FROM announce_log WHERE id()==$1",
+		"announcelog_delete_info_hash": "DELETE FROM announce_log WHERE info_hash==$1",
+		"announcelog_count_since":      "SELECT count(*) FROM announce_log WHERE info_hash==$1 && ts>=$2",
+		"announcelog_count_before":     "SELECT count(*) FROM announce_log WHERE ts<$1",
+		"announcelog_delete_before":    "DELETE FROM announce_log WHERE ts<$1",
+		"announcelog_load_id":          "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE id()==$1 ORDER BY id()",
+		"announcelog_load_info_hash":   "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE info_hash==$1 ORDER BY id()",
+		"announcelog_load_passkey":     "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE passkey==$1 ORDER BY id()",
+		"announcelog_load_key":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE key==$1 ORDER BY id()",
+		"announcelog_load_ip":          "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE ip==$1 ORDER BY id()",
+		"announcelog_load_port":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE port==$1 ORDER BY id()",
+		"announcelog_load_udp":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE udp==$1 ORDER BY id()",
+		"announcelog_load_uploaded":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE uploaded==$1 ORDER BY id()",
+		"announcelog_load_downloaded":  "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE downloaded==$1 ORDER BY id()",
+		"announcelog_load_left":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE left==$1 ORDER BY id()",
+		"announcelog_load_event":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE event==$1 ORDER BY id()",
+		"announcelog_load_client":      "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE client==$1 ORDER BY id()",
+		"announcelog_load_time":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE time==$1 ORDER BY id()",
+		"announcelog_load_recent":      "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE info_hash==$1 ORDER BY ts,id() DESC LIMIT $2 OFFSET $3",
+		"announcelog_save":             "INSERT INTO announce_log VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,now(),$12);",
+		"announcelog_save_time":        "INSERT INTO announce_log VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13);",
 
 		// APIKey
-		"apikey_delete_id":     "DELETE FROM api_keys WHERE id()==$1",
-		"apikey_delete_pubkey": "DELETE FROM api_keys WHERE pubkey==$1",
-		"apikey_load_id":       "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE id()==$1",
-		"apikey_load_user_id":  "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE user_id==$1",
-		"apikey_load_pubkey":   "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE pubkey==$1",
-		"apikey_insert":        "INSERT INTO api_keys VALUES ($1, $2, $3, $4)",
-		"apikey_update":        "UPDATE api_keys expire=$2 WHERE id()==$1",
+		"apikey_delete_id":      "DELETE FROM api_keys WHERE id()==$1",
+		"apikey_delete_pubkey":  "DELETE FROM api_keys WHERE pubkey==$1",
+		"apikey_delete_user_id": "DELETE FROM api_keys WHERE user_id==$1",
+		"apikey_load_id":        "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE id()==$1",
+		"apikey_load_user_id":   "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE user_id==$1",
+		"apikey_load_pubkey":    "SELECT id(),user_id,pubkey,secret,expire FROM api_keys WHERE pubkey==$1",
+		"apikey_insert":         "INSERT INTO api_keys VALUES ($1, $2, $3, $4)",
+		"apikey_update":         "UPDATE api_keys expire=$2 WHERE id()==$1",
+
+		// BanRecord
+		"ban_delete_id":    "DELETE FROM bans WHERE id()==$1",
+		"ban_load_id":      "SELECT id(),ip,user_id,reason,create_time,expire FROM bans WHERE id()==$1",
+		"ban_load_ip":      "SELECT id(),ip,user_id,reason,create_time,expire FROM bans WHERE ip==$1 ORDER BY id() DESC",
+		"ban_load_user_id": "SELECT id(),ip,user_id,reason,create_time,expire FROM bans WHERE user_id==$1 ORDER BY id() DESC",
+		"ban_insert":       "INSERT INTO bans VALUES ($1, $2, $3, $4, $5)",
+		"ban_update":       "UPDATE bans ip=$2, user_id=$3, reason=$4, expire=$5 WHERE id()==$1",
+		"ban_count_active": "SELECT count(*) FROM bans WHERE ((ip!=\"\" && ip==$1) || (user_id!=0 && user_id==$2)) && (expire==0 || expire>$3)",
 
 		// FileRecord
 		"filerecord_delete_id":          "DELETE FROM files WHERE id()==$1",
 		"filerecord_delete_info_hash":   "DELETE FROM files WHERE info_hash==$1",
-		"filerecord_find_peerlist_http": "SELECT DISTINCT a.ip, a.port FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, (SELECT file_id, ip FROM files_users) AS u WHERE a.ip==u.ip && (now()-$1) <= a.time && f.info_hash==$2",
-		"filerecord_find_peerlist_udp":  "SELECT DISTINCT a.ip, a.port FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, WHERE (now()-$1) <= a.time && f.info_hash==$2",
-		"filerecord_load_all":           "SELECT id(),info_hash,verified,create_time,update_time FROM files",
-		"filerecord_load_id":            "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE id()==$1 ORDER BY id()",
-		"filerecord_load_info_hash":     "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE info_hash==$1 ORDER BY id()",
-		"filerecord_load_verified":      "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE verified==$1 ORDER BY id()",
-		"filerecord_load_create_time":   "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE create_time==$1 ORDER BY id()",
-		"filerecord_load_update_time":   "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE update_time==$1 ORDER BY id()",
-		"filerecord_insert":             "INSERT INTO files VALUES ($1,$2,now(),now())",
-		"filerecord_update":             "UPDATE files verified=$2,update_time=now() WHERE id()==$1",
+		"filerecord_find_peerlist_http": "SELECT DISTINCT a.ip, a.port, u.left FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, (SELECT file_id, ip, left FROM files_users) AS u WHERE a.ip==u.ip && a.port != 0 && (now()-$1) <= a.time && f.info_hash==$2",
+		"filerecord_find_peerlist_udp":  "SELECT DISTINCT a.ip, a.port FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, WHERE a.port != 0 && (now()-$1) <= a.time && f.info_hash==$2",
+		"filerecord_count":              "SELECT count(*) FROM files",
+		"filerecord_load_all":           "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files",
+		"filerecord_load_id":            "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files WHERE id()==$1 ORDER BY id()",
+		"filerecord_load_info_hash":     "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files WHERE info_hash==$1 ORDER BY id()",
+		"filerecord_load_verified":      "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files WHERE verified==$1 ORDER BY id()",
+		"filerecord_load_create_time":   "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files WHERE create_time==$1 ORDER BY id()",
+		"filerecord_load_update_time":   "SELECT id(),info_hash,verified,create_time,update_time,announce_interval,peer_limit FROM files WHERE update_time==$1 ORDER BY id()",
+		"filerecord_insert":             "INSERT INTO files VALUES ($1,$2,now(),now(),$3,$4)",
+		"filerecord_update":             "UPDATE files verified=$2,update_time=now(),announce_interval=$3,peer_limit=$4 WHERE id()==$1",
 
 		// fileUser
 		"fileuser_delete":          "DELETE FROM files_users WHERE file_id==$1 && user_id==$2 && ip==$3",
+		"fileuser_delete_user_id":  "DELETE FROM files_users WHERE user_id==$1",
 		"fileuser_load":            "SELECT * FROM files_users WHERE file_id==$1 && user_id==$2 && ip==$3",
+		"fileuser_load_key":        "SELECT * FROM files_users WHERE file_id==$1 && user_id==$2 && peer_key==$3 ORDER BY ts DESC",
 		"fileuser_load_file_id":    "SELECT * FROM files_users WHERE file_id==$1",
-		"fileuser_count_completed": "SELECT count(user_id) FROM files_users WHERE file_id==$1 && completed==true && left==0",
-		"fileuser_count_seeders":   "SELECT count(user_id) FROM files_users WHERE file_id==$1 && active==true && completed==true && left==0",
-		"fileuser_count_leechers":  "SELECT count(user_id) FROM files_users WHERE file_id==$1 && active==true && completed==false && left>0",
-		"fileuser_find_inactive":   "SELECT user_id, ip FROM files_users WHERE (ts<(now()-$2)) && active==true && file_id==$1",
+		"fileuser_count_completed": "SELECT DISTINCT user_id FROM files_users WHERE file_id==$1 && snatched==true",
+		"fileuser_find_active":     "SELECT completed, left, user_id, ip, peer_id FROM files_users WHERE file_id==$1 && active==true",
+		"fileuser_find_all_active": "SELECT completed, left, user_id, ip, peer_id, file_id FROM files_users WHERE active==true",
+		"fileuser_find_inactive":   "SELECT user_id, ip FROM files_users WHERE ts<$2 && active==true && file_id==$1",
 		"fileuser_mark_inactive":   "UPDATE files_users active=false WHERE file_id==$1 && user_id==$2 && ip==$3",
-		"fileuser_insert":          "INSERT INTO files_users VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,now())",
-		"fileuser_update":          "UPDATE files_users active=$4,completed=$5,announced=$6,uploaded=$7,downloaded=$8,left=$9,ts=now() WHERE file_id==$1 && user_id==$2 && ip==$3",
+		"fileuser_insert":          "INSERT INTO files_users VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,now(),$10,$11,$12,$13,$14)",
+		"fileuser_move":            "UPDATE files_users ip=$4 WHERE file_id==$1 && user_id==$2 && ip==$3",
+		"fileuser_update":          "UPDATE files_users active=$4,completed=$5,announced=$6,uploaded=$7,downloaded=$8,left=$9,ts=now(),last_event=$10,last_event_time=$11,snatched=$12,peer_id=$13,peer_key=$14 WHERE file_id==$1 && user_id==$2 && ip==$3",
+
+		// Migration
+		"migration_create":         "CREATE TABLE IF NOT EXISTS schema_migrations (version int64, description string, ts time)",
+		"migration_load_all":       "SELECT version FROM schema_migrations ORDER BY version",
+		"migration_insert":         "INSERT INTO schema_migrations VALUES ($1, $2, now())",
+		"migration_delete_version": "DELETE FROM schema_migrations WHERE version==$1",
+
+		// PasskeyRecord
+		"passkey_delete_passkey": "DELETE FROM passkeys WHERE passkey==$1",
+		"passkey_delete_user_id": "DELETE FROM passkeys WHERE user_id==$1",
+		"passkey_load_id":        "SELECT id(),user_id,passkey,create_time FROM passkeys WHERE id()==$1",
+		"passkey_load_passkey":   "SELECT id(),user_id,passkey,create_time FROM passkeys WHERE passkey==$1",
+		"passkey_load_user_id":   "SELECT id(),user_id,passkey,create_time FROM passkeys WHERE user_id==$1 ORDER BY id()",
+		"passkey_insert":         "INSERT INTO passkeys VALUES ($1, $2, $3)",
 
 		// ScrapeLog
 		"scrapelog_delete_id":      "DELETE FROM scrape_log WHERE id()==$1",
-		"scrapelog_load_id":        "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE id()==$1",
-		"scrapelog_load_info_hash": "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE info_hash==$1",
-		"scrapelog_load_passkey":   "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE passkey==$1",
-		"scrapelog_load_ip":        "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE ip==$1",
-		"scrapelog_insert":         "INSERT INTO scrape_log VALUES ($1, $2, $3, now())",
+		"scrapelog_load_id":        "SELECT id(),info_hash,passkey,ip,ts,user_id FROM scrape_log WHERE id()==$1",
+		"scrapelog_load_info_hash": "SELECT id(),info_hash,passkey,ip,ts,user_id FROM scrape_log WHERE info_hash==$1",
+		"scrapelog_load_passkey":   "SELECT id(),info_hash,passkey,ip,ts,user_id FROM scrape_log WHERE passkey==$1",
+		"scrapelog_load_ip":        "SELECT id(),info_hash,passkey,ip,ts,user_id FROM scrape_log WHERE ip==$1",
+		"scrapelog_load_user_id":   "SELECT id(),info_hash,passkey,ip,ts,user_id FROM scrape_log WHERE user_id==$1",
+		"scrapelog_insert":         "INSERT INTO scrape_log VALUES ($1, $2, $3, now(), $4)",
+
+		// Snapshot
+		"snapshot_clear":           "DELETE FROM files_users; DELETE FROM passkeys; DELETE FROM api_keys; DELETE FROM files; DELETE FROM users;",
+		"snapshot_file_insert":     "INSERT INTO files VALUES ($1,$2,$3,$4,$5,$6)",
+		"snapshot_fileuser_insert": "INSERT INTO files_users VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)",
 
 		// UserRecord
-		"user_delete_username":    "DELETE FROM users WHERE username==$1",
-		"user_load_all":           "SELECT id(),username,password,passkey,torrent_limit FROM users",
-		"user_load_id":            "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE id()==$1",
-		"user_load_username":      "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE username==$1",
-		"user_load_password":      "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE password==$1",
-		"user_load_passkey":       "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE passkey==$1",
-		"user_load_torrent_limit": "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE torrent_limit==$1",
-		"user_insert":             "INSERT INTO users VALUES($1, $2, $3, $4)",
-		"user_update":             "UPDATE users username=$2, password=$3, passkey=$4, torrent_limit=$5 WHERE id()==$1",
+		"user_delete_id":          "DELETE FROM users WHERE id()==$1",
+		"user_load_all":           "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users",
+		"user_load_id":            "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users WHERE id()==$1",
+		"user_load_username":      "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users WHERE username==$1",
+		"user_load_password":      "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users WHERE password==$1",
+		"user_load_passkey":       "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users WHERE passkey==$1",
+		"user_load_torrent_limit": "SELECT id(),username,password,passkey,torrent_limit,admin,banned FROM users WHERE torrent_limit==$1",
+		"user_insert":             "INSERT INTO users VALUES($1, $2, $3, $4, $5, $6)",
+		"user_update":             "UPDATE users username=$2, password=$3, passkey=$4, torrent_limit=$5, admin=$6, banned=$7 WHERE id()==$1",
+		"user_rename":             "UPDATE users username=$2 WHERE id()==$1",
 		"user_uploaded":           "SELECT sum(uploaded) AS uploaded FROM files_users WHERE user_id==$1",
 		"user_downloaded":         "SELECT sum(downloaded) AS downloaded FROM files_users WHERE user_id==$1",
+		"user_transfer":           "SELECT sum(uploaded), sum(downloaded) FROM files_users WHERE user_id==$1",
 		"user_seeding":            "SELECT count(user_id) AS seeding FROM files_users WHERE user_id==$1 && active==true && completed==true && left==0",
 		"user_leeching":           "SELECT count(user_id) AS leeching FROM files_users WHERE user_id==$1 && active==true && completed==false && left>0",
+		"user_active":             "SELECT count(file_id) AS active FROM (SELECT DISTINCT file_id FROM files_users WHERE user_id==$1 && active==true)",
+		"user_file_active":        "SELECT count(*) AS active FROM files_users WHERE user_id==$1 && file_id==$2 && active==true",
 
 		// WhitelistRecord
 		"whitelist_delete_client": "DELETE FROM whitelist WHERE client==$1",
@@ -230,14 +277,32 @@ func (db *qlw) DeleteAnnounceLog(id interface{}, col string) (err error) {
 	return
 }
 
+// DeleteAnnounceLogsBefore deletes all AnnounceLogs older than the specified UNIX timestamp,
+// returning the number deleted
+func (db *qlw) DeleteAnnounceLogsBefore(before int64) (int, error) {
+	count, err := qlQueryI64(db, "announcelog_count_before", time.Unix(before, 0))
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if _, _, err := qlQuery(db, "announcelog_delete_before", true, time.Unix(before, 0)); err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
 // LoadAnnounceLog loads an AnnounceLog using a defined ID and column for query
 func (db *qlw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error) {
 	rs, _, err := qlQuery(db, "announcelog_load_"+col, true, id)
 
 	result := AnnounceLog{}
-	if err != nil || len(rs) < 1 {
+	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = AnnounceLog{
@@ -254,14 +319,50 @@ func (db *qlw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error)
 			Event:      data[10].(string),
 			Client:     data[11].(string),
 			Time:       data[12].(time.Time).Unix(),
+			PeerID:     qlString(data[13]),
 		}
 
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (AnnounceLog{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
+// GetRecentAnnounceLogs returns up to limit of the most recent AnnounceLogs for a file, newest first,
+// skipping the first offset entries
+func (db *qlw) GetRecentAnnounceLogs(infoHash string, limit int, offset int) (announces []AnnounceLog, err error) {
+	announces = make([]AnnounceLog, 0)
+	if rs, _, err := qlQuery(db, "announcelog_load_recent", false, infoHash, int64(limit), int64(offset)); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			announces = append(announces, AnnounceLog{
+				ID:         int(data[0].(int64)),
+				InfoHash:   data[1].(string),
+				Passkey:    data[2].(string),
+				Key:        data[3].(string),
+				IP:         data[4].(string),
+				Port:       int(data[5].(int32)),
+				UDP:        data[6].(bool),
+				Uploaded:   data[7].(int64),
+				Downloaded: data[8].(int64),
+				Left:       data[9].(int64),
+				Event:      data[10].(string),
+				Client:     data[11].(string),
+				Time:       data[12].(time.Time).Unix(),
+				PeerID:     qlString(data[13]),
+			})
+
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // SaveAnnounceLog saves an AnnounceLog to database
 func (db *qlw) SaveAnnounceLog(a AnnounceLog) (err error) {
 	_, _, err = qlQuery(db, "announcelog_save", true,
@@ -269,11 +370,30 @@ func (db *qlw) SaveAnnounceLog(a AnnounceLog) (err error) {
 		a.IP, int32(a.Port), a.UDP,
 		a.Uploaded, a.Downloaded,
 		a.Left, a.Event, a.Client,
-		time.Unix(a.Time, 0))
+		a.PeerID)
 
 	return
 }
 
+// SaveAnnounceLogs saves multiple AnnounceLogs to database in a single transaction, preserving the
+// time at which each was queued
+func (db *qlw) SaveAnnounceLogs(logs []AnnounceLog) (err error) {
+	tx := db.NewTransaction()
+	for _, a := range logs {
+		if _, _, err = tx.Run(qlq["announcelog_save_time"],
+			a.InfoHash, a.Passkey, a.Key,
+			a.IP, int32(a.Port), a.UDP,
+			a.Uploaded, a.Downloaded,
+			a.Left, a.Event, a.Client,
+			time.Unix(a.Time, 0), a.PeerID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // --- APIKey.go ---
 
 // DeleteAPIKey deletes an AnnounceLog using a defined ID and column for query
@@ -291,9 +411,12 @@ func (db *qlw) LoadAPIKey(id interface{}, col string) (APIKey, error) {
 	rs, _, err := qlQuery(db, "apikey_load_"+col, true, id)
 
 	result := APIKey{}
-	if err != nil || len(rs) < 1 {
+	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = APIKey{
@@ -307,6 +430,11 @@ func (db *qlw) LoadAPIKey(id interface{}, col string) (APIKey, error) {
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (APIKey{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
@@ -340,6 +468,72 @@ func (db *qlw) GetAllAPIKeys() (keys []APIKey, err error) {
 	return
 }
 
+// --- BanRecord.go ---
+
+// DeleteBanRecord deletes a BanRecord using a defined ID and column for query
+func (db *qlw) DeleteBanRecord(id interface{}, col string) (err error) {
+	// Prevent error cannot convert 1 (type int) to type int64
+	if value, ok := id.(int); ok {
+		id = int64(value)
+	}
+	_, _, err = qlQuery(db, "ban_delete_"+col, true, id)
+	return
+}
+
+// LoadBanRecord loads the most recent BanRecord using a defined ID and column for query
+func (db *qlw) LoadBanRecord(id interface{}, col string) (BanRecord, error) {
+	// Prevent error cannot convert 1 (type int) to type int64
+	if value, ok := id.(int); ok {
+		id = int64(value)
+	}
+	rs, _, err := qlQuery(db, "ban_load_"+col, true, id)
+
+	result := BanRecord{}
+	if err != nil {
+		return result, err
+	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		result = BanRecord{
+			ID:         int(data[0].(int64)),
+			IP:         qlString(data[1]),
+			UserID:     int(qlInt64(data[2])),
+			Reason:     qlString(data[3]),
+			CreateTime: qlInt64(data[4]),
+			Expire:     qlInt64(data[5]),
+		}
+
+		return false, nil
+	})
+
+	// No record found
+	if err == nil && result == (BanRecord{}) {
+		err = ErrNotFound
+	}
+
+	return result, err
+}
+
+// SaveBanRecord saves a BanRecord to the database, inserting it if it is new, or updating it otherwise
+func (db *qlw) SaveBanRecord(b BanRecord) (err error) {
+	if b.ID == 0 {
+		_, _, err = qlQuery(db, "ban_insert", true, b.IP, int64(b.UserID), b.Reason, b.CreateTime, b.Expire)
+		return
+	}
+
+	_, _, err = qlQuery(db, "ban_update", true, int64(b.ID), b.IP, int64(b.UserID), b.Reason, b.Expire)
+	return
+}
+
+// CountActiveBans counts the bans on an IP address or user ID which have not expired at the specified time
+func (db *qlw) CountActiveBans(ip string, userID int, now int64) (int, error) {
+	count, err := qlQueryI64(db, "ban_count_active", ip, int64(userID), now)
+	return int(count), err
+}
+
 // --- FileRecord.go ---
 
 // DeleteFileRecord deletes an AnnounceLog using a defined ID and column for query
@@ -361,52 +555,90 @@ func (db *qlw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 	rs, _, err := qlQuery(db, "filerecord_load_"+col, true, id)
 
 	result := FileRecord{}
-	if err != nil || len(rs) < 1 {
+	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = FileRecord{
-			ID:         int(data[0].(int64)),
-			InfoHash:   data[1].(string),
-			Verified:   data[2].(bool),
-			CreateTime: data[3].(time.Time).Unix(),
-			UpdateTime: data[4].(time.Time).Unix(),
+			ID:               int(data[0].(int64)),
+			InfoHash:         data[1].(string),
+			Verified:         data[2].(bool),
+			CreateTime:       data[3].(time.Time).Unix(),
+			UpdateTime:       data[4].(time.Time).Unix(),
+			AnnounceInterval: int(qlInt64(data[5])),
+			PeerLimit:        int(qlInt64(data[6])),
 		}
 
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (FileRecord{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
 // SaveFileRecord saves a fileRecord to the database
 func (db *qlw) SaveFileRecord(f FileRecord) (err error) {
 	if fr, _ := db.LoadFileRecord(f.ID, "id"); (fr == FileRecord{}) && err == nil {
-		_, _, err = qlQuery(db, "filerecord_insert", true, f.InfoHash, f.Verified)
+		_, _, err = qlQuery(db, "filerecord_insert", true, f.InfoHash, f.Verified, int64(f.AnnounceInterval), int64(f.PeerLimit))
 	} else {
-		_, _, err = qlQuery(db, "filerecord_update", true, int64(f.ID), f.Verified)
+		_, _, err = qlQuery(db, "filerecord_update", true, int64(f.ID), f.Verified, int64(f.AnnounceInterval), int64(f.PeerLimit))
 	}
 
 	return
 }
 
-// CountFileRecordCompleted counts the number of peers who have completed this file
-func (db *qlw) CountFileRecordCompleted(id int) (int, error) {
-	completed, err := qlQueryI64(db, "fileuser_count_completed", int64(id))
-	return int(completed), err
+// CountFileRecordCompleted counts the number of distinct users who have completed this file
+func (db *qlw) CountFileRecordCompleted(id int) (completed int, err error) {
+	if rs, _, err := qlQuery(db, "fileuser_count_completed", false, int64(id)); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			completed++
+			return true, nil
+		})
+	}
+
+	return
 }
 
-// CountFileRecordSeeders counts the number of peers who are actively seeding this file
-func (db *qlw) CountFileRecordSeeders(id int) (int, error) {
-	seeders, err := qlQueryI64(db, "fileuser_count_seeders", int64(id))
-	return int(seeders), err
+// CountFileRecordAnnounces counts the number of announces on this file since the specified time
+func (db *qlw) CountFileRecordAnnounces(infoHash string, since int64) (int, error) {
+	announces, err := qlQueryI64(db, "announcelog_count_since", infoHash, time.Unix(since, 0))
+	return int(announces), err
 }
 
-// CountFileRecordLeechers counts the number of peers who are actively leeching this file
-func (db *qlw) CountFileRecordLeechers(id int) (int, error) {
-	leechers, err := qlQueryI64(db, "fileuser_count_leechers", int64(id))
-	return int(leechers), err
+// CountFileRecordActivePeers counts the number of peers who are actively seeding and leeching this file
+func (db *qlw) CountFileRecordActivePeers(id int) (seeders int, leechers int, err error) {
+	if rs, _, err := qlQuery(db, "fileuser_find_active", false, int64(id)); err == nil && len(rs) > 0 {
+		// Count a dual-stack client announcing from more than one address once
+		counted := map[string]bool{}
+
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			completed, left := qlBool(data[0]), data[1].(int64)
+
+			peer := FileUserRecord{UserID: int(data[2].(int64)), IP: data[3].(string), PeerID: qlString(data[4])}
+			if counted[peer.peerIdentity()] {
+				return true, nil
+			}
+			counted[peer.peerIdentity()] = true
+
+			if completed && left == 0 {
+				seeders++
+			} else if !completed && left > 0 {
+				leechers++
+			}
+
+			return true, nil
+		})
+	}
+
+	return
 }
 
 // GetFileRecordPeerList returns a list of Peers
@@ -419,10 +651,16 @@ func (db *qlw) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]P
 		query = "filerecord_find_peerlist_udp"
 	}
 
-	rs, _, err := qlQuery(db, query, true, common.Static.Config.Interval, infoHash)
+	// Peers are included if they have announced within the announce interval, allowing for jitter
+	rs, _, err := qlQuery(db, query, true, peerWindow(common.Static.Config.Interval), infoHash)
+
+	// When peers are selected with a seeded PRNG, the pool must be the same set of peers on every
+	// query, so all peers are read and ordered by address before applying the limit
+	seeded := common.Static.Config.PeerList.Seeded
 
 	// Generate peer list
 	peers := make([]Peer, 0)
+	index := map[Peer]int{}
 
 	if err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
@@ -431,18 +669,54 @@ func (db *qlw) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]P
 				Port: uint16(data[1].(int32)),
 			}
 
+			// Seeding status is only known for HTTP peers, and a peer is a seeder if any of
+			// its records have nothing left to download
+			seeder := len(data) > 2 && data[2] != nil && data[2].(int64) == 0
+
+			// Merge duplicate peers
+			if i, ok := index[peer]; ok {
+				peers[i].Seeder = peers[i].Seeder || seeder
+				return true, nil
+			}
+
+			index[peer] = len(peers)
+			peer.Seeder = seeder
 			peers = append(peers[:], peer)
 
-			return len(peers) < limit, nil
+			return seeded || len(peers) < limit, nil
 		})
 	}
 
+	if err == nil && seeded {
+		sort.Sort(peersByAddress(peers))
+		if len(peers) > limit {
+			peers = peers[:limit]
+		}
+	}
+
 	return peers, err
 }
 
-// GetInactiveUserInfo returns a list of users who have not been active for the specified time interval
-func (db *qlw) GetInactiveUserInfo(fid int, interval time.Duration) (users []peerInfo, err error) {
-	if rs, _, err := qlQuery(db, "fileuser_find_inactive", true, int64(fid), interval); err == nil && len(rs) > 0 {
+// StreamFileRecordPeerList calls fn for each Peer, containing IP/port pairs.  Because duplicate peers
+// must be merged, and ql is embedded, the peer list is built in full before being streamed.
+func (db *qlw) StreamFileRecordPeerList(infoHash string, limit int, http bool, fn func(Peer) error) error {
+	peers, err := db.GetFileRecordPeerList(infoHash, limit, http)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		if err := fn(peer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetInactiveUserInfo returns a list of users who have not announced since the specified UNIX timestamp
+func (db *qlw) GetInactiveUserInfo(fid int, before int64) (users []peerInfo, err error) {
+	if rs, _, err := qlQuery(db, "fileuser_find_inactive", true, int64(fid), time.Unix(before, 0)); err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
 			users = append(users, peerInfo{int(data[0].(int64)), data[1].(string)})
 
@@ -454,21 +728,22 @@ func (db *qlw) GetInactiveUserInfo(fid int, interval time.Duration) (users []pee
 }
 
 // MarkFileUsersInactive sets users to be inactive once they have been reaped
-func (db *qlw) MarkFileUsersInactive(fid int, users []peerInfo) (err error) {
-	if list, err := qlCompile("fileuser_mark_inactive", false); err == nil {
-		tx := db.NewTransaction()
+func (db *qlw) MarkFileUsersInactive(fid int, users []peerInfo) error {
+	list, err := qlCompile("fileuser_mark_inactive", false)
+	if err != nil {
+		return err
+	}
 
-		for _, user := range users {
-			if _, _, err = tx.Execute(list, int64(fid), int64(user.UserID), user.IP); err != nil {
-				tx.Rollback()
+	tx := db.NewTransaction()
+	for _, user := range users {
+		if _, _, err := tx.Execute(list, int64(fid), int64(user.UserID), user.IP); err != nil {
+			tx.Rollback()
 
-				return err
-			}
+			return err
 		}
-		err = tx.Commit()
 	}
 
-	return
+	return tx.Commit()
 }
 
 // GetAllFileRecords returns a list of all FileRecords known to the database
@@ -476,11 +751,13 @@ func (db *qlw) GetAllFileRecords() (files []FileRecord, err error) {
 	if rs, _, err := qlQuery(db, "filerecord_load_all", false); err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
 			files = append(files, FileRecord{
-				ID:         int(data[0].(int64)),
-				InfoHash:   data[1].(string),
-				Verified:   data[2].(bool),
-				CreateTime: data[3].(time.Time).Unix(),
-				UpdateTime: data[4].(time.Time).Unix(),
+				ID:               int(data[0].(int64)),
+				InfoHash:         data[1].(string),
+				Verified:         data[2].(bool),
+				CreateTime:       data[3].(time.Time).Unix(),
+				UpdateTime:       data[4].(time.Time).Unix(),
+				AnnounceInterval: int(qlInt64(data[5])),
+				PeerLimit:        int(qlInt64(data[6])),
 			})
 
 			return true, nil
@@ -490,6 +767,104 @@ func (db *qlw) GetAllFileRecords() (files []FileRecord, err error) {
 	return
 }
 
+// qlFileRecordSort sorts files by a permitted sort field, in descending order, falling back to ID
+type qlFileRecordSort struct {
+	files     []FileRecord
+	completed map[int]int
+	field     string
+}
+
+func (s qlFileRecordSort) Len() int      { return len(s.files) }
+func (s qlFileRecordSort) Swap(i, j int) { s.files[i], s.files[j] = s.files[j], s.files[i] }
+func (s qlFileRecordSort) Less(i, j int) bool {
+	a, b := s.files[i], s.files[j]
+
+	var x, y int64
+	switch s.field {
+	case "create_time":
+		x, y = a.CreateTime, b.CreateTime
+	case "update_time":
+		x, y = a.UpdateTime, b.UpdateTime
+	case "completed":
+		x, y = int64(s.completed[a.ID]), int64(s.completed[b.ID])
+	}
+
+	if x != y {
+		return x > y
+	}
+
+	return a.ID < b.ID
+}
+
+// GetFileRecordPage returns up to limit FileRecords, sorted by the specified field and skipping the
+// first offset files
+// note: ql cannot sort by completions, so all files are sorted in memory
+func (db *qlw) GetFileRecordPage(field string, limit int, offset int) ([]FileRecord, error) {
+	files, err := db.GetAllFileRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	// Count completions on each file, if needed for sorting
+	completed := map[int]int{}
+	if field == "completed" {
+		for _, f := range files {
+			if completed[f.ID], err = db.CountFileRecordCompleted(f.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Sort(qlFileRecordSort{files, completed, field})
+
+	// Select requested page
+	if offset >= len(files) {
+		return []FileRecord{}, nil
+	}
+	files = files[offset:]
+	if limit < len(files) {
+		files = files[:limit]
+	}
+
+	return files, nil
+}
+
+// CountFileRecords counts the number of FileRecords known to the database
+func (db *qlw) CountFileRecords() (int, error) {
+	total, err := qlQueryI64(db, "filerecord_count")
+	return int(total), err
+}
+
+// CountActivePeers counts the number of peers who are actively seeding and leeching all files, in a
+// single pass over all active peers, using the same definitions as CountFileRecordActivePeers
+func (db *qlw) CountActivePeers() (seeders int, leechers int, err error) {
+	if rs, _, err := qlQuery(db, "fileuser_find_all_active", false); err == nil && len(rs) > 0 {
+		// Count a dual-stack client announcing from more than one address once per file
+		counted := map[string]bool{}
+
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			completed, left := qlBool(data[0]), data[1].(int64)
+
+			peer := FileUserRecord{UserID: int(data[2].(int64)), IP: data[3].(string), PeerID: qlString(data[4])}
+			identity := fmt.Sprintf("%d/%s", data[5].(int64), peer.peerIdentity())
+			if counted[identity] {
+				return true, nil
+			}
+			counted[identity] = true
+
+			if completed && left == 0 {
+				seeders++
+			} else if !completed && left > 0 {
+				leechers++
+			}
+
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // --- FileUserRecord.go ---
 
 // DeleteFileUserRecord deletes an AnnounceLog using a file ID, user ID, and IP triple
@@ -500,42 +875,99 @@ func (db *qlw) DeleteFileUserRecord(fid, uid int, ip string) (err error) {
 
 // LoadFileUserRecord loads a FileUserRecord using a file ID, user ID, and IP triple
 func (db *qlw) LoadFileUserRecord(fid, uid int, ip string) (FileUserRecord, error) {
-	rs, _, err := qlQuery(db, "fileuser_load", true, int64(fid), int64(uid), ip)
+	return db.loadFileUserRecord("fileuser_load", int64(fid), int64(uid), ip)
+}
 
-	result := FileUserRecord{}
+// LoadFileUserRecordsKey loads all FileUserRecords using a file ID, user ID, and announce key triple,
+// most recently updated first
+func (db *qlw) LoadFileUserRecordsKey(fid, uid int, key string) (records []FileUserRecord, err error) {
+	rs, _, err := qlQuery(db, "fileuser_load_key", true, int64(fid), int64(uid), key)
 	if err != nil || len(rs) < 1 {
+		return nil, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		records = append(records, qlFileUserRecord(data))
+
+		return true, nil
+	})
+
+	return records, err
+}
+
+// MoveFileUserRecord changes the IP of a FileUserRecord, replacing any record already stored at the
+// new IP, in a single transaction
+func (db *qlw) MoveFileUserRecord(fid, uid int, from, to string) (err error) {
+	tx := db.NewTransaction()
+	if _, _, err = tx.Run(qlq["fileuser_delete"], int64(fid), int64(uid), to); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, _, err = tx.Run(qlq["fileuser_move"], int64(fid), int64(uid), from, to); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// loadFileUserRecord loads the first FileUserRecord returned by a query
+func (db *qlw) loadFileUserRecord(key string, args ...interface{}) (FileUserRecord, error) {
+	rs, _, err := qlQuery(db, key, true, args...)
+
+	result := FileUserRecord{}
+	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
-		result = FileUserRecord{
-			FileID:     int(data[0].(int64)),
-			UserID:     int(data[1].(int64)),
-			IP:         data[2].(string),
-			Active:     data[3].(bool),
-			Completed:  data[4].(bool),
-			Announced:  int(data[5].(int64)),
-			Uploaded:   data[6].(int64),
-			Downloaded: data[7].(int64),
-			Left:       data[8].(int64),
-			Time:       data[9].(time.Time).Unix(),
-		}
+		result = qlFileUserRecord(data)
 
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (FileUserRecord{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
+// qlFileUserRecord converts a row of the files_users table to a FileUserRecord
+func qlFileUserRecord(data []interface{}) FileUserRecord {
+	return FileUserRecord{
+		FileID:        int(data[0].(int64)),
+		UserID:        int(data[1].(int64)),
+		IP:            data[2].(string),
+		Active:        data[3].(bool),
+		Completed:     data[4].(bool),
+		Announced:     int(data[5].(int64)),
+		Uploaded:      data[6].(int64),
+		Downloaded:    data[7].(int64),
+		Left:          data[8].(int64),
+		Time:          data[9].(time.Time).Unix(),
+		LastEvent:     qlString(data[10]),
+		LastEventTime: qlInt64(data[11]),
+		Snatched:      qlBool(data[12]),
+		PeerID:        qlString(data[13]),
+		Key:           qlString(data[14]),
+	}
+}
+
 // SaveFileUserRecord saves a FileUserRecord to the database
 func (db *qlw) SaveFileUserRecord(f FileUserRecord) (err error) {
 	if fr, e := db.LoadFileUserRecord(f.FileID, f.UserID, f.IP); (fr == FileUserRecord{}) {
-		if nil == e {
+		if e == nil || e == ErrNotFound {
 			_, _, err = qlQuery(db, "fileuser_insert", true,
 				int64(f.FileID), int64(f.UserID), f.IP,
 				f.Active, f.Completed, int64(f.Announced),
 				f.Uploaded, f.Downloaded, f.Left,
-				time.Unix(f.Time, 0))
+				f.LastEvent, f.LastEventTime, f.Snatched, f.PeerID, f.Key)
 		} else {
 			err = e
 		}
@@ -543,7 +975,8 @@ func (db *qlw) SaveFileUserRecord(f FileUserRecord) (err error) {
 		_, _, err = qlQuery(db, "fileuser_update", true,
 			int64(f.FileID), int64(f.UserID), f.IP,
 			f.Active, f.Completed, int64(f.Announced),
-			f.Uploaded, f.Downloaded, f.Left)
+			f.Uploaded, f.Downloaded, f.Left,
+			f.LastEvent, f.LastEventTime, f.Snatched, f.PeerID, f.Key)
 	}
 
 	return
@@ -554,16 +987,21 @@ func (db *qlw) LoadFileUserRepository(id interface{}, col string) (files []FileU
 	if rs, _, err := qlQuery(db, "fileuser_load_"+col, true, id); err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
 			files = append(files, FileUserRecord{
-				FileID:     int(data[0].(int64)),
-				UserID:     int(data[1].(int64)),
-				IP:         data[2].(string),
-				Active:     data[3].(bool),
-				Completed:  data[4].(bool),
-				Announced:  data[5].(int),
-				Uploaded:   data[6].(int64),
-				Downloaded: data[7].(int64),
-				Left:       data[8].(int64),
-				Time:       data[9].(time.Time).Unix(),
+				FileID:        int(data[0].(int64)),
+				UserID:        int(data[1].(int64)),
+				IP:            data[2].(string),
+				Active:        data[3].(bool),
+				Completed:     data[4].(bool),
+				Announced:     data[5].(int),
+				Uploaded:      data[6].(int64),
+				Downloaded:    data[7].(int64),
+				Left:          data[8].(int64),
+				Time:          data[9].(time.Time).Unix(),
+				LastEvent:     qlString(data[10]),
+				LastEventTime: qlInt64(data[11]),
+				Snatched:      qlBool(data[12]),
+				PeerID:        qlString(data[13]),
+				Key:           qlString(data[14]),
 			})
 
 			return false, nil
@@ -573,6 +1011,138 @@ func (db *qlw) LoadFileUserRepository(id interface{}, col string) (files []FileU
 	return
 }
 
+// --- Migration.go ---
+
+// LoadSchemaMigrations returns the versions of all migrations applied to the database,
+// creating the schema_migrations table if it does not yet exist
+func (db *qlw) LoadSchemaMigrations() (versions []int, err error) {
+	if _, _, err = qlQuery(db, "migration_create", true); err != nil {
+		return
+	}
+
+	if rs, _, err := qlQuery(db, "migration_load_all", false); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			versions = append(versions, int(data[0].(int64)))
+
+			return true, nil
+		})
+	}
+
+	return
+}
+
+// ApplyMigration applies a schema migration, and records its version in the same transaction
+func (db *qlw) ApplyMigration(m Migration) (err error) {
+	tx := db.NewTransaction()
+
+	// Migrations performed entirely by Func have no SQL
+	if m.QL != "" {
+		list, err := qlCompile(m.QL, false)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, _, err = tx.Execute(list); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, _, err = tx.Run(qlq["migration_insert"], int64(m.Version), m.Description); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSchemaMigration deletes the record of an applied migration, so that it may be applied again
+func (db *qlw) DeleteSchemaMigration(version int) (err error) {
+	_, _, err = qlQuery(db, "migration_delete_version", true, int64(version))
+	return
+}
+
+// --- PasskeyRecord.go ---
+
+// DeletePasskeyRecord deletes a PasskeyRecord using a defined ID and column for query
+func (db *qlw) DeletePasskeyRecord(id interface{}, col string) (err error) {
+	_, _, err = qlQuery(db, "passkey_delete_"+col, true, id)
+	return
+}
+
+// LoadPasskeyRecord loads a PasskeyRecord using a defined ID and column for query
+func (db *qlw) LoadPasskeyRecord(id interface{}, col string) (PasskeyRecord, error) {
+	// Prevent error cannot convert 1 (type int) to type int64
+	if value, ok := id.(int); ok {
+		id = int64(value)
+	}
+	rs, _, err := qlQuery(db, "passkey_load_"+col, true, id)
+
+	result := PasskeyRecord{}
+	if err != nil {
+		return result, err
+	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		result = PasskeyRecord{
+			ID:         int(data[0].(int64)),
+			UserID:     int(data[1].(int64)),
+			Passkey:    data[2].(string),
+			CreateTime: data[3].(int64),
+		}
+
+		return false, nil
+	})
+
+	// No record found
+	if err == nil && result == (PasskeyRecord{}) {
+		err = ErrNotFound
+	}
+
+	return result, err
+}
+
+// SavePasskeyRecord saves a PasskeyRecord to the database, if its passkey is not already stored
+func (db *qlw) SavePasskeyRecord(p PasskeyRecord) (err error) {
+	if existing, e := db.LoadPasskeyRecord(p.Passkey, "passkey"); (existing == PasskeyRecord{}) {
+		if e == nil || e == ErrNotFound {
+			_, _, err = qlQuery(db, "passkey_insert", true, int64(p.UserID), p.Passkey, p.CreateTime)
+		} else {
+			err = e
+		}
+	}
+
+	return
+}
+
+// LoadPasskeyRepository loads all PasskeyRecords matching a defined ID and column for query
+func (db *qlw) LoadPasskeyRepository(id interface{}, col string) (passkeys []PasskeyRecord, err error) {
+	// Prevent error cannot convert 1 (type int) to type int64
+	if value, ok := id.(int); ok {
+		id = int64(value)
+	}
+
+	passkeys = make([]PasskeyRecord, 0)
+	if rs, _, err := qlQuery(db, "passkey_load_"+col, true, id); err == nil && len(rs) > 0 {
+		err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+			passkeys = append(passkeys, PasskeyRecord{
+				ID:         int(data[0].(int64)),
+				UserID:     int(data[1].(int64)),
+				Passkey:    data[2].(string),
+				CreateTime: data[3].(int64),
+			})
+
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // --- ScrapeLog.go ---
 
 // DeleteScrapeLog deletes an ScrapeLog using a defined ID and column for query
@@ -587,40 +1157,125 @@ func (db *qlw) DeleteScrapeLog(id interface{}, col string) (err error) {
 
 // LoadScrapeLog loads a ScrapeLog using a defined ID and column for query
 func (db *qlw) LoadScrapeLog(id interface{}, col string) (scrape ScrapeLog, err error) {
-	if rs, _, err := qlQuery(db, "scrapelog_load_"+col, true, id); err == nil && len(rs) > 0 {
-		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
-			scrape = ScrapeLog{
-				ID:       int(data[0].(int64)),
-				InfoHash: data[1].(string),
-				Passkey:  data[2].(string),
-				IP:       data[3].(string),
-				Time:     data[4].(time.Time).Unix(),
-			}
+	// Prevent error cannot convert 1 (type int) to type int64
+	if value, ok := id.(int); ok {
+		id = int64(value)
+	}
 
-			return false, nil
-		})
+	rs, _, err := qlQuery(db, "scrapelog_load_"+col, true, id)
+	if err != nil {
+		return scrape, err
+	}
+	if len(rs) < 1 {
+		return scrape, ErrNotFound
 	}
 
-	return
+	err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+		scrape = ScrapeLog{
+			ID:       int(data[0].(int64)),
+			InfoHash: data[1].(string),
+			Passkey:  data[2].(string),
+			IP:       data[3].(string),
+			Time:     data[4].(time.Time).Unix(),
+			UserID:   int(qlInt64(data[5])),
+		}
+
+		return false, nil
+	})
+
+	// No record found
+	if err == nil && scrape == (ScrapeLog{}) {
+		err = ErrNotFound
+	}
+
+	return scrape, err
 }
 
 // SaveScrapeLog saves a ScrapeLog to the database
 func (db *qlw) SaveScrapeLog(s ScrapeLog) (err error) {
-	_, _, err = qlQuery(db, "scrapelog_insert", true, s.InfoHash, s.Passkey, s.IP)
+	_, _, err = qlQuery(db, "scrapelog_insert", true, s.InfoHash, s.Passkey, s.IP, int64(s.UserID))
 	return
 }
 
+// --- Snapshot.go ---
+
+// ImportSnapshot replaces all users, files, API keys, passkeys, and file/user relationships with those
+// contained in a snapshot, in a single transaction.  ql assigns record IDs itself, so relationships
+// are remapped to the IDs assigned to each restored user and file.
+func (db *qlw) ImportSnapshot(s Snapshot) (err error) {
+	tx := db.NewTransaction()
+
+	// run executes a query in the transaction, stopping at the first failure
+	run := func(key string, arg ...interface{}) (rs []ql.Recordset) {
+		if err == nil {
+			rs, _, err = tx.Run(qlq[key], arg...)
+		}
+
+		return rs
+	}
+
+	// lastID returns the ID of the record loaded by a query in the transaction
+	lastID := func(key string, arg ...interface{}) (id int64) {
+		if rs := run(key, arg...); err == nil && len(rs) > 0 {
+			err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+				id = data[0].(int64)
+				return false, nil
+			})
+		}
+
+		return id
+	}
+
+	// Clear existing state
+	run("snapshot_clear")
+
+	// Restore users and files, mapping snapshot IDs to newly assigned IDs
+	users := map[int]int64{}
+	for _, u := range s.Users {
+		run("user_insert", u.Username, u.Password, u.Passkey, int64(u.TorrentLimit), u.Admin, u.Banned)
+		users[u.ID] = lastID("user_load_username", u.Username)
+	}
+	files := map[int]int64{}
+	for _, f := range s.Files {
+		run("snapshot_file_insert", f.InfoHash, f.Verified, time.Unix(f.CreateTime, 0), time.Unix(f.UpdateTime, 0),
+			int64(f.AnnounceInterval), int64(f.PeerLimit))
+		files[f.ID] = lastID("filerecord_load_info_hash", f.InfoHash)
+	}
+
+	// Restore records which refer to users and files
+	for _, p := range s.Passkeys {
+		run("passkey_insert", users[p.UserID], p.Passkey, p.CreateTime)
+	}
+	for _, k := range s.APIKeys {
+		run("apikey_insert", users[k.UserID], k.Pubkey, k.Secret, k.Expire)
+	}
+	for _, f := range s.FileUsers {
+		run("snapshot_fileuser_insert", files[f.FileID], users[f.UserID], f.IP, f.Active, f.Completed, int64(f.Announced),
+			f.Uploaded, f.Downloaded, f.Left, time.Unix(f.Time, 0), f.LastEvent, f.LastEventTime, f.Snatched, f.PeerID, f.Key)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // --- UserRecord.go ---
 
-// DeleteUserRecord deletes an AnnounceLog using a defined ID and column for query
-func (db *qlw) DeleteUserRecord(id interface{}, col string) (err error) {
-	// Prevent error cannot convert 1 (type int) to type int64
-	if value, ok := id.(int); ok {
-		id = int64(value)
+// DeleteUserRecord deletes a UserRecord with the specified ID, and the records which belong to it,
+// in a single transaction
+func (db *qlw) DeleteUserRecord(id int) (err error) {
+	tx := db.NewTransaction()
+	for _, key := range []string{"fileuser_delete_user_id", "apikey_delete_user_id", "passkey_delete_user_id", "user_delete_id"} {
+		if _, _, err = tx.Run(qlq[key], int64(id)); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
-	_, _, err = qlQuery(db, "user_delete_"+col, true, id)
-	return
+	return tx.Commit()
 }
 
 // LoadUserRecord loads a UserRecord using a defined ID and column for query
@@ -631,6 +1286,9 @@ func (db *qlw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
 	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = UserRecord{
@@ -639,31 +1297,79 @@ func (db *qlw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
 			Password:     data[2].(string),
 			Passkey:      data[3].(string),
 			TorrentLimit: int(data[4].(int64)),
+			Admin:        qlBool(data[5]),
+			Banned:       qlBool(data[6]),
 		}
 
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (UserRecord{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
 // SaveUserRecord saves a userRecord to the database
 func (db *qlw) SaveUserRecord(u UserRecord) (err error) {
 	if user, e := db.LoadUserRecord(int64(u.ID), "id"); (user == UserRecord{}) {
-		if nil == e {
+		if e == nil || e == ErrNotFound {
 			_, _, err = qlQuery(db, "user_insert", true,
-				u.Username, u.Password, u.Passkey, int64(u.TorrentLimit))
+				u.Username, u.Password, u.Passkey, int64(u.TorrentLimit), u.Admin, u.Banned)
 		} else {
 			err = e
 		}
 	} else {
 		_, _, err = qlQuery(db, "user_update", true,
-			int64(user.ID), u.Username, u.Password, u.Passkey, int64(u.TorrentLimit))
+			int64(user.ID), u.Username, u.Password, u.Passkey, int64(u.TorrentLimit), u.Admin, u.Banned)
 	}
 
 	return
 }
 
+// InsertUserRecord inserts a new UserRecord into the database, returning ErrUsernameTaken if
+// the username is already in use.  ql has no unique constraints, so the check and insert share
+// a transaction.
+func (db *qlw) InsertUserRecord(u UserRecord) error {
+	tx := db.NewTransaction()
+	rs, _, err := tx.Run(qlq["user_load_username"], u.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	taken := false
+	if len(rs) > 0 {
+		err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+			taken = true
+			return false, nil
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if taken {
+		tx.Rollback()
+		return ErrUsernameTaken
+	}
+
+	if _, _, err = tx.Run(qlq["user_insert"], u.Username, u.Password, u.Passkey, int64(u.TorrentLimit), u.Admin, u.Banned); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RenameUserRecord changes the username of the user with the specified ID
+func (db *qlw) RenameUserRecord(id int, username string) (err error) {
+	_, _, err = qlQuery(db, "user_rename", true, int64(id), username)
+	return
+}
+
 // GetUserUploaded calculates the total number of bytes this user has uploaded
 func (db *qlw) GetUserUploaded(uid int) (int64, error) {
 	return qlQueryI64(db, "user_uploaded", uid)
@@ -674,6 +1380,22 @@ func (db *qlw) GetUserDownloaded(uid int) (int64, error) {
 	return qlQueryI64(db, "user_downloaded", uid)
 }
 
+// GetUserTransfer calculates the total number of bytes this user has uploaded and downloaded
+func (db *qlw) GetUserTransfer(uid int) (uploaded int64, downloaded int64, err error) {
+	rs, _, err := qlQuery(db, "user_transfer", false, int64(uid))
+	if err != nil || len(rs) < 1 {
+		return 0, 0, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		uploaded, downloaded = qlInt64(data[0]), qlInt64(data[1])
+
+		return false, nil
+	})
+
+	return
+}
+
 // GetUserSeeding calculates the total number of files this user is actively seeding
 func (db *qlw) GetUserSeeding(uid int) (int, error) {
 	i, err := qlQueryI64(db, "user_seeding", uid)
@@ -686,6 +1408,18 @@ func (db *qlw) GetUserLeeching(uid int) (int, error) {
 	return int(i), err
 }
 
+// GetUserActive calculates the total number of distinct files this user is actively seeding or leeching
+func (db *qlw) GetUserActive(uid int) (int, error) {
+	i, err := qlQueryI64(db, "user_active", int64(uid))
+	return int(i), err
+}
+
+// GetUserFileActive determines if this user is actively seeding or leeching a file, from any IP
+func (db *qlw) GetUserFileActive(uid, fid int) (bool, error) {
+	i, err := qlQueryI64(db, "user_file_active", int64(uid), int64(fid))
+	return i > 0, err
+}
+
 // GetAllUserRecords returns a list of all UserRecords known to the database
 func (db *qlw) GetAllUserRecords() (users []UserRecord, err error) {
 	if rs, _, err := qlQuery(db, "user_load_all", false); err == nil && len(rs) > 0 {
@@ -696,6 +1430,8 @@ func (db *qlw) GetAllUserRecords() (users []UserRecord, err error) {
 				Password:     data[2].(string),
 				Passkey:      data[3].(string),
 				TorrentLimit: int(data[4].(int64)),
+				Admin:        qlBool(data[5]),
+				Banned:       qlBool(data[6]),
 			})
 
 			return true, nil
@@ -718,9 +1454,12 @@ func (db *qlw) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord,
 	rs, _, err := qlQuery(db, "whitelist_load_"+col, true, id)
 
 	result := WhitelistRecord{}
-	if err != nil || len(rs) < 1 {
+	if err != nil {
 		return result, err
 	}
+	if len(rs) < 1 {
+		return result, ErrNotFound
+	}
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = WhitelistRecord{
@@ -732,13 +1471,18 @@ func (db *qlw) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord,
 		return false, nil
 	})
 
+	// No record found
+	if err == nil && result == (WhitelistRecord{}) {
+		err = ErrNotFound
+	}
+
 	return result, err
 }
 
 // SaveWhitelistRecord saves a WhitelistRecord to the database
 func (db *qlw) SaveWhitelistRecord(w WhitelistRecord) (err error) {
 	if wl, e := db.LoadWhitelistRecord(w.ID, "id"); (wl == WhitelistRecord{}) {
-		if nil == e {
+		if e == nil || e == ErrNotFound {
 			_, _, err = qlQuery(db, "whitelist_insert", true,
 				w.Client, w.Approved)
 		} else {
@@ -775,6 +1519,25 @@ func qlQueryI64(db *qlw, key string, arg ...interface{}) (i int64, err error) {
 	return
 }
 
+// qlBool converts a ql bool value to a bool, treating NULL values, such as those in
+// columns added by a migration, as false
+func qlBool(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// qlString converts a ql string value to a string, treating NULL values as empty
+func qlString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// qlInt64 converts a ql int64 value to an int64, treating NULL values as zero
+func qlInt64(v interface{}) int64 {
+	i, _ := v.(int64)
+	return i
+}
+
 // qlCompile provides a wrapper to create safe, transaction-encased queries
 func qlCompile(key string, wraptx bool) (list ql.List, err error) {
 	var src string
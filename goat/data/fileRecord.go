@@ -1,35 +1,71 @@
 package data
 
 import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 )
 
-// FileRecord represents a file tracked by tracker
+// FileRecord represents a file tracked by tracker.  AnnounceInterval and PeerLimit override the
+// global announce interval and peer list size for this file only, and are unset when zero.
 type FileRecord struct {
-	ID         int    `json:"id"`
-	InfoHash   string `db:"info_hash" json:"infoHash"`
-	Verified   bool   `json:"verified"`
-	CreateTime int64  `db:"create_time" json:"createTime"`
-	UpdateTime int64  `db:"update_time" json:"updateTime"`
+	ID               int    `json:"id"`
+	InfoHash         string `db:"info_hash" json:"infoHash"`
+	Verified         bool   `json:"verified"`
+	CreateTime       int64  `db:"create_time" json:"createTime"`
+	UpdateTime       int64  `db:"update_time" json:"updateTime"`
+	AnnounceInterval int    `db:"announce_interval" json:"announceInterval"`
+	PeerLimit        int    `db:"peer_limit" json:"peerLimit"`
 }
 
 // FileRecordRepository is used to contain methods to load multiple FileRecord structs
 type FileRecordRepository struct {
 }
 
+// ErrInvalidSort is returned when loading a page of files using a sort field which is not permitted
+var ErrInvalidSort = errors.New("invalid sort field")
+
+// fileRecordSorts contains the fields by which a page of files may be sorted.  Files are sorted by
+// ID when no field is specified, and in descending order otherwise.
+var fileRecordSorts = map[string]bool{
+	"":            true,
+	"create_time": true,
+	"update_time": true,
+	"completed":   true,
+}
+
 // JSONFileRecord represents output FileRecord JSON for API
 type JSONFileRecord struct {
-	ID         int              `json:"id"`
-	InfoHash   string           `json:"infoHash"`
-	Verified   bool             `json:"verified"`
-	CreateTime int64            `json:"createTime"`
-	UpdateTime int64            `json:"updateTime"`
-	Completed  int              `json:"completed"`
-	Seeders    int              `json:"seeders"`
-	Leechers   int              `json:"leechers"`
-	FileUsers  []FileUserRecord `json:"fileUsers"`
+	ID               int              `json:"id"`
+	InfoHash         string           `json:"infoHash"`
+	Verified         bool             `json:"verified"`
+	CreateTime       int64            `json:"createTime"`
+	UpdateTime       int64            `json:"updateTime"`
+	AnnounceInterval int              `json:"announceInterval"`
+	PeerLimit        int              `json:"peerLimit"`
+	Completed        int              `json:"completed"`
+	Seeders          int              `json:"seeders"`
+	Leechers         int              `json:"leechers"`
+	FileUsers        []FileUserRecord `json:"fileUsers"`
+}
+
+// fileStatsWindow is the number of seconds over which a file's recent announce rate is calculated
+const fileStatsWindow = 300
+
+// FileStats represents the swarm statistics of a file, to be serialized to JSON
+type FileStats struct {
+	InfoHash     string  `json:"infoHash"`
+	Seeders      int     `json:"seeders"`
+	Leechers     int     `json:"leechers"`
+	Snatches     int     `json:"snatches"`
+	ActivePeers  int     `json:"activePeers"`
+	AnnounceRate float64 `json:"announceRate"`
+	Cached       bool    `json:"cached"`
 }
 
 // peerInfo represents a peer which will be marked as active or not
@@ -47,6 +83,8 @@ func (f FileRecord) ToJSON() (JSONFileRecord, error) {
 	j.Verified = f.Verified
 	j.CreateTime = f.CreateTime
 	j.UpdateTime = f.UpdateTime
+	j.AnnounceInterval = f.AnnounceInterval
+	j.PeerLimit = f.PeerLimit
 
 	// Load in FileUserRecords associated with this file
 	var err error
@@ -61,12 +99,7 @@ func (f FileRecord) ToJSON() (JSONFileRecord, error) {
 		return JSONFileRecord{}, err
 	}
 
-	j.Seeders, err = f.Seeders()
-	if err != nil {
-		return JSONFileRecord{}, err
-	}
-
-	j.Leechers, err = f.Leechers()
+	j.Seeders, j.Leechers, err = f.PeerCounts()
 	if err != nil {
 		return JSONFileRecord{}, err
 	}
@@ -104,7 +137,7 @@ func (f FileRecord) Save() error {
 	}
 
 	// Save FileRecord
-	if err := db.SaveFileRecord(f); err != nil {
+	if err := withRetry(func() error { return db.SaveFileRecord(f) }); err != nil {
 		return err
 	}
 
@@ -118,6 +151,11 @@ func (f FileRecord) Save() error {
 
 // Load FileRecord from storage
 func (f FileRecord) Load(id interface{}, col string) (FileRecord, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("files", col); err != nil {
+		return FileRecord{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -137,31 +175,91 @@ func (f FileRecord) Load(id interface{}, col string) (FileRecord, error) {
 	return f, nil
 }
 
-// CompactPeerList returns a packed byte array of peers who are active on this file
-func (f FileRecord) CompactPeerList(numwant int, http bool) ([]byte, error) {
+// CompactPeerList returns packed byte arrays of IPv4 and IPv6 peers who are active on this file.
+// IPv4 peers are 6 bytes each, and IPv6 peers are 18 bytes each, as defined in BEP 7.
+func (f FileRecord) CompactPeerList(key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+	// If configured, and no selection strategy requires the full pool of peers, stream peers
+	// directly from the database into the compact peer list
+	if streamPeerList(leecher) {
+		return f.streamCompactPeerList(numwant, http)
+	}
+
+	// Retrieve list of peers
+	peers, err := f.PeerList(key, leecher, numwant, http)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Append each peer to the compact list for its address family
+	peers4, peers6 := make([]byte, 0), make([]byte, 0)
+	for _, peer := range peers {
+		peers4, peers6 = appendCompactPeer(peers4, peers6, peer)
+	}
+
+	return peers4, peers6, nil
+}
+
+// PeerListDict returns a bencoded list of peer dictionaries for this file, containing up to numwant
+// peers, for clients which do not request a compact peer list.  IPv4 and IPv6 peers share one list.
+func (f FileRecord) PeerListDict(key string, leecher bool, numwant int, http bool) ([]byte, error) {
 	// Retrieve list of peers
-	peers, err := f.PeerList(numwant, http)
+	peers, err := f.PeerList(key, leecher, numwant, http)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create buffer to store compact peers
-	compactPeers := make([]byte, 0)
+	return dictPeerList(peers), nil
+}
 
-	// Iterate peers
-	for _, peer := range peers {
-		// Marshal each peer to binary
-		peerBuf, err := peer.MarshalBinary()
-		if err != nil {
-			return nil, err
+// streamPeerList reports whether peer lists may be streamed from the database, which requires that
+// streaming is enabled, and that no configured strategy must select from the full pool of peers
+func streamPeerList(leecher bool) bool {
+	conf := common.Static.Config.PeerList
+	if !conf.Stream || common.Static.Config.Redis.Enabled || conf.Rotate || conf.Seeded {
+		return false
+	}
+
+	// Seeder ratio only applies to leechers
+	return conf.SeederRatio <= 0 || !leecher
+}
+
+// streamCompactPeerList builds a packed byte array of up to numwant peers as rows are read from the
+// database, so that peers in very large swarms are never buffered in full.  numwant is capped, so
+// memory used for the peer list is bounded regardless of client request.
+func (f FileRecord) streamCompactPeerList(numwant int, http bool) ([]byte, []byte, error) {
+	if numwant <= 0 {
+		return make([]byte, 0), make([]byte, 0), nil
+	}
+	if numwant > peerListPool {
+		numwant = peerListPool
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Append each peer to the compact list for its address family as it is read
+	peers4, peers6 := make([]byte, 0, numwant*6), make([]byte, 0)
+	err = db.StreamFileRecordPeerList(f.InfoHash, numwant, http, func(peer Peer) error {
+		if len(peers4)/6+len(peers6)/18 >= numwant {
+			return nil
 		}
 
-		// Append peer to compact list
-		compactPeers = append(compactPeers[:], peerBuf...)
+		peers4, peers6 = appendCompactPeer(peers4, peers6, peer)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return nil, nil, err
 	}
 
-	// Return compact peer list
-	return compactPeers, nil
+	return peers4, peers6, nil
 }
 
 // Completed returns the number of completions, active or not, on this file
@@ -186,63 +284,233 @@ func (f FileRecord) Completed() (int, error) {
 	return completed, nil
 }
 
-// Seeders returns the number of seeders on this file
-func (f FileRecord) Seeders() (int, error) {
+// PeerCounts returns the number of seeders and leechers on this file
+func (f FileRecord) PeerCounts() (int, int, error) {
+	// If enabled, count unexpired peers in Redis swarm state
+	if common.Static.Config.Redis.Enabled {
+		return redisPeerCounts(f.InfoHash, common.Now().Unix())
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	// Return number of active seeders
-	seeders, err := db.CountFileRecordSeeders(f.ID)
+	// Return number of active seeders and leechers
+	seeders, leechers, err := db.CountFileRecordActivePeers(f.ID)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	// Close database connection
 	if err := db.Close(); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return seeders, nil
+	return seeders, leechers, nil
 }
 
-// Leechers returns the number of leechers on this file
-func (f FileRecord) Leechers() (int, error) {
+// ActivePeers returns the number of active peers, both seeders and leechers, on this file
+func (f FileRecord) ActivePeers() (int, error) {
+	seeders, leechers, err := f.PeerCounts()
+	return seeders + leechers, err
+}
+
+// Stats returns the swarm statistics of this file, where the announce rate is the average number of
+// announces per minute over the last five minutes
+func (f FileRecord) Stats() (FileStats, error) {
+	stats := FileStats{InfoHash: f.InfoHash}
+
+	// Retrieve number of seeders, leechers, and distinct users who completed this file,
+	// falling back to live counts if not cached
+	scrape, err := f.ScrapeStats()
+	if err != nil {
+		return FileStats{}, err
+	}
+	stats.Seeders = scrape.Seeders
+	stats.Leechers = scrape.Leechers
+	stats.ActivePeers = stats.Seeders + stats.Leechers
+	stats.Snatches = scrape.Completed
+	stats.Cached = scrape.Cached
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
-		return 0, err
+		return FileStats{}, err
 	}
 
-	// Return number of active leechers
-	leechers, err := db.CountFileRecordLeechers(f.ID)
+	// Retrieve number of recent announces
+	announces, err := db.CountFileRecordAnnounces(f.InfoHash, common.Now().Unix()-fileStatsWindow)
 	if err != nil {
-		return 0, err
+		return FileStats{}, err
 	}
+	stats.AnnounceRate = float64(announces) / (fileStatsWindow / 60)
 
 	// Close database connection
 	if err := db.Close(); err != nil {
-		return 0, err
+		return FileStats{}, err
+	}
+
+	return stats, nil
+}
+
+// Interval returns the announce interval for this file, using its override if set, or the
+// global announce interval otherwise
+func (f FileRecord) Interval() int {
+	if f.AnnounceInterval > 0 {
+		return f.AnnounceInterval
+	}
+
+	return common.Static.Config.Interval
+}
+
+// MinInterval returns the minimum announce interval for this file, using the configured minimum if
+// set, or half of the file's announce interval otherwise.  The minimum never exceeds the interval.
+func (f FileRecord) MinInterval() int {
+	interval := f.Interval()
+
+	minInterval := common.Static.Config.MinInterval
+	if minInterval <= 0 {
+		return interval / 2
+	}
+
+	if minInterval > interval {
+		return interval
+	}
+
+	return minInterval
+}
+
+// intervalRand is the source of random announce interval jitter, which may be replaced with a
+// seeded source for testing
+var intervalRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomJitter returns a random number of seconds, up to the configured jitter percentage of an
+// announce interval, to be added to that interval
+func randomJitter(interval int) int {
+	max := interval * common.Static.Config.JitterPercent / 100
+	if max <= 0 {
+		return 0
+	}
+
+	intervalRand.Lock()
+	defer intervalRand.Unlock()
+
+	return intervalRand.Intn(max + 1)
+}
+
+// ComputeInterval returns an announce interval drawn from the configured base interval plus up to
+// the configured random jitter percentage, and the minimum announce interval reported with it
+func ComputeInterval() (interval int, minInterval int) {
+	base := common.Static.Config.Interval
+	return base + randomJitter(base), FileRecord{}.MinInterval()
+}
+
+// PeerInterval returns the announce interval for a peer on this file, offset by the configured jitter.
+// The offset is derived from a seed identifying the peer, such as its peer_id, so each peer receives
+// a consistent interval across announces, while peers' intervals are spread across the jitter band
+// to avoid announce stampedes.
+// A random amount, up to the configured jitter percentage, is also added to each interval.
+func (f FileRecord) PeerInterval(seed string) int {
+	interval := f.Interval()
+	interval += randomJitter(interval)
+
+	// Jitter disabled, or no seed to derive an offset from
+	jitter := common.Static.Config.IntervalJitter
+	if jitter <= 0 || seed == "" {
+		return interval
 	}
 
-	return leechers, nil
+	// Derive an offset between -jitter and +jitter seconds from the seed
+	sum := sha1.Sum([]byte(seed))
+	interval += int(binary.BigEndian.Uint32(sum[:4])%uint32(2*jitter+1)) - jitter
+
+	// Never return an interval less than one second
+	if interval < 1 {
+		return 1
+	}
+
+	return interval
+}
+
+// peerWindow returns the longest number of seconds a peer may wait between announces, given an
+// announce interval, allowing for the configured jitter and random jitter.  Peers which announce
+// within this window are not yet late, and must not be treated as stale.
+func peerWindow(interval int) int {
+	return interval + common.Static.Config.IntervalJitter + interval*common.Static.Config.JitterPercent/100
+}
+
+// NumWant returns the number of peers which should be returned to a client requesting numwant
+// peers, capped by this file's peer limit, if set
+func (f FileRecord) NumWant(numwant int) int {
+	if f.PeerLimit > 0 && numwant > f.PeerLimit {
+		return f.PeerLimit
+	}
+
+	return numwant
+}
+
+// Seeders returns the number of seeders on this file
+func (f FileRecord) Seeders() (int, error) {
+	seeders, _, err := f.PeerCounts()
+	return seeders, err
+}
+
+// Leechers returns the number of leechers on this file
+func (f FileRecord) Leechers() (int, error) {
+	_, leechers, err := f.PeerCounts()
+	return leechers, err
 }
 
 // PeerList returns a list of peers on this torrent, for tracker announce
-func (f FileRecord) PeerList(numwant int, http bool) ([]Peer, error) {
+// note: key identifies the requesting peer, and is used for peer list rotation, if enabled
+func (f FileRecord) PeerList(key string, leecher bool, numwant int, http bool) ([]Peer, error) {
 	// List of peers
 	peers := make([]Peer, 0)
 
+	// If any selection strategy is configured, retrieve a larger pool of peers to select from
+	conf := common.Static.Config.PeerList
+	limit := numwant
+	if conf.Rotate || conf.Seeded || conf.SeederRatio > 0 {
+		limit = peerListPool
+	}
+
+	// If enabled, retrieve peers from Redis swarm state instead of the database
+	if common.Static.Config.Redis.Enabled {
+		now := common.Now().Unix()
+		peers, err := redisPeerList(f.InfoHash, limit, now)
+		if err != nil {
+			return peers, err
+		}
+
+		return selectPeers(f.InfoHash, key, leecher, peers, numwant, now), nil
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
 		return peers, err
 	}
 
-	// Return list of peers, up to numwant
-	if peers, err = db.GetFileRecordPeerList(f.InfoHash, numwant, http); err != nil {
+	// HTTP peers are tracked by their file/user relationships, so skip the peer list
+	// query entirely when there are no active peers
+	if http {
+		seeders, leechers, err := db.CountFileRecordActivePeers(f.ID)
+		if err != nil {
+			return peers, err
+		}
+
+		if seeders+leechers == 0 {
+			return peers, db.Close()
+		}
+	}
+
+	// Retrieve list of peers, up to limit
+	if peers, err = db.GetFileRecordPeerList(f.InfoHash, limit, http); err != nil {
 		return peers, err
 	}
 
@@ -251,25 +519,112 @@ func (f FileRecord) PeerList(numwant int, http bool) ([]Peer, error) {
 		return peers, err
 	}
 
-	return peers, nil
+	return selectPeers(f.InfoHash, key, leecher, peers, numwant, common.Now().Unix()), nil
 }
 
-// PeerReaper reaps peers who have not recently announced on this torrent, and mark them inactive
-func (f FileRecord) PeerReaper() (int, error) {
+// selectPeers selects up to numwant peers from a pool of peers, using the configured strategies
+func selectPeers(infoHash string, key string, leecher bool, peers []Peer, numwant int, now int64) []Peer {
+	ratio := common.Static.Config.PeerList.SeederRatio
+
+	// Leechers are only preferred by seeders, so only apply preference for leechers
+	if !leecher || ratio <= 0 {
+		return selectPeerGroup(infoHash, key, peers, numwant, now)
+	}
+
+	// Split pool into seeders and leechers
+	seeders := make([]Peer, 0)
+	leechers := make([]Peer, 0)
+	for _, p := range peers {
+		if p.Seeder {
+			seeders = append(seeders[:], p)
+		} else {
+			leechers = append(leechers[:], p)
+		}
+	}
+
+	// Calculate number of each to return, filling any shortfall in one with the other
+	if ratio > 1 {
+		ratio = 1
+	}
+	numSeeders := int(float64(numwant)*ratio + 0.5)
+	if numSeeders > len(seeders) {
+		numSeeders = len(seeders)
+	}
+	numLeechers := numwant - numSeeders
+	if numLeechers > len(leechers) {
+		numLeechers = len(leechers)
+	}
+	if numSeeders+numLeechers < numwant {
+		numSeeders = numwant - numLeechers
+		if numSeeders > len(seeders) {
+			numSeeders = len(seeders)
+		}
+	}
+
+	// Select each group independently, so strategies apply within each
+	return append(selectPeerGroup(infoHash, key+"/seeders", seeders, numSeeders, now),
+		selectPeerGroup(infoHash, key+"/leechers", leechers, numLeechers, now)...)
+}
+
+// selectPeerGroup selects up to numwant peers from a group of peers, by rotation or seeded
+// selection if configured, or otherwise in the order peers were retrieved
+func selectPeerGroup(infoHash string, key string, peers []Peer, numwant int, now int64) []Peer {
+	conf := common.Static.Config.PeerList
+
+	// If configured, rotate through the pool, so that consecutive announces from
+	// the same peer return different peers
+	if conf.Rotate && key != "" {
+		// Discard rotation state for peers which have not announced in two intervals, allowing for jitter
+		ttl := int64(peerWindow(common.Static.Config.Interval)) * 2
+		return rotation.peers(infoHash+key, peers, numwant, ttl, now)
+	}
+
+	// If configured, select peers deterministically from the pool, using a PRNG
+	// seeded by this file and the current time window
+	if conf.Seeded {
+		// Use announce interval as window, if none configured
+		window := conf.SeedWindow
+		if window <= 0 {
+			window = common.Static.Config.Interval
+		}
+
+		return seededPeers(peers, infoHash, numwant, int64(window), now)
+	}
+
+	// Return peers, up to numwant
+	if numwant < 0 {
+		numwant = 0
+	}
+	if numwant < len(peers) {
+		return peers[:numwant]
+	}
+
+	return peers
+}
+
+// PeerTimeout returns the number of seconds after its last announce that a peer is considered
+// stale, based upon the announce interval allowing for jitter, and the configured timeout multiplier
+func PeerTimeout() int64 {
+	return int64(float64(peerWindow(common.Static.Config.Interval)) * common.Static.Config.Reaper.TimeoutMultiplier)
+}
+
+// PeerReaper reaps peers who have not announced on this torrent within the peer timeout, as of the
+// specified UNIX timestamp, and marks them inactive
+func (f FileRecord) PeerReaper(now int64) (int, error) {
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
 		return 0, err
 	}
 
-	// Retrieve list of inactive users (have not announced in just above maximum interval)
-	users, err := db.GetInactiveUserInfo(f.ID, time.Duration(int64(common.Static.Config.Interval))*time.Second+60)
+	// Retrieve list of inactive users (have not announced within the peer timeout)
+	users, err := db.GetInactiveUserInfo(f.ID, now-PeerTimeout())
 	if err != nil {
 		return 0, err
 	}
 
-	// Mark those users inactive on this file
-	if err := db.MarkFileUsersInactive(f.ID, users); err != nil {
+	// Mark those users inactive on this file, which may deadlock with concurrent announces
+	if err := withRetry(func() error { return db.MarkFileUsersInactive(f.ID, users) }); err != nil {
 		return 0, err
 	}
 
@@ -286,6 +641,41 @@ func (f FileRecord) Users() ([]FileUserRecord, error) {
 	return new(FileUserRecordRepository).Select(f.ID, "file_id")
 }
 
+// Page loads up to limit FileRecord structs from storage, sorted by the specified field and skipping
+// the first offset files, along with the total number of files in storage
+func (f FileRecordRepository) Page(sort string, limit int, offset int) ([]FileRecord, int, error) {
+	files := make([]FileRecord, 0)
+
+	// Reject fields which may not be used to sort files
+	if !fileRecordSorts[sort] {
+		return files, 0, ErrInvalidSort
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return files, 0, err
+	}
+
+	// Retrieve requested page of files, and the total number of files
+	files, err = db.GetFileRecordPage(sort, limit, offset)
+	if err != nil {
+		return files, 0, err
+	}
+
+	total, err := db.CountFileRecords()
+	if err != nil {
+		return files, 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return files, 0, err
+	}
+
+	return files, total, nil
+}
+
 // All loads all FileRecord structs from storage
 func (f FileRecordRepository) All() ([]FileRecord, error) {
 	files := make([]FileRecord, 0)
@@ -309,3 +699,53 @@ func (f FileRecordRepository) All() ([]FileRecord, error) {
 
 	return files, nil
 }
+
+// Count returns the number of FileRecords in storage
+func (f FileRecordRepository) Count() (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Count all files
+	total, err := db.CountFileRecords()
+	if err != nil {
+		return 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// PeerCounts returns the total number of seeders and leechers on all files, without loading or
+// counting each file individually
+func (f FileRecordRepository) PeerCounts() (int, int, error) {
+	// If enabled, count unexpired peers in Redis swarm state
+	if common.Static.Config.Redis.Enabled {
+		return redisTotalPeerCounts(common.Now().Unix())
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Return number of active seeders and leechers on all files
+	seeders, leechers, err := db.CountActivePeers()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	return seeders, leechers, nil
+}
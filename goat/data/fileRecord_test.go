@@ -1,8 +1,13 @@
 package data
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 )
@@ -12,7 +17,7 @@ func TestFileRecord(t *testing.T) {
 	log.Println("TestFileRecord()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -55,3 +60,700 @@ func TestFileRecord(t *testing.T) {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
 }
+
+// TestFileRecordActivePeers verifies that active peers are the sum of seeders and leechers
+func TestFileRecordActivePeers(t *testing.T) {
+	log.Println("TestFileRecordActivePeers()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock FileRecord
+	file := FileRecord{
+		InfoHash: "deadbeef",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Generate mock peers: two seeders, one leecher, and one inactive seeder
+	fileUsers := []FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Left: 0},
+		{FileID: file.ID, UserID: 2, IP: "127.0.0.1", Active: true, Completed: true, Left: 0},
+		{FileID: file.ID, UserID: 3, IP: "127.0.0.1", Active: true, Completed: false, Left: 100},
+		{FileID: file.ID, UserID: 4, IP: "127.0.0.1", Active: false, Completed: true, Left: 0},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Verify counts
+	seeders, leechers, err := file.PeerCounts()
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 2 || leechers != 1 {
+		t.Fatalf("Expected 2 seeders and 1 leecher, got %d and %d", seeders, leechers)
+	}
+
+	active, err := file.ActivePeers()
+	if err != nil {
+		t.Fatalf("Failed to count active peers: %s", err.Error())
+	}
+	if active != seeders+leechers {
+		t.Fatalf("Active peers, expected %d, got %d", seeders+leechers, active)
+	}
+
+	// Delete mock peers and file
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestFileRecordPeerCountsPerFile verifies that seeders and leechers are counted separately for each file
+func TestFileRecordPeerCountsPerFile(t *testing.T) {
+	log.Println("TestFileRecordPeerCountsPerFile()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save two mock files
+	files := []FileRecord{
+		{InfoHash: "deadbeef01", Verified: true},
+		{InfoHash: "deadbeef02", Verified: true},
+	}
+	for i := range files {
+		if err := files[i].Save(); err != nil {
+			t.Fatalf("Failed to save mock file: %s", err.Error())
+		}
+
+		// Load mock file to fetch ID
+		file, err := files[i].Load(files[i].InfoHash, "info_hash")
+		if err != nil {
+			t.Fatalf("Failed to load mock file: %s", err.Error())
+		}
+		files[i] = file
+	}
+
+	// Generate mock peers: one seeder and two leechers on the first file, two seeders on the second
+	fileUsers := []FileUserRecord{
+		{FileID: files[0].ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Left: 0},
+		{FileID: files[0].ID, UserID: 2, IP: "127.0.0.1", Active: true, Completed: false, Left: 100},
+		{FileID: files[0].ID, UserID: 3, IP: "127.0.0.1", Active: true, Completed: false, Left: 100},
+		{FileID: files[1].ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Left: 0},
+		{FileID: files[1].ID, UserID: 4, IP: "127.0.0.1", Active: true, Completed: true, Left: 0},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Verify each file reports only its own peers
+	expected := [][2]int{{1, 2}, {2, 0}}
+	for i, file := range files {
+		seeders, err := file.Seeders()
+		if err != nil {
+			t.Fatalf("Failed to count seeders: %s", err.Error())
+		}
+		leechers, err := file.Leechers()
+		if err != nil {
+			t.Fatalf("Failed to count leechers: %s", err.Error())
+		}
+
+		if seeders != expected[i][0] || leechers != expected[i][1] {
+			t.Fatalf("File %s, expected %d seeders and %d leechers, got %d and %d",
+				file.InfoHash, expected[i][0], expected[i][1], seeders, leechers)
+		}
+	}
+
+	// Delete mock peers and files
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	for _, file := range files {
+		if err := file.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file: %s", err.Error())
+		}
+	}
+}
+
+// TestFileRecordDualStackPeer verifies that a client announcing from both an IPv4 and IPv6 address
+// is counted as a single peer
+func TestFileRecordDualStackPeer(t *testing.T) {
+	log.Println("TestFileRecordDualStackPeer()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock FileRecord
+	file := FileRecord{
+		InfoHash: "deadbeef",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Generate mock peers: one dual-stack leecher announcing from two addresses with the same peer ID
+	peerID := "2d5452323834302d6162636465666768696a6b6c"
+	fileUsers := []FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: false, Left: 100, PeerID: peerID},
+		{FileID: file.ID, UserID: 1, IP: "::1", Active: true, Completed: false, Left: 100, PeerID: peerID},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Verify dual-stack peer is counted once
+	seeders, leechers, err := file.PeerCounts()
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 0 || leechers != 1 {
+		t.Fatalf("Expected 0 seeders and 1 leecher, got %d and %d", seeders, leechers)
+	}
+
+	// Delete mock peers and file
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestFileRecordSeededPeerPool verifies that when a swarm is larger than the peer list pool, seeded
+// selection draws from the same pool of lowest addressed peers on every query
+func TestFileRecordSeededPeerPool(t *testing.T) {
+	log.Println("TestFileRecordSeededPeerPool()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.PeerList.Rotate = false
+	config.PeerList.Seeded = true
+	config.PeerList.SeederRatio = 0
+	config.Redis.Enabled = false
+	common.Static.Config = config
+
+	// Pin the clock, so both queries fall within the same seed window
+	now := time.Now()
+	defer func(fn func() time.Time) {
+		common.Now = fn
+	}(common.Now)
+	common.Now = func() time.Time {
+		return now
+	}
+
+	// Generate and save mock FileRecord
+	file := FileRecord{
+		InfoHash: "deadbeef",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Generate a swarm of UDP peers larger than the pool
+	logs := make([]AnnounceLog, 0, peerListPool+100)
+	for i := 0; i < peerListPool+100; i++ {
+		logs = append(logs[:], AnnounceLog{
+			InfoHash: file.InfoHash,
+			IP:       fmt.Sprintf("10.0.%d.%d", i>>8, i&0xff),
+			Port:     5000,
+			UDP:      true,
+			Event:    "started",
+			Time:     now.Unix(),
+		})
+	}
+
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	if err := db.SaveAnnounceLogs(logs); err != nil {
+		t.Fatalf("Failed to save mock announce logs: %s", err.Error())
+	}
+
+	// The pool is the lowest addressed peers in the swarm
+	swarm := make([]Peer, 0, len(logs))
+	for _, a := range logs {
+		swarm = append(swarm[:], Peer{IP: a.IP, Port: uint16(a.Port)})
+	}
+	sort.Sort(peersByAddress(swarm))
+	pool := map[Peer]bool{}
+	for _, p := range swarm[:peerListPool] {
+		pool[p] = true
+	}
+
+	// Verify both queries select the same peers, all from the pool
+	first, err := file.PeerList("", true, 50, false)
+	if err != nil {
+		t.Fatalf("Failed to generate peer list: %s", err.Error())
+	}
+	second, err := file.PeerList("", true, 50, false)
+	if err != nil {
+		t.Fatalf("Failed to generate peer list: %s", err.Error())
+	}
+
+	if len(first) != 50 || len(second) != 50 {
+		t.Fatalf("Peer list length, expected 50, got %d and %d", len(first), len(second))
+	}
+	for i := range first {
+		if first[i].IP != second[i].IP || first[i].Port != second[i].Port {
+			t.Fatalf("Peer %d differs between queries: %s:%d and %s:%d", i,
+				first[i].IP, first[i].Port, second[i].IP, second[i].Port)
+		}
+		if !pool[Peer{IP: first[i].IP, Port: first[i].Port}] {
+			t.Fatalf("Peer %s:%d selected from outside the pool", first[i].IP, first[i].Port)
+		}
+	}
+
+	// Delete mock announce logs and file
+	if err := db.DeleteAnnounceLog(file.InfoHash, "info_hash"); err != nil {
+		t.Fatalf("Failed to delete mock announce logs: %s", err.Error())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database connection: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestFileRecordCompleted verifies that completions are counted by distinct users, not completion events
+func TestFileRecordCompleted(t *testing.T) {
+	log.Println("TestFileRecordCompleted()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock FileRecord
+	file := FileRecord{
+		InfoHash: "deadbeef",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Load mock file to fetch ID
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if file == (FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// A single user completes, stops, re-downloads, and completes again, and also completes from
+	// a second IP
+	fileUsers := []FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Snatched: true, Left: 0},
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: false, Completed: true, Snatched: true, Left: 0},
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: false, Snatched: true, Left: 100},
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.1", Active: true, Completed: true, Snatched: true, Left: 0},
+		{FileID: file.ID, UserID: 1, IP: "127.0.0.2", Active: true, Completed: true, Snatched: true, Left: 0},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+
+		// Verify user is only counted once
+		completed, err := file.Completed()
+		if err != nil {
+			t.Fatalf("Failed to count completions: %s", err.Error())
+		}
+		if completed != 1 {
+			t.Fatalf("Completed, expected 1, got %d", completed)
+		}
+	}
+
+	// A second user completes
+	fileUser := FileUserRecord{FileID: file.ID, UserID: 2, IP: "127.0.0.1", Active: true, Completed: true, Snatched: true}
+	if err := fileUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock file user: %s", err.Error())
+	}
+	fileUsers = append(fileUsers, fileUser)
+
+	completed, err := file.Completed()
+	if err != nil {
+		t.Fatalf("Failed to count completions: %s", err.Error())
+	}
+	if completed != 2 {
+		t.Fatalf("Completed, expected 2, got %d", completed)
+	}
+
+	// Delete mock peers and file
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestFileRecordPeerInterval verifies that each peer receives a consistent jittered interval across
+// announces, and that different peers receive different intervals within the jitter band
+func TestFileRecordPeerInterval(t *testing.T) {
+	log.Println("TestFileRecordPeerInterval()")
+
+	// Load config, with jitter enabled
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 3600
+	config.IntervalJitter = 300
+	common.Static.Config = config
+
+	file := FileRecord{}
+
+	// Verify the same peer receives the same interval across announces, within the jitter band
+	intervals := make(map[int]bool)
+	for _, peerID := range []string{"-TR2840-abcdefghijkl", "-TR2840-mnopqrstuvwx", "-UT3450-abcdefghijkl"} {
+		interval := file.PeerInterval(peerID)
+		for i := 0; i < 3; i++ {
+			if again := file.PeerInterval(peerID); again != interval {
+				t.Fatalf("PeerInterval(%q) not stable, expected %d, got %d", peerID, interval, again)
+			}
+		}
+
+		if interval < 3300 || interval > 3900 {
+			t.Fatalf("PeerInterval(%q), expected interval within 3300-3900, got %d", peerID, interval)
+		}
+
+		intervals[interval] = true
+	}
+
+	// Verify different peers receive different intervals
+	if len(intervals) != 3 {
+		t.Fatalf("Expected distinct intervals for distinct peers, got %v", intervals)
+	}
+
+	// Verify jitter is not applied when disabled, or to peers without a seed
+	if interval := file.PeerInterval(""); interval != 3600 {
+		t.Fatalf("PeerInterval with no seed, expected %d, got %d", 3600, interval)
+	}
+	common.Static.Config.IntervalJitter = 0
+	if interval := file.PeerInterval("-TR2840-abcdefghijkl"); interval != 3600 {
+		t.Fatalf("PeerInterval with jitter disabled, expected %d, got %d", 3600, interval)
+	}
+}
+
+// TestComputeInterval verifies that announce intervals are drawn from the base interval plus up to
+// the configured jitter percentage, repeatably for a seeded source, along with the minimum interval
+func TestComputeInterval(t *testing.T) {
+	log.Println("TestComputeInterval()")
+
+	// Load config, with 10% random jitter
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 3600
+	config.JitterPercent = 10
+	config.MinInterval = 900
+	common.Static.Config = config
+
+	// Draw intervals from a seeded source, restoring the default afterwards
+	source := intervalRand.Rand
+	defer func() {
+		intervalRand.Rand = source
+	}()
+
+	draw := func(seed int64) []int {
+		intervalRand.Rand = rand.New(rand.NewSource(seed))
+
+		intervals := make([]int, 0)
+		for i := 0; i < 20; i++ {
+			interval, minInterval := ComputeInterval()
+			if interval < 3600 || interval > 3960 {
+				t.Fatalf("ComputeInterval(), expected interval within 3600-3960, got %d", interval)
+			}
+			if minInterval != 900 {
+				t.Fatalf("ComputeInterval(), expected min interval %d, got %d", 900, minInterval)
+			}
+
+			intervals = append(intervals, interval)
+		}
+
+		return intervals
+	}
+
+	// Verify the same seed yields the same intervals, and that intervals vary
+	first, second := draw(1), draw(1)
+	distinct := make(map[int]bool)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Seeded intervals differ at %d: %v, %v", i, first, second)
+		}
+		distinct[first[i]] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("Expected jittered intervals to vary, got %v", first)
+	}
+
+	// Verify random jitter is not applied when disabled
+	common.Static.Config.JitterPercent = 0
+	if interval, _ := ComputeInterval(); interval != 3600 {
+		t.Fatalf("ComputeInterval() with jitter disabled, expected %d, got %d", 3600, interval)
+	}
+}
+
+// TestPeerTimeoutJitter verifies that peer timeouts are widened by the configured jitter, so that
+// peers given the longest jittered interval are not treated as stale before they announce
+func TestPeerTimeoutJitter(t *testing.T) {
+	log.Println("TestPeerTimeoutJitter()")
+
+	// Load config, with jitter enabled
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 3600
+	config.IntervalJitter = 300
+	config.Reaper.TimeoutMultiplier = 1
+	common.Static.Config = config
+
+	// Verify the peer list window covers the longest jittered interval
+	if window := peerWindow(config.Interval); window != 3900 {
+		t.Fatalf("peerWindow(%d), expected %d, got %d", config.Interval, 3900, window)
+	}
+
+	// Verify peer timeouts are no shorter than the longest jittered interval
+	if timeout := PeerTimeout(); timeout != 3900 {
+		t.Fatalf("PeerTimeout(), expected %d, got %d", 3900, timeout)
+	}
+	if ttl := redisPeerTTL(); ttl < 3900 {
+		t.Fatalf("redisPeerTTL(), expected at least %d, got %d", 3900, ttl)
+	}
+}
+
+// minIntervalTests contains a configured minimum interval, a file's interval override, and the
+// expected minimum interval reported for that file
+var minIntervalTests = []struct {
+	minInterval      int
+	announceInterval int
+	expected         int
+}{
+	{0, 0, 1800},
+	{0, 7200, 3600},
+	{900, 0, 900},
+	{900, 7200, 900},
+	{5400, 0, 3600},
+	{5400, 1200, 1200},
+}
+
+// TestFileRecordMinInterval verifies that the configured minimum interval is used when set, and that
+// it defaults to half of the file's interval, never exceeding that interval
+func TestFileRecordMinInterval(t *testing.T) {
+	log.Println("TestFileRecordMinInterval()")
+
+	// Iterate all tests
+	for _, test := range minIntervalTests {
+		common.Static.Config = common.Conf{Interval: 3600, MinInterval: test.minInterval}
+
+		file := FileRecord{AnnounceInterval: test.announceInterval}
+		if minInterval := file.MinInterval(); minInterval != test.expected {
+			t.Fatalf("MinInterval(%d/%d), expected %d, got %d", test.minInterval, test.announceInterval,
+				test.expected, minInterval)
+		}
+	}
+}
+
+// peerListBenchDB is a database backend serving a large synthetic swarm, used to benchmark peer lists
+type peerListBenchDB struct {
+	dbModel
+	peers []Peer
+}
+
+// Close does nothing, as there is no connection
+func (db peerListBenchDB) Close() error {
+	return nil
+}
+
+// CountFileRecordActivePeers reports all synthetic peers as leechers
+func (db peerListBenchDB) CountFileRecordActivePeers(id int) (int, int, error) {
+	return 0, len(db.peers), nil
+}
+
+// GetFileRecordPeerList returns up to limit synthetic peers
+func (db peerListBenchDB) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]Peer, error) {
+	peers := make([]Peer, 0)
+	err := db.StreamFileRecordPeerList(infoHash, limit, http, func(peer Peer) error {
+		peers = append(peers[:], peer)
+		return nil
+	})
+
+	return peers, err
+}
+
+// StreamFileRecordPeerList calls fn for up to limit synthetic peers
+func (db peerListBenchDB) StreamFileRecordPeerList(infoHash string, limit int, http bool, fn func(Peer) error) error {
+	for i := 0; i < limit && i < len(db.peers); i++ {
+		if err := fn(db.peers[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// benchmarkCompactPeerList benchmarks compact peer list generation on a synthetic swarm of 100,000 peers
+func benchmarkCompactPeerList(b *testing.B, stream bool) {
+	// Generate synthetic swarm
+	db := peerListBenchDB{peers: make([]Peer, 0, 100000)}
+	for i := 0; i < 100000; i++ {
+		db.peers = append(db.peers[:], Peer{
+			IP:   fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff),
+			Port: uint16(5000 + i%1000),
+		})
+	}
+
+	// Serve swarm in place of database, restoring configuration and database afterwards
+	defaultConfig := common.Static.Config
+	dbConnect := DBConnectFunc
+	defer func() {
+		common.Static.Config = defaultConfig
+		DBConnectFunc = dbConnect
+	}()
+
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+	common.Static.Config.PeerList.Rotate = false
+	common.Static.Config.PeerList.Seeded = false
+	common.Static.Config.PeerList.SeederRatio = 0
+	common.Static.Config.PeerList.Stream = stream
+	common.Static.Config.Redis.Enabled = false
+
+	file := FileRecord{ID: 1, InfoHash: "6465616462656566303030303030303030303030"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		peers, _, err := file.CompactPeerList("", true, 1000, true)
+		if err != nil {
+			b.Fatalf("Failed to generate compact peer list: %s", err.Error())
+		}
+		if len(peers) != 6000 {
+			b.Fatalf("Compact peer list length, expected 6000, got %d", len(peers))
+		}
+	}
+}
+
+// BenchmarkCompactPeerList benchmarks generating a compact peer list by loading all peers first
+func BenchmarkCompactPeerList(b *testing.B) {
+	benchmarkCompactPeerList(b, false)
+}
+
+// BenchmarkCompactPeerListStream benchmarks generating a compact peer list as peers are read
+func BenchmarkCompactPeerListStream(b *testing.B) {
+	benchmarkCompactPeerList(b, true)
+}
+
+// activePeersDB is a database backend which reports fixed totals of active peers on all files,
+// failing if peers are counted for any individual file
+type activePeersDB struct {
+	dbModel
+	files    int
+	seeders  int
+	leechers int
+}
+
+// Close does nothing, as there is no connection
+func (db activePeersDB) Close() error {
+	return nil
+}
+
+// CountFileRecords returns the fixed number of files
+func (db activePeersDB) CountFileRecords() (int, error) {
+	return db.files, nil
+}
+
+// CountActivePeers returns the fixed totals of active seeders and leechers
+func (db activePeersDB) CountActivePeers() (int, int, error) {
+	return db.seeders, db.leechers, nil
+}
+
+// CountFileRecordActivePeers fails, as totals must not be counted per file
+func (db activePeersDB) CountFileRecordActivePeers(id int) (int, int, error) {
+	return 0, 0, errors.New("peers counted for individual file")
+}
+
+// TestFileRecordRepositoryPeerCounts verifies that peers on all files are counted using a single
+// aggregate query, rather than once per file
+func TestFileRecordRepositoryPeerCounts(t *testing.T) {
+	log.Println("TestFileRecordRepositoryPeerCounts()")
+
+	// Serve fixed totals, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = func() (dbModel, error) {
+		return activePeersDB{files: 3, seeders: 5, leechers: 7}, nil
+	}
+	common.Static.Config.Redis.Enabled = false
+
+	files, err := new(FileRecordRepository).Count()
+	if err != nil || files != 3 {
+		t.Fatalf("Count(), expected 3, got %d, %v", files, err)
+	}
+
+	seeders, leechers, err := new(FileRecordRepository).PeerCounts()
+	if err != nil || seeders != 5 || leechers != 7 {
+		t.Fatalf("PeerCounts(), expected 5 seeders and 7 leechers, got %d, %d, %v", seeders, leechers, err)
+	}
+}
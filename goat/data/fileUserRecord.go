@@ -1,17 +1,66 @@
 package data
 
+import (
+	"fmt"
+	"net"
+)
+
 // FileUserRecord represents a file tracked by tracker
 type FileUserRecord struct {
-	FileID     int    `db:"file_id" json:"fileId"`
-	UserID     int    `db:"user_id" json:"userId"`
-	IP         string `json:"ip"`
-	Active     bool   `json:"active"`
-	Completed  bool   `json:"completed"`
-	Announced  int    `json:"announced"`
-	Uploaded   int64  `json:"uploaded"`
-	Downloaded int64  `json:"downloaded"`
-	Left       int64  `json:"left"`
-	Time       int64  `json:"time"`
+	FileID        int    `db:"file_id" json:"fileId"`
+	UserID        int    `db:"user_id" json:"userId"`
+	IP            string `json:"ip"`
+	Active        bool   `json:"active"`
+	Completed     bool   `json:"completed"`
+	Announced     int    `json:"announced"`
+	Uploaded      int64  `json:"uploaded"`
+	Downloaded    int64  `json:"downloaded"`
+	Left          int64  `json:"left"`
+	Time          int64  `json:"time"`
+	LastEvent     string `db:"last_event" json:"lastEvent"`
+	LastEventTime int64  `db:"last_event_time" json:"lastEventTime"`
+	Snatched      bool   `json:"snatched"`
+	PeerID        string `db:"peer_id" json:"peerId"`
+	Key           string `db:"peer_key" json:"key"`
+}
+
+// peerIdentity returns a key identifying the client behind this record.  A dual-stack client announces
+// from both an IPv4 and IPv6 address with the same peer_id, so it is identified by user and peer_id, and
+// counted once in swarm totals.  Records with no known peer_id are identified by user and IP.
+func (f FileUserRecord) peerIdentity() string {
+	if f.PeerID == "" {
+		return fmt.Sprintf("%d/%s", f.UserID, f.IP)
+	}
+
+	return fmt.Sprintf("%d/%s", f.UserID, f.PeerID)
+}
+
+// RecordEvent stores the last event reported by this peer, and the time it was reported.
+// Announces with no event are periodic updates, and are recorded as "update".
+func (f *FileUserRecord) RecordEvent(event string, now int64) {
+	if event == "" {
+		event = "update"
+	}
+
+	f.LastEvent = event
+	f.LastEventTime = now
+}
+
+// ImplausibleRate reports whether the uploaded and downloaded totals reported by a peer at time now
+// have increased faster than maxRate bytes per second since this record's last announce.  Records
+// which have never announced cannot be checked, and are always plausible.
+func (f FileUserRecord) ImplausibleRate(uploaded int64, downloaded int64, now int64, maxRate int64) bool {
+	if f.Time == 0 || maxRate <= 0 {
+		return false
+	}
+
+	// Allow at least one second, so announces in quick succession are not flagged for tiny deltas
+	elapsed := now - f.Time
+	if elapsed < 1 {
+		elapsed = 1
+	}
+
+	return uploaded-f.Uploaded > maxRate*elapsed || downloaded-f.Downloaded > maxRate*elapsed
 }
 
 // FileUserRecordRepository is used to contain methods to load multiple FileRecord structs
@@ -61,6 +110,73 @@ func (f FileUserRecord) Load(fileID int, userID int, ip string) (FileUserRecord,
 	return f, nil
 }
 
+// LoadPeer loads the FileUserRecord for a peer announcing from the specified IP.  Clients may send a
+// random key which stays the same when their IP changes, so if no record exists at this IP, the record
+// stored with that key is used, and a peer behind a changing NAT is matched to its existing record.
+// Dual-stack clients send the same key over IPv4 and IPv6, so a keyed record is only matched within
+// the same address family, and each family keeps its own record.
+func (f FileUserRecord) LoadPeer(fileID int, userID int, ip string, key string) (FileUserRecord, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return FileUserRecord{}, err
+	}
+
+	// Load FileUserRecord using file ID, user ID, IP triple, falling back to key
+	f, err = db.LoadFileUserRecord(fileID, userID, ip)
+	if err == ErrNotFound && key != "" {
+		var records []FileUserRecord
+		if records, err = db.LoadFileUserRecordsKey(fileID, userID, key); err == nil {
+			// Use the most recently updated record in the same address family
+			err = ErrNotFound
+			for _, r := range records {
+				if sameAddressFamily(r.IP, ip) {
+					f, err = r, nil
+					break
+				}
+			}
+		}
+	}
+
+	// Close database connection
+	if err2 := db.Close(); err2 != nil && err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		return FileUserRecord{}, err
+	}
+
+	return f, nil
+}
+
+// sameAddressFamily reports whether two IP addresses are both IPv4, or both IPv6
+func sameAddressFamily(a string, b string) bool {
+	return (net.ParseIP(a).To4() == nil) == (net.ParseIP(b).To4() == nil)
+}
+
+// Move changes the IP of the stored FileUserRecord for this file and user at the specified IP, to
+// the IP of this record, replacing any record already stored at this record's IP
+func (f FileUserRecord) Move(ip string) error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Move FileUserRecord
+	if err := withRetry(func() error { return db.MoveFileUserRecord(f.FileID, f.UserID, ip, f.IP) }); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Save FileUserRecord to storage
 func (f FileUserRecord) Save() error {
 	// Open database connection
@@ -70,7 +186,7 @@ func (f FileUserRecord) Save() error {
 	}
 
 	// Save FileUserRecord
-	if err := db.SaveFileUserRecord(f); err != nil {
+	if err := withRetry(func() error { return db.SaveFileUserRecord(f) }); err != nil {
 		return err
 	}
 
@@ -86,6 +202,11 @@ func (f FileUserRecord) Save() error {
 func (f FileUserRecordRepository) Select(id interface{}, col string) ([]FileUserRecord, error) {
 	fileUsers := make([]FileUserRecord, 0)
 
+	// Reject columns which may not be used to load records
+	if err := checkColumn("files_users", col); err != nil {
+		return fileUsers, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -2,6 +2,7 @@ package data
 
 import (
 	"log"
+	"sort"
 	"testing"
 	"time"
 
@@ -13,7 +14,7 @@ func TestFileUserRecord(t *testing.T) {
 	log.Println("TestFileUserRecord()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -32,6 +33,7 @@ func TestFileUserRecord(t *testing.T) {
 		Left:       0,
 		Time:       time.Now().Unix(),
 	}
+	fileUser.RecordEvent("started", fileUser.Time)
 
 	// Save mock fileUser
 	if err := fileUser.Save(); err != nil {
@@ -44,8 +46,169 @@ func TestFileUserRecord(t *testing.T) {
 		t.Fatalf("Failed to load mock fileUser: %s", err.Error())
 	}
 
+	// Verify last event was saved
+	if fileUser.LastEvent != "started" {
+		t.Fatalf("Unexpected last event: %s", fileUser.LastEvent)
+	}
+
 	// Delete mock fileUser
 	if err := fileUser.Delete(); err != nil {
 		t.Fatalf("Failed to delete mock fileUser: %s", err.Error())
 	}
 }
+
+// lastEventTests contains peer events, in order, and the expected last event after each
+var lastEventTests = []struct {
+	event     string
+	lastEvent string
+}{
+	{"started", "started"},
+	{"", "update"},
+	{"completed", "completed"},
+	{"", "update"},
+	{"stopped", "stopped"},
+}
+
+// TestFileUserRecordLastEvent verifies that a peer's last event is updated across its lifecycle
+func TestFileUserRecordLastEvent(t *testing.T) {
+	log.Println("TestFileUserRecordLastEvent()")
+
+	fileUser := FileUserRecord{}
+	for i, test := range lastEventTests {
+		now := int64(1000 + i)
+		fileUser.RecordEvent(test.event, now)
+
+		if fileUser.LastEvent != test.lastEvent || fileUser.LastEventTime != now {
+			t.Fatalf("RecordEvent(%q), expected %s at %d, got %s at %d", test.event, test.lastEvent, now,
+				fileUser.LastEvent, fileUser.LastEventTime)
+		}
+	}
+}
+
+// implausibleRateTests contains reported uploaded and downloaded totals and announce times, checked against a
+// record which last announced at time 1000 with 1 MB uploaded and downloaded, and a maximum rate of 1 MB/s
+var implausibleRateTests = []struct {
+	uploaded   int64
+	downloaded int64
+	now        int64
+	flagged    bool
+}{
+	// Plausible increases
+	{1000000, 1000000, 1010, false},
+	{11000000, 1000000, 1010, false},
+	{1000000, 11000000, 1010, false},
+	{2000000, 2000000, 1000, false},
+	// Impossibly fast increases
+	{11000001, 1000000, 1010, true},
+	{1000000, 11000001, 1010, true},
+	{1000000000000, 1000000, 4600, true},
+	{3000000, 1000000, 1000, true},
+}
+
+// TestFileUserRecordImplausibleRate verifies that statistics increasing faster than the maximum rate are flagged
+func TestFileUserRecordImplausibleRate(t *testing.T) {
+	log.Println("TestFileUserRecordImplausibleRate()")
+
+	fileUser := FileUserRecord{Uploaded: 1000000, Downloaded: 1000000, Time: 1000}
+
+	for _, test := range implausibleRateTests {
+		if flagged := fileUser.ImplausibleRate(test.uploaded, test.downloaded, test.now, 1000000); flagged != test.flagged {
+			t.Fatalf("ImplausibleRate(%d, %d, %d), expected %t, got %t", test.uploaded, test.downloaded, test.now, test.flagged, flagged)
+		}
+	}
+
+	// Verify first announce is never flagged
+	if (FileUserRecord{}).ImplausibleRate(1000000000000, 0, 1000, 1000000) {
+		t.Fatalf("First announce flagged as implausible")
+	}
+}
+
+// peerDB is a database backend which stores FileUserRecords by IP, for a single file and user
+type peerDB struct {
+	dbModel
+	peers map[string]FileUserRecord
+}
+
+// Close does nothing, as there is no connection
+func (db peerDB) Close() error {
+	return nil
+}
+
+// LoadFileUserRecord returns the record stored at an IP
+func (db peerDB) LoadFileUserRecord(fid, uid int, ip string) (FileUserRecord, error) {
+	if f, ok := db.peers[ip]; ok {
+		return f, nil
+	}
+
+	return FileUserRecord{}, ErrNotFound
+}
+
+// LoadFileUserRecordsKey returns the records stored with a key, most recently updated first
+func (db peerDB) LoadFileUserRecordsKey(fid, uid int, key string) ([]FileUserRecord, error) {
+	records := []FileUserRecord{}
+	for _, f := range db.peers {
+		if f.Key == key {
+			records = append(records, f)
+		}
+	}
+
+	sort.Sort(sort.Reverse(fileUserRecordsByTime(records)))
+	return records, nil
+}
+
+// fileUserRecordsByTime sorts FileUserRecords by the time they were last updated
+type fileUserRecordsByTime []FileUserRecord
+
+func (s fileUserRecordsByTime) Len() int           { return len(s) }
+func (s fileUserRecordsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s fileUserRecordsByTime) Less(i, j int) bool { return s[i].Time < s[j].Time }
+
+// loadPeerTests contains announcing IPs and keys, and the IP of the record which should be loaded,
+// checked against records for a dual-stack peer with key "abcd"
+var loadPeerTests = []struct {
+	ip  string
+	key string
+	out string
+}{
+	// Records at the current IP are preferred over the most recent keyed record
+	{"192.0.2.1", "abcd", "192.0.2.1"},
+	{"2001:db8::1", "abcd", "2001:db8::1"},
+	// Changed IPs are matched by key within the same address family
+	{"192.0.2.2", "abcd", "192.0.2.1"},
+	{"2001:db8::2", "abcd", "2001:db8::1"},
+	// Unknown keys and IPs are new peers
+	{"192.0.2.2", "efgh", ""},
+	{"192.0.2.2", "", ""},
+}
+
+// TestFileUserRecordLoadPeer verifies that a dual-stack peer's records are matched by IP first, and
+// by key only within the same address family, so they are not moved between families
+func TestFileUserRecordLoadPeer(t *testing.T) {
+	log.Println("TestFileUserRecordLoadPeer()")
+
+	// Serve dual-stack peer records, with IPv6 updated most recently, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = func() (dbModel, error) {
+		return peerDB{peers: map[string]FileUserRecord{
+			"192.0.2.1":   {IP: "192.0.2.1", Key: "abcd", Time: 1000},
+			"2001:db8::1": {IP: "2001:db8::1", Key: "abcd", Time: 2000},
+		}}, nil
+	}
+
+	for _, test := range loadPeerTests {
+		f, err := new(FileUserRecord).LoadPeer(1, 1, test.ip, test.key)
+		if test.out == "" {
+			if err != ErrNotFound {
+				t.Fatalf("LoadPeer(%s, %s), expected ErrNotFound, got: %+v %v", test.ip, test.key, f, err)
+			}
+			continue
+		}
+
+		if err != nil || f.IP != test.out {
+			t.Fatalf("LoadPeer(%s, %s), expected record at %s, got: %+v %v", test.ip, test.key, test.out, f, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Migration represents an ordered change to the database schema, applied once per database
+type Migration struct {
+	Version     int
+	Description string
+	MySQL       string
+	QL          string
+
+	// Func, if set, is run before the migration's SQL, for changes which cannot be expressed in
+	// SQL on every backend.  It must be safe to run again if the migration fails.
+	Func func(dbModel) error
+}
+
+// migrationsByVersion sorts a slice of migrations by version
+type migrationsByVersion []Migration
+
+func (m migrationsByVersion) Len() int           { return len(m) }
+func (m migrationsByVersion) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m migrationsByVersion) Less(i, j int) bool { return m[i].Version < m[j].Version }
+
+// migrations contains all schema migrations, which are applied on top of the schema in 'res/'
+// note: migrations must never be edited or removed once released, only appended
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "add admin flag to users",
+		MySQL:       "ALTER TABLE users ADD `admin` tinyint(1) NOT NULL DEFAULT 0;",
+		QL:          "ALTER TABLE users ADD admin bool;",
+	},
+	{
+		Version:     2,
+		Description: "add banned flag to users",
+		MySQL:       "ALTER TABLE users ADD `banned` tinyint(1) NOT NULL DEFAULT 0;",
+		QL:          "ALTER TABLE users ADD banned bool;",
+	},
+	{
+		Version:     3,
+		Description: "index active peers on files_users",
+		MySQL:       "ALTER TABLE files_users ADD INDEX `file_active` (`file_id`, `active`);",
+		QL:          "CREATE INDEX IF NOT EXISTS files_users_file_id ON files_users (file_id);",
+	},
+	{
+		Version:     4,
+		Description: "add last event tracking to files_users",
+		MySQL:       "ALTER TABLE files_users ADD `last_event` varchar(16) NOT NULL DEFAULT '', ADD `last_event_time` int(11) NOT NULL DEFAULT 0;",
+		QL:          "ALTER TABLE files_users ADD last_event string; ALTER TABLE files_users ADD last_event_time int64;",
+	},
+	{
+		Version:     5,
+		Description: "add snatched flag to files_users, which is never cleared once a user completes",
+		MySQL:       "ALTER TABLE files_users ADD `snatched` tinyint(1) NOT NULL DEFAULT 0;",
+		QL:          "ALTER TABLE files_users ADD snatched bool;",
+	},
+	{
+		Version:     6,
+		Description: "mark completed files_users as snatched",
+		MySQL:       "UPDATE files_users SET `snatched` = 1 WHERE `completed` = 1 AND `left` = 0;",
+		QL:          "UPDATE files_users snatched=true WHERE completed==true && left==0;",
+	},
+	{
+		Version:     7,
+		Description: "add per-file announce interval and peer limit overrides to files",
+		MySQL:       "ALTER TABLE files ADD `announce_interval` int(11) NOT NULL DEFAULT 0, ADD `peer_limit` int(11) NOT NULL DEFAULT 0;",
+		QL:          "ALTER TABLE files ADD announce_interval int64; ALTER TABLE files ADD peer_limit int64;",
+	},
+	{
+		Version:     8,
+		Description: "add peer ID to announce_log, and index announces by file and time",
+		MySQL:       "ALTER TABLE announce_log ADD `peer_id` varchar(40) NOT NULL DEFAULT '', ADD INDEX `info_hash_time` (`info_hash`, `time`);",
+		QL:          "ALTER TABLE announce_log ADD peer_id string; CREATE INDEX IF NOT EXISTS announce_log_info_hash ON announce_log (info_hash);",
+	},
+	{
+		Version:     9,
+		Description: "add passkeys table, storing additional passkeys issued to users",
+		MySQL: "CREATE TABLE IF NOT EXISTS passkeys (`id` int(11) NOT NULL AUTO_INCREMENT, `user_id` int(11) NOT NULL, " +
+			"`passkey` char(40) NOT NULL, `create_time` int(11) NOT NULL, PRIMARY KEY (`id`), UNIQUE KEY (`passkey`), " +
+			"KEY `user_id` (`user_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;",
+		QL: "CREATE TABLE IF NOT EXISTS passkeys (user_id int64, passkey string, create_time int64); " +
+			"CREATE INDEX IF NOT EXISTS passkeys_passkey ON passkeys (passkey);",
+	},
+	{
+		Version:     10,
+		Description: "add peer ID to files_users, and widen IP to store IPv6 addresses",
+		MySQL:       "ALTER TABLE files_users ADD `peer_id` varchar(40) NOT NULL DEFAULT '', MODIFY `ip` varchar(45) NOT NULL;",
+		QL:          "ALTER TABLE files_users ADD peer_id string;",
+	},
+	{
+		Version:     11,
+		Description: "add bans table, storing bans on IP addresses and users",
+		MySQL: "CREATE TABLE IF NOT EXISTS bans (`id` int(11) NOT NULL AUTO_INCREMENT, `ip` varchar(45) NOT NULL DEFAULT '', " +
+			"`user_id` int(11) NOT NULL DEFAULT 0, `reason` varchar(255) NOT NULL DEFAULT '', `create_time` int(11) NOT NULL, " +
+			"`expire` int(11) NOT NULL DEFAULT 0, PRIMARY KEY (`id`), KEY `ip` (`ip`), KEY `user_id` (`user_id`)) " +
+			"ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;",
+		QL: "CREATE TABLE IF NOT EXISTS bans (ip string, user_id int64, reason string, create_time int64, expire int64); " +
+			"CREATE INDEX IF NOT EXISTS bans_ip ON bans (ip);",
+	},
+	{
+		Version:     12,
+		Description: "add user ID to scrape_log",
+		MySQL:       "ALTER TABLE scrape_log ADD `user_id` int(11) NOT NULL DEFAULT 0, ADD KEY `user_id` (`user_id`);",
+		QL:          "ALTER TABLE scrape_log ADD user_id int64;",
+	},
+	{
+		Version:     13,
+		Description: "add announce key to files_users, identifying peers across IP changes",
+		MySQL:       "ALTER TABLE files_users ADD `peer_key` varchar(40) NOT NULL DEFAULT '', ADD KEY `file_user_key` (`file_id`, `user_id`, `peer_key`);",
+		QL:          "ALTER TABLE files_users ADD peer_key string;",
+	},
+	{
+		Version:     14,
+		Description: "normalize existing usernames, which are now compared without case",
+		Func:        normalizeUsernames,
+	},
+	{
+		Version:     15,
+		Description: "widen announce_log IP to store IPv6 addresses",
+		MySQL:       "ALTER TABLE announce_log MODIFY `ip` varchar(45) NOT NULL;",
+	},
+	{
+		Version:     16,
+		Description: "widen scrape_log IP to store IPv6 addresses",
+		MySQL:       "ALTER TABLE scrape_log MODIFY `ip` varchar(45) NOT NULL;",
+	},
+}
+
+// normalizeUsernames normalizes the usernames of users created before usernames were normalized on
+// save, so they can still log in.  If two usernames differ only by case, no usernames are changed, and
+// one of the users must be renamed by an administrator before the migration can be applied.
+func normalizeUsernames(db dbModel) error {
+	users, err := db.GetAllUserRecords()
+	if err != nil {
+		return err
+	}
+
+	// Check for collisions before renaming any users
+	names := map[string]string{}
+	for _, u := range users {
+		name := NormalizeUsername(u.Username)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("usernames %q and %q differ only by case, and one must be renamed", other, u.Username)
+		}
+
+		names[name] = u.Username
+	}
+
+	// Rename users whose usernames are not normalized
+	for _, u := range users {
+		if name := NormalizeUsername(u.Username); name != u.Username {
+			if err := db.RenameUserRecord(u.ID, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Migrate applies all pending schema migrations in order, returning the number applied
+func Migrate() (int, error) {
+	return migrate(migrations)
+}
+
+// migrate applies any migrations from the input list which have not yet been applied, in order
+func migrate(list []Migration) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Load versions which have already been applied
+	versions, err := db.LoadSchemaMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := map[int]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	// Sort a copy of migrations, so they are always applied in order
+	pending := make([]Migration, len(list))
+	copy(pending, list)
+	sort.Sort(migrationsByVersion(pending))
+
+	// Apply all pending migrations, stopping on the first failure
+	count := 0
+	for _, m := range pending {
+		if applied[m.Version] {
+			continue
+		}
+
+		if m.Func != nil {
+			if err := m.Func(db); err != nil {
+				return count, fmt.Errorf("migration %d (%s): %s", m.Version, m.Description, err.Error())
+			}
+		}
+
+		if err := db.ApplyMigration(m); err != nil {
+			return count, err
+		}
+
+		applied[m.Version] = true
+		count++
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestMigrate verifies that migrations are applied once, and skipped on subsequent runs
+func TestMigrate(t *testing.T) {
+	log.Println("TestMigrate()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Apply all real migrations, so the schema is current
+	if _, err := Migrate(); err != nil {
+		t.Fatalf("Failed to apply migrations: %s", err.Error())
+	}
+
+	// Generate mock migration, using a version which will never be used by a real migration
+	mock := []Migration{
+		{
+			Version:     1000000,
+			Description: "test migration",
+			MySQL:       "CREATE TABLE IF NOT EXISTS migration_test (`id` int(11) NOT NULL);",
+			QL:          "CREATE TABLE IF NOT EXISTS migration_test (id int64);",
+		},
+	}
+
+	// Verify mock migration is applied once
+	count, err := migrate(mock)
+	if err != nil {
+		t.Fatalf("Failed to apply mock migration: %s", err.Error())
+	}
+
+	if count != 1 {
+		t.Fatalf("Applied migrations, expected 1, got %d", count)
+	}
+
+	// Verify mock migration is skipped when run again
+	count, err = migrate(mock)
+	if err != nil {
+		t.Fatalf("Failed to apply mock migration: %s", err.Error())
+	}
+
+	if count != 0 {
+		t.Fatalf("Applied migrations, expected 0, got %d", count)
+	}
+
+	// Remove mock migration version, so test may be run again
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+
+	if err := db.DeleteSchemaMigration(mock[0].Version); err != nil {
+		t.Fatalf("Failed to delete mock migration: %s", err.Error())
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %s", err.Error())
+	}
+}
+
+// usernameDB is a database backend storing users in memory, used to verify username normalization
+type usernameDB struct {
+	dbModel
+	users []UserRecord
+}
+
+// GetAllUserRecords returns all users
+func (db *usernameDB) GetAllUserRecords() ([]UserRecord, error) {
+	return db.users, nil
+}
+
+// RenameUserRecord renames the user with the specified ID
+func (db *usernameDB) RenameUserRecord(id int, username string) error {
+	for i := range db.users {
+		if db.users[i].ID == id {
+			db.users[i].Username = username
+		}
+	}
+
+	return nil
+}
+
+// TestNormalizeUsernames verifies that existing usernames are normalized, and that no usernames are
+// changed if two differ only by case
+func TestNormalizeUsernames(t *testing.T) {
+	log.Println("TestNormalizeUsernames()")
+
+	// Verify mixed case usernames are normalized
+	db := &usernameDB{users: []UserRecord{{ID: 1, Username: "Alice"}, {ID: 2, Username: "bob"}, {ID: 3, Username: " Carol"}}}
+	if err := normalizeUsernames(db); err != nil {
+		t.Fatalf("Failed to normalize usernames: %s", err.Error())
+	}
+
+	for i, name := range []string{"alice", "bob", "carol"} {
+		if db.users[i].Username != name {
+			t.Fatalf("Normalized username, expected %q, got %q", name, db.users[i].Username)
+		}
+	}
+
+	// Verify colliding usernames fail the migration, without renaming any users
+	db = &usernameDB{users: []UserRecord{{ID: 1, Username: "Dave"}, {ID: 2, Username: "Alice"}, {ID: 3, Username: "alice"}}}
+	if err := normalizeUsernames(db); err == nil {
+		t.Fatalf("Colliding usernames were normalized")
+	}
+
+	if db.users[0].Username != "Dave" || db.users[1].Username != "Alice" {
+		t.Fatalf("Usernames were renamed despite a collision: %+v", db.users)
+	}
+}
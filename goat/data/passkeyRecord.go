@@ -0,0 +1,174 @@
+package data
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// PasskeyRecord represents an additional passkey issued to a user, so a user may announce from
+// several devices using separate passkeys, any of which may be revoked independently
+type PasskeyRecord struct {
+	ID         int
+	UserID     int `db:"user_id"`
+	Passkey    string
+	CreateTime int64 `db:"create_time"`
+}
+
+// PasskeyRecordRepository is used to contain methods to load multiple PasskeyRecord structs
+type PasskeyRecordRepository struct {
+}
+
+// JSONPasskeyRecord represents output PasskeyRecord JSON for API
+type JSONPasskeyRecord struct {
+	ID         int    `json:"id"`
+	Passkey    string `json:"passkey"`
+	CreateTime int64  `json:"createTime"`
+}
+
+// ToJSON converts a PasskeyRecord to a JSONPasskeyRecord struct
+func (p PasskeyRecord) ToJSON() (JSONPasskeyRecord, error) {
+	j := JSONPasskeyRecord{}
+	j.ID = p.ID
+	j.Passkey = p.Passkey
+	j.CreateTime = p.CreateTime
+
+	return j, nil
+}
+
+// Create a new PasskeyRecord for the user with the specified ID
+func (p *PasskeyRecord) Create(userID int) error {
+	p.UserID = userID
+
+	// Randomly generate a new passkey
+	sha := sha1.New()
+	if _, err := sha.Write([]byte(common.RandString())); err != nil {
+		return err
+	}
+	p.Passkey = fmt.Sprintf("%x", sha.Sum(nil))
+
+	p.CreateTime = common.Now().Unix()
+
+	return nil
+}
+
+// Delete PasskeyRecord from storage, revoking the passkey
+func (p PasskeyRecord) Delete() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete PasskeyRecord
+	if err = db.DeletePasskeyRecord(p.Passkey, "passkey"); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Load PasskeyRecord from storage
+func (p PasskeyRecord) Load(id interface{}, col string) (PasskeyRecord, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("passkeys", col); err != nil {
+		return PasskeyRecord{}, err
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return PasskeyRecord{}, err
+	}
+
+	// Load PasskeyRecord using specified column
+	if p, err = db.LoadPasskeyRecord(id, col); err != nil {
+		return PasskeyRecord{}, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return PasskeyRecord{}, err
+	}
+
+	return p, nil
+}
+
+// Save PasskeyRecord to storage
+func (p PasskeyRecord) Save() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save PasskeyRecord
+	if err := withRetry(func() error { return db.SavePasskeyRecord(p) }); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Select loads selected PasskeyRecord structs from storage
+func (p PasskeyRecordRepository) Select(id interface{}, col string) ([]PasskeyRecord, error) {
+	passkeys := make([]PasskeyRecord, 0)
+
+	// Reject columns which may not be used to load records
+	if err := checkColumn("passkeys", col); err != nil {
+		return passkeys, err
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return passkeys, err
+	}
+
+	// Retrieve passkeys
+	passkeys, err = db.LoadPasskeyRepository(id, col)
+	if err != nil {
+		return passkeys, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return passkeys, err
+	}
+
+	return passkeys, nil
+}
+
+// Passkeys loads all additional passkeys issued to this user
+func (u UserRecord) Passkeys() ([]PasskeyRecord, error) {
+	return new(PasskeyRecordRepository).Select(u.ID, "user_id")
+}
+
+// User loads the user to whom an additional passkey was issued.  ErrNotFound is returned if the
+// passkey has not been issued, or has been revoked.  Passkeys are verified using a constant-time
+// comparison, so a passkey cannot be discovered by response time.
+func (p PasskeyRecord) User(passkey string) (UserRecord, error) {
+	// Load PasskeyRecord by passkey
+	record, err := p.Load(passkey, "passkey")
+	if err != nil {
+		return UserRecord{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(record.Passkey), []byte(passkey)) != 1 {
+		return UserRecord{}, ErrNotFound
+	}
+
+	// Load user who owns this passkey
+	return new(UserRecord).Load(record.UserID, "id")
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestPasskeyRecord verifies that multiple passkeys resolve to the same user, and that a revoked
+// passkey no longer resolves while others are unaffected
+func TestPasskeyRecord(t *testing.T) {
+	log.Println("TestPasskeyRecord()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Create and save mock user
+	user := new(UserRecord)
+	if err := user.Create("passkeys", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	// Load user to get ID
+	user2, err := user.Load("passkeys", "username")
+	if err != nil || user2 == (UserRecord{}) {
+		t.Fatalf("Failed to load mock user: %v", err)
+	}
+
+	// Issue two passkeys to the user
+	passkeys := make([]PasskeyRecord, 2)
+	for i := range passkeys {
+		if err := passkeys[i].Create(user2.ID); err != nil {
+			t.Fatalf("Failed to create passkey: %s", err.Error())
+		}
+		if len(passkeys[i].Passkey) != 40 {
+			t.Fatalf("Passkey is %d characters, expected 40", len(passkeys[i].Passkey))
+		}
+		if err := passkeys[i].Save(); err != nil {
+			t.Fatalf("Failed to save passkey: %s", err.Error())
+		}
+	}
+	if passkeys[0].Passkey == passkeys[1].Passkey {
+		t.Fatalf("Passkeys are not unique: %s", passkeys[0].Passkey)
+	}
+
+	// Verify both passkeys resolve to the same user
+	for _, p := range passkeys {
+		owner, err := new(PasskeyRecord).User(p.Passkey)
+		if err != nil {
+			t.Fatalf("Failed to resolve passkey: %s", err.Error())
+		}
+		if owner.ID != user2.ID {
+			t.Fatalf("Passkey %s, expected user %d, got %d", p.Passkey, user2.ID, owner.ID)
+		}
+	}
+
+	// Verify both passkeys are listed for the user
+	list, err := user2.Passkeys()
+	if err != nil {
+		t.Fatalf("Failed to load user passkeys: %s", err.Error())
+	}
+	if len(list) != len(passkeys) {
+		t.Fatalf("Mismatched passkey count, expected %d, got %d", len(passkeys), len(list))
+	}
+
+	// Revoke the first passkey
+	if err := passkeys[0].Delete(); err != nil {
+		t.Fatalf("Failed to revoke passkey: %s", err.Error())
+	}
+
+	// Verify revoked passkey no longer resolves
+	if owner, err := new(PasskeyRecord).User(passkeys[0].Passkey); err != ErrNotFound || owner != (UserRecord{}) {
+		t.Fatalf("Revoked passkey resolved to user: %+v %v", owner, err)
+	}
+
+	// Verify remaining passkey still resolves
+	if owner, err := new(PasskeyRecord).User(passkeys[1].Passkey); err != nil || owner.ID != user2.ID {
+		t.Fatalf("Remaining passkey failed to resolve: %+v %v", owner, err)
+	}
+
+	// Delete mock data
+	if err := passkeys[1].Delete(); err != nil {
+		t.Fatalf("Failed to delete passkey: %s", err.Error())
+	}
+	if err := user2.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
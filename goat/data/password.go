@@ -0,0 +1,182 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"code.google.com/p/go.crypto/bcrypt"
+	"code.google.com/p/go.crypto/scrypt"
+	"github.com/mdlayher/goat/goat/common"
+)
+
+const (
+	// defaultBcryptCost is the bcrypt cost used to hash passwords, if none is configured
+	defaultBcryptCost = 12
+
+	// defaultPasswordMinLength is the minimum password length, if none is configured
+	defaultPasswordMinLength = 8
+
+	// scryptPrefix identifies a password hash generated using scrypt
+	scryptPrefix = "$scrypt$"
+
+	// scryptLogN, scryptR, and scryptP are the scrypt parameters used to hash passwords
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+
+	// scryptSaltLength and scryptKeyLength are the number of bytes of salt and derived key
+	// stored in a scrypt hash, chosen so the encoded hash fits the 60 character password
+	// column, which is sized for bcrypt
+	scryptSaltLength = 12
+	scryptKeyLength  = 21
+)
+
+var (
+	// ErrPasswordMismatch is returned when a password does not match a password hash
+	ErrPasswordMismatch = errors.New("password does not match hash")
+
+	// ErrPasswordHash is returned when the algorithm of a password hash cannot be determined
+	ErrPasswordHash = errors.New("unrecognized password hash format")
+)
+
+// ValidatePassword verifies that a password is at least the configured minimum length
+func ValidatePassword(password string) error {
+	min := common.Static.Config.Users.PasswordMinLength
+	if min <= 0 {
+		min = defaultPasswordMinLength
+	}
+
+	if utf8.RuneCountInString(password) < min {
+		return fmt.Errorf("password must be at least %d characters", min)
+	}
+
+	return nil
+}
+
+// passwordAlgorithm returns the configured password hashing algorithm, defaulting to bcrypt
+func passwordAlgorithm() string {
+	if common.Static.Config.Users.PasswordAlgorithm == "scrypt" {
+		return "scrypt"
+	}
+
+	return "bcrypt"
+}
+
+// bcryptCost returns the configured bcrypt cost, or the default cost if none is configured
+func bcryptCost() int {
+	cost := common.Static.Config.Users.BcryptCost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return defaultBcryptCost
+	}
+
+	return cost
+}
+
+// HashPassword generates a password hash using the configured algorithm and cost
+func HashPassword(password string) (string, error) {
+	if passwordAlgorithm() == "scrypt" {
+		return scryptHash(password)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+	return string(hash), err
+}
+
+// ComparePassword compares a password with a password hash, detecting the hashing algorithm from the
+// hash's prefix, so hashes generated by any supported algorithm may be verified.  Comparison is
+// constant-time for all algorithms.
+func ComparePassword(hash string, password string) error {
+	// scrypt hash
+	if strings.HasPrefix(hash, scryptPrefix) {
+		return scryptCompare(hash, password)
+	}
+
+	// bcrypt hash, in any of its variants
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrPasswordMismatch
+		}
+
+		return err
+	}
+
+	return ErrPasswordHash
+}
+
+// PasswordNeedsRehash reports whether a password hash was generated using an algorithm or parameters
+// other than those currently configured, and should be regenerated on next successful login
+func PasswordNeedsRehash(hash string) bool {
+	// scrypt hash, which is outdated if scrypt is not configured, or if generated with other parameters
+	if strings.HasPrefix(hash, scryptPrefix) {
+		params := fmt.Sprintf("%s%02x%02x%02x$", scryptPrefix, scryptLogN, scryptR, scryptP)
+		return passwordAlgorithm() != "scrypt" || !strings.HasPrefix(hash, params)
+	}
+
+	// bcrypt hash, which is outdated if bcrypt is not configured, or if generated with another cost
+	if passwordAlgorithm() != "bcrypt" {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != bcryptCost()
+}
+
+// scryptHash generates a scrypt password hash with a random salt, in the form:
+// $scrypt$<log2(N)><r><p>$<salt>$<key>, with parameters encoded as two hex digits each
+func scryptHash(password string) (string, error) {
+	salt := make([]byte, scryptSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return scryptEncode(password, salt, scryptLogN, scryptR, scryptP)
+}
+
+// scryptEncode derives a scrypt key from a password and salt, and encodes it with its parameters
+func scryptEncode(password string, salt []byte, logN int, r int, p int) (string, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, scryptKeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%02x%02x%02x$%s$%s", scryptPrefix, logN, r, p,
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// scryptCompare compares a password with a scrypt password hash, using the parameters and salt
+// stored in the hash
+func scryptCompare(hash string, password string) error {
+	// Split hash into parameters, salt, and key
+	parts := strings.Split(strings.TrimPrefix(hash, scryptPrefix), "$")
+	if len(parts) != 3 {
+		return ErrPasswordHash
+	}
+
+	var logN, r, p int
+	if n, err := fmt.Sscanf(parts[0], "%02x%02x%02x", &logN, &r, &p); err != nil || n != 3 {
+		return ErrPasswordHash
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrPasswordHash
+	}
+
+	// Regenerate hash using stored parameters, and compare
+	expected, err := scryptEncode(password, salt, logN, r, p)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"log"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestPassword verifies that passwords hashed by all supported algorithms are validated correctly
+func TestPassword(t *testing.T) {
+	log.Println("TestPassword()")
+
+	// Restore configuration afterwards
+	defaultConfig := common.Static.Config
+	defer func() {
+		common.Static.Config = defaultConfig
+	}()
+
+	// Use the minimum bcrypt cost, to keep tests fast
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost
+
+	for _, algorithm := range []string{"bcrypt", "scrypt"} {
+		common.Static.Config.Users.PasswordAlgorithm = algorithm
+
+		hash, err := HashPassword("goat")
+		if err != nil {
+			t.Fatalf("Failed to hash password with %s: %s", algorithm, err.Error())
+		}
+
+		// Verify hash fits the password column
+		if len(hash) > 60 {
+			t.Fatalf("%s hash too long for password column: %d", algorithm, len(hash))
+		}
+
+		// Verify correct password is accepted
+		if err := ComparePassword(hash, "goat"); err != nil {
+			t.Fatalf("Failed to validate %s password: %s", algorithm, err.Error())
+		}
+
+		// Verify incorrect password is rejected
+		if err := ComparePassword(hash, "tMOg"); err != ErrPasswordMismatch {
+			t.Fatalf("Incorrect %s password, expected mismatch, got %v", algorithm, err)
+		}
+
+		// Verify hashes are salted
+		hash2, err := HashPassword("goat")
+		if err != nil {
+			t.Fatalf("Failed to hash password with %s: %s", algorithm, err.Error())
+		}
+		if hash == hash2 && algorithm == "scrypt" {
+			t.Fatalf("Identical %s hashes generated for the same password", algorithm)
+		}
+	}
+
+	// Verify new scrypt hashes are detected by prefix
+	common.Static.Config.Users.PasswordAlgorithm = "scrypt"
+	hash, err := HashPassword("goat")
+	if err != nil {
+		t.Fatalf("Failed to hash password with scrypt: %s", err.Error())
+	}
+	if !strings.HasPrefix(hash, scryptPrefix) {
+		t.Fatalf("scrypt hash missing prefix: %s", hash)
+	}
+
+	// Verify configured bcrypt cost is used
+	common.Static.Config.Users.PasswordAlgorithm = "bcrypt"
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost + 1
+	if hash, err = HashPassword("goat"); err != nil {
+		t.Fatalf("Failed to hash password with bcrypt: %s", err.Error())
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.MinCost+1 {
+		t.Fatalf("bcrypt cost, expected %d, got %d", bcrypt.MinCost+1, cost)
+	}
+
+	// Verify malformed and unknown hashes are rejected
+	for _, h := range []string{"", "plaintext", "$scrypt$zz$abc$def", "$scrypt$0f0801"} {
+		if err := ComparePassword(h, "goat"); err == nil {
+			t.Fatalf("Expected error for hash %q", h)
+		}
+	}
+}
+
+// TestPasswordNeedsRehash verifies that hashes generated with an outdated algorithm or cost are detected
+func TestPasswordNeedsRehash(t *testing.T) {
+	log.Println("TestPasswordNeedsRehash()")
+
+	// Restore configuration afterwards
+	defaultConfig := common.Static.Config
+	defer func() {
+		common.Static.Config = defaultConfig
+	}()
+
+	// Generate hashes using each algorithm
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost
+	common.Static.Config.Users.PasswordAlgorithm = "bcrypt"
+	bcryptHash, err := HashPassword("goat")
+	if err != nil {
+		t.Fatalf("Failed to hash password with bcrypt: %s", err.Error())
+	}
+
+	common.Static.Config.Users.PasswordAlgorithm = "scrypt"
+	scryptHash, err := HashPassword("goat")
+	if err != nil {
+		t.Fatalf("Failed to hash password with scrypt: %s", err.Error())
+	}
+
+	// Verify only the bcrypt hash is outdated when scrypt is configured
+	if !PasswordNeedsRehash(bcryptHash) || PasswordNeedsRehash(scryptHash) {
+		t.Fatalf("Incorrect rehash detection with scrypt configured")
+	}
+
+	// Verify only the scrypt hash is outdated when bcrypt is configured
+	common.Static.Config.Users.PasswordAlgorithm = "bcrypt"
+	if PasswordNeedsRehash(bcryptHash) || !PasswordNeedsRehash(scryptHash) {
+		t.Fatalf("Incorrect rehash detection with bcrypt configured")
+	}
+
+	// Verify bcrypt hash is outdated when configured cost changes
+	common.Static.Config.Users.BcryptCost = bcrypt.MinCost + 1
+	if !PasswordNeedsRehash(bcryptHash) {
+		t.Fatalf("bcrypt hash with lower cost not detected as outdated")
+	}
+}
+
+// validatePasswordTests contains passwords, and whether or not they should be valid with the default
+// minimum length
+var validatePasswordTests = []struct {
+	password string
+	valid    bool
+}{
+	{"", false},
+	{"passwor", false},
+	{"password", true},
+	{"pässwörd", true},
+	{"pässwö", false},
+}
+
+// TestValidatePassword verifies that passwords shorter than the configured minimum length are rejected
+func TestValidatePassword(t *testing.T) {
+	log.Println("TestValidatePassword()")
+
+	// Use default validation settings
+	common.Static.Config = common.Conf{}
+
+	// Iterate all tests
+	for _, test := range validatePasswordTests {
+		if err := ValidatePassword(test.password); (err == nil) != test.valid {
+			t.Fatalf("ValidatePassword(%q), expected valid %v, got error: %v", test.password, test.valid, err)
+		}
+	}
+
+	// Verify configured minimum is respected
+	common.Static.Config.Users.PasswordMinLength = 12
+	if err := ValidatePassword("password"); err == nil {
+		t.Fatalf("Password shorter than configured minimum should be invalid")
+	}
+}
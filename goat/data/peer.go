@@ -4,13 +4,22 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"hash/fnv"
+	"math/rand"
 	"net"
+	"sort"
+	"strconv"
+	"sync"
 )
 
+// peerListPool is the number of candidate peers retrieved for seeded or rotated peer selection
+const peerListPool = 1000
+
 // Peer represents an IP and port peer, used as part of the peer list
 type Peer struct {
-	IP   string
-	Port uint16
+	IP     string
+	Port   uint16
+	Seeder bool
 }
 
 // MarshalBinary creates a packed byte array from a peer
@@ -22,8 +31,13 @@ func (p Peer) MarshalBinary() ([]byte, error) {
 		return nil, nil
 	}
 
-	// IP (uint32)
-	if err := binary.Write(res, binary.BigEndian, binary.BigEndian.Uint32((net.ParseIP(p.IP).To4()))); err != nil {
+	// IP (uint32), which must be IPv4 to fit the compact format
+	ip := net.ParseIP(p.IP).To4()
+	if ip == nil {
+		return nil, errors.New("peer IP is not an IPv4 address: " + p.IP)
+	}
+
+	if err := binary.Write(res, binary.BigEndian, binary.BigEndian.Uint32(ip)); err != nil {
 		return nil, err
 	}
 
@@ -35,6 +49,66 @@ func (p Peer) MarshalBinary() ([]byte, error) {
 	return res.Bytes(), nil
 }
 
+// MarshalBinary6 creates a packed byte array from an IPv6 peer, in the 18 byte compact format
+// used for the peers6 list defined in BEP 7
+func (p Peer) MarshalBinary6() ([]byte, error) {
+	// IP (16 bytes), which must be IPv6
+	ip := net.ParseIP(p.IP)
+	if ip == nil || ip.To4() != nil {
+		return nil, errors.New("peer IP is not an IPv6 address: " + p.IP)
+	}
+
+	res := bytes.NewBuffer(make([]byte, 0, 18))
+	if _, err := res.Write(ip.To16()); err != nil {
+		return nil, err
+	}
+
+	// Port (uint16)
+	if err := binary.Write(res, binary.BigEndian, uint16(p.Port)); err != nil {
+		return nil, err
+	}
+
+	return res.Bytes(), nil
+}
+
+// appendCompactPeer appends a peer to the IPv4 or IPv6 compact peer list, according to its address.
+// Peers with malformed addresses are skipped, so a bad record cannot fail an entire peer list.
+func appendCompactPeer(peers4 []byte, peers6 []byte, peer Peer) ([]byte, []byte) {
+	ip := net.ParseIP(peer.IP)
+	if ip == nil {
+		return peers4, peers6
+	}
+
+	// IPv4 peers are 6 bytes
+	if ip4 := ip.To4(); ip4 != nil {
+		peers4 = append(peers4, ip4...)
+		return append(peers4, byte(peer.Port>>8), byte(peer.Port)), peers6
+	}
+
+	// IPv6 peers are 18 bytes
+	peers6 = append(peers6, ip.To16()...)
+	return peers4, append(peers6, byte(peer.Port>>8), byte(peer.Port))
+}
+
+// dictPeerList creates a bencoded list of peer dictionaries, as used by clients which do not request
+// a compact peer list.  Peer IDs are not stored with peers, so they are omitted, as permitted for
+// clients which set no_peer_id.  Peers with malformed addresses are skipped.
+func dictPeerList(peers []Peer) []byte {
+	out := []byte("l")
+	for _, peer := range peers {
+		ip := net.ParseIP(peer.IP)
+		if ip == nil {
+			continue
+		}
+
+		// Keys must be sorted: ip, port
+		addr := ip.String()
+		out = append(out, "d2:ip"+strconv.Itoa(len(addr))+":"+addr+"4:porti"+strconv.Itoa(int(peer.Port))+"ee"...)
+	}
+
+	return append(out, 'e')
+}
+
 // UnmarshalBinary creates a Peer from a packed byte array
 func (p *Peer) UnmarshalBinary(buf []byte) (err error) {
 	// Set up recovery function to catch a panic as an error
@@ -53,3 +127,115 @@ func (p *Peer) UnmarshalBinary(buf []byte) (err error) {
 
 	return nil
 }
+
+// peersByAddress sorts a slice of peers by IP and port
+type peersByAddress []Peer
+
+func (p peersByAddress) Len() int      { return len(p) }
+func (p peersByAddress) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p peersByAddress) Less(i, j int) bool {
+	if p[i].IP == p[j].IP {
+		return p[i].Port < p[j].Port
+	}
+
+	return p[i].IP < p[j].IP
+}
+
+// seededPeers selects up to numwant peers using a PRNG seeded by info_hash and the time window
+// containing now, so that identical swarm state yields an identical selection within a window
+func seededPeers(peers []Peer, infoHash string, numwant int, window int64, now int64) []Peer {
+	// Sort peers, so selection does not depend on the order rows were returned
+	sorted := make([]Peer, len(peers))
+	copy(sorted, peers)
+	sort.Sort(peersByAddress(sorted))
+
+	// Seed PRNG using info_hash and time window
+	if window <= 0 {
+		window = 1
+	}
+
+	seed := fnv.New64a()
+	if _, err := seed.Write([]byte(infoHash)); err != nil {
+		return sorted
+	}
+	if err := binary.Write(seed, binary.BigEndian, now/window); err != nil {
+		return sorted
+	}
+	rng := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	// Cap selection at number of peers available
+	if numwant > len(sorted) {
+		numwant = len(sorted)
+	}
+	if numwant < 0 {
+		numwant = 0
+	}
+
+	// Select peers using a seeded permutation
+	selected := make([]Peer, 0, numwant)
+	for _, i := range rng.Perm(len(sorted))[:numwant] {
+		selected = append(selected[:], sorted[i])
+	}
+
+	return selected
+}
+
+// peerRotation tracks the offset into a swarm at which each peer's next peer list begins,
+// so that consecutive announces from one peer return different slices of a large swarm
+type peerRotation struct {
+	sync.Mutex
+	offsets map[string]rotationOffset
+	pruned  int64
+}
+
+// rotationOffset stores a peer's rotation offset, and the time at which it was last used
+type rotationOffset struct {
+	offset int
+	seen   int64
+}
+
+// rotation stores peer list rotation state for all peers
+var rotation = &peerRotation{offsets: map[string]rotationOffset{}}
+
+// peers selects up to numwant peers for the peer identified by key, starting where its
+// previous selection ended, and discards state which has not been used within ttl seconds
+func (r *peerRotation) peers(key string, peers []Peer, numwant int, ttl int64, now int64) []Peer {
+	// Sort peers, so offsets refer to a stable ordering of the swarm
+	sorted := make([]Peer, len(peers))
+	copy(sorted, peers)
+	sort.Sort(peersByAddress(sorted))
+
+	// Cap selection at number of peers available
+	if numwant > len(sorted) {
+		numwant = len(sorted)
+	}
+	if numwant <= 0 {
+		return make([]Peer, 0)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// Discard stale offsets, at most once per ttl
+	if now-r.pruned >= ttl {
+		for k, v := range r.offsets {
+			if now-v.seen >= ttl {
+				delete(r.offsets, k)
+			}
+		}
+
+		r.pruned = now
+	}
+
+	// Select peers beginning at this peer's offset, wrapping around the swarm
+	start := r.offsets[key].offset % len(sorted)
+	selected := make([]Peer, 0, numwant)
+	for i := 0; i < numwant; i++ {
+		selected = append(selected[:], sorted[(start+i)%len(sorted)])
+	}
+
+	// Advance offset for the next announce
+	r.offsets[key] = rotationOffset{offset: (start + numwant) % len(sorted), seen: now}
+
+	return selected
+}
@@ -2,7 +2,12 @@ package data
 
 import (
 	"log"
+	"net"
+	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
 )
 
 // TestPeer verifies that Peer binary marshal and unmarshal work properly
@@ -31,4 +36,233 @@ func TestPeer(t *testing.T) {
 	if peer.IP != peer2.IP || peer.Port != peer2.Port {
 		t.Fatalf("Peer results do not match")
 	}
+
+	// Verify compact peers are exactly 6 bytes
+	if len(out) != 6 {
+		t.Fatalf("Compact peer length, expected 6, got %d", len(out))
+	}
+
+	// Verify IPv6 peers cannot be marshaled to compact format
+	if _, err := (Peer{IP: "::1", Port: 8080}).MarshalBinary(); err == nil {
+		t.Fatalf("Expected error marshaling IPv6 peer")
+	}
+}
+
+// TestPeerMarshalBinary6 verifies that IPv6 peers are marshaled to the 18 byte compact format
+func TestPeerMarshalBinary6(t *testing.T) {
+	log.Println("TestPeerMarshalBinary6()")
+
+	// Marshal IPv6 peer, verify address and port
+	out, err := (Peer{IP: "2001:db8::1", Port: 8080}).MarshalBinary6()
+	if err != nil {
+		t.Fatalf("Failed to marshal IPv6 peer: %s", err.Error())
+	}
+	if len(out) != 18 {
+		t.Fatalf("Compact IPv6 peer length, expected 18, got %d", len(out))
+	}
+	if ip := net.IP(out[:16]).String(); ip != "2001:db8::1" {
+		t.Fatalf("Compact IPv6 peer address, expected %s, got %s", "2001:db8::1", ip)
+	}
+	if port := int(out[16])<<8 | int(out[17]); port != 8080 {
+		t.Fatalf("Compact IPv6 peer port, expected %d, got %d", 8080, port)
+	}
+
+	// Verify IPv4 and malformed peers cannot be marshaled to IPv6 compact format
+	for _, ip := range []string{"127.0.0.1", "not an ip", ""} {
+		if _, err := (Peer{IP: ip, Port: 8080}).MarshalBinary6(); err == nil {
+			t.Fatalf("Expected error marshaling peer %q to IPv6 compact format", ip)
+		}
+	}
+}
+
+// TestAppendCompactPeer verifies that peers are split into IPv4 and IPv6 compact lists, and that
+// peers with malformed addresses are skipped
+func TestAppendCompactPeer(t *testing.T) {
+	log.Println("TestAppendCompactPeer()")
+
+	peers := []Peer{
+		{IP: "10.0.0.1", Port: 5001},
+		{IP: "2001:db8::1", Port: 5002},
+		{IP: "not an ip", Port: 5003},
+		{IP: "", Port: 5004},
+		{IP: "::ffff:10.0.0.2", Port: 5005},
+	}
+
+	peers4, peers6 := make([]byte, 0), make([]byte, 0)
+	for _, p := range peers {
+		peers4, peers6 = appendCompactPeer(peers4, peers6, p)
+	}
+
+	// IPv4-mapped IPv6 addresses are IPv4 peers
+	if len(peers4) != 12 || len(peers6) != 18 {
+		t.Fatalf("Compact peer lengths, expected 12 and 18, got %d and %d", len(peers4), len(peers6))
+	}
+
+	peer := new(Peer)
+	if err := peer.UnmarshalBinary(peers4[6:]); err != nil || peer.IP != "10.0.0.2" || peer.Port != 5005 {
+		t.Fatalf("Mismatched IPv4 peer: %+v %v", peer, err)
+	}
+}
+
+// TestDictPeerList verifies that peers are encoded as a bencoded list of dictionaries, and that
+// peers with malformed addresses are skipped
+func TestDictPeerList(t *testing.T) {
+	log.Println("TestDictPeerList()")
+
+	peers := []Peer{
+		{IP: "10.0.0.1", Port: 5001},
+		{IP: "not an ip", Port: 5002},
+		{IP: "2001:db8::1", Port: 5003},
+	}
+
+	expected := "ld2:ip8:10.0.0.14:porti5001eed2:ip11:2001:db8::14:porti5003eee"
+	if out := string(dictPeerList(peers)); out != expected {
+		t.Fatalf("dictPeerList, expected %q, got %q", expected, out)
+	}
+
+	// Verify an empty list is still a valid bencoded list
+	if out := string(dictPeerList(nil)); out != "le" {
+		t.Fatalf("dictPeerList of no peers, expected %q, got %q", "le", out)
+	}
+}
+
+// TestSeededPeers verifies that seeded peer selection is deterministic within a time window
+func TestSeededPeers(t *testing.T) {
+	log.Println("TestSeededPeers()")
+
+	// Generate mock swarm
+	peers := make([]Peer, 0)
+	for i := 1; i <= 100; i++ {
+		peers = append(peers[:], Peer{IP: "10.0.0." + strconv.Itoa(i), Port: uint16(5000 + i)})
+	}
+
+	// Generate the same swarm, returned in a different order
+	reversed := make([]Peer, 0)
+	for i := len(peers) - 1; i >= 0; i-- {
+		reversed = append(reversed[:], peers[i])
+	}
+
+	infoHash := "6465616462656566303030303030303030303030"
+
+	// Select peers twice within the same window
+	first := seededPeers(peers, infoHash, 50, 1800, 3600)
+	second := seededPeers(reversed, infoHash, 50, 1800, 3600+1799)
+
+	if len(first) != 50 {
+		t.Fatalf("Expected 50 peers, got %d", len(first))
+	}
+
+	// Verify selections are identical
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("Seeded peer selections within the same window do not match")
+	}
+
+	// Verify the next window yields a different selection
+	third := seededPeers(peers, infoHash, 50, 1800, 3600+1800)
+	if reflect.DeepEqual(first, third) {
+		t.Fatalf("Seeded peer selections in different windows are identical")
+	}
+
+	// Verify numwant larger than swarm returns entire swarm
+	if all := seededPeers(peers, infoHash, 200, 1800, 3600); len(all) != len(peers) {
+		t.Fatalf("Expected %d peers, got %d", len(peers), len(all))
+	}
+}
+
+// TestPeerRotation verifies that consecutive peer lists for one peer are disjoint
+func TestPeerRotation(t *testing.T) {
+	log.Println("TestPeerRotation()")
+
+	// Generate mock swarm
+	peers := make([]Peer, 0)
+	for i := 1; i <= 100; i++ {
+		peers = append(peers[:], Peer{IP: "10.0.0." + strconv.Itoa(i), Port: uint16(5000 + i)})
+	}
+
+	r := &peerRotation{offsets: map[string]rotationOffset{}}
+
+	// Select peers twice for the same peer
+	first := r.peers("peer", peers, 40, 3600, 1000)
+	second := r.peers("peer", peers, 40, 3600, 1001)
+	if len(first) != 40 || len(second) != 40 {
+		t.Fatalf("Expected 40 peers, got %d and %d", len(first), len(second))
+	}
+
+	// Verify selections do not overlap
+	seen := map[Peer]bool{}
+	for _, p := range first {
+		seen[p] = true
+	}
+	for _, p := range second {
+		if seen[p] {
+			t.Fatalf("Consecutive peer lists both contain peer: %v", p)
+		}
+	}
+
+	// Verify another peer's rotation begins independently
+	if other := r.peers("other", peers, 40, 3600, 1002); !reflect.DeepEqual(first, other) {
+		t.Fatalf("Rotation for a new peer did not begin at start of swarm")
+	}
+
+	// Verify selection wraps around the swarm
+	third := r.peers("peer", peers, 40, 3600, 1003)
+	if len(third) != 40 {
+		t.Fatalf("Expected 40 peers, got %d", len(third))
+	}
+	if third[20] != first[0] {
+		t.Fatalf("Peer list did not wrap around swarm: %v != %v", third[20], first[0])
+	}
+
+	// Verify stale rotation state is discarded
+	if fresh := r.peers("peer", peers, 40, 3600, 1003+3600); !reflect.DeepEqual(first, fresh) {
+		t.Fatalf("Stale rotation state was not discarded")
+	}
+}
+
+// TestSelectPeersSeederRatio verifies that leechers receive seeder-heavy peer lists
+func TestSelectPeersSeederRatio(t *testing.T) {
+	log.Println("TestSelectPeersSeederRatio()")
+
+	// Prefer seeders for leechers
+	common.Static.Config = common.Conf{}
+	common.Static.Config.PeerList.SeederRatio = 0.8
+
+	// Generate mock swarm, mostly leechers
+	peers := make([]Peer, 0)
+	for i := 1; i <= 100; i++ {
+		peers = append(peers[:], Peer{IP: "10.0.0." + strconv.Itoa(i), Port: uint16(5000 + i), Seeder: i%5 == 0})
+	}
+
+	// Count seeders in a peer list
+	countSeeders := func(peers []Peer) int {
+		count := 0
+		for _, p := range peers {
+			if p.Seeder {
+				count++
+			}
+		}
+
+		return count
+	}
+
+	// Verify leecher receives mostly seeders, with some leechers for connectivity
+	selected := selectPeers("deadbeef", "peer", true, peers, 20, 1000)
+	if len(selected) != 20 {
+		t.Fatalf("Expected 20 peers, got %d", len(selected))
+	}
+	if seeders := countSeeders(selected); seeders != 16 {
+		t.Fatalf("Expected 16 seeders for leecher, got %d", seeders)
+	}
+
+	// Verify seeder shortfall is filled with leechers
+	selected = selectPeers("deadbeef", "peer", true, peers, 50, 1000)
+	if len(selected) != 50 || countSeeders(selected) != 20 {
+		t.Fatalf("Expected 50 peers with 20 seeders, got %d with %d", len(selected), countSeeders(selected))
+	}
+
+	// Verify seeders do not receive preferential treatment
+	selected = selectPeers("deadbeef", "peer", false, peers, 20, 1000)
+	if len(selected) != 20 || countSeeders(selected) != 4 {
+		t.Fatalf("Expected 20 peers with 4 seeders, got %d with %d", len(selected), countSeeders(selected))
+	}
 }
@@ -0,0 +1,275 @@
+package data
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+
+	// Import redigo Redis client
+	"github.com/garyburd/redigo/redis"
+)
+
+// Redis swarm state consistency model:
+//   - Redis holds only active swarm state: the peers which have recently announced on a file.
+//     Durable records (files, users, file/user relationships, logs) are always stored in SQL.
+//   - Each file has a sorted set of seeders and a sorted set of leechers, keyed by info_hash,
+//     with each peer scored by the time of its last announce.
+//   - Announces are written to Redis asynchronously, in a single transaction, so a peer moves
+//     atomically between sets, but may not appear in peer lists until shortly after announcing.
+//   - Peers which have not announced within 1.5 announce intervals have expired, and are excluded
+//     from seeder and leecher counts and peer lists, without need for a reaper.  Each set also
+//     expires as a whole after the same TTL without announces, so abandoned swarms do not linger.
+//   - If Redis is lost or flushed, swarm state is rebuilt as peers announce again, within one
+//     announce interval.
+
+// redisSeedersKey returns the Redis key of the sorted set of seeders for a file
+func redisSeedersKey(infoHash string) string {
+	return "goat:seeders:" + infoHash
+}
+
+// redisLeechersKey returns the Redis key of the sorted set of leechers for a file
+func redisLeechersKey(infoHash string) string {
+	return "goat:leechers:" + infoHash
+}
+
+// redisPeerTTL returns the number of seconds after which a peer which has not announced expires,
+// allowing for jitter, and some leeway for clients which announce late
+func redisPeerTTL() int64 {
+	return int64(peerWindow(common.Static.Config.Interval)) * 3 / 2
+}
+
+// redisPool is the shared Redis connection pool, opened on first use
+var redisPool *redis.Pool
+
+// redisPoolMutex guards the shared Redis connection pool
+var redisPoolMutex sync.Mutex
+
+// redisDial opens and authenticates a new connection to the configured Redis server
+func redisDial() (redis.Conn, error) {
+	conf := common.Static.Config.Redis
+
+	conn, err := redis.Dial("tcp", conf.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate, if a password is configured
+	if conf.Password != "" {
+		if _, err := conn.Do("AUTH", conf.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// redisConnect retrieves a connection to the configured Redis server from the shared connection
+// pool, which must be closed to return it to the pool
+func redisConnect() (redis.Conn, error) {
+	redisPoolMutex.Lock()
+	if redisPool == nil {
+		// Bound the number of connections in the pool, as configured, waiting for a connection
+		// to be returned if all are in use
+		conf := common.Static.Config.Redis
+		redisPool = &redis.Pool{
+			Dial:        redisDial,
+			MaxActive:   conf.MaxActive,
+			MaxIdle:     conf.MaxIdle,
+			IdleTimeout: 5 * time.Minute,
+			Wait:        true,
+		}
+	}
+	pool := redisPool
+	redisPoolMutex.Unlock()
+
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// RedisClose closes the shared Redis connection pool, if it is open
+func RedisClose() {
+	redisPoolMutex.Lock()
+	defer redisPoolMutex.Unlock()
+
+	if redisPool != nil {
+		if err := redisPool.Close(); err != nil {
+			log.Println(err.Error())
+		}
+
+		redisPool = nil
+	}
+}
+
+// RedisAnnounce records a peer's announce on a file in Redis swarm state, removing the peer
+// if it has stopped
+func RedisAnnounce(infoHash string, peer Peer, stopped bool, now int64) error {
+	conn, err := redisConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Peer is stored in the set matching its state, and removed from the other
+	member := net.JoinHostPort(peer.IP, strconv.Itoa(int(peer.Port)))
+	key, other := redisLeechersKey(infoHash), redisSeedersKey(infoHash)
+	if peer.Seeder {
+		key, other = other, key
+	}
+
+	// Update both sets in a single transaction
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := conn.Send("ZREM", other, member); err != nil {
+		return err
+	}
+
+	if stopped {
+		if err := conn.Send("ZREM", key, member); err != nil {
+			return err
+		}
+	} else {
+		if err := conn.Send("ZADD", key, now, member); err != nil {
+			return err
+		}
+		if err := conn.Send("EXPIRE", key, redisPeerTTL()); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// redisPeerList retrieves up to limit active peers on a file from Redis swarm state, seeders
+// first, and most recently announced first within each group
+func redisPeerList(infoHash string, limit int, now int64) ([]Peer, error) {
+	peers := make([]Peer, 0)
+	if limit <= 0 {
+		return peers, nil
+	}
+
+	conn, err := redisConnect()
+	if err != nil {
+		return peers, err
+	}
+	defer conn.Close()
+
+	// Retrieve seeders, then leechers
+	for _, group := range []struct {
+		key    string
+		seeder bool
+	}{
+		{redisSeedersKey(infoHash), true},
+		{redisLeechersKey(infoHash), false},
+	} {
+		// Remove expired peers before reading
+		if _, err := conn.Do("ZREMRANGEBYSCORE", group.key, "-inf", now-redisPeerTTL()); err != nil {
+			return peers, err
+		}
+
+		members, err := redis.Strings(conn.Do("ZREVRANGE", group.key, 0, limit-len(peers)-1))
+		if err != nil {
+			return peers, err
+		}
+
+		for _, m := range members {
+			// Skip any malformed members
+			host, port, err := net.SplitHostPort(m)
+			if err != nil {
+				continue
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				continue
+			}
+
+			peers = append(peers[:], Peer{IP: host, Port: uint16(p), Seeder: group.seeder})
+		}
+
+		if len(peers) >= limit {
+			break
+		}
+	}
+
+	return peers, nil
+}
+
+// redisPeerCounts retrieves the number of seeders and leechers on a file which have not expired
+// from Redis swarm state
+func redisPeerCounts(infoHash string, now int64) (int, int, error) {
+	conn, err := redisConnect()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	// Count only peers which announced within the TTL
+	min := "(" + strconv.FormatInt(now-redisPeerTTL(), 10)
+	seeders, err := redis.Int(conn.Do("ZCOUNT", redisSeedersKey(infoHash), min, "+inf"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	leechers, err := redis.Int(conn.Do("ZCOUNT", redisLeechersKey(infoHash), min, "+inf"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return seeders, leechers, nil
+}
+
+// redisTotalPeerCounts retrieves the number of seeders and leechers on all files which have not
+// expired from Redis swarm state, using a single connection
+func redisTotalPeerCounts(now int64) (int, int, error) {
+	conn, err := redisConnect()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	// Count only peers which announced within the TTL
+	min := "(" + strconv.FormatInt(now-redisPeerTTL(), 10)
+
+	// Iterate the sets of seeders and leechers on all files
+	counts := make([]int, 2)
+	for i, pattern := range []string{redisSeedersKey("*"), redisLeechersKey("*")} {
+		cursor := 0
+		for {
+			res, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+			if err != nil {
+				return 0, 0, err
+			}
+
+			var keys []string
+			if _, err := redis.Scan(res, &cursor, &keys); err != nil {
+				return 0, 0, err
+			}
+
+			for _, key := range keys {
+				count, err := redis.Int(conn.Do("ZCOUNT", key, min, "+inf"))
+				if err != nil {
+					return 0, 0, err
+				}
+
+				counts[i] += count
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+
+	return counts[0], counts[1], nil
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+
+	// Import miniredis in-memory Redis server
+	"github.com/alicebob/miniredis"
+)
+
+// TestRedisPeerList verifies that announces are stored in Redis swarm state, and that stale
+// and stopped peers are not returned in peer lists
+func TestRedisPeerList(t *testing.T) {
+	log.Println("TestRedisPeerList()")
+
+	// Start in-memory Redis server
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %s", err.Error())
+	}
+	defer server.Close()
+	defer RedisClose()
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 60
+	config.Redis.Enabled = true
+	config.Redis.Host = server.Addr()
+	config.Redis.Password = ""
+	common.Static.Config = config
+
+	infoHash := "6465616462656566303030303030303030303030"
+	seeder := Peer{IP: "10.0.0.1", Port: 5000, Seeder: true}
+	leecher := Peer{IP: "10.0.0.2", Port: 5001}
+	stale := Peer{IP: "10.0.0.3", Port: 5002}
+
+	// Announce peers, with one peer announcing longer ago than the TTL
+	if err := RedisAnnounce(infoHash, stale, false, 1000); err != nil {
+		t.Fatalf("Failed to announce stale peer: %s", err.Error())
+	}
+	if err := RedisAnnounce(infoHash, leecher, false, 1100); err != nil {
+		t.Fatalf("Failed to announce leecher: %s", err.Error())
+	}
+	if err := RedisAnnounce(infoHash, seeder, false, 1110); err != nil {
+		t.Fatalf("Failed to announce seeder: %s", err.Error())
+	}
+
+	// Verify stale peer is removed, and seeders are returned first
+	peers, err := redisPeerList(infoHash, 50, 1130)
+	if err != nil {
+		t.Fatalf("Failed to retrieve peer list: %s", err.Error())
+	}
+	if len(peers) != 2 || peers[0] != seeder || peers[1] != leecher {
+		t.Fatalf("Unexpected peer list: %v", peers)
+	}
+
+	// Verify limit is respected
+	if peers, err = redisPeerList(infoHash, 1, 1130); err != nil || len(peers) != 1 {
+		t.Fatalf("Unexpected limited peer list: %v, %v", peers, err)
+	}
+
+	// Leecher completes, and moves to seeders
+	leecher.Seeder = true
+	if err := RedisAnnounce(infoHash, leecher, false, 1120); err != nil {
+		t.Fatalf("Failed to announce completed leecher: %s", err.Error())
+	}
+
+	// Seeder stops, and is removed
+	if err := RedisAnnounce(infoHash, seeder, true, 1120); err != nil {
+		t.Fatalf("Failed to announce stopped seeder: %s", err.Error())
+	}
+
+	peers, err = redisPeerList(infoHash, 50, 1130)
+	if err != nil {
+		t.Fatalf("Failed to retrieve peer list: %s", err.Error())
+	}
+	if len(peers) != 1 || peers[0] != leecher {
+		t.Fatalf("Unexpected peer list after completion and stop: %v", peers)
+	}
+}
+
+// TestRedisPeerExpiry verifies that a peer which does not re-announce within the TTL is gone from the swarm
+func TestRedisPeerExpiry(t *testing.T) {
+	log.Println("TestRedisPeerExpiry()")
+
+	// Start in-memory Redis server
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %s", err.Error())
+	}
+	defer server.Close()
+	defer RedisClose()
+
+	// Load config, with a TTL of 90 seconds
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 60
+	config.Redis.Enabled = true
+	config.Redis.Host = server.Addr()
+	config.Redis.Password = ""
+	common.Static.Config = config
+
+	infoHash := "6465616462656566303030303030303030303030"
+	seeder := Peer{IP: "10.0.0.1", Port: 5000, Seeder: true}
+	leecher := Peer{IP: "10.0.0.2", Port: 5001}
+
+	// Announce both peers, but only the seeder re-announces
+	if err := RedisAnnounce(infoHash, seeder, false, 1000); err != nil {
+		t.Fatalf("Failed to announce seeder: %s", err.Error())
+	}
+	if err := RedisAnnounce(infoHash, leecher, false, 1000); err != nil {
+		t.Fatalf("Failed to announce leecher: %s", err.Error())
+	}
+	if err := RedisAnnounce(infoHash, seeder, false, 1060); err != nil {
+		t.Fatalf("Failed to re-announce seeder: %s", err.Error())
+	}
+
+	// Within the TTL, both peers are counted
+	seeders, leechers, err := redisPeerCounts(infoHash, 1089)
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 1 || leechers != 1 {
+		t.Fatalf("Unexpected counts within TTL: %d seeders, %d leechers", seeders, leechers)
+	}
+
+	// After the TTL, the leecher has expired
+	if seeders, leechers, err = redisPeerCounts(infoHash, 1090); err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 1 || leechers != 0 {
+		t.Fatalf("Unexpected counts after TTL: %d seeders, %d leechers", seeders, leechers)
+	}
+
+	peers, err := redisPeerList(infoHash, 50, 1090)
+	if err != nil {
+		t.Fatalf("Failed to retrieve peer list: %s", err.Error())
+	}
+	if len(peers) != 1 || peers[0] != seeder {
+		t.Fatalf("Unexpected peer list after TTL: %v", peers)
+	}
+}
+
+// TestRedisPool verifies that Redis connections are reused from the shared pool, rather than
+// dialed for each announce
+func TestRedisPool(t *testing.T) {
+	log.Println("TestRedisPool()")
+
+	// Start in-memory Redis server
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %s", err.Error())
+	}
+	defer server.Close()
+	defer RedisClose()
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Redis.Enabled = true
+	config.Redis.Host = server.Addr()
+	config.Redis.Password = ""
+	common.Static.Config = config
+
+	// Announce and count peers repeatedly
+	infoHash := "6465616462656566303030303030303030303030"
+	for i := 0; i < 10; i++ {
+		if err := RedisAnnounce(infoHash, Peer{IP: "10.0.0.1", Port: 5000}, false, 1000); err != nil {
+			t.Fatalf("Failed to announce peer: %s", err.Error())
+		}
+		if _, _, err := redisPeerCounts(infoHash, 1000); err != nil {
+			t.Fatalf("Failed to count peers: %s", err.Error())
+		}
+	}
+
+	// Verify a single connection was dialed
+	if count := server.TotalConnectionCount(); count != 1 {
+		t.Fatalf("Expected 1 Redis connection, got %d", count)
+	}
+}
+
+// TestRedisTotalPeerCounts verifies that unexpired seeders and leechers are counted on all files
+func TestRedisTotalPeerCounts(t *testing.T) {
+	log.Println("TestRedisTotalPeerCounts()")
+
+	// Start in-memory Redis server
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %s", err.Error())
+	}
+	defer server.Close()
+	defer RedisClose()
+
+	// Load config, with a TTL of 90 seconds
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 60
+	config.IntervalJitter = 0
+	config.Redis.Enabled = true
+	config.Redis.Host = server.Addr()
+	config.Redis.Password = ""
+	common.Static.Config = config
+
+	// Announce peers on two files, with one leecher expiring
+	announces := []struct {
+		infoHash string
+		peer     Peer
+		now      int64
+	}{
+		{"6465616462656566303030303030303030303030", Peer{IP: "10.0.0.1", Port: 5000, Seeder: true}, 1000},
+		{"6465616462656566303030303030303030303030", Peer{IP: "10.0.0.2", Port: 5000}, 1000},
+		{"6265656664656164303030303030303030303030", Peer{IP: "10.0.0.1", Port: 5000, Seeder: true}, 1000},
+		{"6265656664656164303030303030303030303030", Peer{IP: "10.0.0.3", Port: 5000}, 900},
+	}
+	for _, a := range announces {
+		if err := RedisAnnounce(a.infoHash, a.peer, false, a.now); err != nil {
+			t.Fatalf("Failed to announce peer: %s", err.Error())
+		}
+	}
+
+	seeders, leechers, err := redisTotalPeerCounts(1010)
+	if err != nil {
+		t.Fatalf("Failed to count peers: %s", err.Error())
+	}
+	if seeders != 2 || leechers != 1 {
+		t.Fatalf("Unexpected total counts: %d seeders, %d leechers", seeders, leechers)
+	}
+}
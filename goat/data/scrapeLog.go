@@ -5,13 +5,15 @@ import (
 	"encoding/hex"
 	"errors"
 	"net/url"
-	"time"
+
+	"github.com/mdlayher/goat/goat/common"
 )
 
 // ScrapeLog represents a scrapelog, to be logged to storage
 type ScrapeLog struct {
 	ID       int
 	InfoHash string `db:"info_hash"`
+	UserID   int    `db:"user_id"`
 	Passkey  string
 	IP       string
 	Time     int64
@@ -48,7 +50,7 @@ func (s ScrapeLog) Save() error {
 	}
 
 	// Save ScrapeLog
-	if err := db.SaveScrapeLog(s); err != nil {
+	if err := withRetry(func() error { return db.SaveScrapeLog(s) }); err != nil {
 		return err
 	}
 
@@ -62,6 +64,11 @@ func (s ScrapeLog) Save() error {
 
 // Load ScrapeLog from storage
 func (s ScrapeLog) Load(id interface{}, col string) (ScrapeLog, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("scrape_log", col); err != nil {
+		return ScrapeLog{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -99,7 +106,7 @@ func (s *ScrapeLog) FromValues(query url.Values) error {
 	s.IP = query.Get("ip")
 
 	// Current UNIX timestamp
-	s.Time = time.Now().Unix()
+	s.Time = common.Now().Unix()
 
 	// udp
 	if query.Get("udp") == "1" {
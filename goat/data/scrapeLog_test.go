@@ -13,7 +13,7 @@ func TestScrapeLog(t *testing.T) {
 	log.Println("TestScrapeLog()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
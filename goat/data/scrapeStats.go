@@ -0,0 +1,114 @@
+package data
+
+import (
+	"log"
+	"sync"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// ScrapeStats represents the seeder, leecher, and completion counts reported by a scrape
+type ScrapeStats struct {
+	Seeders   int
+	Leechers  int
+	Completed int
+	// Cached is true if stats were served from cache, or false if loaded live from storage
+	Cached bool
+}
+
+// scrapeCacheStaleTTLs is the number of cache TTLs for which stale stats are kept, so that they may
+// be served if live stats cannot be loaded
+const scrapeCacheStaleTTLs = 10
+
+// scrapeCacheEntry stores ScrapeStats, and the time at which they were loaded
+type scrapeCacheEntry struct {
+	stats  ScrapeStats
+	loaded int64
+}
+
+// scrapeCache caches ScrapeStats by info_hash, so that repeated scrapes of popular files
+// within a short window do not each require database queries
+type scrapeCache struct {
+	sync.Mutex
+	entries map[string]scrapeCacheEntry
+	pruned  int64
+	load    func(FileRecord) (ScrapeStats, error)
+}
+
+// scrapeStatsCache caches ScrapeStats for all files
+var scrapeStatsCache = &scrapeCache{
+	entries: map[string]scrapeCacheEntry{},
+	load:    loadScrapeStats,
+}
+
+// get returns ScrapeStats for a file, using cached stats if they are less than ttl seconds old
+func (c *scrapeCache) get(f FileRecord, ttl int64, now int64) (ScrapeStats, error) {
+	// If caching disabled, always load stats
+	if ttl <= 0 {
+		return c.load(f)
+	}
+
+	// Check for fresh cached stats
+	c.Lock()
+	entry, ok := c.entries[f.InfoHash]
+	c.Unlock()
+	if ok && now-entry.loaded < ttl {
+		entry.stats.Cached = true
+		return entry.stats, nil
+	}
+
+	// Load stats live, without holding lock during database queries
+	stats, err := c.load(f)
+	if err != nil {
+		// If stale stats are available, serve them rather than failing outright
+		if ok && now-entry.loaded < ttl*scrapeCacheStaleTTLs {
+			log.Println(err.Error())
+			entry.stats.Cached = true
+			return entry.stats, nil
+		}
+
+		return stats, err
+	}
+	stats.Cached = false
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Discard entries which are too old to be served as stale stats, at most once per ttl
+	if now-c.pruned >= ttl {
+		for k, v := range c.entries {
+			if now-v.loaded >= ttl*scrapeCacheStaleTTLs {
+				delete(c.entries, k)
+			}
+		}
+
+		c.pruned = now
+	}
+
+	c.entries[f.InfoHash] = scrapeCacheEntry{stats: stats, loaded: now}
+	return stats, nil
+}
+
+// loadScrapeStats loads ScrapeStats for a file from storage
+func loadScrapeStats(f FileRecord) (ScrapeStats, error) {
+	stats := ScrapeStats{}
+
+	// Retrieve number of active seeders and leechers
+	var err error
+	if stats.Seeders, stats.Leechers, err = f.PeerCounts(); err != nil {
+		return stats, err
+	}
+
+	// Retrieve number of file completions
+	if stats.Completed, err = f.Completed(); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// ScrapeStats returns the seeder, leecher, and completion counts for this file, which may be
+// cached for a short time, as configured
+func (f FileRecord) ScrapeStats() (ScrapeStats, error) {
+	return scrapeStatsCache.get(f, int64(common.Static.Config.Scrape.CacheTTL), common.Now().Unix())
+}
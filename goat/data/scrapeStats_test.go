@@ -0,0 +1,167 @@
+package data
+
+import (
+	"errors"
+	"log"
+	"testing"
+)
+
+// TestScrapeCache verifies that scrapes within the cache TTL do not load stats from storage
+func TestScrapeCache(t *testing.T) {
+	log.Println("TestScrapeCache()")
+
+	// Generate mock cache, counting loads in place of database queries
+	loads := 0
+	cache := &scrapeCache{
+		entries: map[string]scrapeCacheEntry{},
+		load: func(f FileRecord) (ScrapeStats, error) {
+			loads++
+			return ScrapeStats{Seeders: loads, Leechers: 2, Completed: 3}, nil
+		},
+	}
+
+	file := FileRecord{ID: 1, InfoHash: "6465616462656566303030303030303030303030"}
+	file2 := FileRecord{ID: 2, InfoHash: "6265656664656164303030303030303030303030"}
+
+	// Verify first scrape loads stats
+	stats, err := cache.get(file, 30, 1000)
+	if err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if loads != 1 || stats.Seeders != 1 {
+		t.Fatalf("Expected 1 load, got %d", loads)
+	}
+
+	// Verify second scrape within TTL is served from cache
+	if stats, err = cache.get(file, 30, 1029); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if loads != 1 || stats.Seeders != 1 {
+		t.Fatalf("Scrape within TTL loaded stats, expected 1 load, got %d", loads)
+	}
+
+	// Verify a different file is cached separately
+	if _, err = cache.get(file2, 30, 1029); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if loads != 2 {
+		t.Fatalf("Expected 2 loads, got %d", loads)
+	}
+
+	// Verify scrape after TTL loads fresh stats
+	if stats, err = cache.get(file, 30, 1030); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if loads != 3 || stats.Seeders != 3 {
+		t.Fatalf("Scrape after TTL did not load stats, expected 3 loads, got %d", loads)
+	}
+
+	// Verify caching is disabled with zero TTL
+	if _, err = cache.get(file, 0, 1031); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if loads != 4 {
+		t.Fatalf("Scrape with caching disabled did not load stats, expected 4 loads, got %d", loads)
+	}
+}
+
+// TestScrapeCacheFallback verifies that an empty cache falls back to live stats, and that stale
+// stats are served if live stats cannot be loaded
+func TestScrapeCacheFallback(t *testing.T) {
+	log.Println("TestScrapeCacheFallback()")
+
+	// Generate mock cache with no entries, failing loads on demand
+	fail := false
+	cache := &scrapeCache{
+		entries: map[string]scrapeCacheEntry{},
+		load: func(f FileRecord) (ScrapeStats, error) {
+			if fail {
+				return ScrapeStats{}, errors.New("load failed")
+			}
+			return ScrapeStats{Seeders: 5, Leechers: 3, Completed: 7}, nil
+		},
+	}
+
+	file := FileRecord{ID: 1, InfoHash: "6465616462656566303030303030303030303030"}
+
+	// Verify empty cache returns live stats, rather than zeros
+	stats, err := cache.get(file, 30, 1000)
+	if err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if stats.Cached || stats.Seeders != 5 || stats.Leechers != 3 || stats.Completed != 7 {
+		t.Fatalf("Expected live stats {5 3 7 false}, got %v", stats)
+	}
+
+	// Verify subsequent scrape is flagged as cached
+	if stats, err = cache.get(file, 30, 1001); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if !stats.Cached || stats.Seeders != 5 {
+		t.Fatalf("Expected cached stats, got %v", stats)
+	}
+
+	// Verify stale stats are served if live stats cannot be loaded
+	fail = true
+	if stats, err = cache.get(file, 30, 1100); err != nil {
+		t.Fatalf("Failed to fall back to stale stats: %s", err.Error())
+	}
+	if !stats.Cached || stats.Seeders != 5 {
+		t.Fatalf("Expected stale cached stats, got %v", stats)
+	}
+
+	// Verify failure with no cached stats returns an error
+	if _, err = cache.get(FileRecord{ID: 2, InfoHash: "6265656664656164303030303030303030303030"}, 30, 1100); err == nil {
+		t.Fatalf("Expected error with no cached stats")
+	}
+}
+
+// TestScrapeCacheStaleAfterPrune verifies that stale stats are kept when other entries are loaded
+// after they expire, so they may still be served if live stats cannot be loaded, until the stale
+// window elapses
+func TestScrapeCacheStaleAfterPrune(t *testing.T) {
+	log.Println("TestScrapeCacheStaleAfterPrune()")
+
+	// Generate mock cache, failing loads on demand
+	fail := false
+	cache := &scrapeCache{
+		entries: map[string]scrapeCacheEntry{},
+		load: func(f FileRecord) (ScrapeStats, error) {
+			if fail {
+				return ScrapeStats{}, errors.New("load failed")
+			}
+			return ScrapeStats{Seeders: 5, Leechers: 3, Completed: 7}, nil
+		},
+	}
+
+	file := FileRecord{ID: 1, InfoHash: "6465616462656566303030303030303030303030"}
+	file2 := FileRecord{ID: 2, InfoHash: "6265656664656164303030303030303030303030"}
+
+	// Load stats for file, and after they expire, load another file, pruning the cache
+	if _, err := cache.get(file, 30, 1000); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	if _, err := cache.get(file2, 30, 1060); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+
+	// Verify expired stats are served if live stats cannot be loaded
+	fail = true
+	stats, err := cache.get(file, 30, 1061)
+	if err != nil {
+		t.Fatalf("Failed to fall back to stale stats after prune: %s", err.Error())
+	}
+	if !stats.Cached || stats.Seeders != 5 {
+		t.Fatalf("Expected stale cached stats, got %v", stats)
+	}
+
+	// Verify stats beyond the stale window are discarded, and an error is returned
+	fail = false
+	if _, err := cache.get(file2, 30, 1000+30*scrapeCacheStaleTTLs); err != nil {
+		t.Fatalf("Failed to get scrape stats: %s", err.Error())
+	}
+	fail = true
+	if _, err := cache.get(file, 30, 1000+30*scrapeCacheStaleTTLs); err == nil {
+		t.Fatalf("Expected error with stats beyond stale window")
+	}
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// snapshotFormat identifies a JSON document as a goat snapshot
+const snapshotFormat = "goat-snapshot"
+
+// SnapshotVersion is the version of the snapshot format written by ExportSnapshot.  It is incremented
+// whenever the format changes in a way older versions of goat cannot read.
+const SnapshotVersion = 1
+
+// ErrSnapshotNotEmpty is returned when importing a snapshot into a database which already contains
+// users or files, and import has not been forced
+var ErrSnapshotNotEmpty = errors.New("database is not empty, refusing to import snapshot")
+
+// Snapshot represents the full user and swarm state of a tracker, used to migrate state between instances
+type Snapshot struct {
+	Format        string           `json:"format"`
+	Version       int              `json:"version"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          int64            `json:"time"`
+	Users         []UserRecord     `json:"users"`
+	Files         []FileRecord     `json:"files"`
+	APIKeys       []APIKey         `json:"apiKeys"`
+	Passkeys      []PasskeyRecord  `json:"passkeys"`
+	FileUsers     []FileUserRecord `json:"fileUsers"`
+}
+
+// schemaVersion returns the newest schema migration applied to the database
+func schemaVersion(db dbModel) (int, error) {
+	versions, err := db.LoadSchemaMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for _, v := range versions {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// ExportSnapshot writes all users, files, API keys, passkeys, and file/user relationships in storage
+// to the output stream, as a versioned JSON snapshot
+func ExportSnapshot(w io.Writer) error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	s := Snapshot{
+		Format:  snapshotFormat,
+		Version: SnapshotVersion,
+		Time:    common.Now().Unix(),
+	}
+
+	// Record schema version, so import can check compatibility
+	if s.SchemaVersion, err = schemaVersion(db); err != nil {
+		return err
+	}
+
+	// Load users, and any additional passkeys issued to them
+	if s.Users, err = db.GetAllUserRecords(); err != nil {
+		return err
+	}
+	s.Passkeys = make([]PasskeyRecord, 0)
+	for _, u := range s.Users {
+		passkeys, err := db.LoadPasskeyRepository(u.ID, "user_id")
+		if err != nil {
+			return err
+		}
+
+		s.Passkeys = append(s.Passkeys, passkeys...)
+	}
+
+	// Load API keys
+	if s.APIKeys, err = db.GetAllAPIKeys(); err != nil {
+		return err
+	}
+
+	// Load files, and the swarm of users on each file
+	if s.Files, err = db.GetAllFileRecords(); err != nil {
+		return err
+	}
+	s.FileUsers = make([]FileUserRecord, 0)
+	for _, f := range s.Files {
+		fileUsers, err := db.LoadFileUserRepository(f.ID, "file_id")
+		if err != nil {
+			return err
+		}
+
+		s.FileUsers = append(s.FileUsers, fileUsers...)
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	// Stream snapshot to output
+	return json.NewEncoder(w).Encode(s)
+}
+
+// ImportSnapshot reads a snapshot from the input stream, and restores it to storage in a single
+// transaction.  Import is refused if storage already contains users or files, unless force is set,
+// in which case all existing users, files, API keys, passkeys, and file/user relationships are replaced.
+func ImportSnapshot(r io.Reader, force bool) error {
+	// Decode and verify snapshot
+	s := Snapshot{}
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	if s.Format != snapshotFormat {
+		return errors.New("input is not a goat snapshot")
+	}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected at most %d", s.Version, SnapshotVersion)
+	}
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Snapshots from a newer schema may contain data this database cannot store
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if s.SchemaVersion > version {
+		return fmt.Errorf("snapshot schema version %d is newer than database schema version %d, apply migrations first",
+			s.SchemaVersion, version)
+	}
+
+	// Refuse to clobber existing state, unless forced
+	if !force {
+		users, err := db.GetAllUserRecords()
+		if err != nil {
+			return err
+		}
+		files, err := db.GetAllFileRecords()
+		if err != nil {
+			return err
+		}
+
+		if len(users) > 0 || len(files) > 0 {
+			return ErrSnapshotNotEmpty
+		}
+	}
+
+	// Restore snapshot
+	if err := db.ImportSnapshot(s); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
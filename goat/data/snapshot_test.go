@@ -0,0 +1,178 @@
+package data
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshotDB is an in-memory database backend, used to verify snapshot export and import
+type snapshotDB struct {
+	dbModel
+	schema    []int
+	snapshot  *Snapshot
+	fileUsers map[int][]FileUserRecord
+	passkeys  map[int][]PasskeyRecord
+}
+
+// newSnapshotDB creates an in-memory database backend containing the state in a snapshot
+func newSnapshotDB(s Snapshot) *snapshotDB {
+	db := &snapshotDB{schema: []int{1, 2, 3}}
+	if err := db.ImportSnapshot(s); err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+// Close does nothing, as there is no connection
+func (db *snapshotDB) Close() error {
+	return nil
+}
+
+// LoadSchemaMigrations returns the schema versions applied to this backend
+func (db *snapshotDB) LoadSchemaMigrations() ([]int, error) {
+	return db.schema, nil
+}
+
+// GetAllUserRecords returns all users in memory
+func (db *snapshotDB) GetAllUserRecords() ([]UserRecord, error) {
+	return db.snapshot.Users, nil
+}
+
+// GetAllFileRecords returns all files in memory
+func (db *snapshotDB) GetAllFileRecords() ([]FileRecord, error) {
+	return db.snapshot.Files, nil
+}
+
+// GetAllAPIKeys returns all API keys in memory
+func (db *snapshotDB) GetAllAPIKeys() ([]APIKey, error) {
+	return db.snapshot.APIKeys, nil
+}
+
+// LoadPasskeyRepository returns all passkeys in memory for a user ID
+func (db *snapshotDB) LoadPasskeyRepository(id interface{}, col string) ([]PasskeyRecord, error) {
+	return db.passkeys[id.(int)], nil
+}
+
+// LoadFileUserRepository returns all file/user relationships in memory for a file ID
+func (db *snapshotDB) LoadFileUserRepository(id interface{}, col string) ([]FileUserRecord, error) {
+	return db.fileUsers[id.(int)], nil
+}
+
+// ImportSnapshot replaces all state in memory
+func (db *snapshotDB) ImportSnapshot(s Snapshot) error {
+	db.snapshot = &s
+	db.passkeys = map[int][]PasskeyRecord{}
+	for _, p := range s.Passkeys {
+		db.passkeys[p.UserID] = append(db.passkeys[p.UserID], p)
+	}
+	db.fileUsers = map[int][]FileUserRecord{}
+	for _, f := range s.FileUsers {
+		db.fileUsers[f.FileID] = append(db.fileUsers[f.FileID], f)
+	}
+
+	return nil
+}
+
+// mockSnapshot contains user and swarm state used to verify snapshot export and import
+var mockSnapshot = Snapshot{
+	Users: []UserRecord{
+		{ID: 1, Username: "alice", Password: "hash1", Passkey: "passkey1", TorrentLimit: 10},
+		{ID: 2, Username: "bob", Password: "hash2", Passkey: "passkey2", TorrentLimit: 5, Admin: true},
+	},
+	Files: []FileRecord{
+		{ID: 1, InfoHash: "6465616462656566303030303030303030303030", Verified: true, CreateTime: 1400000000,
+			UpdateTime: 1400000100, AnnounceInterval: 1800, PeerLimit: 50},
+	},
+	APIKeys: []APIKey{
+		{ID: 1, UserID: 2, Pubkey: "pubkey", Secret: "secret", Expire: 1400086400},
+	},
+	Passkeys: []PasskeyRecord{
+		{ID: 1, UserID: 1, Passkey: "passkey3", CreateTime: 1400000000},
+	},
+	FileUsers: []FileUserRecord{
+		{FileID: 1, UserID: 1, IP: "10.0.0.1", Active: true, Completed: true, Announced: 3, Uploaded: 1000,
+			Time: 1400000200, LastEvent: "completed", LastEventTime: 1400000200, Snatched: true},
+		{FileID: 1, UserID: 2, IP: "10.0.0.2", Active: true, Announced: 1, Downloaded: 500, Left: 500,
+			Time: 1400000300, LastEvent: "started", LastEventTime: 1400000300},
+	},
+}
+
+// TestSnapshot verifies that a snapshot exported from one database may be imported into a fresh
+// database, and that import refuses to replace existing state unless forced
+func TestSnapshot(t *testing.T) {
+	log.Println("TestSnapshot()")
+
+	// Serve in-memory databases, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+
+	source := newSnapshotDB(mockSnapshot)
+	dest := newSnapshotDB(Snapshot{})
+
+	var current dbModel
+	DBConnectFunc = func() (dbModel, error) {
+		return current, nil
+	}
+
+	// Export snapshot from source database
+	buf := bytes.NewBuffer(nil)
+	current = source
+	if err := ExportSnapshot(buf); err != nil {
+		t.Fatalf("Failed to export snapshot: %s", err.Error())
+	}
+	exported := buf.Bytes()
+
+	// Import snapshot into fresh database
+	current = dest
+	if err := ImportSnapshot(bytes.NewReader(exported), false); err != nil {
+		t.Fatalf("Failed to import snapshot: %s", err.Error())
+	}
+
+	// Verify all state was restored
+	s := *dest.snapshot
+	if s.Version != SnapshotVersion || s.SchemaVersion != 3 {
+		t.Fatalf("Snapshot version, expected %d/%d, got %d/%d", SnapshotVersion, 3, s.Version, s.SchemaVersion)
+	}
+	if !reflect.DeepEqual(s.Users, mockSnapshot.Users) {
+		t.Fatalf("Users, expected %+v, got %+v", mockSnapshot.Users, s.Users)
+	}
+	if !reflect.DeepEqual(s.Files, mockSnapshot.Files) {
+		t.Fatalf("Files, expected %+v, got %+v", mockSnapshot.Files, s.Files)
+	}
+	if !reflect.DeepEqual(s.APIKeys, mockSnapshot.APIKeys) {
+		t.Fatalf("APIKeys, expected %+v, got %+v", mockSnapshot.APIKeys, s.APIKeys)
+	}
+	if !reflect.DeepEqual(s.Passkeys, mockSnapshot.Passkeys) {
+		t.Fatalf("Passkeys, expected %+v, got %+v", mockSnapshot.Passkeys, s.Passkeys)
+	}
+	if !reflect.DeepEqual(s.FileUsers, mockSnapshot.FileUsers) {
+		t.Fatalf("FileUsers, expected %+v, got %+v", mockSnapshot.FileUsers, s.FileUsers)
+	}
+
+	// Verify import into a non-empty database is refused, unless forced
+	if err := ImportSnapshot(bytes.NewReader(exported), false); err != ErrSnapshotNotEmpty {
+		t.Fatalf("Import into non-empty database, expected ErrSnapshotNotEmpty, got: %v", err)
+	}
+	if err := ImportSnapshot(bytes.NewReader(exported), true); err != nil {
+		t.Fatalf("Failed to force import snapshot: %s", err.Error())
+	}
+
+	// Verify snapshots from a newer schema are refused
+	dest.schema = []int{1, 2}
+	if err := ImportSnapshot(bytes.NewReader(exported), true); err == nil {
+		t.Fatalf("Import of snapshot with newer schema succeeded")
+	}
+
+	// Verify unknown formats and versions are refused
+	for _, input := range []string{`{}`, `{"format":"goat-snapshot","version":99}`, `not json`} {
+		if err := ImportSnapshot(strings.NewReader(input), true); err == nil {
+			t.Fatalf("Import of invalid snapshot succeeded: %s", input)
+		}
+	}
+}
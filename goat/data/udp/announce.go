@@ -186,6 +186,7 @@ func (u AnnounceRequest) ToValues() url.Values {
 
 	// Copy all fields into query map
 	query.Set("info_hash", string(u.InfoHash))
+	query.Set("peer_id", string(u.PeerID))
 
 	// Integer fields
 	query.Set("downloaded", strconv.FormatUint(u.Downloaded, 10))
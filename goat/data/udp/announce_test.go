@@ -66,7 +66,7 @@ func TestAnnounceResponse(t *testing.T) {
 		Interval: 3600,
 		Leechers: 1,
 		Seeders:  1,
-		PeerList: []data.Peer{data.Peer{"127.0.0.1", 8080}, data.Peer{"192.168.1.1", 4040}},
+		PeerList: []data.Peer{data.Peer{IP: "127.0.0.1", Port: 8080}, data.Peer{IP: "192.168.1.1", Port: 4040}},
 	}
 
 	// Marshal to binary representation
@@ -2,12 +2,34 @@ package data
 
 import (
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 
-	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/mdlayher/goat/goat/common"
 )
 
+const (
+	// defaultUsernamePattern is the pattern usernames must match, if none is configured
+	defaultUsernamePattern = "^[a-z0-9_.-]+$"
+
+	// defaultUsernameMinLength is the minimum username length, if none is configured
+	defaultUsernameMinLength = 2
+
+	// maxUsernameLength is the maximum username length, limited by the size of the username column
+	maxUsernameLength = 20
+)
+
+var (
+	// ErrUsernamePattern is returned when a username contains disallowed characters
+	ErrUsernamePattern = errors.New("username contains invalid characters")
+
+	// ErrUsernameTaken is returned when a username is already in use, ignoring case
+	ErrUsernameTaken = errors.New("username is already taken")
+)
+
 // UserRecord represents a user on the tracker
 type UserRecord struct {
 	ID           int    `json:"id"`
@@ -15,6 +37,8 @@ type UserRecord struct {
 	Password     string `json:"password"`
 	Passkey      string `json:"passkey"`
 	TorrentLimit int    `db:"torrent_limit" json:"torrentLimit"`
+	Admin        bool   `json:"admin"`
+	Banned       bool   `json:"banned"`
 }
 
 // UserRecordRepository is used to contain methods to load multiple UserRecord structs
@@ -26,6 +50,8 @@ type JSONUserRecord struct {
 	ID           int    `json:"id"`
 	Username     string `json:"username"`
 	TorrentLimit int    `json:"torrentLimit"`
+	Admin        bool   `json:"admin"`
+	Banned       bool   `json:"banned"`
 }
 
 // ToJSON converts a UserRecord to a JSONUserRecord struct
@@ -35,22 +61,73 @@ func (u UserRecord) ToJSON() (JSONUserRecord, error) {
 	j.ID = u.ID
 	j.Username = u.Username
 	j.TorrentLimit = u.TorrentLimit
+	j.Admin = u.Admin
+	j.Banned = u.Banned
 
 	return j, nil
 }
 
+// NormalizeUsername trims whitespace from a username, and folds it to lowercase, so that
+// usernames which differ only in case are considered identical
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// ValidateUsername verifies that a normalized username is of configured length and matches
+// the configured pattern
+func ValidateUsername(username string) error {
+	username = NormalizeUsername(username)
+
+	// Load length bounds, using defaults if unset
+	min := common.Static.Config.Users.UsernameMinLength
+	if min <= 0 {
+		min = defaultUsernameMinLength
+	}
+	max := common.Static.Config.Users.UsernameMaxLength
+	if max <= 0 || max > maxUsernameLength {
+		max = maxUsernameLength
+	}
+
+	// Check username length in characters
+	if length := utf8.RuneCountInString(username); length < min || length > max {
+		return fmt.Errorf("username must be between %d and %d characters", min, max)
+	}
+
+	// Load pattern, using default if unset
+	pattern := common.Static.Config.Users.UsernamePattern
+	if pattern == "" {
+		pattern = defaultUsernamePattern
+	}
+
+	// Check username against pattern
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid username pattern: %s", err.Error())
+	}
+	if !re.MatchString(username) {
+		return ErrUsernamePattern
+	}
+
+	return nil
+}
+
 // Create a UserRecord, using defined parameters
 func (u *UserRecord) Create(username string, password string, torrentLimit int) error {
-	// Set username and torrent limit
-	u.Username = username
+	// Validate username
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+
+	// Set normalized username and torrent limit
+	u.Username = NormalizeUsername(username)
 	u.TorrentLimit = torrentLimit
 
-	// Generate password hash using bcrypt
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	// Generate password hash using configured algorithm
+	hash, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hash)
+	u.Password = hash
 
 	// Randomly generate a new passkey
 	sha := sha1.New()
@@ -62,16 +139,21 @@ func (u *UserRecord) Create(username string, password string, torrentLimit int)
 	return nil
 }
 
-// Delete UserRecord from storage
+// Delete UserRecord from storage, along with the user's files_users rows, API keys, and passkeys.
+// Users which have not been loaded from storage have no ID, and are ignored.
 func (u UserRecord) Delete() error {
+	if u.ID == 0 {
+		return nil
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
 		return err
 	}
 
-	// Delete UserRecord
-	if err = db.DeleteUserRecord(u.Username, "username"); err != nil {
+	// Delete UserRecord and related records
+	if err = db.DeleteUserRecord(u.ID); err != nil {
 		return err
 	}
 
@@ -85,14 +167,38 @@ func (u UserRecord) Delete() error {
 
 // Save UserRecord to storage
 func (u UserRecord) Save() error {
+	// Normalize username.  Usernames are validated only on creation, so that existing users whose
+	// names predate the configured rules may still be saved.
+	u.Username = NormalizeUsername(u.Username)
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
 		return err
 	}
 
+	// Insert new users, so that concurrent registrations of the same username cannot overwrite
+	// one another.  The database reports ErrUsernameTaken if the username is already in use.
+	if u.ID == 0 {
+		if err := withRetry(func() error { return db.InsertUserRecord(u) }); err != nil {
+			return err
+		}
+
+		return db.Close()
+	}
+
+	// Ensure username is not already in use by another user
+	// note: usernames are stored normalized, so this check ignores case
+	existing, err := db.LoadUserRecord(u.Username, "username")
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err == nil && existing.ID != u.ID {
+		return ErrUsernameTaken
+	}
+
 	// Save UserRecord
-	if err := db.SaveUserRecord(u); err != nil {
+	if err := withRetry(func() error { return db.SaveUserRecord(u) }); err != nil {
 		return err
 	}
 
@@ -106,6 +212,11 @@ func (u UserRecord) Save() error {
 
 // Load UserRecord from storage
 func (u UserRecord) Load(id interface{}, col string) (UserRecord, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("users", col); err != nil {
+		return UserRecord{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -170,6 +281,45 @@ func (u UserRecord) Downloaded() (int64, error) {
 	return downloaded, nil
 }
 
+// RatioInfinite is the ratio reported for a user who has uploaded, but not downloaded, any bytes
+const RatioInfinite = -1
+
+// Ratio loads this user's total upload and download, and calculates their share ratio
+func (u UserRecord) Ratio() (float64, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Retrieve total bytes user has uploaded and downloaded
+	uploaded, downloaded, err := db.GetUserTransfer(u.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return 0, err
+	}
+
+	return ratio(uploaded, downloaded), nil
+}
+
+// ratio calculates a share ratio from bytes uploaded and downloaded, returning RatioInfinite if
+// only uploaded is non-zero, and 0 if neither is
+func ratio(uploaded int64, downloaded int64) float64 {
+	if downloaded == 0 {
+		if uploaded > 0 {
+			return RatioInfinite
+		}
+
+		return 0
+	}
+
+	return float64(uploaded) / float64(downloaded)
+}
+
 // Seeding counts the number of torrents this user is seeding
 func (u UserRecord) Seeding() (int, error) {
 	// Open database connection
@@ -201,7 +351,7 @@ func (u UserRecord) Leeching() (int, error) {
 	}
 
 	// Retrieve total number of torrents user is actively leeching
-	leeching, err := db.GetUserSeeding(u.ID)
+	leeching, err := db.GetUserLeeching(u.ID)
 	if err != nil {
 		return 0, err
 	}
@@ -214,6 +364,50 @@ func (u UserRecord) Leeching() (int, error) {
 	return leeching, nil
 }
 
+// ActiveTorrentCount counts the number of distinct torrents a user is actively seeding or leeching
+func ActiveTorrentCount(userID int) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Retrieve total number of torrents user is active on
+	active, err := db.GetUserActive(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return 0, err
+	}
+
+	return active, nil
+}
+
+// ActiveOnFile determines if a user is actively seeding or leeching a torrent, from any IP
+func ActiveOnFile(userID int, fileID int) (bool, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return false, err
+	}
+
+	// Check for any active file/user relationship records
+	active, err := db.GetUserFileActive(userID, fileID)
+	if err != nil {
+		return false, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
 // All loads all UserRecord structs from storage
 func (u UserRecordRepository) All() ([]UserRecord, error) {
 	users := make([]UserRecord, 0)
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"errors"
 	"log"
 	"testing"
 
@@ -12,7 +13,7 @@ func TestUserRecord(t *testing.T) {
 	log.Println("TestUserRecord()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -49,4 +50,422 @@ func TestUserRecord(t *testing.T) {
 	if err := user2.Delete(); err != nil {
 		t.Fatalf("Failed to delete UserRecord: %s", err.Error())
 	}
+
+	// Verify deleted user is reported as not found
+	if user3, err := user.Load("test", "username"); err != ErrNotFound || user3 != (UserRecord{}) {
+		t.Fatalf("Load of deleted UserRecord, expected ErrNotFound, got: %+v %v", user3, err)
+	}
+}
+
+// loadErrorDB is a database backend which fails to load any user, used to verify database errors
+// are not mistaken for missing records
+type loadErrorDB struct {
+	dbModel
+}
+
+// Close does nothing, as there is no connection
+func (db loadErrorDB) Close() error {
+	return nil
+}
+
+// LoadUserRecord always fails with a database error
+func (db loadErrorDB) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
+	return UserRecord{}, errors.New("database failure")
+}
+
+// TestUserRecordLoadError verifies that database errors are returned from Load, rather than ErrNotFound
+func TestUserRecordLoadError(t *testing.T) {
+	log.Println("TestUserRecordLoadError()")
+
+	// Serve failing database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = func() (dbModel, error) {
+		return loadErrorDB{}, nil
+	}
+
+	user, err := new(UserRecord).Load("test", "username")
+	if err == nil || err == ErrNotFound {
+		t.Fatalf("Load with failing database, expected database error, got: %v", err)
+	}
+	if user != (UserRecord{}) {
+		t.Fatalf("Load with failing database returned user: %+v", user)
+	}
+}
+
+// loadColumnTests contains columns used to load a user, and whether or not they should be permitted
+var loadColumnTests = []struct {
+	col   string
+	valid bool
+}{
+	{"id", true},
+	{"username", true},
+	{"passkey", true},
+	{"password", false},
+	{"", false},
+	{"username` = 'x' OR 1=1 -- ", false},
+	{"id; DROP TABLE users", false},
+}
+
+// TestUserRecordLoadColumn verifies that Load rejects columns which are not permitted, before
+// any query reaches the database
+func TestUserRecordLoadColumn(t *testing.T) {
+	log.Println("TestUserRecordLoadColumn()")
+
+	// Serve failing database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	DBConnectFunc = func() (dbModel, error) {
+		return loadErrorDB{}, nil
+	}
+
+	// Permitted columns reach the database, which fails, while others are rejected up front
+	for _, test := range loadColumnTests {
+		_, err := new(UserRecord).Load("test", test.col)
+		if (err != ErrInvalidColumn) != test.valid {
+			t.Fatalf("Load(%q), expected valid %v, got error: %v", test.col, test.valid, err)
+		}
+	}
+}
+
+// insertDB is a database backend which records inserted users, rejecting usernames already stored
+type insertDB struct {
+	dbModel
+	users map[string]UserRecord
+}
+
+// Close does nothing, as there is no connection
+func (db insertDB) Close() error {
+	return nil
+}
+
+// InsertUserRecord stores a user, unless its username is already taken
+func (db insertDB) InsertUserRecord(u UserRecord) error {
+	if _, ok := db.users[u.Username]; ok {
+		return ErrUsernameTaken
+	}
+
+	db.users[u.Username] = u
+	return nil
+}
+
+// LoadUserRecord loads a stored user by username
+func (db insertDB) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
+	u, ok := db.users[id.(string)]
+	if !ok {
+		return UserRecord{}, ErrNotFound
+	}
+
+	return u, nil
+}
+
+// SaveUserRecord stores an existing user
+func (db insertDB) SaveUserRecord(u UserRecord) error {
+	db.users[u.Username] = u
+	return nil
+}
+
+// TestUserRecordSaveInsert verifies that new users are inserted rather than upserted, so that a
+// registration cannot overwrite an existing user with the same username
+func TestUserRecordSaveInsert(t *testing.T) {
+	log.Println("TestUserRecordSaveInsert()")
+
+	// Serve recording database, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	db := insertDB{users: map[string]UserRecord{}}
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+
+	// Verify new user is inserted, using the normalized username
+	if err := (UserRecord{Username: " Alice ", Passkey: "first"}).Save(); err != nil {
+		t.Fatalf("Failed to save new user: %s", err.Error())
+	}
+	if db.users["alice"].Passkey != "first" {
+		t.Fatalf("New user was not inserted: %+v", db.users)
+	}
+
+	// Verify second registration of the same username is rejected, leaving the first intact
+	if err := (UserRecord{Username: "ALICE", Passkey: "second"}).Save(); err != ErrUsernameTaken {
+		t.Fatalf("Duplicate registration, expected ErrUsernameTaken, got: %v", err)
+	}
+	if db.users["alice"].Passkey != "first" {
+		t.Fatalf("Duplicate registration overwrote existing user: %+v", db.users["alice"])
+	}
+}
+
+// TestUserRecordSaveLegacyUsername verifies that existing users whose usernames predate the
+// configured username rules may still be saved, so they can be banned or change passwords
+func TestUserRecordSaveLegacyUsername(t *testing.T) {
+	log.Println("TestUserRecordSaveLegacyUsername()")
+
+	// Use default validation settings, and serve recording database, restoring the defaults afterwards
+	common.Static.Config = common.Conf{}
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+	db := insertDB{users: map[string]UserRecord{}}
+	DBConnectFunc = func() (dbModel, error) {
+		return db, nil
+	}
+
+	// Verify existing users with invalid usernames are saved, with normalized usernames
+	for _, username := range []string{"John Smith", "j"} {
+		if err := ValidateUsername(username); err == nil {
+			t.Fatalf("Username %q should be invalid", username)
+		}
+
+		user := UserRecord{ID: 1, Username: username, Banned: true}
+		if err := user.Save(); err != nil {
+			t.Fatalf("Failed to save existing user %q: %s", username, err.Error())
+		}
+		if !db.users[NormalizeUsername(username)].Banned {
+			t.Fatalf("Existing user %q was not saved: %+v", username, db.users)
+		}
+	}
+}
+
+// transferDB is a database backend which reports fixed upload and download totals for any user
+type transferDB struct {
+	dbModel
+	uploaded   int64
+	downloaded int64
+}
+
+// Close does nothing, as there is no connection
+func (db transferDB) Close() error {
+	return nil
+}
+
+// GetUserTransfer returns the fixed upload and download totals
+func (db transferDB) GetUserTransfer(uid int) (int64, int64, error) {
+	return db.uploaded, db.downloaded, nil
+}
+
+// ratioTests contains bytes uploaded and downloaded, and the expected share ratio
+var ratioTests = []struct {
+	uploaded   int64
+	downloaded int64
+	ratio      float64
+}{
+	{0, 0, 0},
+	{1024, 0, RatioInfinite},
+	{0, 1024, 0},
+	{1024, 1024, 1},
+	{3072, 1024, 3},
+	{512, 1024, 0.5},
+}
+
+// TestUserRecordRatio verifies that a user's share ratio is calculated from their total upload and
+// download, including when nothing has been downloaded
+func TestUserRecordRatio(t *testing.T) {
+	log.Println("TestUserRecordRatio()")
+
+	// Serve fixed totals, restoring the default afterwards
+	dbConnect := DBConnectFunc
+	defer func() {
+		DBConnectFunc = dbConnect
+	}()
+
+	// Iterate all tests
+	for _, test := range ratioTests {
+		db := transferDB{uploaded: test.uploaded, downloaded: test.downloaded}
+		DBConnectFunc = func() (dbModel, error) {
+			return db, nil
+		}
+
+		ratio, err := new(UserRecord).Ratio()
+		if err != nil {
+			t.Fatalf("Failed to calculate ratio: %s", err.Error())
+		}
+		if ratio != test.ratio {
+			t.Fatalf("Ratio(%d/%d), expected %g, got %g", test.uploaded, test.downloaded, test.ratio, ratio)
+		}
+	}
+}
+
+// validateUsernameTests contains usernames and whether or not they should be valid
+var validateUsernameTests = []struct {
+	username string
+	valid    bool
+}{
+	{"test", true},
+	{"  test\t", true},
+	{"Test.User_1", true},
+	{"ab", true},
+	{"abcdefghijklmnopqrst", true},
+	{"", false},
+	{"   ", false},
+	{"a", false},
+	{" a ", false},
+	{"abcdefghijklmnopqrstu", false},
+	{"test user", false},
+	{"test!", false},
+}
+
+// TestValidateUsername verifies that usernames are normalized and validated properly
+func TestValidateUsername(t *testing.T) {
+	log.Println("TestValidateUsername()")
+
+	// Use default validation settings
+	common.Static.Config = common.Conf{}
+
+	// Verify normalization
+	if username := NormalizeUsername("  Alice\n"); username != "alice" {
+		t.Fatalf("NormalizeUsername, expected alice, got %s", username)
+	}
+
+	// Iterate all tests
+	for _, test := range validateUsernameTests {
+		err := ValidateUsername(test.username)
+		if test.valid && err != nil {
+			t.Fatalf("Username %q should be valid, got error: %s", test.username, err.Error())
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("Username %q should be invalid", test.username)
+		}
+	}
+
+	// Verify configured bounds are respected
+	common.Static.Config.Users.UsernameMinLength = 5
+	if err := ValidateUsername("test"); err == nil {
+		t.Fatalf("Username shorter than configured minimum should be invalid")
+	}
+}
+
+// TestUserRecordDuplicate verifies that usernames differing only in case cannot both be saved
+func TestUserRecordDuplicate(t *testing.T) {
+	log.Println("TestUserRecordDuplicate()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Create and save a user
+	user := new(UserRecord)
+	if err := user.Create("Alice", "test", 100); err != nil {
+		t.Fatalf("Failed to create UserRecord: %s", err.Error())
+	}
+
+	if user.Username != "alice" {
+		t.Fatalf("user.Username, expected alice, got %s", user.Username)
+	}
+
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save UserRecord: %s", err.Error())
+	}
+
+	// Create a second user, with the same username in a different case
+	user2 := new(UserRecord)
+	if err := user2.Create(" ALICE ", "test2", 100); err != nil {
+		t.Fatalf("Failed to create UserRecord: %s", err.Error())
+	}
+
+	// Verify second user cannot be saved
+	if err := user2.Save(); err != ErrUsernameTaken {
+		t.Fatalf("Expected ErrUsernameTaken, got: %v", err)
+	}
+
+	// Verify existing user can still be saved
+	user3, err := user.Load("alice", "username")
+	if user3 == (UserRecord{}) || err != nil {
+		t.Fatalf("Failed to load UserRecord: %v", err)
+	}
+
+	if err := user3.Save(); err != nil {
+		t.Fatalf("Failed to save existing UserRecord: %s", err.Error())
+	}
+
+	// Delete mock user
+	if err := user3.Delete(); err != nil {
+		t.Fatalf("Failed to delete UserRecord: %s", err.Error())
+	}
+}
+
+// TestUserRecordDeleteCascade verifies that deleting a user also deletes their files_users rows,
+// API keys, and passkeys, and that deleting a user which was never loaded does nothing
+func TestUserRecordDeleteCascade(t *testing.T) {
+	log.Println("TestUserRecordDeleteCascade()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Verify users without an ID never reach the database
+	dbConnect := DBConnectFunc
+	DBConnectFunc = func() (dbModel, error) {
+		return nil, errors.New("database should not be reached")
+	}
+	if err := new(UserRecord).Delete(); err != nil {
+		t.Fatalf("Delete of zero-value UserRecord, expected no error, got: %s", err.Error())
+	}
+	if err := (UserRecord{Username: "cascade"}).Delete(); err != nil {
+		t.Fatalf("Delete of unloaded UserRecord, expected no error, got: %s", err.Error())
+	}
+	DBConnectFunc = dbConnect
+
+	// Create, save, and load a mock user to fetch ID
+	mockUser := new(UserRecord)
+	if err := mockUser.Create("cascade", "test", 100); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := mockUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	user, err := mockUser.Load("cascade", "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// Save records belonging to the mock user
+	fileUser := FileUserRecord{FileID: 1, UserID: user.ID, IP: "127.0.0.1"}
+	if err := fileUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock FileUserRecord: %s", err.Error())
+	}
+
+	key, err := NewAPIKey(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to save mock APIKey: %s", err.Error())
+	}
+
+	passkey := new(PasskeyRecord)
+	if err := passkey.Create(user.ID); err != nil {
+		t.Fatalf("Failed to create mock PasskeyRecord: %s", err.Error())
+	}
+	if err := passkey.Save(); err != nil {
+		t.Fatalf("Failed to save mock PasskeyRecord: %s", err.Error())
+	}
+
+	// Delete the mock user
+	if err := user.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+
+	// Verify the user and all related records are gone
+	if _, err := user.Load(user.ID, "id"); err != ErrNotFound {
+		t.Fatalf("Load of deleted user, expected ErrNotFound, got: %v", err)
+	}
+	if _, err := fileUser.Load(fileUser.FileID, user.ID, fileUser.IP); err != ErrNotFound {
+		t.Fatalf("Load of deleted user's FileUserRecord, expected ErrNotFound, got: %v", err)
+	}
+	if _, err := key.Load(key.Pubkey, "pubkey"); err != ErrNotFound {
+		t.Fatalf("Load of deleted user's APIKey, expected ErrNotFound, got: %v", err)
+	}
+	if _, err := passkey.Load(passkey.Passkey, "passkey"); err != ErrNotFound {
+		t.Fatalf("Load of deleted user's PasskeyRecord, expected ErrNotFound, got: %v", err)
+	}
 }
@@ -30,6 +30,11 @@ func (w WhitelistRecord) Delete() error {
 
 // Load WhitelistRecord from storage
 func (w WhitelistRecord) Load(id interface{}, col string) (WhitelistRecord, error) {
+	// Reject columns which may not be used to load records
+	if err := checkColumn("whitelist", col); err != nil {
+		return WhitelistRecord{}, err
+	}
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
@@ -57,7 +62,7 @@ func (w WhitelistRecord) Save() error {
 	}
 
 	// Save WhitelistRecord
-	if err := db.SaveWhitelistRecord(w); err != nil {
+	if err := withRetry(func() error { return db.SaveWhitelistRecord(w) }); err != nil {
 		return err
 	}
 
@@ -12,7 +12,7 @@ func TestWhitelistRecord(t *testing.T) {
 	log.Println("TestWhitelistRecord()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
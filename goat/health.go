@@ -0,0 +1,85 @@
+package goat
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// ready is set to 1 once startup has completed, including database migrations, and cleared when a
+// graceful shutdown begins
+var ready int32
+
+// healthResponse is the JSON body returned by health and readiness checks
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// dbHealthCheck caches the result of a database check, so that frequent health probes do not
+// overload the database
+type dbHealthCheck struct {
+	sync.Mutex
+	ok      bool
+	checked time.Time
+}
+
+// dbHealth is the database check used by all health and readiness checks
+var dbHealth = new(dbHealthCheck)
+
+// OK reports whether the database is reachable, checking it again only once the configured
+// cache interval has elapsed since the last check
+func (h *dbHealthCheck) OK() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	now := common.Now()
+	interval := time.Duration(common.Static.Config.Health.CacheInterval) * time.Second
+	if h.checked.IsZero() || now.Sub(h.checked) >= interval {
+		h.ok = data.DBPing()
+		h.checked = now
+	}
+
+	return h.ok
+}
+
+// serveHealth serves the health and readiness checks, returning true if the path was one of these
+// checks.  /health reports whether the database is reachable, and /ready additionally requires that
+// startup has completed.  Both return HTTP 503 if they fail.
+func serveHealth(w http.ResponseWriter, path string) bool {
+	var ok bool
+	switch path {
+	case "/health":
+		ok = dbHealth.OK()
+	case "/ready":
+		ok = atomic.LoadInt32(&ready) == 1 && dbHealth.OK()
+	default:
+		return false
+	}
+
+	res := healthResponse{Status: "ok"}
+	code := http.StatusOK
+	if !ok {
+		res.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		log.Println(err.Error())
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(body); err != nil {
+		log.Println(err.Error())
+	}
+
+	return true
+}
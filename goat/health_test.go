@@ -0,0 +1,127 @@
+package goat
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// healthTests contains a health or readiness check path, whether the database is reachable, whether
+// startup has completed, and the expected HTTP status code and status
+var healthTests = []struct {
+	path   string
+	dbOK   bool
+	ready  bool
+	code   int
+	status string
+}{
+	{"/health", true, false, 200, "ok"},
+	{"/health", false, true, 503, "unavailable"},
+	{"/ready", true, true, 200, "ok"},
+	{"/ready", true, false, 503, "unavailable"},
+	{"/ready", false, true, 503, "unavailable"},
+}
+
+// TestServeHealth verifies that health and readiness checks report the state of the database and
+// of startup
+func TestServeHealth(t *testing.T) {
+	log.Println("TestServeHealth()")
+
+	// Check the database on every probe
+	common.Static.Config.Health.CacheInterval = 0
+
+	// Fake database ping and readiness, restoring the defaults afterwards
+	dbPing := data.DBPingFunc
+	defer func() {
+		data.DBPingFunc = dbPing
+		atomic.StoreInt32(&ready, 0)
+		dbHealth = new(dbHealthCheck)
+	}()
+
+	// Iterate all tests
+	for _, test := range healthTests {
+		dbOK := test.dbOK
+		data.DBPingFunc = func() bool {
+			return dbOK
+		}
+
+		atomic.StoreInt32(&ready, 0)
+		if test.ready {
+			atomic.StoreInt32(&ready, 1)
+		}
+
+		r, err := http.NewRequest("GET", "http://localhost:8080"+test.path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+		w := httptest.NewRecorder()
+		routeHTTP(w, r)
+
+		var res healthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Failed to unmarshal %s JSON: %s", test.path, err.Error())
+		}
+		if w.Code != test.code || res.Status != test.status {
+			t.Fatalf("%s (database %v, ready %v), expected HTTP %d %q, got HTTP %d %q",
+				test.path, test.dbOK, test.ready, test.code, test.status, w.Code, res.Status)
+		}
+	}
+
+	// Verify other paths are not health checks
+	if serveHealth(httptest.NewRecorder(), "/announce") {
+		t.Fatalf("serveHealth handled /announce")
+	}
+}
+
+// TestDBHealthCheckCache verifies that the database is checked at most once per cache interval
+func TestDBHealthCheckCache(t *testing.T) {
+	log.Println("TestDBHealthCheckCache()")
+
+	// Use a fake clock, restoring the default afterwards
+	now := time.Unix(1400000000, 0)
+	common.Now = func() time.Time {
+		return now
+	}
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Count database pings, restoring the default afterwards
+	pings := 0
+	dbOK := true
+	dbPing := data.DBPingFunc
+	data.DBPingFunc = func() bool {
+		pings++
+		return dbOK
+	}
+	defer func() {
+		data.DBPingFunc = dbPing
+	}()
+
+	common.Static.Config.Health.CacheInterval = 5
+	check := new(dbHealthCheck)
+
+	// Verify the first check pings the database, and later checks within the interval do not
+	if !check.OK() || !check.OK() || pings != 1 {
+		t.Fatalf("Checks within cache interval, expected healthy with 1 ping, got %d pings", pings)
+	}
+
+	// Verify a failure is not noticed until the interval has elapsed
+	dbOK = false
+	now = now.Add(4 * time.Second)
+	if !check.OK() {
+		t.Fatalf("Check within cache interval did not reuse healthy result")
+	}
+
+	now = now.Add(time.Second)
+	if check.OK() || pings != 2 {
+		t.Fatalf("Check after cache interval, expected unhealthy with 2 pings, got %d pings", pings)
+	}
+}
@@ -1,13 +1,24 @@
 package goat
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/mdlayher/goat/goat/api"
 	"github.com/mdlayher/goat/goat/common"
@@ -15,16 +26,48 @@ import (
 	"github.com/mdlayher/goat/goat/tracker"
 )
 
+// maxAnnounceBodySize is the maximum number of bytes read from a POST announce body,
+// both before and after decompression, preventing "zip bomb" style attacks
+const maxAnnounceBodySize = 16 * 1024
+
+// HTTP errors
+var (
+	// errBodyEncoding is returned when a client sends an announce body with an unknown Content-Encoding
+	errBodyEncoding = errors.New("http: unsupported announce body encoding")
+	// errBodyTooLarge is returned when a client sends an announce body which exceeds the maximum size
+	errBodyTooLarge = errors.New("http: announce body exceeds maximum size")
+)
+
 // Handle incoming HTTP connections and serve
 func handleHTTP(l net.Listener, sendChan chan bool, recvChan chan bool) {
+	// Serve HTTP requests, closing keep-alive connections which remain idle too long
+	server := &http.Server{
+		IdleTimeout: time.Duration(common.Static.Config.KeepAlive.IdleTimeout) * time.Second,
+	}
+
 	// Create shutdown function
 	go func(l net.Listener, sendChan chan bool, recvChan chan bool) {
 		// Wait for done signal
 		<-sendChan
 
-		// Close listener
-		if err := l.Close(); err != nil {
-			log.Println(err.Error())
+		// Stop accepting connections, and wait for in-flight requests to complete, up to the
+		// configured grace period
+		grace := time.Duration(common.Static.Config.Shutdown.GracePeriod) * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		if err := server.Shutdown(ctx); err != nil {
+			// Grace period elapsed, so force any remaining connections closed
+			log.Println("HTTP(S) listener grace period elapsed, closing remaining connections")
+			if err := server.Close(); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		cancel()
+
+		// Clean up Unix socket file, if one was used
+		if l.Addr().Network() == "unix" {
+			if err := removeSocket(l.Addr().String()); err != nil {
+				log.Println(err.Error())
+			}
 		}
 
 		log.Println("HTTP(S) listener stopped")
@@ -36,8 +79,7 @@ func handleHTTP(l net.Listener, sendChan chan bool, recvChan chan bool) {
 		log.Println("API functionality enabled")
 	}
 
-	// Serve HTTP requests
-	if err := http.Serve(l, nil); err != nil {
+	if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
 		// Ignore connection closing error, caused by stopping listener
 		if !strings.Contains(err.Error(), "use of closed network connection") {
 			log.Println("Could not serve HTTP(S), exiting now")
@@ -46,14 +88,98 @@ func handleHTTP(l net.Listener, sendChan chan bool, recvChan chan bool) {
 	}
 }
 
+// headTrackerMessage is the tracker error returned in answer to HEAD requests on tracker functions,
+// which are never processed as a tracker call
+const headTrackerMessage = "HEAD requests are not processed, use GET"
+
+// headResponseWriter records the status and length of a response to a HEAD request, discarding its body
+type headResponseWriter struct {
+	header http.Header
+	code   int
+	length int
+}
+
+// Header returns the header map which will be sent with the response
+func (h *headResponseWriter) Header() http.Header {
+	return h.header
+}
+
+// WriteHeader records the HTTP status code of the response
+func (h *headResponseWriter) WriteHeader(code int) {
+	if h.code == 0 {
+		h.code = code
+	}
+}
+
+// Write counts the length of the response body, but discards it.  As with any other response, the
+// content type is detected from the body if it was not set.
+func (h *headResponseWriter) Write(buf []byte) (int, error) {
+	if h.code == 0 {
+		h.code = http.StatusOK
+	}
+
+	if h.length == 0 && h.header.Get("Content-Type") == "" {
+		h.header.Set("Content-Type", http.DetectContentType(buf))
+	}
+
+	h.length += len(buf)
+	return len(buf), nil
+}
+
+// basicAuthCall reports whether an API call is authenticated using HTTP Basic + bcrypt, rather than
+// HMAC, as is the case for login, management of API keys, and password changes
+func basicAuthCall(method string, apiMethod string) bool {
+	return (method == "POST" && (apiMethod == "login" || apiMethod == "key")) ||
+		(method == "DELETE" && apiMethod == "key") ||
+		(method == "PUT" && apiMethod == "user")
+}
+
+// apiRateLimitKey returns the key used to rate limit an authenticated API call: the public key of its
+// API key if one was used, or otherwise the authenticated user
+func apiRateLimitKey(apiAuth api.APIAuthenticator, session data.UserRecord) string {
+	if keyed, ok := apiAuth.(interface {
+		Pubkey() string
+	}); ok && keyed.Pubkey() != "" {
+		return "pubkey:" + keyed.Pubkey()
+	}
+
+	return "user:" + strconv.Itoa(session.ID)
+}
+
 // Parse incoming HTTP connections before making tracker calls
 func parseHTTP(w http.ResponseWriter, r *http.Request) {
+	// HEAD requests are handled exactly as GET requests, but only headers are sent.  Tracker
+	// functions answer HEAD requests with a tracker error, without making a tracker call.
+	if r.Method == "HEAD" {
+		head := &headResponseWriter{header: w.Header()}
+		routeHTTP(head, r)
+
+		if head.code == 0 {
+			head.code = http.StatusOK
+		}
+
+		// Report the length the response body would have had
+		w.Header().Set("Content-Length", strconv.Itoa(head.length))
+		w.WriteHeader(head.code)
+		return
+	}
+
+	routeHTTP(w, r)
+}
+
+// routeHTTP routes HTTP requests to the API or the tracker
+func routeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Create a tracker to handle this client
 	httpTracker := tracker.HTTPTracker{}
 
 	// Add header to identify goat
 	w.Header().Add("Server", fmt.Sprintf("%s/%s", App, Version))
 
+	// Answer health and readiness checks, regardless of maintenance mode
+	if serveHealth(w, r.URL.Path) {
+		return
+	}
+
 	// Store current URL path
 	url := r.URL.Path
 
@@ -78,17 +204,43 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 		atomic.AddInt64(&common.Static.API.Hour, 1)
 		atomic.AddInt64(&common.Static.API.Total, 1)
 
+		// Require an API call, such as /api/status, before choosing an authenticator
+		if len(urlArr) < 3 {
+			http.Error(w, api.ErrorResponse("No API call"), 404)
+			return
+		}
+
 		// API authentication
 		var apiAuth api.APIAuthenticator
 
-		// For login, make use of HTTP Basic + bcrypt authenticator
-		if r.Method == "POST" && urlArr[2] == "login" {
+		if r.Method == "POST" && urlArr[2] == "user" {
+			// For registration, no authentication is required
+			apiAuth = new(api.NoAuthenticator)
+		} else if (r.Method == "GET" || r.Method == "HEAD") && urlArr[2] == "file" {
+			// For single file data, used by web frontends, accept either HMAC or HTTP Basic + bcrypt
+			apiAuth = new(api.HMACOrBasicAuthenticator)
+		} else if basicAuthCall(r.Method, urlArr[2]) {
+			// For login and API key management, make use of HTTP Basic + bcrypt authenticator
 			apiAuth = new(api.BasicAuthenticator)
 		} else {
 			// For all other calls, use HMAC authenticator
 			apiAuth = new(api.HMACAuthenticator)
 		}
 
+		// Unauthenticated calls are rate limited by client IP.  Other calls are only charged to the
+		// client IP when authentication fails, but are refused before authentication once those
+		// failures exhaust its bucket, so that failed password and signature checks cannot be used
+		// to exhaust the server.
+		ipKey := "ip:" + clientIP(r)
+		_, anonymous := apiAuth.(*api.NoAuthenticator)
+		if anonymous {
+			if api.RateLimited(w, ipKey) {
+				return
+			}
+		} else if api.RateLimitExhausted(w, ipKey) {
+			return
+		}
+
 		// Attempt authentication
 		clientErr, serverErr := apiAuth.Auth(r)
 
@@ -99,6 +251,9 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 				log.Println(serverErr.Error())
 			}
 
+			// Charge failed authentication to the client IP
+			api.Limiter.Allow(ipKey)
+
 			http.Error(w, api.ErrorResponse("Authentication failed: "+clientErr.Error()), 401)
 			return
 		}
@@ -118,34 +273,47 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Rate limit authenticated calls by the public key of their API key, or by user if HTTP
+		// Basic authentication was used
+		if !anonymous && api.RateLimited(w, apiRateLimitKey(apiAuth, session)) {
+			return
+		}
+
 		// Handle API calls, output JSON
 		api.Router(w, r, session)
 		return
 	}
 
+	// If configured, answer browsers and crawlers directly, so they do not reach the tracker
+	if common.Static.Config.ServeRobots && serveRobots(w, r.URL.Path) {
+		return
+	}
+
 	// Count incoming connections
 	atomic.AddInt64(&common.Static.HTTP.Minute, 1)
 	atomic.AddInt64(&common.Static.HTTP.HalfHour, 1)
 	atomic.AddInt64(&common.Static.HTTP.Hour, 1)
 	atomic.AddInt64(&common.Static.HTTP.Total, 1)
 
+	// Detect tracker function, and passkey if present in URL
+	url, passkey := trackerPath(urlArr)
+
 	// Check for maintenance mode
-	if common.Static.Maintenance {
-		// Return tracker error with maintenance message
-		if _, err := w.Write(httpTracker.Error("Maintenance: " + common.Static.StatusMessage)); err != nil {
+	if maintenance, message := common.Static.Maintenance.Get(); maintenance {
+		// Return a minimal announce response, so clients back off, or a tracker error with
+		// maintenance message for other calls.  Nothing is written to storage.
+		res := httpTracker.Error("Maintenance: " + message)
+		if url == "announce" {
+			res = httpTracker.Maintenance(message)
+		}
+
+		if _, err := w.Write(res); err != nil {
 			log.Println(err.Error())
 		}
 
 		return
 	}
 
-	// Detect if passkey present in URL
-	var passkey string
-	if len(urlArr) == 3 {
-		passkey = urlArr[1]
-		url = urlArr[2]
-	}
-
 	// Make sure URL is valid torrent function
 	if url != "announce" && url != "scrape" {
 		if _, err := w.Write(httpTracker.Error("Malformed announce")); err != nil {
@@ -155,6 +323,37 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Tracker functions allow GET and HEAD, and announce also allows POST
+	if r.Method != "GET" && r.Method != "HEAD" && !(r.Method == "POST" && url == "announce") {
+		allow := "GET, HEAD"
+		if url == "announce" {
+			allow = "GET, HEAD, POST"
+		}
+
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		if _, err := w.Write(httpTracker.Error("Method not allowed")); err != nil {
+			log.Println(err.Error())
+		}
+
+		return
+	}
+
+	// Answer HEAD requests on tracker functions with a fixed tracker error, before the client is
+	// checked or the request is captured, so that monitoring cannot change swarm state or storage
+	if r.Method == "HEAD" {
+		if _, err := w.Write(httpTracker.Error(headTrackerMessage)); err != nil {
+			log.Println(err.Error())
+		}
+
+		return
+	}
+
+	// Capture announce for later replay, if configured
+	if url == "announce" && announceCapture != nil {
+		announceCapture.Capture(r, passkey)
+	}
+
 	// Verify that torrent client is advertising its User-Agent, so we can use a whitelist
 	if r.Header.Get("User-Agent") == "" {
 		if _, err := w.Write(httpTracker.Error("Your client is not identifying itself")); err != nil {
@@ -169,7 +368,7 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 	// If configured, verify that torrent client is on whitelist
 	if common.Static.Config.Whitelist {
 		whitelist, err := new(data.WhitelistRecord).Load(client, "client")
-		if err != nil {
+		if err != nil && err != data.ErrNotFound {
 			log.Println(err.Error())
 		}
 
@@ -203,17 +402,47 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse querystring into a Values map
-	query := r.URL.Query()
+	query, err := trackerQuery(r.URL.RawQuery)
+	if err != nil {
+		if _, err := w.Write(httpTracker.Error("Malformed info_hash")); err != nil {
+			log.Println(err.Error())
+		}
+
+		return
+	}
+
+	// Some clients POST their announce parameters, possibly compressed, so merge them
+	// into the query map.  GET announces are not affected.
+	if r.Method == "POST" && url == "announce" {
+		body, err := announceBody(r)
+		if err != nil {
+			log.Println(err.Error())
+			if _, err := w.Write(httpTracker.Error("Malformed announce body")); err != nil {
+				log.Println(err.Error())
+			}
+
+			return
+		}
 
-	// Check if IP was previously set
-	if query.Get("ip") == "" {
-		// If no IP set, detect and store it in query map
-		query.Set("ip", strings.Split(r.RemoteAddr, ":")[0])
+		for k, v := range body {
+			query[k] = v
+		}
+	}
+
+	// Apply client-supplied IP policy, detecting and storing IP in query map if it is not trusted
+	if !trustedClientIP(query.Get("ip"), common.Static.Config.IPPolicy) {
+		query.Set("ip", clientIP(r))
+	}
+	if query.Get("ipv6") != "" && !trustedClientIP(query.Get("ipv6"), common.Static.Config.IPPolicy) {
+		query.Del("ipv6")
 	}
 
 	// Put client in query map
 	query.Set("client", client)
 
+	// Put connection address in query map, used to enforce IP bans, as the client may advertise any IP
+	query.Set("remote_ip", clientIP(r))
+
 	// Check if server is configured for passkey announce
 	if common.Static.Config.Passkey && passkey == "" {
 		if _, err := w.Write(httpTracker.Error("No passkey found in announce URL")); err != nil {
@@ -223,8 +452,20 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate passkey if needed
+	// Validate passkey if needed, accepting either the passkey generated with a user's account, or
+	// any additional passkey issued to the user
 	user, err := new(data.UserRecord).Load(passkey, "passkey")
+	if err == nil && !validPasskey(user, passkey) {
+		user, err = data.UserRecord{}, data.ErrNotFound
+	}
+	if err == data.ErrNotFound && passkey != "" {
+		user, err = new(data.PasskeyRecord).User(passkey)
+	}
+
+	// Unknown passkeys are anonymous, and rejected only if passkeys are required
+	if err == data.ErrNotFound {
+		err = nil
+	}
 	if err != nil || (common.Static.Config.Passkey && user == (data.UserRecord{})) {
 		if err != nil {
 			log.Println(err.Error())
@@ -237,105 +478,359 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reject announces from disabled users
+	if user.Banned {
+		if _, err := w.Write(httpTracker.Error("Account disabled")); err != nil {
+			log.Println(err.Error())
+		}
+
+		return
+	}
+
 	// Put passkey in query map
 	query.Set("passkey", user.Passkey)
 
 	// Mark client as HTTP
 	query.Set("udp", "0")
 
-	// Get user's number of active torrents
-	seeding, err := user.Seeding()
-	leeching, err2 := user.Leeching()
-	if err != nil || err2 != nil {
-		if _, err := w.Write(httpTracker.Error("Failed to calculate active torrents")); err != nil {
-			log.Println(err.Error())
+	// Tracker announce
+	if url == "announce" {
+		// Validate announce parameters
+		announce, msg := validateAnnounce(query)
+		if msg != "" {
+			if _, err := w.Write(httpTracker.Error(msg)); err != nil {
+				log.Println(err.Error())
+			}
+
+			return
 		}
 
+		// NOTE: compact announce responses are never compressed using gzip, for two reasons:
+		// 1) Clients may or may not support gzip in the first place
+		// 2) gzip may actually make announce response larger, as per testing in What.CD's ocelot
+		// Dictionary peer lists are much larger, so they are compressed above a configurable size.
+
+		// Perform tracker announce
+		writeAnnounce(w, r, tracker.Announce(httpTracker, user, query), announce.Compact)
 		return
 	}
 
-	// Verify that client has not exceeded this user's torrent limit
-	activeSum := seeding + leeching
-	if user.TorrentLimit < activeSum {
-		msg := fmt.Sprintf("Exceeded active torrent limit: %d > %d", activeSum, user.TorrentLimit)
-		if _, err := w.Write(httpTracker.Error(msg)); err != nil {
-			log.Println(err.Error())
+	// Tracker scrape
+	if url == "scrape" {
+		// Check for required parameter info_hash
+		if query.Get("info_hash") == "" {
+			if _, err := w.Write(httpTracker.Error("Missing required parameter: info_hash")); err != nil {
+				log.Println(err.Error())
+			}
+
+			return
 		}
 
+		// Perform tracker scrape, compressing large responses
+		writeGzip(w, r, tracker.Scrape(httpTracker, user, query), common.Static.Config.Scrape.GzipThreshold)
 		return
 	}
 
-	// Tracker announce
-	if url == "announce" {
-		// Validate required parameter input
-		required := []string{"info_hash", "ip", "port", "uploaded", "downloaded", "left"}
-		// Validate required integer input
-		reqInt := []string{"port", "uploaded", "downloaded", "left"}
-
-		// Check for required parameters
-		for _, r := range required {
-			if query.Get(r) == "" {
-				if _, err := w.Write(httpTracker.Error("Missing required parameter: " + r)); err != nil {
-					log.Println(err.Error())
-				}
+	return
+}
 
-				return
+// privateNetworks contains the address ranges which are not publicly routable
+var privateNetworks = func() []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8",
+		"169.254.0.0/16", "100.64.0.0/10", "::1/128", "fc00::/7", "fe80::/10"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks[:], network)
+	}
+
+	return networks
+}()
+
+// clientIP returns the IP address of the client which made a request.  If goat is configured to
+// trust a reverse proxy, the leftmost address in the X-Forwarded-For header is used, or the
+// X-Real-IP header if it is not present or invalid.  Otherwise, these headers are ignored, as
+// any client may set them, and the connection's remote address is used.
+func clientIP(r *http.Request) string {
+	if common.Static.Config.TrustProxy {
+		// Leftmost address is the originating client, with each proxy appending its own
+		forwarded := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+		if net.ParseIP(forwarded) != nil {
+			return forwarded
+		}
+
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+	}
+
+	// Strip port from remote address, if present
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// trustedClientIP determines if a client-supplied IP may be used in place of the client's
+// remote address, according to the configured policy:
+//   - "reject-all": client-supplied IPs are never used
+//   - "allow-public-only": only publicly routable IPs are used
+//   - "allow-all" (default): all valid IPs are used
+//
+// Unspecified and multicast addresses can never identify a peer, so they are never used.
+func trustedClientIP(ip string, policy string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsUnspecified() || addr.IsMulticast() || policy == "reject-all" {
+		return false
+	}
+
+	// Reject addresses in private ranges
+	if policy == "allow-public-only" {
+		for _, network := range privateNetworks {
+			if network.Contains(addr) {
+				return false
 			}
 		}
+	}
 
-		// Check for all valid integers
-		for _, r := range reqInt {
-			if query.Get(r) != "" {
-				_, err := strconv.Atoi(query.Get(r))
-				if err != nil {
-					if _, err := w.Write(httpTracker.Error("Invalid integer parameter: " + r)); err != nil {
-						log.Println(err.Error())
-					}
+	return true
+}
 
-					return
+// writeAnnounce writes an announce response, compressing non-compact (dictionary) responses
+// using gzip if they exceed the configured size and the client accepts gzip
+func writeAnnounce(w http.ResponseWriter, r *http.Request, res []byte, compact bool) {
+	// Compact responses are never compressed
+	threshold := common.Static.Config.Announce.DictGzipThreshold
+	if compact {
+		threshold = 0
+	}
+
+	writeGzip(w, r, res, threshold)
+}
+
+// acceptsGzip determines if a client accepts gzip'd responses, according to its Accept-Encoding header
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		// Split encoding from its parameters, such as quality
+		params := strings.Split(encoding, ";")
+		if name := strings.TrimSpace(params[0]); name != "gzip" && name != "*" {
+			continue
+		}
+
+		// A quality of 0 indicates the encoding is not acceptable
+		for _, p := range params[1:] {
+			if q := strings.TrimSpace(p); strings.HasPrefix(q, "q=") {
+				if quality, err := strconv.ParseFloat(q[2:], 64); err == nil && quality == 0 {
+					return false
 				}
 			}
 		}
 
-		// Only allow compact announce
-		if query.Get("compact") == "" || query.Get("compact") != "1" {
-			if _, err := w.Write(httpTracker.Error("Your client does not support compact announce")); err != nil {
-				log.Println(err.Error())
-			}
+		return true
+	}
 
-			return
-		}
+	return false
+}
 
-		// NOTE: currently, we do not bother using gzip to compress the tracker announce response
-		// This is done for two reasons:
-		// 1) Clients may or may not support gzip in the first place
-		// 2) gzip may actually make announce response larger, as per testing in What.CD's ocelot
+// writeGzip writes a response, compressing it using gzip if it exceeds the size threshold and the
+// client accepts gzip.  Small responses are written directly, as compression only adds overhead.
+// A threshold of 0 disables compression.
+func writeGzip(w http.ResponseWriter, r *http.Request, res []byte, threshold int) {
+	// Responses which may be compressed vary based on the client's accepted encodings
+	if threshold > 0 {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
 
-		// Perform tracker announce
-		if _, err := w.Write(tracker.Announce(httpTracker, user, query)); err != nil {
+	// Write small or uncompressible responses directly
+	if threshold <= 0 || len(res) <= threshold || !acceptsGzip(r) {
+		if _, err := w.Write(res); err != nil {
 			log.Println(err.Error())
 		}
 
 		return
 	}
 
-	// Tracker scrape
-	if url == "scrape" {
-		// Check for required parameter info_hash
-		if query.Get("info_hash") == "" {
-			if _, err := w.Write(httpTracker.Error("Missing required parameter: info_hash")); err != nil {
-				log.Println(err.Error())
-			}
+	// Write gzip'd response
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(res); err != nil {
+		log.Println(err.Error())
+		return
+	}
 
-			return
-		}
+	if err := gz.Close(); err != nil {
+		log.Println(err.Error())
+	}
+}
 
-		if _, err := w.Write(tracker.Scrape(httpTracker, query)); err != nil {
+// robotsTxt disallows crawling of the entire tracker
+const robotsTxt = "User-agent: *\nDisallow: /\n"
+
+// serveRobots serves a disallow-all robots.txt and an empty favicon, returning true if the path
+// was one of these files
+func serveRobots(w http.ResponseWriter, path string) bool {
+	switch path {
+	case "/robots.txt":
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := w.Write([]byte(robotsTxt)); err != nil {
 			log.Println(err.Error())
 		}
+	case "/favicon.ico":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return false
+	}
 
-		return
+	return true
+}
+
+// trackerPath returns the tracker function and passkey from the elements of a request path.  The
+// passkey may appear either before or after the function, as in /{passkey}/announce or
+// /announce/{passkey}, and is empty if not present.
+func trackerPath(urlArr []string) (string, string) {
+	if len(urlArr) != 3 {
+		return urlArr[1], ""
 	}
 
-	return
+	if urlArr[1] == "announce" || urlArr[1] == "scrape" {
+		return urlArr[1], urlArr[2]
+	}
+
+	return urlArr[2], urlArr[1]
+}
+
+// validPasskey verifies that a user loaded by passkey has that passkey, using a constant-time
+// comparison so the passkey cannot be discovered by response time
+func validPasskey(user data.UserRecord, passkey string) bool {
+	return subtle.ConstantTimeCompare([]byte(user.Passkey), []byte(passkey)) == 1
+}
+
+// validateAnnounce parses and validates the parameters of an announce, returning a failure reason
+// if they are invalid
+func validateAnnounce(query url.Values) (data.AnnounceRequest, string) {
+	announce, err := new(data.AnnounceRequest).FromValues(query)
+	if err != nil {
+		return announce, "Invalid announce: " + err.Error()
+	}
+
+	// Store normalized event, so it is handled consistently by the tracker
+	if query.Get("event") != "" {
+		query.Set("event", announce.Event)
+	}
+
+	return announce, ""
+}
+
+// announceBody reads the parameters from a POST announce body, transparently decompressing
+// the body if the client set a gzip or deflate Content-Encoding
+func announceBody(r *http.Request) (url.Values, error) {
+	// Read raw body, up to one byte more than maximum to detect oversized bodies
+	raw, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAnnounceBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxAnnounceBodySize {
+		return nil, errBodyTooLarge
+	}
+
+	// Choose decompressor using Content-Encoding header
+	var body io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		body = ioutil.NopCloser(bytes.NewReader(raw))
+	case "gzip", "x-gzip":
+		if body, err = gzip.NewReader(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+	case "deflate":
+		// HTTP deflate should be zlib wrapped, but some clients send a raw deflate stream
+		if body, err = zlib.NewReader(bytes.NewReader(raw)); err != nil {
+			body = flate.NewReader(bytes.NewReader(raw))
+		}
+	default:
+		return nil, errBodyEncoding
+	}
+
+	// Decompress body, guarding against a small body which expands beyond the maximum size
+	buf, err := ioutil.ReadAll(io.LimitReader(body, maxAnnounceBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	if len(buf) > maxAnnounceBodySize {
+		return nil, errBodyTooLarge
+	}
+
+	// Parse form encoded parameters, exactly as a querystring
+	return trackerQuery(string(buf))
+}
+
+// trackerQuery parses the parameters of a tracker request from a raw querystring or form encoded
+// body into a Values map.  The info_hash is parsed from the raw string, to preserve its exact bytes,
+// and other parameters are parsed as by url.Query(), ignoring malformed pairs.
+func trackerQuery(rawQuery string) (url.Values, error) {
+	query, _ := url.ParseQuery(rawQuery)
+
+	// Parse info_hash from the raw querystring, to preserve its exact bytes
+	infoHashes, err := rawQueryValues(rawQuery, "info_hash", common.Static.Config.StrictInfoHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(infoHashes) > 0 {
+		query["info_hash"] = infoHashes
+	}
+
+	return query, nil
+}
+
+// rawQueryValues parses all values of a parameter from a raw querystring, percent-decoding them
+// manually so that binary values, such as info_hash, keep their exact bytes.  Unlike url.Query(),
+// a '+' is kept as a literal byte, and a pair is not discarded due to an unrelated malformed pair.
+// If strict, a malformed percent-encoding is an error; otherwise, it is kept literally.
+func rawQueryValues(rawQuery string, key string, strict bool) ([]string, error) {
+	values := make([]string, 0)
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		// Only decode pairs matching the key
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] != key {
+			continue
+		}
+
+		// Decode value byte by byte
+		raw := kv[1]
+		value := make([]byte, 0, len(raw))
+		for i := 0; i < len(raw); i++ {
+			if raw[i] != '%' {
+				value = append(value, raw[i])
+				continue
+			}
+
+			// Decode percent-encoded byte, if well formed
+			if i+2 < len(raw) {
+				if b, err := strconv.ParseUint(raw[i+1:i+3], 16, 8); err == nil {
+					value = append(value, byte(b))
+					i += 2
+					continue
+				}
+			}
+
+			if strict {
+				return nil, errors.New("malformed percent-encoding in parameter: " + key)
+			}
+
+			value = append(value, raw[i])
+		}
+
+		values = append(values, string(value))
+	}
+
+	return values, nil
 }
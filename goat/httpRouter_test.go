@@ -1,13 +1,25 @@
 package goat
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/mdlayher/goat/goat/api"
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/tracker"
 )
 
 // Table driven tests to iterate over and test the main HTTP router
@@ -29,7 +41,7 @@ func TestHTTPRouter(t *testing.T) {
 	log.Println("TestHTTPRouter()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -82,3 +94,913 @@ func TestHTTPRouter(t *testing.T) {
 		t.Fatalf("Failed to delete mock file : %s %s", err.Error(), err2.Error())
 	}
 }
+
+// TestHTTPAnnounceBody verifies that compressed POST announce bodies are decoded properly
+func TestHTTPAnnounceBody(t *testing.T) {
+	log.Println("TestHTTPAnnounceBody()")
+
+	// Compress a mock announce body using gzip
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte("info_hash=deadbeef&port=5000&left=10")); err != nil {
+		t.Fatalf("Failed to compress announce body: %s", err.Error())
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to compress announce body: %s", err.Error())
+	}
+
+	// Generate mock HTTP request with gzip'd body
+	r, err := http.NewRequest("POST", "http://localhost:8080/announce", buf)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+
+	// Verify body is decompressed and parsed
+	query, err := announceBody(r)
+	if err != nil {
+		t.Fatalf("Failed to decode announce body: %s", err.Error())
+	}
+
+	if query.Get("info_hash") != "deadbeef" || query.Get("port") != "5000" || query.Get("left") != "10" {
+		t.Fatalf("Incorrect announce body, got %v", query)
+	}
+
+	// Compress a body which is small on the wire, but very large after decompression
+	buf.Reset()
+	gz = gzip.NewWriter(buf)
+	if _, err := gz.Write(make([]byte, maxAnnounceBodySize*64)); err != nil {
+		t.Fatalf("Failed to compress announce body: %s", err.Error())
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to compress announce body: %s", err.Error())
+	}
+
+	if buf.Len() > maxAnnounceBodySize {
+		t.Fatalf("Compressed announce body is too large for test: %d bytes", buf.Len())
+	}
+
+	// Generate mock HTTP request with oversized body
+	r, err = http.NewRequest("POST", "http://localhost:8080/announce", buf)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+
+	// Verify decompression is halted
+	if _, err := announceBody(r); err != errBodyTooLarge {
+		t.Fatalf("Expected errBodyTooLarge, got %v", err)
+	}
+}
+
+// TestHTTPRouterMethods verifies that the main HTTP router handles HEAD and unsupported methods properly
+func TestHTTPRouterMethods(t *testing.T) {
+	log.Println("TestHTTPRouterMethods()")
+
+	// Generate mock GET request
+	r, err := http.NewRequest("GET", "http://localhost:8080/test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("User-Agent", "goat_test")
+
+	// Capture GET response
+	get := httptest.NewRecorder()
+	parseHTTP(get, r)
+
+	// Generate mock HEAD request for same resource
+	r, err = http.NewRequest("HEAD", "http://localhost:8080/test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("User-Agent", "goat_test")
+
+	// Capture HEAD response
+	head := httptest.NewRecorder()
+	parseHTTP(head, r)
+
+	// Verify HEAD returns no body, but the same status and length as GET
+	if head.Body.Len() != 0 {
+		t.Fatalf("HEAD response contained a body: %s", head.Body.String())
+	}
+
+	if head.Code != get.Code {
+		t.Fatalf("HEAD status, expected HTTP %d, got HTTP %d", get.Code, head.Code)
+	}
+
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Fatalf("HEAD Content-Length, expected %d, got %s", get.Body.Len(), head.Header().Get("Content-Length"))
+	}
+
+	// Generate mock PUT request on announce
+	r, err = http.NewRequest("PUT", "http://localhost:8080/announce", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("User-Agent", "goat_test")
+
+	// Verify PUT is not allowed
+	w := httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT status, expected HTTP %d, got HTTP %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	if w.Header().Get("Allow") != "GET, HEAD, POST" {
+		t.Fatalf("PUT Allow header, expected \"GET, HEAD, POST\", got \"%s\"", w.Header().Get("Allow"))
+	}
+}
+
+// Table driven tests to iterate over and test announce validation
+var validateAnnounceTests = []struct {
+	event string
+	port  string
+	ok    bool
+}{
+	{"started", "", false},
+	{"started", "0", false},
+	{"started", "5000", true},
+	{"", "", false},
+	{"", "0", false},
+	{"completed", "0", false},
+	{"stopped", "", true},
+	{"stopped", "0", true},
+	{"stopped", "5000", true},
+}
+
+// TestValidateAnnounce verifies that announces with a missing or zero port are only accepted when stopping
+func TestValidateAnnounce(t *testing.T) {
+	log.Println("TestValidateAnnounce()")
+
+	// Iterate all validation tests
+	for _, test := range validateAnnounceTests {
+		// Generate fake announce query
+		query := url.Values{}
+		query.Set("info_hash", "deadbeef000000000000")
+		query.Set("ip", "127.0.0.1")
+		query.Set("uploaded", "0")
+		query.Set("downloaded", "0")
+		query.Set("left", "10")
+		query.Set("compact", "1")
+
+		if test.event != "" {
+			query.Set("event", test.event)
+		}
+		if test.port != "" {
+			query.Set("port", test.port)
+		}
+
+		// Validate announce
+		_, msg := validateAnnounce(query)
+		if test.ok && msg != "" {
+			t.Fatalf("Test event=%s port=%s, expected valid, got failure: %s", test.event, test.port, msg)
+		}
+
+		if !test.ok && msg == "" {
+			t.Fatalf("Test event=%s port=%s, expected failure, got valid", test.event, test.port)
+		}
+	}
+}
+
+// validPasskeyTests contains passkeys compared against a user's passkey, and whether they should match
+var validPasskeyTests = []struct {
+	passkey string
+	valid   bool
+}{
+	{"deadbeefdeadbeefdeadbeefdeadbeefdeadbeef", true},
+	{"deadbeefdeadbeefdeadbeefdeadbeefdeadbeee", false},
+	{"DEADBEEFDEADBEEFDEADBEEFDEADBEEFDEADBEEF", false},
+	{"deadbeef", false},
+	{"", false},
+}
+
+// TestValidPasskey verifies that passkeys are compared using the constant-time comparison path
+func TestValidPasskey(t *testing.T) {
+	log.Println("TestValidPasskey()")
+
+	user := data.UserRecord{Passkey: "deadbeefdeadbeefdeadbeefdeadbeefdeadbeef"}
+
+	// Iterate all tests
+	for _, test := range validPasskeyTests {
+		if valid := validPasskey(user, test.passkey); valid != test.valid {
+			t.Fatalf("validPasskey(%q), expected %t, got %t", test.passkey, test.valid, valid)
+		}
+	}
+}
+
+// trackerPathTests contains request paths, and the tracker function and passkey detected from them
+var trackerPathTests = []struct {
+	path    string
+	url     string
+	passkey string
+}{
+	{"/announce", "announce", ""},
+	{"/scrape", "scrape", ""},
+	{"/0123456789abcdef/announce", "announce", "0123456789abcdef"},
+	{"/announce/0123456789abcdef", "announce", "0123456789abcdef"},
+	{"/scrape/0123456789abcdef", "scrape", "0123456789abcdef"},
+	{"/0123456789abcdef/foo", "foo", "0123456789abcdef"},
+}
+
+// TestTrackerPath verifies that the passkey is detected either before or after the tracker function
+func TestTrackerPath(t *testing.T) {
+	log.Println("TestTrackerPath()")
+
+	// Iterate all tests
+	for _, test := range trackerPathTests {
+		url, passkey := trackerPath(strings.Split(test.path, "/"))
+		if url != test.url || passkey != test.passkey {
+			t.Fatalf("trackerPath(%q), expected %q/%q, got %q/%q", test.path, test.url, test.passkey, url, passkey)
+		}
+	}
+}
+
+// TestHTTPBannedUser verifies that announces from disabled users are rejected
+func TestHTTPBannedUser(t *testing.T) {
+	log.Println("TestHTTPBannedUser()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Whitelist = false
+
+	// Generate and save mock user
+	user := new(data.UserRecord)
+	if err := user.Create("banned", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	// Load user to get ID
+	user2, err := user.Load("banned", "username")
+	if err != nil || user2 == (data.UserRecord{}) {
+		t.Fatalf("Failed to load mock user: %v", err)
+	}
+
+	// Announce as user, returning response body
+	announce := func() string {
+		r, err := http.NewRequest("GET", "http://localhost:8080/"+user2.Passkey+"/announce?info_hash=deadbeef&ip=127.0.0.1&port=5000&uploaded=0&downloaded=0&left=10", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.Header.Set("User-Agent", "goat_test")
+
+		w := httptest.NewRecorder()
+		parseHTTP(w, r)
+		return w.Body.String()
+	}
+
+	// Verify enabled user's announce is not rejected
+	if body := announce(); strings.Contains(body, "Account disabled") {
+		t.Fatalf("Enabled user's announce was rejected: %s", body)
+	}
+
+	// Disable user
+	user2.Banned = true
+	if err := user2.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	// Verify disabled user's announce is rejected
+	if body := announce(); !strings.Contains(body, "Account disabled") {
+		t.Fatalf("Disabled user's announce was not rejected: %s", body)
+	}
+
+	// Delete mock user
+	if err := user2.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
+
+// rawQueryValuesTests contains raw querystrings, and the info_hash values which should be parsed from them
+var rawQueryValuesTests = []struct {
+	rawQuery string
+	strict   bool
+	values   []string
+	err      bool
+}{
+	// Percent-encoded bytes, including non-UTF8 bytes
+	{"info_hash=%DE%AD%BE%EF%FF%00abc&left=0", true, []string{"\xde\xad\xbe\xef\xff\x00abc"}, false},
+	// Literal '+' is an info_hash byte, not a space
+	{"info_hash=%12%34+%56&left=0", true, []string{"\x12\x34+\x56"}, false},
+	// Multiple info_hash values, as used by scrape
+	{"info_hash=%01%02&info_hash=%03%04", true, []string{"\x01\x02", "\x03\x04"}, false},
+	// Malformed percent-encoding
+	{"info_hash=%ZZ%01", true, nil, true},
+	{"info_hash=%ZZ%01", false, []string{"%ZZ\x01"}, false},
+	{"info_hash=%0", false, []string{"%0"}, false},
+	// No info_hash
+	{"left=0", true, []string{}, false},
+}
+
+// TestRawQueryValues verifies that info_hash values are parsed from the raw querystring with their exact bytes
+func TestRawQueryValues(t *testing.T) {
+	log.Println("TestRawQueryValues()")
+
+	// Verify that url.Query() mangles the info_hash which rawQueryValues preserves
+	mangled, err := url.ParseQuery("info_hash=%12%34+%56")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %s", err.Error())
+	}
+	if mangled.Get("info_hash") == "\x12\x34+\x56" {
+		t.Fatalf("url.ParseQuery() unexpectedly preserved literal '+'")
+	}
+
+	// Iterate all tests
+	for _, test := range rawQueryValuesTests {
+		values, err := rawQueryValues(test.rawQuery, "info_hash", test.strict)
+		if (err != nil) != test.err {
+			t.Fatalf("rawQueryValues(%q), unexpected error result: %v", test.rawQuery, err)
+		}
+
+		if len(values) != len(test.values) {
+			t.Fatalf("rawQueryValues(%q), expected %q, got %q", test.rawQuery, test.values, values)
+		}
+		for i := range values {
+			if values[i] != test.values[i] {
+				t.Fatalf("rawQueryValues(%q), expected %q, got %q", test.rawQuery, test.values, values)
+			}
+		}
+	}
+}
+
+// writeAnnounceTests contains announce response sizes and formats, and whether they should be gzip'd
+var writeAnnounceTests = []struct {
+	size    int
+	compact bool
+	gzip    bool
+}{
+	// Large dictionary response
+	{8192, false, true},
+	// Small dictionary response
+	{512, false, false},
+	// Large compact response
+	{8192, true, false},
+}
+
+// TestWriteAnnounce verifies that only large dictionary announce responses are compressed using gzip
+func TestWriteAnnounce(t *testing.T) {
+	log.Println("TestWriteAnnounce()")
+
+	common.Static.Config.Announce.DictGzipThreshold = 4096
+
+	// Iterate all tests
+	for _, test := range writeAnnounceTests {
+		r, err := http.NewRequest("GET", "http://localhost:8080/announce", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		res := bytes.Repeat([]byte("d2:ip9:127.0.0.14:porti5000ee"), test.size/29+1)
+		w := httptest.NewRecorder()
+		writeAnnounce(w, r, res, test.compact)
+
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.gzip {
+			t.Fatalf("writeAnnounce(%d bytes, compact: %t), expected gzip %t, got %t", len(res), test.compact, test.gzip, gzipped)
+		}
+
+		// Verify response body decodes to the original response
+		body := w.Body.Bytes()
+		if gzipped {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Failed to read gzip response: %s", err.Error())
+			}
+			if body, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("Failed to read gzip response: %s", err.Error())
+			}
+		}
+
+		if !bytes.Equal(body, res) {
+			t.Fatalf("writeAnnounce(%d bytes, compact: %t), response body mismatch", len(res), test.compact)
+		}
+	}
+}
+
+// acceptsGzipTests contains Accept-Encoding headers, and whether they accept gzip
+var acceptsGzipTests = []struct {
+	header string
+	gzip   bool
+}{
+	{"", false},
+	{"gzip", true},
+	{"deflate, gzip", true},
+	{"gzip;q=0.5, identity", true},
+	{"*", true},
+	{"identity", false},
+	{"gzip;q=0", false},
+	{"gzip; q=0.000", false},
+	{"xgzip", false},
+}
+
+// TestAcceptsGzip verifies that Accept-Encoding headers are parsed properly
+func TestAcceptsGzip(t *testing.T) {
+	log.Println("TestAcceptsGzip()")
+
+	// Iterate all tests
+	for _, test := range acceptsGzipTests {
+		r, err := http.NewRequest("GET", "http://localhost:8080/scrape", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.Header.Set("Accept-Encoding", test.header)
+
+		if accepted := acceptsGzip(r); accepted != test.gzip {
+			t.Fatalf("acceptsGzip(%q), expected %t, got %t", test.header, test.gzip, accepted)
+		}
+	}
+}
+
+// writeGzipTests contains response sizes, whether the client accepts gzip, and whether the response
+// should be gzip'd
+var writeGzipTests = []struct {
+	size       int
+	acceptGzip bool
+	gzip       bool
+}{
+	// Large response, client accepts gzip
+	{8192, true, true},
+	// Large response, client does not accept gzip
+	{8192, false, false},
+	// Small response, client accepts gzip
+	{512, true, false},
+	// Small response, client does not accept gzip
+	{512, false, false},
+}
+
+// TestWriteGzip verifies that responses are compressed using gzip only when they exceed the threshold
+// and the client accepts gzip, and that compressed responses decode to the original bencode
+func TestWriteGzip(t *testing.T) {
+	log.Println("TestWriteGzip()")
+
+	// Iterate all tests
+	for _, test := range writeGzipTests {
+		r, err := http.NewRequest("GET", "http://localhost:8080/scrape", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		if test.acceptGzip {
+			r.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		res := append([]byte("d5:filesd"), bytes.Repeat([]byte("20:aaaaaaaaaaaaaaaaaaaad8:completei1e10:incompletei2e10:downloadedi3ee"), test.size/70+1)...)
+		res = append(res, []byte("ee")...)
+		w := httptest.NewRecorder()
+		writeGzip(w, r, res, 4096)
+
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.gzip {
+			t.Fatalf("writeGzip(%d bytes, accept gzip: %t), expected gzip %t, got %t", len(res), test.acceptGzip, test.gzip, gzipped)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("writeGzip(%d bytes, accept gzip: %t), expected Vary header", len(res), test.acceptGzip)
+		}
+
+		// Verify response body decodes to the original response
+		body := w.Body.Bytes()
+		if gzipped {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Failed to read gzip response: %s", err.Error())
+			}
+			if body, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("Failed to read gzip response: %s", err.Error())
+			}
+		}
+
+		if !bytes.Equal(body, res) {
+			t.Fatalf("writeGzip(%d bytes, accept gzip: %t), response body mismatch", len(res), test.acceptGzip)
+		}
+	}
+}
+
+// trustedClientIPTests contains client-supplied IPs, policies, and whether the IP should be trusted
+var trustedClientIPTests = []struct {
+	ip      string
+	policy  string
+	trusted bool
+}{
+	{"192.168.1.10", "reject-all", false},
+	{"8.8.8.8", "reject-all", false},
+	{"192.168.1.10", "allow-public-only", false},
+	{"10.0.0.1", "allow-public-only", false},
+	{"fd00::1", "allow-public-only", false},
+	{"127.0.0.1", "allow-public-only", false},
+	{"::1", "allow-public-only", false},
+	{"100.64.0.1", "allow-public-only", false},
+	{"8.8.8.8", "allow-public-only", true},
+	{"2001:4860:4860::8888", "allow-public-only", true},
+	{"192.168.1.10", "allow-all", true},
+	{"8.8.8.8", "allow-all", true},
+	{"192.168.1.10", "", true},
+	{"", "allow-all", false},
+	{"abc", "allow-all", false},
+	{"0.0.0.0", "allow-all", false},
+	{"::", "allow-all", false},
+	{"224.0.0.1", "allow-all", false},
+	{"ff02::1", "allow-all", false},
+}
+
+// TestTrustedClientIP verifies that client-supplied IPs are trusted according to the configured policy
+func TestTrustedClientIP(t *testing.T) {
+	log.Println("TestTrustedClientIP()")
+
+	// Iterate all tests
+	for _, test := range trustedClientIPTests {
+		if trusted := trustedClientIP(test.ip, test.policy); trusted != test.trusted {
+			t.Fatalf("trustedClientIP(%q, %q), expected %t, got %t", test.ip, test.policy, test.trusted, trusted)
+		}
+	}
+}
+
+// clientIPTests contains request remote addresses and headers, whether a proxy is trusted, and the
+// expected client IP
+var clientIPTests = []struct {
+	remoteAddr string
+	forwarded  string
+	realIP     string
+	trustProxy bool
+	ip         string
+}{
+	{"10.0.0.1:6881", "", "", false, "10.0.0.1"},
+	{"[2001:db8::1]:6881", "", "", false, "2001:db8::1"},
+	{"10.0.0.1", "", "", false, "10.0.0.1"},
+	{"127.0.0.1:6881", "8.8.8.8", "8.8.4.4", false, "127.0.0.1"},
+	{"127.0.0.1:6881", "8.8.8.8", "", true, "8.8.8.8"},
+	{"127.0.0.1:6881", " 8.8.8.8 , 10.0.0.2, 10.0.0.3", "", true, "8.8.8.8"},
+	{"127.0.0.1:6881", "2001:db8::2, 10.0.0.2", "", true, "2001:db8::2"},
+	{"127.0.0.1:6881", "", "8.8.4.4", true, "8.8.4.4"},
+	{"127.0.0.1:6881", "unknown, 8.8.8.8", "8.8.4.4", true, "8.8.4.4"},
+	{"127.0.0.1:6881", "8.8.8.8:1234", "", true, "127.0.0.1"},
+	{"127.0.0.1:6881", ",", "not an ip", true, "127.0.0.1"},
+	{"127.0.0.1:6881", "", "", true, "127.0.0.1"},
+}
+
+// TestClientIP verifies that proxy headers are only used to determine client IP when a proxy is
+// trusted, and that malformed header values are ignored
+func TestClientIP(t *testing.T) {
+	log.Println("TestClientIP()")
+
+	// Iterate all tests
+	for _, test := range clientIPTests {
+		common.Static.Config = common.Conf{TrustProxy: test.trustProxy}
+
+		r, err := http.NewRequest("GET", "http://localhost:8080/announce", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+
+		if ip := clientIP(r); ip != test.ip {
+			t.Fatalf("clientIP(%q, %q, %q, %t), expected %q, got %q", test.remoteAddr, test.forwarded,
+				test.realIP, test.trustProxy, test.ip, ip)
+		}
+	}
+}
+
+// TestHTTPMaintenance verifies that maintenance mode returns an extended interval without accessing storage
+func TestHTTPMaintenance(t *testing.T) {
+	log.Println("TestHTTPMaintenance()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 3600
+	config.Maintenance.Interval = 7200
+	common.Static.Config = config
+
+	// Enable maintenance mode, and make any database access panic
+	common.Static.Maintenance.Set(true, "testing")
+	dbConnect := data.DBConnectFunc
+	data.DBConnectFunc = nil
+	defer func() {
+		common.Static.Maintenance.Set(false, "")
+		data.DBConnectFunc = dbConnect
+	}()
+
+	// Announce, without passkey or whitelist checks touching storage
+	r, err := http.NewRequest("GET", "http://localhost:8080/0123456789abcdef/announce?info_hash=deadbeef000000000000&ip=127.0.0.1&port=5000&uploaded=0&downloaded=0&left=10&compact=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("User-Agent", "goat_test")
+
+	w := httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	// Verify response contains extended interval and warning, and no failure
+	body := w.Body.String()
+	if strings.Contains(body, "failure reason") {
+		t.Fatalf("Unexpected failure in maintenance announce: %s", body)
+	}
+	if !strings.Contains(body, "8:intervali7200e") || !strings.Contains(body, "15:warning message20:Maintenance: testing") {
+		t.Fatalf("Unexpected maintenance announce: %s", body)
+	}
+	if !strings.Contains(body, "5:peers0:") {
+		t.Fatalf("Unexpected peers in maintenance announce: %s", body)
+	}
+}
+
+// TestHTTPRobots verifies that robots.txt and favicon.ico are served directly, if configured
+func TestHTTPRobots(t *testing.T) {
+	log.Println("TestHTTPRobots()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.ServeRobots = true
+	common.Static.Config = config
+
+	// Request robots.txt
+	r, err := http.NewRequest("GET", "http://localhost:8080/robots.txt", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+
+	w := httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	// Verify disallow-all body
+	if w.Code != http.StatusOK || w.Body.String() != "User-agent: *\nDisallow: /\n" {
+		t.Fatalf("Unexpected robots.txt response: %d %q", w.Code, w.Body.String())
+	}
+
+	// Request favicon.ico, and verify empty response
+	if r, err = http.NewRequest("GET", "http://localhost:8080/favicon.ico", nil); err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+
+	w = httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("Unexpected favicon.ico response: %d %q", w.Code, w.Body.String())
+	}
+
+	// Verify other paths are not handled
+	if serveRobots(httptest.NewRecorder(), "/announce") {
+		t.Fatalf("serveRobots handled /announce")
+	}
+}
+
+// TestAPIRateLimitUnauthenticated verifies that API calls which fail authentication are rate limited
+// by client IP
+func TestAPIRateLimitUnauthenticated(t *testing.T) {
+	log.Println("TestAPIRateLimitUnauthenticated()")
+
+	// Allow bursts of 2 calls per client, using a fresh limiter, restoring the defaults afterwards
+	common.Static.Config = common.DefaultConfig()
+	common.Static.Config.API = true
+	common.Static.Config.RateLimit.Enabled = true
+	common.Static.Config.RateLimit.Rate = 1
+	common.Static.Config.RateLimit.Burst = 2
+
+	limiter := api.Limiter
+	api.Limiter = api.NewRateLimiter()
+	defer func() {
+		api.Limiter = limiter
+		common.Static.Config = common.DefaultConfig()
+	}()
+
+	// Verify HMAC calls with invalid credentials fail authentication until the burst is exhausted
+	for i, code := range []int{401, 401, 429} {
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("Authorization", "Basic invalid")
+
+		w := httptest.NewRecorder()
+		routeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("Call %d, expected HTTP %d, got HTTP %d", i, code, w.Code)
+		}
+	}
+}
+
+// TestAPIRateLimitAuthenticated verifies that authenticated API calls are rate limited by public key
+// alone, and are not charged to the client IP
+func TestAPIRateLimitAuthenticated(t *testing.T) {
+	log.Println("TestAPIRateLimitAuthenticated()")
+
+	// Load config, allowing bursts of 2 calls per client, using a fresh limiter, restoring the
+	// defaults afterwards
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.API = true
+	config.RateLimit.Enabled = true
+	config.RateLimit.Rate = 1
+	config.RateLimit.Burst = 2
+	common.Static.Config = config
+
+	limiter := api.Limiter
+	api.Limiter = api.NewRateLimiter()
+	defer func() {
+		api.Limiter = limiter
+		common.Static.Config = common.DefaultConfig()
+	}()
+
+	// Generate and save mock user, loading it to get ID
+	user := new(data.UserRecord)
+	if err := user.Create("ratelimit", "ratelimit", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	user2, err := user.Load("ratelimit", "username")
+	if err != nil || (user2 == data.UserRecord{}) {
+		t.Fatalf("Failed to load mock user: %v", err)
+	}
+
+	// Generate two mock API keys for the user
+	keys := make([]data.APIKey, 2)
+	for i := range keys {
+		if keys[i], err = data.NewAPIKey(user2.ID); err != nil {
+			t.Fatalf("Failed to create mock API key: %s", err.Error())
+		}
+	}
+
+	// call makes an API call from the specified IP, signed using the specified API key
+	nonce := 0
+	call := func(key data.APIKey, ip string) int {
+		nonce++
+		timestamp := common.Now().Unix()
+
+		mac := hmac.New(sha1.New, []byte(key.Secret))
+		fmt.Fprintf(mac, "%d-%d-%d-GET-/api/status", key.UserID, nonce, timestamp)
+		credentials := fmt.Sprintf("%s:%d/%d/%x", key.Pubkey, nonce, timestamp, mac.Sum(nil))
+
+		r, err := http.NewRequest("GET", "http://localhost:8080/api/status", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+		r.RemoteAddr = ip + ":5000"
+		r.Header.Set("Authorization", "Basic "+base64.URLEncoding.EncodeToString([]byte(credentials)))
+
+		w := httptest.NewRecorder()
+		routeHTTP(w, r)
+		return w.Code
+	}
+
+	// Verify a key is limited once its burst is exhausted, regardless of client IP
+	for i, test := range []struct {
+		key  data.APIKey
+		ip   string
+		code int
+	}{
+		{keys[0], "10.0.0.1", 200},
+		{keys[0], "10.0.0.2", 200},
+		{keys[0], "10.0.0.3", 429},
+		{keys[1], "10.0.0.1", 200},
+		{keys[1], "10.0.0.1", 200},
+		{keys[1], "10.0.0.1", 429},
+	} {
+		if code := call(test.key, test.ip); code != test.code {
+			t.Fatalf("Call %d, expected HTTP %d, got HTTP %d", i, test.code, code)
+		}
+	}
+
+	// Verify authenticated calls were not charged to the client IP
+	if ok, _ := api.Limiter.Peek("ip:10.0.0.1"); !ok {
+		t.Fatalf("Authenticated calls were charged to the client IP")
+	}
+
+	// Delete mock API keys and user
+	for _, key := range keys {
+		if err := key.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock API key: %s", err.Error())
+		}
+	}
+	if err := user2.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
+
+// TestAPIRouterNoCall verifies that API requests without a call return an error, rather than panicking
+func TestAPIRouterNoCall(t *testing.T) {
+	log.Println("TestAPIRouterNoCall()")
+
+	// Enable API, restoring the defaults afterwards
+	common.Static.Config = common.DefaultConfig()
+	common.Static.Config.API = true
+	defer func() {
+		common.Static.Config = common.DefaultConfig()
+	}()
+
+	// Verify /api, without a trailing slash, is rejected for each method
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "DELETE"} {
+		r, err := http.NewRequest(method, "http://localhost:8080/api", nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request: %s", err.Error())
+		}
+
+		w := httptest.NewRecorder()
+		routeHTTP(w, r)
+		if w.Code != 404 {
+			t.Fatalf("%s /api, expected HTTP 404, got HTTP %d", method, w.Code)
+		}
+	}
+}
+
+// TestHTTPHeadAnnounce verifies that HEAD requests on tracker functions are answered without a
+// tracker call, so no announce_log row or other record is written, and report the length and type
+// of their tracker error
+func TestHTTPHeadAnnounce(t *testing.T) {
+	log.Println("TestHTTPHeadAnnounce()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Make any database access panic, so no announce may be recorded
+	dbConnect := data.DBConnectFunc
+	data.DBConnectFunc = nil
+	defer func() {
+		data.DBConnectFunc = dbConnect
+	}()
+
+	for _, path := range []string{
+		"/announce?info_hash=deadbeef000000000000&ip=127.0.0.1&port=5000&uploaded=0&downloaded=0&left=10&compact=1",
+		"/scrape?info_hash=deadbeef000000000000",
+	} {
+		r, err := http.NewRequest("HEAD", "http://localhost:8080"+path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.Header.Set("User-Agent", "goat_test")
+
+		// Verify headers only are returned, describing the fixed tracker error
+		w := httptest.NewRecorder()
+		parseHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Fatalf("HEAD %s, expected empty HTTP 200, got HTTP %d: %q", path, w.Code, w.Body.String())
+		}
+
+		length := strconv.Itoa(len(tracker.HTTPTracker{}.Error(headTrackerMessage)))
+		if w.Header().Get("Content-Length") != length {
+			t.Fatalf("HEAD %s, expected Content-Length %s, got %q", path, length, w.Header().Get("Content-Length"))
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			t.Fatalf("HEAD %s, expected text/plain Content-Type, got %q", path, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// TestHTTPAnnounceBodyBinary verifies that a non-UTF-8 info_hash in a POST announce body keeps its
+// exact bytes, as it does in a GET querystring
+func TestHTTPAnnounceBodyBinary(t *testing.T) {
+	log.Println("TestHTTPAnnounceBodyBinary()")
+
+	common.Static.Config = common.DefaultConfig()
+	raw := "info_hash=%FF%FE%12%34+%56%C0%80&port=5000&left=10"
+
+	// Parse the same parameters as a GET querystring
+	get, err := trackerQuery(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse querystring: %s", err.Error())
+	}
+
+	// Generate mock HTTP request with the same parameters in its body
+	r, err := http.NewRequest("POST", "http://localhost:8080/announce", strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+
+	post, err := announceBody(r)
+	if err != nil {
+		t.Fatalf("Failed to decode announce body: %s", err.Error())
+	}
+
+	// Verify info_hash keeps its exact bytes, and matches the GET parameters
+	if post.Get("info_hash") != "\xff\xfe\x12\x34+\x56\xc0\x80" {
+		t.Fatalf("Incorrect POST info_hash, got %q", post.Get("info_hash"))
+	}
+	if post.Get("info_hash") != get.Get("info_hash") || post.Get("port") != get.Get("port") {
+		t.Fatalf("POST parameters %v differ from GET parameters %v", post, get)
+	}
+}
@@ -2,17 +2,108 @@ package goat
 
 import (
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 )
 
-// Listen and handle HTTP (TCP) connections
+// listenAddress determines the network and address to listen on, from a configured address
+// and port.  Addresses may be unix:///path/to/socket, tcp://host:port, host:port, or empty to
+// listen on the port on all interfaces.
+func listenAddress(addr string, port int) (string, string) {
+	if strings.HasPrefix(addr, "unix://") {
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	}
+
+	if addr = strings.TrimPrefix(addr, "tcp://"); addr != "" {
+		return "tcp", addr
+	}
+
+	return "tcp", ":" + strconv.Itoa(port)
+}
+
+// removeSocket removes a stale Unix socket file, left behind by an unclean shutdown
+func removeSocket(path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	// Never remove a file which is not a socket
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("refusing to remove non-socket file: %s", path)
+	}
+
+	return os.Remove(path)
+}
+
+// keepAliveListener sets TCP keep-alive on accepted connections, so connections from clients
+// which have disappeared without closing them are eventually detected and closed
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+// Accept waits for the next connection, and enables keep-alive on it.  Failing to enable keep-alive
+// is logged, but the connection is still served, as an error would stop the server.
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetKeepAlive(true); err != nil {
+		log.Println(err.Error())
+	} else if err := conn.SetKeepAlivePeriod(l.period); err != nil {
+		log.Println(err.Error())
+	}
+
+	return conn, nil
+}
+
+// withKeepAlive wraps a TCP listener to enable keep-alive on accepted connections, if configured
+func withKeepAlive(l net.Listener) net.Listener {
+	tcp, ok := l.(*net.TCPListener)
+	if !ok || !common.Static.Config.KeepAlive.Enabled {
+		return l
+	}
+
+	return keepAliveListener{tcp, time.Duration(common.Static.Config.KeepAlive.Period) * time.Second}
+}
+
+// httpListener creates a listener for HTTP connections, on a TCP port or Unix socket as configured
+func httpListener() (net.Listener, error) {
+	network, address := listenAddress(common.Static.Config.Listen, common.Static.Config.Port)
+
+	// Clean up any stale socket, so the listener can bind to its path
+	if network == "unix" {
+		if err := removeSocket(address); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return withKeepAlive(l), nil
+}
+
+// Listen and handle HTTP (TCP or Unix socket) connections
 func listenHTTP(sendChan chan bool, recvChan chan bool) {
-	// Listen on specified TCP port
-	l, err := net.Listen("tcp", ":"+strconv.Itoa(common.Static.Config.Port))
+	// Listen on specified TCP port or Unix socket
+	l, err := httpListener()
 	if err != nil {
 		log.Println("Cannot start HTTP server, exiting now.")
 		panic(err)
@@ -22,29 +113,52 @@ func listenHTTP(sendChan chan bool, recvChan chan bool) {
 	go handleHTTP(l, sendChan, recvChan)
 }
 
-// Listen and handle HTTPS (SSL over TCP) connections
-func listenHTTPS(sendChan chan bool, recvChan chan bool) {
+// tlsCipherSuites contains the cipher suites permitted for TLS 1.2 connections, all of which provide
+// forward secrecy and authenticated encryption.  TLS 1.3 cipher suites are always permitted.
+var tlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// tlsConfig creates the TLS configuration used for HTTPS connections, using the certificate and key at
+// the specified paths.  Connections must use TLS 1.2 or newer.
+func tlsConfig(certificate string, key string) (*tls.Config, error) {
 	// Load certificate and key
-	cert, err := tls.LoadX509KeyPair(common.Static.Config.SSL.Certificate, common.Static.Config.SSL.Key)
+	cert, err := tls.LoadX509KeyPair(certificate, key)
 	if err != nil {
-		log.Println("Cannot load HTTPS X509 key pair, exiting now.")
-		panic(err)
+		return nil, err
 	}
 
-	// SSL configuration
-	sslConfig := tls.Config{
-		Certificates: []tls.Certificate{cert},
+	return &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		CipherSuites:             tlsCipherSuites,
+		PreferServerCipherSuites: true,
+	}, nil
+}
+
+// Listen and handle HTTPS (TLS over TCP) connections
+func listenHTTPS(sendChan chan bool, recvChan chan bool) {
+	// TLS configuration
+	config, err := tlsConfig(common.Static.Config.SSL.Certificate, common.Static.Config.SSL.Key)
+	if err != nil {
+		log.Println("Cannot load HTTPS X509 key pair, exiting now.")
+		panic(err)
 	}
 
 	// Listen on specified SSL port
-	l, err := tls.Listen("tcp", ":"+strconv.Itoa(common.Static.Config.SSL.Port), &sslConfig)
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(common.Static.Config.SSL.Port))
 	if err != nil {
 		log.Println("Cannot start HTTPS server, exiting now.")
 		panic(err)
 	}
 
 	// Send listener to handler
-	go handleHTTP(l, sendChan, recvChan)
+	go handleHTTP(tls.NewListener(withKeepAlive(l), config), sendChan, recvChan)
 }
 
 // Listen on specified UDP port, accept and handle connections
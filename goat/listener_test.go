@@ -0,0 +1,454 @@
+package goat
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// listenAddressTests contains configured addresses, and the network and address they should produce
+var listenAddressTests = []struct {
+	addr    string
+	network string
+	address string
+}{
+	{"", "tcp", ":8080"},
+	{"unix:///var/run/goat.sock", "unix", "/var/run/goat.sock"},
+	{"tcp://127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+	{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+}
+
+// TestListenAddress verifies that configured listener addresses are parsed properly
+func TestListenAddress(t *testing.T) {
+	log.Println("TestListenAddress()")
+
+	// Iterate all tests
+	for _, test := range listenAddressTests {
+		network, address := listenAddress(test.addr, 8080)
+		if network != test.network || address != test.address {
+			t.Fatalf("listenAddress(%q), expected %s %s, got %s %s", test.addr, test.network, test.address, network, address)
+		}
+	}
+}
+
+// registerHTTPOnce ensures the HTTP handler is only registered once, as multiple tests serve HTTP
+var registerHTTPOnce sync.Once
+
+// registerHTTPHandler registers the HTTP handler with the default mux, as is done on startup
+func registerHTTPHandler() {
+	registerHTTPOnce.Do(func() {
+		http.HandleFunc("/", parseHTTP)
+	})
+}
+
+// TestListenUnix verifies that announces may be served over a Unix domain socket
+func TestListenUnix(t *testing.T) {
+	log.Println("TestListenUnix()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Create temporary socket directory
+	dir, err := ioutil.TempDir("", "goat_unix")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "goat.sock")
+	common.Static.Config.Listen = "unix://" + socket
+
+	// Listen on Unix socket
+	l, err := httpListener()
+	if err != nil {
+		t.Fatalf("Failed to listen on Unix socket: %s", err.Error())
+	}
+
+	// Serve HTTP over socket
+	registerHTTPHandler()
+	sendChan := make(chan bool)
+	recvChan := make(chan bool)
+	go handleHTTP(l, sendChan, recvChan)
+
+	// Connect to socket, and send an announce
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to connect to Unix socket: %s", err.Error())
+	}
+
+	r, err := http.NewRequest("GET", "http://localhost/announce?info_hash=deadbeef&ip=127.0.0.1&port=5000&uploaded=0&downloaded=0&left=10", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %s", err.Error())
+	}
+	r.Header.Set("User-Agent", "goat_test")
+
+	if err := r.Write(conn); err != nil {
+		t.Fatalf("Failed to write HTTP request: %s", err.Error())
+	}
+
+	// Verify a bencoded tracker response is returned
+	res, err := http.ReadResponse(bufio.NewReader(conn), r)
+	if err != nil {
+		t.Fatalf("Failed to read HTTP response: %s", err.Error())
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read HTTP response body: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(string(body), "d") {
+		t.Fatalf("Expected bencoded response, got: %s", string(body))
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Failed to close connection: %s", err.Error())
+	}
+
+	// Stop listener
+	sendChan <- true
+	<-recvChan
+
+	// Verify socket file was cleaned up
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("Unix socket file was not removed on shutdown")
+	}
+}
+
+// TestKeepAliveListener verifies that TCP keep-alive is enabled on accepted HTTP connections
+func TestKeepAliveListener(t *testing.T) {
+	log.Println("TestKeepAliveListener()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Listen = "tcp://127.0.0.1:0"
+	common.Static.Config.KeepAlive.Enabled = true
+	common.Static.Config.KeepAlive.Period = 30
+
+	// Listen on ephemeral TCP port
+	l, err := httpListener()
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP port: %s", err.Error())
+	}
+	defer l.Close()
+
+	if _, ok := l.(keepAliveListener); !ok {
+		t.Fatalf("Listener does not enable keep-alive: %T", l)
+	}
+
+	// Connect to listener, and accept the connection
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to listener: %s", err.Error())
+	}
+	defer client.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept connection: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// Verify keep-alive socket option is set on the accepted connection
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("Failed to access raw connection: %s", err.Error())
+	}
+
+	var keepAlive int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		keepAlive, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	}); err != nil {
+		t.Fatalf("Failed to control raw connection: %s", err.Error())
+	}
+	if sockErr != nil {
+		t.Fatalf("Failed to read keep-alive socket option: %s", sockErr.Error())
+	}
+	if keepAlive == 0 {
+		t.Fatalf("Keep-alive is not enabled on accepted connection")
+	}
+
+	// Verify keep-alive is not applied when disabled
+	common.Static.Config.KeepAlive.Enabled = false
+	l2, err := httpListener()
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP port: %s", err.Error())
+	}
+	defer l2.Close()
+
+	if _, ok := l2.(keepAliveListener); ok {
+		t.Fatalf("Listener enables keep-alive while disabled")
+	}
+}
+
+// writeSelfSignedCert generates a self-signed certificate for 127.0.0.1, writing the certificate and
+// key to the specified directory, and returning their paths and the certificate
+func writeSelfSignedCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err.Error())
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "goat"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err.Error())
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err.Error())
+	}
+
+	// Write certificate and key in PEM format
+	certPath := filepath.Join(dir, "goat.crt")
+	keyPath := filepath.Join(dir, "goat.key")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %s", err.Error())
+	}
+
+	return certPath, keyPath, cert
+}
+
+// TestListenHTTPS verifies that an announce succeeds over HTTPS using a self-signed certificate, and that
+// clients using TLS versions older than 1.2 are refused
+func TestListenHTTPS(t *testing.T) {
+	log.Println("TestListenHTTPS()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Enable maintenance mode, so announces succeed without accessing storage
+	common.Static.Maintenance.Set(true, "")
+	defer common.Static.Maintenance.Set(false, "")
+
+	// Create temporary certificate directory
+	dir, err := ioutil.TempDir("", "goat_https")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath, cert := writeSelfSignedCert(t, dir)
+
+	// Listen using TLS on a random local port
+	tlsConf, err := tlsConfig(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load TLS configuration: %s", err.Error())
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+
+	// Serve HTTPS
+	registerHTTPHandler()
+	sendChan := make(chan bool)
+	recvChan := make(chan bool)
+	go handleHTTP(tls.NewListener(l, tlsConf), sendChan, recvChan)
+
+	// Trust only the self-signed certificate
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	// Send an announce, verifying a successful bencoded tracker response over TLS 1.2 or newer
+	res, err := client.Get("https://" + l.Addr().String() + "/announce?info_hash=deadbeef000000000000&ip=127.0.0.1&port=5000&uploaded=0&downloaded=0&left=10&compact=1")
+	if err != nil {
+		t.Fatalf("Failed to announce via HTTPS: %s", err.Error())
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read HTTPS response body: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(string(body), "d") || strings.Contains(string(body), "failure reason") {
+		t.Fatalf("Expected successful bencoded response, got: %s", string(body))
+	}
+	if res.TLS == nil || res.TLS.Version < tls.VersionTLS12 {
+		t.Fatalf("Expected TLS 1.2 or newer, got: %+v", res.TLS)
+	}
+
+	// Verify clients limited to TLS 1.1 cannot connect
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS11})
+	if err == nil {
+		conn.Close()
+		t.Fatalf("TLS 1.1 connection was accepted")
+	}
+
+	// Stop listener
+	sendChan <- true
+	<-recvChan
+}
+
+// slowStarted reports when a request to the slow handler is in flight
+var slowStarted = make(chan bool)
+
+// registerSlowOnce ensures the slow handler is only registered once
+var registerSlowOnce sync.Once
+
+// registerSlowHandler registers a handler which takes some time to complete its response
+func registerSlowHandler() {
+	registerSlowOnce.Do(func() {
+		http.HandleFunc("/goat_test_slow", func(w http.ResponseWriter, r *http.Request) {
+			slowStarted <- true
+			time.Sleep(500 * time.Millisecond)
+			w.Write([]byte("done"))
+		})
+	})
+}
+
+// TestHTTPGracefulShutdown verifies that in-flight HTTP requests complete on graceful shutdown, and
+// that no new connections are accepted afterwards
+func TestHTTPGracefulShutdown(t *testing.T) {
+	log.Println("TestHTTPGracefulShutdown()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Shutdown.GracePeriod = 5
+
+	// Serve HTTP on a random port
+	registerHTTPHandler()
+	registerSlowHandler()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	address := l.Addr().String()
+
+	sendChan := make(chan bool)
+	recvChan := make(chan bool)
+	go handleHTTP(l, sendChan, recvChan)
+
+	// Issue a request, and trigger shutdown while it is in flight
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		res, err := http.Get("http://" + address + "/goat_test_slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		results <- result{string(body), err}
+	}()
+
+	<-slowStarted
+	go func() {
+		sendChan <- true
+	}()
+
+	// Verify the in-flight request completes
+	res := <-results
+	if res.err != nil || res.body != "done" {
+		t.Fatalf("In-flight request did not complete, got %q: %v", res.body, res.err)
+	}
+
+	// Verify the listener stops, and refuses new connections
+	select {
+	case <-recvChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("HTTP listener did not stop")
+	}
+
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Fatalf("HTTP listener accepted a connection after shutdown")
+	}
+}
+
+// TestUDPGracefulShutdown verifies that the UDP listener stops reading packets and closes on
+// graceful shutdown
+func TestUDPGracefulShutdown(t *testing.T) {
+	log.Println("TestUDPGracefulShutdown()")
+
+	common.Static.Config.Shutdown.GracePeriod = 5
+
+	// Serve UDP on a random port
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to resolve UDP address: %s", err.Error())
+	}
+	l, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+
+	sendChan := make(chan bool)
+	recvChan := make(chan bool)
+	go handleUDP(l, sendChan, recvChan)
+
+	// Trigger shutdown, and verify the listener stops
+	sendChan <- true
+	select {
+	case <-recvChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("UDP listener did not stop")
+	}
+
+	// Verify the listener was closed
+	if _, err := l.WriteToUDP([]byte("goat"), l.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Fatalf("UDP listener was not closed on shutdown")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,7 +23,7 @@ const Version = "git-master"
 // Manager is responsible for coordinating the application
 func Manager(killChan chan bool, exitChan chan int) {
 	// Capture startup time
-	common.Static.StartTime = time.Now().Unix()
+	common.Static.StartTime = common.Now().Unix()
 
 	// Set up logging flags
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
@@ -37,16 +38,16 @@ func Manager(killChan chan bool, exitChan chan int) {
 	}
 
 	// Load configuration
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig(*common.ConfigPath)
 	if config == (common.Conf{}) || err != nil {
 		log.Println(err.Error())
 		panic("Cannot load configuration, panicking")
 	}
 	common.Static.Config = config
 
-	// Check for sane announce interval (10 minutes or more)
-	if common.Static.Config.Interval <= 600 {
-		panic("Announce interval must be at least 600 seconds, panicking")
+	// Check for sane settings, such as an announce interval of more than 10 minutes
+	if err := common.Static.Config.Validate(); err != nil {
+		panic(fmt.Errorf("invalid configuration: %s; panicking", err.Error()))
 	}
 
 	// Attempt database connection
@@ -55,6 +56,25 @@ func Manager(killChan chan bool, exitChan chan int) {
 	}
 	log.Println("Database", data.DBName(), ": OK")
 
+	// Apply any pending schema migrations
+	count, err := data.Migrate()
+	if err != nil {
+		panic(fmt.Errorf("failed to apply schema migrations: %s; panicking", err.Error()))
+	}
+	log.Println("Database", data.DBName(), ": applied", count, "migration(s)")
+
+	// Report readiness, now that the database is initialized
+	atomic.StoreInt32(&ready, 1)
+
+	// Open announce capture file, if configured
+	if common.Static.Config.Capture.Enabled {
+		c := common.Static.Config.Capture
+		if announceCapture, err = newCaptureWriter(c.Path, c.MaxSize, c.Rotations, c.SampleRate); err != nil {
+			panic(fmt.Errorf("cannot open announce capture file %s; panicking", c.Path))
+		}
+		log.Printf("Capturing %.2f%% of announces to %s", c.SampleRate*100, c.Path)
+	}
+
 	// Start cron manager
 	go cronManager()
 
@@ -72,7 +92,8 @@ func Manager(killChan chan bool, exitChan chan int) {
 	// Launch listeners as configured
 	if common.Static.Config.HTTP {
 		go listenHTTP(httpSendChan, httpRecvChan)
-		log.Println("HTTP listener launched on port " + strconv.Itoa(common.Static.Config.Port))
+		network, address := listenAddress(common.Static.Config.Listen, common.Static.Config.Port)
+		log.Println("HTTP listener launched on " + network + " " + address)
 	}
 	if common.Static.Config.SSL.Enabled {
 		go listenHTTPS(httpsSendChan, httpsRecvChan)
@@ -82,6 +103,10 @@ func Manager(killChan chan bool, exitChan chan int) {
 		go listenUDP(udpSendChan, udpRecvChan)
 		log.Println("UDP listener launched on port " + strconv.Itoa(common.Static.Config.Port))
 	}
+	if common.Static.Config.Metrics.Enabled {
+		go listenMetrics()
+		log.Println("Metrics listener launched on " + common.Static.Config.Metrics.Listen)
+	}
 
 	// Wait for shutdown signal
 	for {
@@ -90,9 +115,14 @@ func Manager(killChan chan bool, exitChan chan int) {
 			// Trigger a graceful shutdown
 			log.Println("Triggering graceful shutdown, press Ctrl+C again to force halt")
 
-			// If program hangs for more than 10 seconds, trigger a force halt
+			// Stop reporting readiness, so load balancers stop sending traffic
+			atomic.StoreInt32(&ready, 0)
+
+			// If program hangs for more than 10 seconds, in addition to the grace period each listener
+			// may spend draining in-flight requests, trigger a force halt
+			grace := time.Duration(common.Static.Config.Shutdown.GracePeriod) * time.Second
 			go func() {
-				<-time.After(10 * time.Second)
+				<-time.After(10*time.Second + 3*grace)
 				log.Println("Timeout reached, triggering force halt")
 				if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
 					log.Println(err.Error())
@@ -116,8 +146,21 @@ func Manager(killChan chan bool, exitChan chan int) {
 				<-udpRecvChan
 			}
 
+			// Close announce capture file
+			if announceCapture != nil {
+				if err := announceCapture.Close(); err != nil {
+					log.Println(err.Error())
+				}
+			}
+
+			// Save any announces waiting to be batched
+			if err := data.FlushAnnounceLogs(); err != nil {
+				log.Println(err.Error())
+			}
+
 			log.Println("Closing database:", data.DBName())
 			data.DBCloseFunc()
+			data.RedisClose()
 
 			// Report that program should exit gracefully
 			exitChan <- 0
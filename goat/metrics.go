@@ -0,0 +1,50 @@
+package goat
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// listenMetrics serves tracker metrics at /metrics on the configured metrics address
+func listenMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+
+	if err := http.ListenAndServe(common.Static.Config.Metrics.Listen, mux); err != nil {
+		log.Println(err.Error())
+		log.Println("Cannot start metrics listener")
+	}
+}
+
+// metricsHandler writes tracker metrics in Prometheus text exposition format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := common.WriteMetrics(w); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// refreshMetrics updates the seeder, leecher, and file gauges from storage
+func refreshMetrics() error {
+	// Count all files
+	files, err := new(data.FileRecordRepository).Count()
+	if err != nil {
+		return err
+	}
+
+	// Count active peers on all files
+	seeders, leechers, err := new(data.FileRecordRepository).PeerCounts()
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&common.Static.Metrics.Seeders, int64(seeders))
+	atomic.StoreInt64(&common.Static.Metrics.Leechers, int64(leechers))
+	atomic.StoreInt64(&common.Static.Metrics.Files, int64(files))
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package goat
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestMetricsHandler verifies that the metrics endpoint exports all tracker metrics in Prometheus
+// text exposition format
+func TestMetricsHandler(t *testing.T) {
+	log.Println("TestMetricsHandler()")
+
+	// Record some activity
+	atomic.StoreInt64(&common.Static.Metrics.Announces, 5)
+	atomic.StoreInt64(&common.Static.Metrics.Files, 2)
+
+	// Scrape metrics endpoint
+	r, err := http.NewRequest("GET", "http://localhost:9100/metrics", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %s", err.Error())
+	}
+	w := httptest.NewRecorder()
+	metricsHandler(w, r)
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected Content-Type: %s", w.Header().Get("Content-Type"))
+	}
+
+	// Verify all metrics are present, with their types and values
+	body := w.Body.String()
+	for _, name := range []string{"goat_announces_total", "goat_announce_errors_total", "goat_scrapes_total",
+		"goat_seeders", "goat_leechers", "goat_files"} {
+		if !strings.Contains(body, "# TYPE "+name+" ") {
+			t.Fatalf("Metric %s missing from output:\n%s", name, body)
+		}
+	}
+	for _, line := range []string{"goat_announces_total 5\n", "goat_files 2\n"} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("Metric value %q missing from output:\n%s", strings.TrimSpace(line), body)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package tracker
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"log"
 	"net/url"
 	"strconv"
@@ -16,6 +18,8 @@ import (
 
 // HTTPTracker generates responses in the HTTP bencode format
 type HTTPTracker struct {
+	// warning is sent as a warning message in announce responses, if set
+	warning string
 }
 
 // AnnounceResponse defines the response structure of an HTTP tracker announce
@@ -27,37 +31,66 @@ type AnnounceResponse struct {
 	Peers       string "peers"
 }
 
+// trackerIDSecret is generated when goat starts, so tracker ids remain the same until it restarts
+var trackerIDSecret = common.RandString()
+
+// trackerID returns the tracker id issued to clients announcing a file, which is the same for all
+// files or different for each file, as configured, or an empty string if tracker ids are disabled
+func trackerID(file data.FileRecord) string {
+	var seed string
+	switch common.Static.Config.Announce.TrackerID {
+	case "tracker":
+	case "file":
+		seed = file.InfoHash
+	default:
+		return ""
+	}
+
+	sum := sha1.Sum([]byte(trackerIDSecret + seed))
+	return hex.EncodeToString(sum[:8])
+}
+
+// appendTrailer appends the keys which sort after the peer lists to an unterminated announce
+// response: the tracker id, and the warning message, if they are set
+func (h HTTPTracker) appendTrailer(out []byte, id string) []byte {
+	out = appendString(out, "tracker id", id)
+	return appendString(out, "warning message", h.warning)
+}
+
+// appendString appends a key and string value to an unterminated bencode dictionary, if the value is
+// set.  Keys must sort after those already in the dictionary, so they are appended in sorted order.
+func appendString(out []byte, key string, value string) []byte {
+	if value == "" {
+		return out
+	}
+
+	return append(out, []byte(strconv.Itoa(len(key))+":"+key+strconv.Itoa(len(value))+":"+value)...)
+}
+
 // Announce announces using HTTP format
 func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
-	// Generate response struct
-	announce := AnnounceResponse{
-		Interval:    common.Static.Config.Interval,
-		MinInterval: common.Static.Config.Interval / 2,
+	// Clients echo the tracker id from a previous response, so log any which do not match the
+	// current id.  Clients announcing for the first time send none.
+	id := trackerID(file)
+	if echoed := query.Get("trackerid"); id != "" && echoed != "" && echoed != id {
+		log.Printf("tracker: client %s sent unknown trackerid %q for file %s", query.Get("ip"), echoed, file.InfoHash)
 	}
 
-	// Get seeders count on file
-	var err error
-	announce.Complete, err = file.Seeders()
-	if err != nil {
-		log.Println(err.Error())
+	// Generate response struct, using file's interval override, if set, offset by this peer's jitter
+	announce := AnnounceResponse{
+		Interval:    file.PeerInterval(jitterSeed(query)),
+		MinInterval: file.MinInterval(),
 	}
 
-	// Get leechers count on file
-	announce.Incomplete, err = file.Leechers()
+	// Get seeders and leechers counts on file
+	stats, err := file.ScrapeStats()
 	if err != nil {
 		log.Println(err.Error())
 	}
+	announce.Complete, announce.Incomplete = stats.Seeders, stats.Leechers
 
 	// Check for numwant parameter, return up to that number of peers
-	// Default is 50 per protocol
-	numwant := 50
-	if query.Get("numwant") != "" {
-		// Verify numwant is an integer
-		num, err := strconv.Atoi(query.Get("numwant"))
-		if err == nil {
-			numwant = num
-		}
-	}
+	numwant := numWant(query, file)
 
 	// Marshal struct into bencode
 	buf := bytes.NewBuffer(make([]byte, 0))
@@ -66,24 +99,85 @@ func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 		return h.Error(ErrAnnounceFailure.Error())
 	}
 
+	// Get initial buffer, chop off 3 bytes: "0:e", so the peer list may be appended
+	out := buf.Bytes()
+	out = out[0 : len(out)-3]
+
+	// Peer lists are skipped for seeders only reporting statistics, and clients requesting no peers
+	wantPeers := numwant > 0 && !statOnlyAnnounce(query)
+	leecher := query.Get("left") != "0"
+
+	// Clients which explicitly disable compact receive a list of peer dictionaries
+	if query.Get("compact") == "0" {
+		peers := []byte("le")
+		if wantPeers {
+			if peers, err = peerListDict(file, query.Get("peer_id")+query.Get("ip"), leecher, numwant, true); err != nil {
+				log.Println(err.Error())
+				return h.Error(ErrPeerListFailure.Error())
+			}
+		}
+
+		out = append(out, peers...)
+		return append(h.appendTrailer(out, id), byte('e'))
+	}
+
 	// Generate compact peer list of length numwant
-	// Note: because we are HTTP, we can mark second parameter as 'true' to get a
+	// Note: because we are HTTP, we can mark last parameter as 'true' to get a
 	// more accurate peer list
-	compactPeers, err := file.CompactPeerList(numwant, true)
-	if err != nil {
-		log.Println(err.Error())
-		return h.Error(ErrPeerListFailure.Error())
+	compactPeers, compactPeers6 := make([]byte, 0), make([]byte, 0)
+	if wantPeers {
+		compactPeers, compactPeers6, err = compactPeerList(file, query.Get("peer_id")+query.Get("ip"), leecher, numwant, true)
+		if err != nil {
+			log.Println(err.Error())
+			return h.Error(ErrPeerListFailure.Error())
+		}
 	}
 
 	// Because the bencode marshaler does not handle compact, binary peer list conversion,
-	// we handle it manually here.
+	// we handle it manually here, appending the actual list length with new colon
+	out = append(out, []byte(strconv.Itoa(len(compactPeers))+":")...)
 
-	// Get initial buffer, chop off 3 bytes: "0:e", append the actual list length with new colon
-	out := buf.Bytes()
-	out = append(out[0:len(out)-3], []byte(strconv.Itoa(len(compactPeers))+":")...)
+	// Append peers list
+	out = append(out, compactPeers...)
+
+	// Append IPv6 peers list, if any, which sorts after peers as BEP 7 requires
+	if len(compactPeers6) > 0 {
+		out = append(out, []byte("6:peers6"+strconv.Itoa(len(compactPeers6))+":")...)
+		out = append(out, compactPeers6...)
+	}
+
+	// Append tracker id and warning message, if set, and terminate with an "e"
+	return append(h.appendTrailer(out, id), byte('e'))
+}
+
+// maintenanceResponse defines the response structure of an HTTP tracker announce during maintenance
+type maintenanceResponse struct {
+	Complete       int    "complete"
+	Incomplete     int    "incomplete"
+	Interval       int    "interval"
+	MinInterval    int    "min interval"
+	Peers          string "peers"
+	WarningMessage string "warning message"
+}
+
+// Maintenance reports a bencoded []byte announce response with no peers, an extended interval, and a
+// warning message, so clients back off during maintenance without storage being accessed
+func (h HTTPTracker) Maintenance(message string) []byte {
+	interval := maintenanceInterval()
+	res := maintenanceResponse{
+		Interval:       interval,
+		MinInterval:    interval / 2,
+		WarningMessage: "Maintenance: " + message,
+	}
+
+	// Marshal struct into bencode
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := bencode.Marshal(buf, res); err != nil {
+		log.Println(err.Error())
+		return h.Error(ErrAnnounceFailure.Error())
+	}
 
-	// Append peers list, terminate with an "e"
-	return append(append(out, compactPeers...), byte('e'))
+	return buf.Bytes()
 }
 
 // errorResponse defines the response structure of an HTTP tracker error
@@ -95,11 +189,8 @@ type errorResponse struct {
 
 // Error reports a bencoded []byte response as specified by input string
 func (h HTTPTracker) Error(err string) []byte {
-	res := errorResponse{
-		FailureReason: err,
-		Interval:      common.Static.Config.Interval,
-		MinInterval:   common.Static.Config.Interval / 2,
-	}
+	res := errorResponse{FailureReason: err}
+	res.Interval, res.MinInterval = data.ComputeInterval()
 
 	// Marshal struct into bencode
 	buf := bytes.NewBuffer(make([]byte, 0))
@@ -121,6 +212,19 @@ type scrapeResponse struct {
 	Files map[string]scrapeFile "files"
 }
 
+// scrapeFlagsResponse defines the top-level response structure of an HTTP tracker scrape, including
+// the flags extension
+type scrapeFlagsResponse struct {
+	Files map[string]scrapeFile "files"
+	Flags scrapeFlags           "flags"
+}
+
+// scrapeFlags defines the fields of the scrape flags extension, which informs clients how often they
+// may scrape
+type scrapeFlags struct {
+	MinRequestInterval int "min_request_interval"
+}
+
 // scrapeFile defines the fields of a scrape response for a single info_hash
 type scrapeFile struct {
 	Complete   int "complete"
@@ -146,26 +250,17 @@ func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
 	// Iterate all files in parallel
 	for _, f := range files {
 		go func(f data.FileRecord, scrape *scrapeResponse, mutex *sync.RWMutex, wg *sync.WaitGroup) {
-			// Generate scrapeFile struct
-			fileInfo := scrapeFile{}
-			var err error
-
-			// Seeders count
-			fileInfo.Complete, err = f.Seeders()
-			if err != nil {
-				log.Println(err.Error())
-			}
-
-			// Completion count
-			fileInfo.Downloaded, err = f.Completed()
+			// Seeders, leechers, and completion counts, possibly cached
+			stats, err := f.ScrapeStats()
 			if err != nil {
 				log.Println(err.Error())
 			}
 
-			// Leechers count
-			fileInfo.Incomplete, err = f.Leechers()
-			if err != nil {
-				log.Println(err.Error())
+			// Generate scrapeFile struct
+			fileInfo := scrapeFile{
+				Complete:   stats.Seeders,
+				Downloaded: stats.Completed,
+				Incomplete: stats.Leechers,
 			}
 
 			// Add hash and file info to map
@@ -181,9 +276,20 @@ func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
 	// Wait for all information to be generated
 	wg.Wait()
 
+	// Include flags extension with minimum scrape interval, if configured
+	var res interface{} = scrape
+	if interval := common.Static.Config.Scrape.MinRequestInterval; interval > 0 {
+		res = scrapeFlagsResponse{
+			Files: scrape.Files,
+			Flags: scrapeFlags{
+				MinRequestInterval: interval,
+			},
+		}
+	}
+
 	// Marshal struct into bencode
 	buf := bytes.NewBuffer(make([]byte, 0))
-	if err := bencode.Marshal(buf, scrape); err != nil {
+	if err := bencode.Marshal(buf, res); err != nil {
 		log.Println(err.Error())
 		return h.Error(ErrScrapeFailure.Error())
 	}
@@ -18,7 +18,7 @@ func TestHTTPAnnounce(t *testing.T) {
 	log.Println("TestHTTPAnnounce()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -131,3 +131,482 @@ func TestHTTPTrackerScrape(t *testing.T) {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
 }
+
+// TestHTTPTrackerScrapeFlags verifies that the HTTP tracker scrape includes the flags extension only when
+// a minimum scrape interval is configured
+func TestHTTPTrackerScrapeFlags(t *testing.T) {
+	log.Println("TestHTTPTrackerScrapeFlags()")
+
+	// Load config, with flags disabled
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Scrape.MinRequestInterval = 0
+	common.Static.Config = config
+
+	// Verify flags are omitted when disabled
+	tracker := HTTPTracker{}
+	res := tracker.Scrape(nil)
+	if bytes.Contains(res, []byte("flags")) {
+		t.Fatalf("Scrape contained flags when disabled: %s", string(res))
+	}
+
+	// Enable flags, trigger a scrape
+	common.Static.Config.Scrape.MinRequestInterval = 900
+	res = tracker.Scrape(nil)
+	log.Println(string(res))
+
+	// Unmarshal response, verify minimum request interval
+	scrape := scrapeFlagsResponse{}
+	if err := bencode.Unmarshal(bytes.NewReader(res), &scrape); err != nil {
+		t.Fatalf("Failed to unmarshal bencode scrape response")
+	}
+	if !bytes.Contains(res, []byte("5:flagsd20:min_request_intervali900e")) {
+		t.Fatalf("Scrape missing flags/min_request_interval: %s", string(res))
+	}
+	if scrape.Flags.MinRequestInterval != 900 {
+		t.Fatalf("Mismatched min_request_interval, expected %d, got %d", 900, scrape.Flags.MinRequestInterval)
+	}
+}
+
+// TestHTTPStatOnlyAnnounce verifies that a seeder's stat-only announce returns counts without peer selection
+func TestHTTPStatOnlyAnnounce(t *testing.T) {
+	log.Println("TestHTTPStatOnlyAnnounce()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.PeerList.StatOnlySeeders = true
+	common.Static.Config = config
+
+	// Count peer list generations, restoring the original function afterwards
+	calls := 0
+	defaultPeerList := compactPeerList
+	compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+		calls++
+		return defaultPeerList(file, key, leecher, numwant, http)
+	}
+	defer func() {
+		compactPeerList = defaultPeerList
+	}()
+
+	// Generate mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+
+	// Save mock file
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Generate fake stat-only seeder announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "0")
+	query.Set("numwant", "0")
+
+	// Create a HTTP tracker, trigger an announce
+	tracker := HTTPTracker{}
+	res := tracker.Announce(query, file)
+	log.Println(string(res))
+
+	// Unmarshal response
+	announce := AnnounceResponse{}
+	if err := bencode.Unmarshal(bytes.NewReader(res), &announce); err != nil {
+		t.Fatalf("Failed to unmarshal bencode announce response")
+	}
+
+	// Verify peer selection was skipped, and no peers returned
+	if calls != 0 {
+		t.Fatalf("Peer list generated %d times for stat-only announce", calls)
+	}
+	if announce.Peers != "" {
+		t.Fatalf("Unexpected peers in stat-only announce: %q", announce.Peers)
+	}
+
+	// Verify a leecher requesting no peers does not generate a peer list
+	query.Set("left", "100")
+	tracker.Announce(query, file)
+	if calls != 0 {
+		t.Fatalf("Peer list generated %d times for announce with numwant 0", calls)
+	}
+
+	// Verify a leecher announce still generates a peer list
+	query.Set("numwant", "50")
+	tracker.Announce(query, file)
+	if calls != 1 {
+		t.Fatalf("Peer list generated %d times for leecher announce, expected 1", calls)
+	}
+
+	// Delete mock file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestHTTPAnnounceOverrides verifies that a file's stored interval and peer limit overrides are used in place of global configuration
+func TestHTTPAnnounceOverrides(t *testing.T) {
+	log.Println("TestHTTPAnnounceOverrides()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Capture requested peer list size, restoring the original function afterwards
+	requested := 0
+	defaultPeerList := compactPeerList
+	compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+		requested = numwant
+		return defaultPeerList(file, key, leecher, numwant, http)
+	}
+	defer func() {
+		compactPeerList = defaultPeerList
+	}()
+
+	// Generate mock data.FileRecord with overrides
+	file := data.FileRecord{
+		InfoHash:         "6465616462656566303030303030303030303030",
+		Verified:         true,
+		AnnounceInterval: config.Interval + 600,
+		PeerLimit:        5,
+	}
+
+	// Save mock file, and reload it to verify overrides are stored
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+	if file.AnnounceInterval != config.Interval+600 || file.PeerLimit != 5 {
+		t.Fatalf("Overrides not stored, got interval %d, peer limit %d", file.AnnounceInterval, file.PeerLimit)
+	}
+
+	// Generate fake announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "100")
+	query.Set("numwant", "50")
+
+	// Create a HTTP tracker, trigger an announce
+	tracker := HTTPTracker{}
+	res := tracker.Announce(query, file)
+
+	// Unmarshal response
+	announce := AnnounceResponse{}
+	if err := bencode.Unmarshal(bytes.NewReader(res), &announce); err != nil {
+		t.Fatalf("Failed to unmarshal bencode announce response")
+	}
+
+	// Verify overrides were honored
+	if announce.Interval != config.Interval+600 || announce.MinInterval != (config.Interval+600)/2 {
+		t.Fatalf("Interval, expected %d, got %d (min %d)", config.Interval+600, announce.Interval, announce.MinInterval)
+	}
+	if requested != 5 {
+		t.Fatalf("Peer list size, expected 5, got %d", requested)
+	}
+
+	// Verify a file without overrides uses global configuration
+	file.AnnounceInterval = 0
+	if interval := file.Interval(); interval != config.Interval {
+		t.Fatalf("Interval without override, expected %d, got %d", config.Interval, interval)
+	}
+
+	// Delete mock file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestHTTPAnnouncePeers6 verifies that IPv6 peers are returned in a separate peers6 list, per BEP 7
+func TestHTTPAnnouncePeers6(t *testing.T) {
+	log.Println("TestHTTPAnnouncePeers6()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate one IPv4 and one IPv6 peer, restoring the original function afterwards
+	peer4, err := data.Peer{IP: "10.0.0.1", Port: 5001}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal IPv4 peer: %s", err.Error())
+	}
+	peer6, err := data.Peer{IP: "2001:db8::1", Port: 5002}.MarshalBinary6()
+	if err != nil {
+		t.Fatalf("Failed to marshal IPv6 peer: %s", err.Error())
+	}
+
+	defaultPeerList := compactPeerList
+	compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+		return peer4, peer6, nil
+	}
+	defer func() {
+		compactPeerList = defaultPeerList
+	}()
+
+	// Generate mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+
+	// Generate fake announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("left", "100")
+
+	// Create a HTTP tracker, trigger an announce
+	tracker := HTTPTracker{}
+	res := tracker.Announce(query, file)
+	log.Println(string(res))
+
+	// Verify both peer lists are present, with peers6 after peers
+	expected := append(append([]byte("5:peers6:"), peer4...), []byte("6:peers618:")...)
+	expected = append(append(expected, peer6...), byte('e'))
+	if !bytes.HasSuffix(res, expected) {
+		t.Fatalf("Announce missing peers and peers6 lists: %q", string(res))
+	}
+
+	// Verify peers6 is omitted when there are no IPv6 peers.  The peers key followed by a peer
+	// list of length 6 also spells "peers6", so check for the bencoded key itself.
+	peer6 = nil
+	if res := tracker.Announce(query, file); bytes.Contains(res, []byte("6:peers6")) {
+		t.Fatalf("Announce contained empty peers6 list: %q", string(res))
+	}
+}
+
+// TestHTTPAnnounceDict verifies that clients which disable compact receive a list of peer dictionaries,
+// and that other clients receive a compact peer list
+func TestHTTPAnnounceDict(t *testing.T) {
+	log.Println("TestHTTPAnnounceDict()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Serve one peer in either format, restoring the original functions afterwards
+	peer := data.Peer{IP: "10.0.0.1", Port: 5001}
+	compact, err := peer.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal peer: %s", err.Error())
+	}
+	dict := []byte("ld2:ip8:10.0.0.14:porti5001eee")
+
+	defaultPeerList, defaultPeerListDict := compactPeerList, peerListDict
+	compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+		return compact, nil, nil
+	}
+	peerListDict = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, error) {
+		return dict, nil
+	}
+	defer func() {
+		compactPeerList, peerListDict = defaultPeerList, defaultPeerListDict
+	}()
+
+	// Generate mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+
+	// Generate fake announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("left", "100")
+
+	// Verify compact is the default, and may be requested explicitly
+	tracker := HTTPTracker{}
+	for _, c := range []string{"", "1"} {
+		query.Set("compact", c)
+		res := tracker.Announce(query, file)
+		if !bytes.HasSuffix(res, append(append([]byte("5:peers6:"), compact...), 'e')) {
+			t.Fatalf("Announce with compact=%q missing compact peer list: %q", c, string(res))
+		}
+	}
+
+	// Verify disabling compact returns peer dictionaries, which decode as a list
+	query.Set("compact", "0")
+	res := tracker.Announce(query, file)
+	log.Println(string(res))
+	if !bytes.HasSuffix(res, append(append([]byte("5:peers"), dict...), 'e')) {
+		t.Fatalf("Announce with compact=0 missing dictionary peer list: %q", string(res))
+	}
+
+	decoded, err := bencode.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatalf("Failed to decode dictionary announce response: %s", err.Error())
+	}
+	response, ok := decoded.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Announce response is not a dictionary: %#v", decoded)
+	}
+	if peers, ok := response["peers"].([]interface{}); !ok || len(peers) != 1 {
+		t.Fatalf("Announce response peers is not a list of 1 peer: %#v", response["peers"])
+	}
+}
+
+// trackerIDResponse contains the tracker id from an HTTP tracker announce response
+type trackerIDResponse struct {
+	TrackerID string "tracker id"
+}
+
+// TestTrackerID verifies that tracker ids are issued for all files or for each file, as configured
+func TestTrackerID(t *testing.T) {
+	log.Println("TestTrackerID()")
+
+	file := data.FileRecord{InfoHash: "6465616462656566303030303030303030303030"}
+	file2 := data.FileRecord{InfoHash: "6265656664656164303030303030303030303030"}
+
+	// Verify no tracker id is issued when disabled
+	common.Static.Config.Announce.TrackerID = ""
+	if id := trackerID(file); id != "" {
+		t.Fatalf("Tracker id issued while disabled: %q", id)
+	}
+	if out := appendString([]byte("d"), "tracker id", trackerID(file)); string(out) != "d" {
+		t.Fatalf("Empty tracker id was appended: %q", string(out))
+	}
+
+	// Verify one tracker id is issued for all files
+	common.Static.Config.Announce.TrackerID = "tracker"
+	if id := trackerID(file); id == "" || id != trackerID(file2) {
+		t.Fatalf("Tracker-wide ids, expected equal, got %q and %q", id, trackerID(file2))
+	}
+
+	// Verify a stable tracker id is issued for each file
+	common.Static.Config.Announce.TrackerID = "file"
+	if id := trackerID(file); id == "" || id != trackerID(file) || id == trackerID(file2) {
+		t.Fatalf("Per-file ids, expected stable and distinct, got %q and %q", id, trackerID(file2))
+	}
+
+	// Verify an appended tracker id is part of the response dictionary
+	var res trackerIDResponse
+	out := append(appendString([]byte("d8:intervali3600e"), "tracker id", trackerID(file)), byte('e'))
+	if err := bencode.Unmarshal(bytes.NewReader(out), &res); err != nil || res.TrackerID != trackerID(file) {
+		t.Fatalf("Appended tracker id, expected %q, got %q: %v", trackerID(file), res.TrackerID, err)
+	}
+
+	common.Static.Config.Announce.TrackerID = ""
+}
+
+// TestHTTPAnnounceTrackerID verifies that the tracker id appears in HTTP announce responses only
+// when enabled, including when a client echoes an earlier tracker id
+func TestHTTPAnnounceTrackerID(t *testing.T) {
+	log.Println("TestHTTPAnnounceTrackerID()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Generate fake announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "0")
+
+	// announce triggers an announce, returning the tracker id from the response
+	announce := func() string {
+		res := HTTPTracker{}.Announce(query, file)
+
+		var announce trackerIDResponse
+		if err := bencode.Unmarshal(bytes.NewReader(res), &announce); err != nil {
+			t.Fatalf("Failed to unmarshal bencode announce response: %s", string(res))
+		}
+
+		return announce.TrackerID
+	}
+
+	// Verify no tracker id is sent when disabled
+	if id := announce(); id != "" {
+		t.Fatalf("Tracker id sent while disabled: %q", id)
+	}
+
+	// Verify the tracker id is sent on a first announce, and again when echoed, in both response formats
+	common.Static.Config.Announce.TrackerID = "file"
+	id := announce()
+	if id == "" || id != trackerID(file) {
+		t.Fatalf("First announce, expected tracker id %q, got %q", trackerID(file), id)
+	}
+
+	query.Set("trackerid", id)
+	query.Set("compact", "0")
+	if id2 := announce(); id2 != id {
+		t.Fatalf("Echoed tracker id, expected %q, got %q", id, id2)
+	}
+
+	// Delete mock file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// trailerResponse contains the keys which follow the peer lists in an HTTP tracker announce response
+type trailerResponse struct {
+	Interval       int    "interval"
+	TrackerID      string "tracker id"
+	WarningMessage string "warning message"
+}
+
+// TestHTTPAnnounceTrailer verifies that the tracker id and warning message are appended in sorted
+// order, so the response is a valid bencode dictionary when both are set
+func TestHTTPAnnounceTrailer(t *testing.T) {
+	log.Println("TestHTTPAnnounceTrailer()")
+
+	// Verify nothing is appended when neither is set
+	if out := (HTTPTracker{}).appendTrailer([]byte("d"), ""); string(out) != "d" {
+		t.Fatalf("Empty trailer was appended: %q", string(out))
+	}
+
+	// Verify both are appended in sorted order, and can be decoded
+	out := append(HTTPTracker{warning: "low ratio"}.appendTrailer([]byte("d8:intervali3600e"), "abcd"), byte('e'))
+	expected := "d8:intervali3600e10:tracker id4:abcd15:warning message9:low ratioe"
+	if string(out) != expected {
+		t.Fatalf("Announce trailer, expected %q, got %q", expected, string(out))
+	}
+
+	var res trailerResponse
+	if err := bencode.Unmarshal(bytes.NewReader(out), &res); err != nil || res.TrackerID != "abcd" || res.WarningMessage != "low ratio" {
+		t.Fatalf("Failed to decode announce trailer %+v: %v", res, err)
+	}
+}
@@ -2,9 +2,13 @@ package tracker
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 
+	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 )
 
@@ -22,6 +26,68 @@ var (
 	ErrScrapeFailure = errors.New("tracker: failed to create scrape response")
 )
 
+// compactPeerList generates compact IPv4 and IPv6 peer lists for a file, and may be replaced for testing
+var compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+	return file.CompactPeerList(key, leecher, numwant, http)
+}
+
+// isBanned determines if an IP address or user ID is banned, and may be replaced for testing
+var isBanned = data.IsBanned
+
+// defaultNumWant is the number of peers returned to a client which does not specify numwant
+const defaultNumWant = 50
+
+// numWant returns the number of peers to return for an announce, using the client's numwant
+// parameter, or the default if it is absent or invalid.  It is capped by the configured maximum,
+// and by the file's peer limit, if set.  A numwant of 0 requests no peers.
+func numWant(query url.Values, file data.FileRecord) int {
+	numwant, err := strconv.Atoi(query.Get("numwant"))
+	if err != nil || numwant < 0 {
+		numwant = defaultNumWant
+	}
+
+	// Cap numwant using configured maximum, if set
+	if max := common.Static.Config.PeerList.MaxNumWant; max > 0 && numwant > max {
+		numwant = max
+	}
+
+	// Cap numwant using file's peer limit, if set
+	return file.NumWant(numwant)
+}
+
+// peerListDict generates a bencoded list of peer dictionaries for a file, and may be replaced for testing
+var peerListDict = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, error) {
+	return file.PeerListDict(key, leecher, numwant, http)
+}
+
+// maintenanceInterval returns the announce interval sent to clients during maintenance, which is the
+// configured extended interval, or twice the normal interval if none is configured
+func maintenanceInterval() int {
+	if interval := common.Static.Config.Maintenance.Interval; interval > 0 {
+		return interval
+	}
+
+	return common.Static.Config.Interval * 2
+}
+
+// statOnlyAnnounce determines if an announce is from a seeder which is only reporting statistics,
+// and wants no peers, meaning no peer list need be generated
+func statOnlyAnnounce(query url.Values) bool {
+	if !common.Static.Config.PeerList.StatOnlySeeders {
+		return false
+	}
+
+	event := query.Get("event")
+	return query.Get("left") == "0" && query.Get("numwant") == "0" && (event == "" || event == "started")
+}
+
+// firstAnnounceAllowed determines if a peer with no existing record may join the swarm using an
+// announce with the specified event.  Any announce is treated as an implicit start, unless an
+// explicit started event is required.
+func firstAnnounceAllowed(event string) bool {
+	return !common.Static.Config.RequireStarted || event == "started"
+}
+
 // TorrentTracker defines the common interface for trackers to generate their responses
 type TorrentTracker interface {
 	Announce(url.Values, data.FileRecord) []byte
@@ -30,21 +96,81 @@ type TorrentTracker interface {
 	Scrape([]data.FileRecord) []byte
 }
 
-// Announce generates and triggers a tracker announces request
+// WarningHook returns a warning message for a user announcing a file, which is sent in HTTP announce
+// responses.  An empty string sends no warning.  It may be replaced to issue other warnings.
+var WarningHook = LowRatioWarning
+
+// LowRatioWarning warns users whose share ratio is below the configured minimum, once they have
+// downloaded any data
+func LowRatioWarning(user data.UserRecord, file data.FileRecord) string {
+	min := common.Static.Config.Announce.LowRatioWarning
+	if min <= 0 || user.ID == 0 {
+		return ""
+	}
+
+	ratio, err := user.Ratio()
+	if err != nil {
+		log.Println(err.Error())
+		return ""
+	}
+
+	// A ratio of 0 is also reported for users who have not transferred any data, who are not warned
+	if ratio == 0 {
+		downloaded, err := user.Downloaded()
+		if err != nil {
+			log.Println(err.Error())
+			return ""
+		}
+
+		if downloaded == 0 {
+			return ""
+		}
+	}
+
+	return ratioWarning(ratio, min)
+}
+
+// ratioWarning generates a low ratio warning if a share ratio is below the minimum.  Users who have
+// only uploaded have an infinite ratio, and are never warned.
+func ratioWarning(ratio float64, min float64) string {
+	if ratio == data.RatioInfinite || ratio >= min {
+		return ""
+	}
+
+	return fmt.Sprintf("Your share ratio is %.2f, below the minimum of %.2f. Please seed to improve it.", ratio, min)
+}
+
+// Announce generates and triggers a tracker announces request.  The query must contain remote_ip, the
+// address of the client's connection, which is used to enforce IP bans.
 func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []byte {
+	// Count announce, and any error response returned for it
+	atomic.AddInt64(&common.Static.Metrics.Announces, 1)
+	fail := func(msg string) []byte {
+		atomic.AddInt64(&common.Static.Metrics.AnnounceErrors, 1)
+		return tracker.Error(msg)
+	}
+
 	// Store announce information in struct
 	announce := new(data.AnnounceLog)
 	err := announce.FromValues(query)
 	if err != nil {
-		return tracker.Error("Malformed announce")
+		return fail("Malformed announce")
 	}
 
-	// Request to store announce
-	go func(announce *data.AnnounceLog) {
-		if err := announce.Save(); err != nil {
-			log.Println(err.Error())
-		}
-	}(announce)
+	// Reject clients older than their configured minimum version
+	allowed, err := data.ClientVersionAllowed([]byte(query.Get("peer_id")), common.Static.Config.MinClientVersions)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	if !allowed {
+		return fail("Client version not supported, please upgrade")
+	}
+
+	// Reject announces from banned IP addresses and users, checking the address of the connection
+	// rather than the IP address the client advertises
+	if isBanned(query.Get("remote_ip"), user.ID) {
+		return fail("Banned")
+	}
 
 	// Only report event when needed
 	event := ""
@@ -56,13 +182,13 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 
 	// Check for a matching file via info_hash
 	file, err := new(data.FileRecord).Load(announce.InfoHash, "info_hash")
-	if err != nil {
+	if err != nil && err != data.ErrNotFound {
 		log.Println(err.Error())
-		return tracker.Error(ErrAnnounceFailure.Error())
+		return fail(ErrAnnounceFailure.Error())
 	}
 
 	// Torrent is currently unregistered
-	if file == (data.FileRecord{}) {
+	if err == data.ErrNotFound {
 		log.Printf("tracker: detected new file, awaiting manual approval [hash: %s]", announce.InfoHash)
 
 		// Create an entry in file table for this hash, but mark it as unverified
@@ -77,78 +203,99 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		}(file)
 
 		// Report error
-		return tracker.Error("Unregistered torrent")
+		return fail("Unregistered torrent")
 	}
 
 	// Ensure file is verified, meaning we will permit tracking of it
 	if !file.Verified {
-		return tracker.Error("Unverified torrent")
+		return fail("Unverified torrent")
 	}
 
 	// Launch peer reaper asynchronously to remove old peers from this file
 	go func(file data.FileRecord) {
 		// Start peer reaper
-		count, err := file.PeerReaper()
+		count, err := file.PeerReaper(common.Now().Unix())
 		if err != nil {
 			log.Println(err.Error())
 		}
 
 		// Report peers reaped
 		if count > 0 {
-			log.Println("peerReaper: reaped %d peers on file ID: %d", count, file.ID)
+			log.Printf("peerReaper: reaped %d peers on file ID: %d", count, file.ID)
 		}
 	}(file)
 
 	// If UDP tracker, we cannot reliably detect user, so we announce anonymously
 	if _, ok := tracker.(UDPTracker); ok {
+		recordAnnounce(*announce)
 		return tracker.Announce(query, file)
 	}
 
-	// Check existing record for this user with this file and this IP
-	fileUser, err := new(data.FileUserRecord).Load(file.ID, user.ID, query.Get("ip"))
-	if err != nil {
+	// Check existing record for this user with this file and this announce key, or this IP
+	fileUser, err := new(data.FileUserRecord).LoadPeer(file.ID, user.ID, query.Get("ip"), announce.Key)
+	if err != nil && err != data.ErrNotFound {
 		log.Println(err.Error())
-		return tracker.Error(ErrAnnounceFailure.Error())
+		return fail(ErrAnnounceFailure.Error())
+	}
+
+	// Reject torrents which are not already active for this user, once their torrent limit is reached.
+	// The torrent may be active for this user from another IP, in which case it is already counted.
+	// Anonymous users have no limit.
+	if user.ID != 0 && !fileUser.Active && announce.Event != "stopped" {
+		activeOnFile, err := data.ActiveOnFile(user.ID, file.ID)
+		if err != nil {
+			log.Println(err.Error())
+			return fail(ErrAnnounceFailure.Error())
+		}
+
+		active, err := data.ActiveTorrentCount(user.ID)
+		if err != nil {
+			log.Println(err.Error())
+			return fail(ErrAnnounceFailure.Error())
+		}
+
+		if !activeOnFile && active >= user.TorrentLimit {
+			return fail(fmt.Sprintf("Exceeded active torrent limit: %d torrents active, limit %d", active, user.TorrentLimit))
+		}
 	}
 
 	// New user, starting torrent
-	if fileUser == (data.FileUserRecord{}) {
+	if err == data.ErrNotFound {
+		// Reject peers joining without a started event, if required
+		if !firstAnnounceAllowed(announce.Event) {
+			return fail("Announce must begin with a started event")
+		}
+
 		// Create new relationship
 		fileUser.FileID = file.ID
 		fileUser.UserID = user.ID
 		fileUser.IP = query.Get("ip")
-		fileUser.Active = true
 		fileUser.Announced = 1
 
-		// If announce reports 0 left, but no existing record, user is probably the initial seeder
-		if announce.Left == 0 {
-			fileUser.Completed = true
-		} else {
-			fileUser.Completed = false
-		}
-
 		// Track the initial uploaded, download, and left values
 		// NOTE: clients report absolute values, so delta should NEVER be calculated for these
 		fileUser.Uploaded = announce.Uploaded
 		fileUser.Downloaded = announce.Downloaded
 		fileUser.Left = announce.Left
 	} else {
-		// Else, pre-existing record, so update
-		// Event "stopped", mark as inactive
-		// NOTE: likely only reported by clients which are actively seeding, NOT when stopped during leeching
-		if announce.Event == "stopped" {
-			fileUser.Active = false
-		} else {
-			// Else, "started", "completed", or no status, mark as active
-			fileUser.Active = true
-		}
-
-		// Check for completion
-		// Could be from a peer stating completed, or a seed reporting 0 left
-		if announce.Event == "completed" || announce.Left == 0 {
-			fileUser.Completed = true
-		} else {
-			fileUser.Completed = false
+		// Else, pre-existing record, so check that statistics have not increased faster than possible
+		// since the last announce, if enabled
+		conf := common.Static.Config.StatCheck
+		if conf.Enabled && fileUser.ImplausibleRate(announce.Uploaded, announce.Downloaded, announce.Time, conf.MaxRate) {
+			log.Printf("tracker: implausible statistics [user: %d, file: %d, uploaded: %d -> %d, downloaded: %d -> %d, elapsed: %ds]",
+				user.ID, file.ID, fileUser.Uploaded, announce.Uploaded, fileUser.Downloaded, announce.Downloaded, announce.Time-fileUser.Time)
+
+			// If configured, disable the offending user's account
+			if conf.Ban {
+				user.Banned = true
+				go func(user data.UserRecord) {
+					if err := user.Save(); err != nil {
+						log.Println(err.Error())
+					}
+				}(user)
+
+				return fail("Account disabled")
+			}
 		}
 
 		// Add an announce
@@ -171,19 +318,115 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		}
 	}
 
+	// Announce was accepted, so record it
+	recordAnnounce(*announce)
+
+	// Update peer state according to the reported event
+	applyAnnounceEvent(announce, &fileUser)
+
+	// Store peer ID, so a dual-stack client is counted once in swarm totals
+	fileUser.PeerID = announce.PeerID
+
+	// Store announce key, and if the peer was matched by key from another IP in the same address family,
+	// move its record to the current IP, rather than creating a duplicate
+	fileUser.Key = announce.Key
+	movedFrom := ""
+	if err == nil && fileUser.IP != query.Get("ip") {
+		movedFrom = fileUser.IP
+		fileUser.IP = query.Get("ip")
+	}
+
 	// Update file/user relationship record asynchronously
-	go func(fileUser data.FileUserRecord) {
+	go func(fileUser data.FileUserRecord, movedFrom string) {
+		if movedFrom != "" {
+			if err := fileUser.Move(movedFrom); err != nil {
+				log.Println(err.Error())
+				return
+			}
+		}
+
 		if err := fileUser.Save(); err != nil {
 			log.Println(err.Error())
 		}
-	}(fileUser)
+	}(fileUser, movedFrom)
+
+	// Send a warning to the user with HTTP announce responses, if there is one
+	if h, ok := tracker.(HTTPTracker); ok && WarningHook != nil {
+		h.warning = WarningHook(user, file)
+		tracker = h
+	}
 
 	// Create announce
 	return tracker.Announce(query, file)
 }
 
-// Scrape generates and triggers a tracker scrape request
-func Scrape(tracker TorrentTracker, query url.Values) []byte {
+// recordAnnounce asynchronously stores an accepted announce, batched with others if configured, and if
+// enabled, records the peer in Redis swarm state.  Rejected announces are not recorded, so they do not
+// appear in the swarm.
+func recordAnnounce(announce data.AnnounceLog) {
+	go func() {
+		if err := data.QueueAnnounceLog(announce); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	if common.Static.Config.Redis.Enabled {
+		go func() {
+			peer := data.Peer{
+				IP:     announce.IP,
+				Port:   uint16(announce.Port),
+				Seeder: announce.Left == 0,
+			}
+
+			if err := data.RedisAnnounce(announce.InfoHash, peer, announce.Event == "stopped", announce.Time); err != nil {
+				log.Println(err.Error())
+			}
+		}()
+	}
+}
+
+// applyAnnounceEvent updates a file/user relationship record according to the event and statistics
+// reported by an announce:
+//   - "stopped": the peer is marked inactive
+//   - "started", "completed", or no event: the peer is marked active
+//   - "completed", or nothing left: the peer is marked completed, and the file snatched, counting
+//     towards the file's completed total.  A new peer with nothing left is likely the initial seeder.
+//
+// The last event is also recorded, for diagnostics.
+func applyAnnounceEvent(announce *data.AnnounceLog, fileUser *data.FileUserRecord) {
+	// NOTE: "stopped" is likely only reported by clients which are actively seeding, NOT when stopped during leeching
+	fileUser.Active = announce.Event != "stopped"
+	fileUser.Completed = announce.Event == "completed" || announce.Left == 0
+
+	// Once a user completes a file, it remains snatched, even if the user later re-downloads it
+	if fileUser.Completed {
+		fileUser.Snatched = true
+	}
+
+	fileUser.RecordEvent(announce.Event, announce.Time)
+}
+
+// jitterSeed returns a value identifying the peer making an announce, used to derive a consistent
+// announce interval jitter for that peer: its peer_id, or its key and IP if no peer_id is present
+func jitterSeed(query url.Values) string {
+	if peerID := query.Get("peer_id"); peerID != "" {
+		return peerID
+	}
+
+	return query.Get("key") + query.Get("ip")
+}
+
+// Scrape generates and triggers a tracker scrape request.  As with announces, the query must contain
+// remote_ip, the address of the client's connection.
+func Scrape(tracker TorrentTracker, user data.UserRecord, query url.Values) []byte {
+	// Count scrape
+	atomic.AddInt64(&common.Static.Metrics.Scrapes, 1)
+
+	// Reject scrapes from banned IP addresses and users
+	if isBanned(query.Get("remote_ip"), user.ID) {
+		return tracker.Error("Banned")
+	}
+
 	// List of files to be scraped
 	scrapeFiles := make([]data.FileRecord, 0)
 
@@ -199,6 +442,7 @@ func Scrape(tracker TorrentTracker, query url.Values) []byte {
 		if err != nil {
 			return tracker.Error("Malformed scrape")
 		}
+		scrape.UserID = user.ID
 
 		// Request to store scrape
 		go func(scrape *data.ScrapeLog) {
@@ -211,13 +455,13 @@ func Scrape(tracker TorrentTracker, query url.Values) []byte {
 
 		// Check for a matching file via info_hash
 		file, err := new(data.FileRecord).Load(scrape.InfoHash, "info_hash")
-		if err != nil {
+		if err != nil && err != data.ErrNotFound {
 			log.Println(err.Error())
 			return tracker.Error(ErrScrapeFailure.Error())
 		}
 
 		// Torrent is not currently registered
-		if file == (data.FileRecord{}) {
+		if err == data.ErrNotFound {
 			return tracker.Error("Unregistered torrent")
 		}
 
@@ -229,14 +473,14 @@ func Scrape(tracker TorrentTracker, query url.Values) []byte {
 		// Launch peer reaper asynchronously to remove old peers from this file
 		go func(file data.FileRecord) {
 			// Start peer reaper
-			count, err := file.PeerReaper()
+			count, err := file.PeerReaper(common.Now().Unix())
 			if err != nil {
 				log.Println(err.Error())
 			}
 
 			// Report peers reaped
 			if count > 0 {
-				log.Println("peerReaper: reaped %d peers on file ID: %d", count, file.ID)
+				log.Printf("peerReaper: reaped %d peers on file ID: %d", count, file.ID)
 			}
 		}(file)
 
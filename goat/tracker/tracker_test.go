@@ -0,0 +1,736 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+
+	// Import bencode library
+	bencode "code.google.com/p/bencode-go"
+)
+
+// firstAnnounceAllowedTests contains events, and whether a first announce using them is allowed
+// with and without an explicit started event required
+var firstAnnounceAllowedTests = []struct {
+	event          string
+	implicitStart  bool
+	requireStarted bool
+}{
+	{"", true, false},
+	{"started", true, true},
+	{"completed", true, false},
+	{"stopped", true, false},
+}
+
+// TestFirstAnnounceAllowed verifies that a first-contact periodic announce is treated as an implicit
+// start, unless an explicit started event is required
+func TestFirstAnnounceAllowed(t *testing.T) {
+	log.Println("TestFirstAnnounceAllowed()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Iterate all tests
+	for _, test := range firstAnnounceAllowedTests {
+		common.Static.Config.RequireStarted = false
+		if allowed := firstAnnounceAllowed(test.event); allowed != test.implicitStart {
+			t.Fatalf("firstAnnounceAllowed(%q) with implicit start, expected %v, got %v", test.event, test.implicitStart, allowed)
+		}
+
+		common.Static.Config.RequireStarted = true
+		if allowed := firstAnnounceAllowed(test.event); allowed != test.requireStarted {
+			t.Fatalf("firstAnnounceAllowed(%q) with started required, expected %v, got %v", test.event, test.requireStarted, allowed)
+		}
+	}
+}
+
+// numWantTests contains numwant parameters and file peer limits, and the expected number of peers
+var numWantTests = []struct {
+	numwant   string
+	peerLimit int
+	expected  int
+}{
+	{"", 0, 50},
+	{"abc", 0, 50},
+	{"-1", 0, 50},
+	{"0", 0, 0},
+	{"30", 0, 30},
+	{"200", 0, 200},
+	{"5000", 0, 200},
+	{"5000", 100, 100},
+	{"", 20, 20},
+}
+
+// TestNumWant verifies that numwant defaults when absent, and is capped by the configured maximum
+// and the file's peer limit
+func TestNumWant(t *testing.T) {
+	log.Println("TestNumWant()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.PeerList.MaxNumWant = 200
+
+	// Iterate all tests
+	for _, test := range numWantTests {
+		query := url.Values{}
+		if test.numwant != "" {
+			query.Set("numwant", test.numwant)
+		}
+
+		if numwant := numWant(query, data.FileRecord{PeerLimit: test.peerLimit}); numwant != test.expected {
+			t.Fatalf("numWant(%q, %d), expected %d, got %d", test.numwant, test.peerLimit, test.expected, numwant)
+		}
+	}
+}
+
+// applyAnnounceEventTests contains an announce event and amount left, the peer's prior state, and the
+// expected state after the announce is applied
+var applyAnnounceEventTests = []struct {
+	event     string
+	left      int64
+	before    data.FileUserRecord
+	active    bool
+	completed bool
+	snatched  bool
+	lastEvent string
+}{
+	// Leecher starts, announces periodically, completes, and stops
+	{"started", 100, data.FileUserRecord{}, true, false, false, "started"},
+	{"", 50, data.FileUserRecord{Active: true}, true, false, false, "update"},
+	{"completed", 0, data.FileUserRecord{Active: true}, true, true, true, "completed"},
+	{"stopped", 0, data.FileUserRecord{Active: true, Completed: true, Snatched: true}, false, true, true, "stopped"},
+
+	// Initial seeder starts with nothing left
+	{"started", 0, data.FileUserRecord{}, true, true, true, "started"},
+
+	// Leecher stops before completing
+	{"stopped", 50, data.FileUserRecord{Active: true}, false, false, false, "stopped"},
+
+	// Snatched file is re-downloaded, and remains snatched
+	{"started", 100, data.FileUserRecord{Completed: true, Snatched: true}, true, false, true, "started"},
+}
+
+// TestApplyAnnounceEvent verifies that each announce event updates a peer's state properly
+func TestApplyAnnounceEvent(t *testing.T) {
+	log.Println("TestApplyAnnounceEvent()")
+
+	// Iterate all tests
+	for i, test := range applyAnnounceEventTests {
+		announce := &data.AnnounceLog{Event: test.event, Left: test.left, Time: 1400000000}
+		fileUser := test.before
+		applyAnnounceEvent(announce, &fileUser)
+
+		if fileUser.Active != test.active || fileUser.Completed != test.completed || fileUser.Snatched != test.snatched {
+			t.Fatalf("Test %d (event %q, left %d), expected active/completed/snatched %v/%v/%v, got %v/%v/%v",
+				i, test.event, test.left, test.active, test.completed, test.snatched,
+				fileUser.Active, fileUser.Completed, fileUser.Snatched)
+		}
+		if fileUser.LastEvent != test.lastEvent || fileUser.LastEventTime != announce.Time {
+			t.Fatalf("Test %d (event %q), expected last event %q, got %q at %d",
+				i, test.event, test.lastEvent, fileUser.LastEvent, fileUser.LastEventTime)
+		}
+	}
+}
+
+// TestAnnounceRecords verifies that an announce is recorded in the announce log, and creates a
+// file/user relationship record for the announcing user
+func TestAnnounceRecords(t *testing.T) {
+	log.Println("TestAnnounceRecords()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.MinClientVersions = ""
+	common.Static.Config.RequireStarted = false
+
+	// Generate and save mock file
+	infoHash := "goat_announce_record"
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte(infoHash)),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate and save mock user
+	user := new(data.UserRecord)
+	if err := user.Create("announcerecord", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	owner, err := user.Load("announcerecord", "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// Trigger an announce
+	query := url.Values{}
+	query.Set("info_hash", infoHash)
+	query.Set("peer_id", "-TR2840-abcdefghijkl")
+	query.Set("passkey", owner.Passkey)
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("uploaded", "10")
+	query.Set("downloaded", "20")
+	query.Set("left", "100")
+	query.Set("event", "started")
+	Announce(HTTPTracker{}, owner, query)
+
+	// Records are saved asynchronously, so wait for them to appear
+	var announce data.AnnounceLog
+	var fileUser data.FileUserRecord
+	for i := 0; i < 50; i++ {
+		announce, err = new(data.AnnounceLog).Load(file.InfoHash, "info_hash")
+		if err == nil {
+			fileUser, err = new(data.FileUserRecord).Load(file.ID, owner.ID, "127.0.0.1")
+		}
+		if err != data.ErrNotFound {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Announce was not recorded: %v", err)
+	}
+
+	// Verify recorded announce
+	if announce.Port != 5000 || announce.Left != 100 || announce.Event != "started" ||
+		announce.PeerID != hex.EncodeToString([]byte("-TR2840-abcdefghijkl")) {
+		t.Fatalf("Mismatched announce log: %+v", announce)
+	}
+	if !fileUser.Active || fileUser.Uploaded != 10 || fileUser.Downloaded != 20 || fileUser.Left != 100 {
+		t.Fatalf("Mismatched file user record: %+v", fileUser)
+	}
+
+	// Delete mock data
+	if err := announce.Delete(); err != nil {
+		t.Fatalf("Failed to delete announce log: %s", err.Error())
+	}
+	if err := fileUser.Delete(); err != nil {
+		t.Fatalf("Failed to delete file user record: %s", err.Error())
+	}
+	if err := owner.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestAnnounceTorrentLimit verifies that a user's torrent limit blocks announces for additional
+// torrents, but not re-announces of torrents which are already active, including from another IP
+func TestAnnounceTorrentLimit(t *testing.T) {
+	log.Println("TestAnnounceTorrentLimit()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.MinClientVersions = ""
+	common.Static.Config.RequireStarted = false
+
+	// Generate and save mock files
+	infoHashes := []string{"goat_torrent_limit_1", "goat_torrent_limit_2"}
+	files := make([]data.FileRecord, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		file := data.FileRecord{
+			InfoHash: hex.EncodeToString([]byte(infoHash)),
+			Verified: true,
+		}
+		if err := file.Save(); err != nil {
+			t.Fatalf("Failed to save mock file: %s", err.Error())
+		}
+		if files[i], err = file.Load(file.InfoHash, "info_hash"); err != nil {
+			t.Fatalf("Failed to load mock file: %s", err.Error())
+		}
+	}
+
+	// Generate and save mock user, limited to a single torrent
+	user := new(data.UserRecord)
+	if err := user.Create("torrentlimit", "test", 1); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	owner, err := user.Load("torrentlimit", "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// announce triggers an announce from the mock user for the specified info_hash and IP
+	announce := func(infoHash string, ip string) []byte {
+		query := url.Values{}
+		query.Set("info_hash", infoHash)
+		query.Set("peer_id", "-TR2840-abcdefghijkl")
+		query.Set("passkey", owner.Passkey)
+		query.Set("ip", ip)
+		query.Set("port", "5000")
+		query.Set("uploaded", "0")
+		query.Set("downloaded", "0")
+		query.Set("left", "100")
+		query.Set("event", "started")
+
+		return Announce(HTTPTracker{}, owner, query)
+	}
+
+	// Verify the first torrent is permitted
+	if res := announce(infoHashes[0], "127.0.0.1"); bytes.Contains(res, []byte("failure reason")) {
+		t.Fatalf("First torrent was rejected: %s", string(res))
+	}
+
+	// Records are saved asynchronously, so wait for the first torrent to become active
+	for i := 0; i < 50; i++ {
+		if active, err := data.ActiveTorrentCount(owner.ID); err == nil && active == 1 {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Verify an additional torrent is rejected, but the active torrent may re-announce, from any IP
+	if res := announce(infoHashes[1], "127.0.0.1"); !bytes.Contains(res, []byte("Exceeded active torrent limit")) {
+		t.Fatalf("Torrent beyond limit was not rejected: %s", string(res))
+	}
+	if res := announce(infoHashes[0], "127.0.0.1"); bytes.Contains(res, []byte("failure reason")) {
+		t.Fatalf("Re-announce of active torrent was rejected: %s", string(res))
+	}
+	if res := announce(infoHashes[0], "127.0.0.2"); bytes.Contains(res, []byte("failure reason")) {
+		t.Fatalf("Announce of active torrent from another IP was rejected: %s", string(res))
+	}
+
+	// Delete mock data
+	for _, file := range files {
+		for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+			if fileUser, err := new(data.FileUserRecord).Load(file.ID, owner.ID, ip); err == nil {
+				if err := fileUser.Delete(); err != nil {
+					t.Fatalf("Failed to delete file user record: %s", err.Error())
+				}
+			}
+		}
+		for {
+			announce, err := new(data.AnnounceLog).Load(file.InfoHash, "info_hash")
+			if err != nil {
+				break
+			}
+			if err := announce.Delete(); err != nil {
+				t.Fatalf("Failed to delete announce log: %s", err.Error())
+			}
+		}
+		if err := file.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file: %s", err.Error())
+		}
+	}
+	if err := owner.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+}
+
+// TestBanned verifies that announces and scrapes are rejected for banned IP addresses and users
+func TestBanned(t *testing.T) {
+	log.Println("TestBanned()")
+
+	common.Static.Config = common.DefaultConfig()
+	common.Static.Config.MinClientVersions = ""
+
+	// Ban a single IP address and user ID, restoring the default afterwards
+	defer func(fn func(string, int) bool) {
+		isBanned = fn
+	}(isBanned)
+	isBanned = func(ip string, userID int) bool {
+		return ip == "10.0.0.66" || userID == 66
+	}
+
+	// query generates an announce or scrape query from a connection from the specified IP address,
+	// advertising a different, unbanned IP address
+	query := func(ip string) url.Values {
+		query := url.Values{}
+		query.Set("info_hash", "goat_banned_hash_000")
+		query.Set("peer_id", "-TR2840-abcdefghijkl")
+		query.Set("ip", "10.0.0.2")
+		query.Set("remote_ip", ip)
+		query.Set("port", "5000")
+		query.Set("uploaded", "0")
+		query.Set("downloaded", "0")
+		query.Set("left", "100")
+		return query
+	}
+
+	banned := HTTPTracker{}.Error("Banned")
+	for _, test := range []struct {
+		ip     string
+		userID int
+	}{
+		{"10.0.0.66", 0},
+		{"10.0.0.66", 1},
+		{"10.0.0.1", 66},
+	} {
+		user := data.UserRecord{ID: test.userID}
+
+		if res := Announce(HTTPTracker{}, user, query(test.ip)); !bytes.Equal(res, banned) {
+			t.Fatalf("Announce(%s, %d), expected banned, got: %s", test.ip, test.userID, string(res))
+		}
+		if res := Scrape(HTTPTracker{}, user, query(test.ip)); !bytes.Equal(res, banned) {
+			t.Fatalf("Scrape(%s, %d), expected banned, got: %s", test.ip, test.userID, string(res))
+		}
+	}
+}
+
+// TestAnnouncePort verifies that announces with an invalid port are rejected before any storage is used
+func TestAnnouncePort(t *testing.T) {
+	log.Println("TestAnnouncePort()")
+
+	common.Static.Config = common.DefaultConfig()
+	common.Static.Config.MinClientVersions = ""
+	common.Static.Config.StrictPort = true
+
+	// Fail if storage is used to check bans, restoring the default afterwards
+	defer func(fn func(string, int) bool) {
+		isBanned = fn
+	}(isBanned)
+	isBanned = func(ip string, userID int) bool {
+		t.Fatalf("Announce with invalid port reached storage")
+		return false
+	}
+
+	malformed := HTTPTracker{}.Error("Malformed announce")
+	for _, port := range []string{"-1", "0", "80", "1023", "65536"} {
+		query := url.Values{}
+		query.Set("info_hash", "goat_port_hash_00000")
+		query.Set("peer_id", "-TR2840-abcdefghijkl")
+		query.Set("ip", "10.0.0.1")
+		query.Set("port", port)
+		query.Set("uploaded", "0")
+		query.Set("downloaded", "0")
+		query.Set("left", "100")
+
+		if res := Announce(HTTPTracker{}, data.UserRecord{}, query); !bytes.Equal(res, malformed) {
+			t.Fatalf("Announce(port %s), expected malformed announce, got: %s", port, string(res))
+		}
+	}
+}
+
+// TestScrapeLogged verifies that each scrape is recorded in the scrape log, along with the scraping user
+func TestScrapeLogged(t *testing.T) {
+	log.Println("TestScrapeLogged()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock verified file
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte("goat_scrape_log_0000")),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Scrape the file
+	query := url.Values{}
+	query.Set("info_hash", "goat_scrape_log_0000")
+	query.Set("ip", "10.0.0.1")
+	Scrape(HTTPTracker{}, data.UserRecord{ID: 42}, query)
+
+	// Verify the scrape is logged, allowing time for it to be saved in the background
+	var scrape data.ScrapeLog
+	for i := 0; i < 100; i++ {
+		scrape, err = new(data.ScrapeLog).Load(file.InfoHash, "info_hash")
+		if err != data.ErrNotFound {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to load scrape log: %v", err)
+	}
+
+	if scrape.UserID != 42 || scrape.IP != "10.0.0.1" || scrape.Time == 0 {
+		t.Fatalf("Scrape log, expected user 42 from 10.0.0.1, got: %+v", scrape)
+	}
+
+	// Delete mock records
+	if err := scrape.Delete(); err != nil {
+		t.Fatalf("Failed to delete scrape log: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestAnnounceKey verifies that a peer which announces from a new IP with the same announce key
+// updates its existing file user record, rather than creating a new one
+func TestAnnounceKey(t *testing.T) {
+	log.Println("TestAnnounceKey()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.MinClientVersions = ""
+	common.Static.Config.RequireStarted = false
+
+	// Generate and save mock file
+	infoHash := "goat_announce_key"
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte(infoHash)),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate and save mock user
+	user := new(data.UserRecord)
+	if err := user.Create("announcekey", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	owner, err := user.Load("announcekey", "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// announce triggers an announce from the specified IP, and waits for the file user record at
+	// that IP to reflect the reported uploaded total, as records are saved asynchronously
+	announce := func(ip string, uploaded string) data.FileUserRecord {
+		query := url.Values{}
+		query.Set("info_hash", infoHash)
+		query.Set("peer_id", "-TR2840-abcdefghijkl")
+		query.Set("passkey", owner.Passkey)
+		query.Set("key", "abcd1234")
+		query.Set("ip", ip)
+		query.Set("port", "5000")
+		query.Set("uploaded", uploaded)
+		query.Set("downloaded", "0")
+		query.Set("left", "100")
+		Announce(HTTPTracker{}, owner, query)
+
+		var fileUser data.FileUserRecord
+		for i := 0; i < 50; i++ {
+			fileUser, err = new(data.FileUserRecord).Load(file.ID, owner.ID, ip)
+			if err == nil && strconv.FormatInt(fileUser.Uploaded, 10) == uploaded {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("Announce from %s was not recorded: %v", ip, err)
+		}
+
+		return fileUser
+	}
+
+	// Announce from one IP, then from another with the same key
+	first := announce("127.0.0.1", "10")
+	second := announce("127.0.0.2", "20")
+
+	// Verify the existing record was moved and updated, and no record remains at the old IP
+	if second.Key != "abcd1234" || second.Announced != first.Announced+1 || second.Uploaded != 20 {
+		t.Fatalf("Record was not updated after IP change, before: %+v, after: %+v", first, second)
+	}
+	if _, err := new(data.FileUserRecord).Load(file.ID, owner.ID, "127.0.0.1"); err != data.ErrNotFound {
+		t.Fatalf("Record at old IP, expected ErrNotFound, got: %v", err)
+	}
+	if fileUsers, err := new(data.FileUserRecordRepository).Select(file.ID, "file_id"); err != nil || len(fileUsers) != 1 {
+		t.Fatalf("Expected 1 file user record, got %d: %v", len(fileUsers), err)
+	}
+
+	// Delete mock data, including file user records, which are deleted with the user
+	for {
+		announce, err := new(data.AnnounceLog).Load(file.InfoHash, "info_hash")
+		if err != nil {
+			break
+		}
+		if err := announce.Delete(); err != nil {
+			t.Fatalf("Failed to delete announce log: %s", err.Error())
+		}
+	}
+	if err := owner.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// ratioWarningTests contains a share ratio, a minimum ratio, and whether a low ratio warning is
+// expected
+var ratioWarningTests = []struct {
+	ratio   float64
+	min     float64
+	warning bool
+}{
+	{data.RatioInfinite, 0.5, false},
+	{0, 0.5, true},
+	{0.49, 0.5, true},
+	{0.5, 0.5, false},
+	{2, 0.5, false},
+}
+
+// TestRatioWarning verifies that low ratio warnings are generated only for users below the minimum
+// ratio
+func TestRatioWarning(t *testing.T) {
+	log.Println("TestRatioWarning()")
+
+	// Iterate all tests
+	for _, test := range ratioWarningTests {
+		if warning := ratioWarning(test.ratio, test.min); (warning != "") != test.warning {
+			t.Fatalf("ratioWarning(%g, %g), expected warning %v, got %q", test.ratio, test.min, test.warning, warning)
+		}
+	}
+}
+
+// warningResponse contains the warning message from an HTTP tracker announce response
+type warningResponse struct {
+	WarningMessage string "warning message"
+}
+
+// TestAnnounceWarning verifies that a warning message appears in HTTP announce responses only when
+// the warning hook returns one
+func TestAnnounceWarning(t *testing.T) {
+	log.Println("TestAnnounceWarning()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.MinClientVersions = ""
+	common.Static.Config.RequireStarted = false
+
+	// Fake warning hook, restoring the default afterwards
+	warning := ""
+	WarningHook = func(user data.UserRecord, file data.FileRecord) string {
+		return warning
+	}
+	defer func() {
+		WarningHook = LowRatioWarning
+	}()
+
+	// Generate and save mock file
+	infoHash := "goat_announce_warning"
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte(infoHash)),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate and save mock user
+	user := new(data.UserRecord)
+	if err := user.Create("announcewarning", "test", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	owner, err := user.Load("announcewarning", "username")
+	if err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+
+	// announce triggers an announce, returning the warning message from the response
+	announce := func() string {
+		query := url.Values{}
+		query.Set("info_hash", infoHash)
+		query.Set("peer_id", "-TR2840-abcdefghijkl")
+		query.Set("passkey", owner.Passkey)
+		query.Set("ip", "127.0.0.1")
+		query.Set("port", "5000")
+		query.Set("uploaded", "0")
+		query.Set("downloaded", "0")
+		query.Set("left", "100")
+		res := Announce(HTTPTracker{}, owner, query)
+
+		var announce warningResponse
+		if err := bencode.Unmarshal(bytes.NewReader(res), &announce); err != nil {
+			t.Fatalf("Failed to unmarshal bencode announce response: %s", string(res))
+		}
+
+		return announce.WarningMessage
+	}
+
+	// Verify no warning is sent when the hook returns none
+	if message := announce(); message != "" {
+		t.Fatalf("Warning sent when hook returned none: %q", message)
+	}
+
+	// Verify the warning is sent when the hook returns one
+	warning = "Your share ratio is low"
+	if message := announce(); message != warning {
+		t.Fatalf("Announce warning, expected %q, got %q", warning, message)
+	}
+
+	// Allow records to be saved asynchronously before cleanup
+	time.Sleep(500 * time.Millisecond)
+
+	// Delete mock data, including file user records, which are deleted with the user
+	for {
+		announce, err := new(data.AnnounceLog).Load(file.InfoHash, "info_hash")
+		if err != nil {
+			break
+		}
+		if err := announce.Delete(); err != nil {
+			t.Fatalf("Failed to delete announce log: %s", err.Error())
+		}
+	}
+	if err := owner.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
@@ -5,9 +5,7 @@ import (
 	"encoding/binary"
 	"log"
 	"net/url"
-	"strconv"
 
-	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 	"github.com/mdlayher/goat/goat/data/udp"
 )
@@ -29,21 +27,16 @@ func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 	announce := udp.AnnounceResponse{
 		Action:   1,
 		TransID:  u.TransID,
-		Interval: uint32(common.Static.Config.Interval),
+		Interval: uint32(file.PeerInterval(jitterSeed(query))),
 	}
 
 	// Calculate file seeders and leechers
-	seeders, err := file.Seeders()
+	stats, err := file.ScrapeStats()
 	if err != nil {
 		log.Println(err.Error())
 	}
-	announce.Seeders = uint32(seeders)
-
-	leechers, err := file.Leechers()
-	if err != nil {
-		log.Println(err.Error())
-	}
-	announce.Leechers = uint32(leechers)
+	announce.Seeders = uint32(stats.Seeders)
+	announce.Leechers = uint32(stats.Leechers)
 
 	// Convert to UDP byte buffer
 	announceBuf, err := announce.MarshalBinary()
@@ -53,18 +46,20 @@ func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 	}
 
 	// Numwant
-	numwant, err := strconv.Atoi(query.Get("numwant"))
-	if err != nil {
-		numwant = 50
-	}
+	numwant := numWant(query, file)
 
-	// Retrieve compact peer list
-	// Note: because we are UDP, we send the second parameter 'false' to get
+	// Retrieve compact peer list, unless this is a seeder only reporting statistics
+	// Note: because we are UDP, we send the last parameter 'false' to get
 	// a "best guess" peer list, due to anonymous announces
-	peers, err := file.CompactPeerList(numwant, false)
-	if err != nil {
-		log.Println(err.Error())
-		return u.Error(ErrPeerListFailure.Error())
+	peers := make([]byte, 0)
+	if numwant > 0 && !statOnlyAnnounce(query) {
+		leecher := query.Get("left") != "0"
+		// UDP announce responses carry only IPv4 peers
+		peers, _, err = compactPeerList(file, query.Get("peer_id")+query.Get("ip"), leecher, numwant, false)
+		if err != nil {
+			log.Println(err.Error())
+			return u.Error(ErrPeerListFailure.Error())
+		}
 	}
 
 	// Add compact peer list
@@ -78,6 +73,25 @@ func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 	return res.Bytes()
 }
 
+// Maintenance reports a UDP []byte announce response with no peers and an extended interval, so
+// clients back off during maintenance without storage being accessed
+func (u UDPTracker) Maintenance() []byte {
+	announce := udp.AnnounceResponse{
+		Action:   1,
+		TransID:  u.TransID,
+		Interval: uint32(maintenanceInterval()),
+	}
+
+	// Convert to UDP byte buffer
+	buf, err := announce.MarshalBinary()
+	if err != nil {
+		log.Println(err.Error())
+		return u.Error(ErrAnnounceFailure.Error())
+	}
+
+	return buf
+}
+
 // Error reports a UDP []byte response packed datagram
 func (u UDPTracker) Error(msg string) []byte {
 	// Create UDP error response
@@ -120,27 +134,14 @@ func (u UDPTracker) Scrape(files []data.FileRecord) []byte {
 		index++
 
 		go func(f data.FileRecord, o *orderedScrape) {
-			// Seeders count
-			var err error
-			seeders, err := f.Seeders()
-			if err != nil {
-				log.Println(err.Error())
-			}
-			o.File.Seeders = uint32(seeders)
-
-			// Completion count
-			completed, err := f.Completed()
-			if err != nil {
-				log.Println(err.Error())
-			}
-			o.File.Completed = uint32(completed)
-
-			// Leechers count
-			leechers, err := f.Leechers()
+			// Seeders, leechers, and completion counts, possibly cached
+			stats, err := f.ScrapeStats()
 			if err != nil {
 				log.Println(err.Error())
 			}
-			o.File.Leechers = uint32(leechers)
+			o.File.Seeders = uint32(stats.Seeders)
+			o.File.Completed = uint32(stats.Completed)
+			o.File.Leechers = uint32(stats.Leechers)
 
 			// Return results on channel
 			resChan <- o
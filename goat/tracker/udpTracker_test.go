@@ -16,7 +16,7 @@ func TestUDPAnnounce(t *testing.T) {
 	log.Println("TestUDPAnnounce()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -76,6 +76,125 @@ func TestUDPAnnounce(t *testing.T) {
 	}
 }
 
+// TestUDPAnnounceCounts verifies that the UDP announce response carries seeder and leecher counts
+// matching those used by HTTP, followed by a 6 byte compact entry for each IPv4 peer
+func TestUDPAnnounceCounts(t *testing.T) {
+	log.Println("TestUDPAnnounceCounts()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	// Disable scrape stats cache, so counts reflect the mock peers below
+	config.Scrape.CacheTTL = 0
+	common.Static.Config = config
+
+	// Return a known peer list, restoring the original function afterwards
+	mockPeers := []data.Peer{
+		{IP: "10.0.0.1", Port: 5001},
+		{IP: "10.0.0.2", Port: 5002},
+	}
+	defaultPeerList := compactPeerList
+	compactPeerList = func(file data.FileRecord, key string, leecher bool, numwant int, http bool) ([]byte, []byte, error) {
+		buf := make([]byte, 0)
+		for _, p := range mockPeers {
+			b, err := p.MarshalBinary()
+			if err != nil {
+				return nil, nil, err
+			}
+			buf = append(buf[:], b...)
+		}
+
+		return buf, nil, nil
+	}
+	defer func() {
+		compactPeerList = defaultPeerList
+	}()
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	file, err = file.Load(file.InfoHash, "info_hash")
+	if err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate mock peers: two seeders and one leecher
+	fileUsers := []data.FileUserRecord{
+		{FileID: file.ID, UserID: 1, IP: "10.0.0.1", Active: true, Completed: true, Left: 0},
+		{FileID: file.ID, UserID: 2, IP: "10.0.0.2", Active: true, Completed: true, Left: 0},
+		{FileID: file.ID, UserID: 3, IP: "10.0.0.3", Active: true, Completed: false, Left: 100},
+	}
+	for _, f := range fileUsers {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file user: %s", err.Error())
+		}
+	}
+
+	// Generate fake announce query
+	query := url.Values{}
+	query.Set("info_hash", "deadbeef")
+	query.Set("ip", "127.0.0.1")
+	query.Set("port", "5000")
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "100")
+	query.Set("numwant", "50")
+
+	// Create a UDP tracker, trigger an announce
+	tracker := UDPTracker{TransID: uint32(1234)}
+	res := tracker.Announce(query, file)
+
+	// Verify header is followed by exactly 6 bytes per peer
+	if len(res) != 20+6*len(mockPeers) {
+		t.Fatalf("UDP announce response length, expected %d, got %d", 20+6*len(mockPeers), len(res))
+	}
+
+	// Decode response
+	announce := new(udp.AnnounceResponse)
+	if err := announce.UnmarshalBinary(res); err != nil {
+		t.Fatalf("Failed to decode UDP announce response: %s", err.Error())
+	}
+
+	// Verify counts match those reported to HTTP clients
+	stats, err := file.ScrapeStats()
+	if err != nil {
+		t.Fatalf("Failed to retrieve scrape stats: %s", err.Error())
+	}
+	if int(announce.Seeders) != stats.Seeders || int(announce.Leechers) != stats.Leechers {
+		t.Fatalf("Counts, expected %d seeders, %d leechers, got %d, %d", stats.Seeders, stats.Leechers, announce.Seeders, announce.Leechers)
+	}
+	if announce.Seeders != 2 || announce.Leechers != 1 {
+		t.Fatalf("Counts, expected 2 seeders, 1 leecher, got %d, %d", announce.Seeders, announce.Leechers)
+	}
+
+	// Verify peer entries
+	if len(announce.PeerList) != len(mockPeers) {
+		t.Fatalf("Peer list length, expected %d, got %d", len(mockPeers), len(announce.PeerList))
+	}
+	for i, p := range announce.PeerList {
+		if p.IP != mockPeers[i].IP || p.Port != mockPeers[i].Port {
+			t.Fatalf("Peer %d, expected %s:%d, got %s:%d", i, mockPeers[i].IP, mockPeers[i].Port, p.IP, p.Port)
+		}
+	}
+
+	// Delete mock data
+	for _, f := range fileUsers {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file user: %s", err.Error())
+		}
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
 // TestUDPTrackerError verifies that the UDP tracker error format is correct
 func TestUDPTrackerError(t *testing.T) {
 	log.Println("TestUDPTrackerError()")
@@ -1,10 +1,13 @@
 package goat
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,16 +32,104 @@ var (
 	errUDPWrite = errors.New("udp: udpTracker cannot generate UDP udpTracker response")
 )
 
-// UDP address to connection ID map
-var udpAddrToID = map[string]uint64{}
+// udpConnTTL is the duration for which a connection ID is valid after it is issued to a client
+const udpConnTTL = 2 * time.Minute
+
+// udpConn stores a connection ID issued to a client, and the time at which it expires
+type udpConn struct {
+	id      uint64
+	expires time.Time
+}
+
+// udpConns stores the connection ID issued to each client address
+var udpConns = struct {
+	sync.Mutex
+	ids    map[string]udpConn
+	pruned time.Time
+}{ids: map[string]udpConn{}}
+
+// issueConnID generates a connection ID for a client address, which will be expected on the client's
+// requests until it expires.  Expired connection IDs are discarded, at most once per TTL.
+// Connection IDs are generated using a cryptographically secure source, so that they cannot be
+// predicted by a client spoofing another's address.
+func issueConnID(addr string) (uint64, error) {
+	now := common.Now()
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, err
+	}
+	id := binary.BigEndian.Uint64(buf)
+
+	udpConns.Lock()
+	defer udpConns.Unlock()
+
+	if now.Sub(udpConns.pruned) >= udpConnTTL {
+		for k, v := range udpConns.ids {
+			if !now.Before(v.expires) {
+				delete(udpConns.ids, k)
+			}
+		}
+
+		udpConns.pruned = now
+	}
+
+	udpConns.ids[addr] = udpConn{id: id, expires: now.Add(udpConnTTL)}
+	return id, nil
+}
+
+// checkConnID verifies the connection ID sent by a client address, returning an error message for
+// the client if the ID was never issued, does not match, or has expired
+func checkConnID(addr string, id uint64) string {
+	udpConns.Lock()
+	conn, ok := udpConns.ids[addr]
+	udpConns.Unlock()
+
+	if !ok {
+		return "Client must properly handshake before announce"
+	}
+	if conn.id != id {
+		return "Invalid UDP connection ID"
+	}
+	if !common.Now().Before(conn.expires) {
+		return "Expired UDP connection ID"
+	}
+
+	return ""
+}
 
 // Handle incoming UDP connections and return response
 func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
+	// Track packets being handled, so they may complete on graceful shutdown
+	var packets sync.WaitGroup
+	var stopping int32
+	readDone := make(chan bool)
+
 	// Create shutdown function
 	go func(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 		// Wait for done signal
 		<-sendChan
 
+		// Stop reading packets, by expiring any pending read
+		atomic.StoreInt32(&stopping, 1)
+		if err := l.SetReadDeadline(time.Now()); err != nil {
+			log.Println(err.Error())
+		}
+		<-readDone
+
+		// Wait for outstanding packets to be handled, up to the configured grace period
+		handled := make(chan bool)
+		go func() {
+			packets.Wait()
+			close(handled)
+		}()
+
+		select {
+		case <-handled:
+		case <-time.After(time.Duration(common.Static.Config.Shutdown.GracePeriod) * time.Second):
+			log.Println("UDP listener grace period elapsed, closing with packets outstanding")
+		}
+
 		// Close listener
 		if err := l.Close(); err != nil {
 			log.Println(err.Error())
@@ -49,6 +140,7 @@ func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 	}(l, sendChan, recvChan)
 
 	// Loop and read connections
+	defer close(readDone)
 	for {
 		buf := make([]byte, 2048)
 		rlen, addr, err := l.ReadFromUDP(buf)
@@ -61,6 +153,10 @@ func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 
 		// Triggered on graceful shutdown
 		if err != nil {
+			if atomic.LoadInt32(&stopping) == 1 {
+				return
+			}
+
 			// Ignore connection closing error, caused by stopping network listener
 			if !strings.Contains(err.Error(), "use of closed network connection") {
 				log.Println(err.Error())
@@ -77,7 +173,10 @@ func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 		}
 
 		// Spawn a goroutine to handle the connection and send back the response
+		packets.Add(1)
 		go func(l *net.UDPConn, buf []byte, addr *net.UDPAddr) {
+			defer packets.Done()
+
 			// Capture initial response from buffer
 			res, err := parseUDP(buf, addr)
 			if err != nil {
@@ -117,12 +216,6 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 	// Create a udpTracker to handle this client
 	udpTracker := tracker.UDPTracker{TransID: packet.TransID}
 
-	// Check for maintenance mode
-	if common.Static.Maintenance {
-		// Return tracker error with maintenance message
-		return udpTracker.Error("Maintenance: " + common.Static.StatusMessage), nil
-	}
-
 	// Action switch
 	// Action 0: Connect
 	if packet.Action == 0 {
@@ -131,11 +224,12 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 			return udpTracker.Error("Invalid UDP udpTracker handshake"), errUDPHandshake
 		}
 
-		// Generate a connection ID, which will be expected for this client next call
-		expID := uint64(common.RandRange(1, 1000000000))
-
-		// Store this client's address and ID in map
-		udpAddrToID[addr.String()] = expID
+		// Generate a connection ID, which will be expected for this client's requests
+		expID, err := issueConnID(addr.String())
+		if err != nil {
+			log.Println(err.Error())
+			return udpTracker.Error("Could not generate UDP connect response"), errUDPWrite
+		}
 
 		// Generate connect response
 		connect := udp.ConnectResponse{
@@ -155,25 +249,21 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 	}
 
 	// For all udpTracker actions other than connect, we must validate the connection ID for this
-	// address, ensuring it matches the previously set value
-
-	// Ensure connection ID map contains this IP address
-	expID, ok := udpAddrToID[addr.String()]
-	if !ok {
-		return udpTracker.Error("Client must properly handshake before announce"), errUDPHandshake
+	// address, ensuring it matches the previously issued, unexpired value
+	if msg := checkConnID(addr.String(), packet.ConnID); msg != "" {
+		return udpTracker.Error(msg), errUDPHandshake
 	}
 
-	// Validate expected connection ID using map
-	if packet.ConnID != expID {
-		return udpTracker.Error("Invalid UDP connection ID"), errUDPHandshake
-	}
+	// Check for maintenance mode.  Connections are still accepted, as they do not access storage.
+	if maintenance, message := common.Static.Maintenance.Get(); maintenance {
+		// Return a minimal announce response, so clients back off, or a tracker error with
+		// maintenance message for other actions
+		if packet.Action == 1 {
+			return udpTracker.Maintenance(), nil
+		}
 
-	// Clear this IP from the connection map after 2 minutes
-	// note: this is done to conserve memory and prevent session fixation
-	go func(addr *net.UDPAddr) {
-		<-time.After(2 * time.Minute)
-		delete(udpAddrToID, addr.String())
-	}(addr)
+		return udpTracker.Error("Maintenance: " + message), nil
+	}
 
 	// Action 1: Announce
 	if packet.Action == 1 {
@@ -192,6 +282,9 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 			query.Set("ip", strings.Split(addr.String(), ":")[0])
 		}
 
+		// Store UDP connection address, used to enforce IP bans
+		query.Set("remote_ip", addr.IP.String())
+
 		// Trigger an anonymous announce
 		return tracker.Announce(udpTracker, data.UserRecord{}, query), nil
 	}
@@ -210,9 +303,10 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 
 		// Store IP in query map
 		query.Set("ip", strings.Split(addr.String(), ":")[0])
+		query.Set("remote_ip", addr.IP.String())
 
-		// Trigger a scrape
-		return tracker.Scrape(udpTracker, query), nil
+		// Trigger an anonymous scrape
+		return tracker.Scrape(udpTracker, data.UserRecord{}, query), nil
 	}
 
 	// No action matched
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
@@ -15,7 +16,7 @@ func TestUDPRouter(t *testing.T) {
 	log.Println("TestUDPRouter()")
 
 	// Load config
-	config, err := common.LoadConfig()
+	config, err := common.LoadConfig("")
 	if err != nil {
 		t.Fatalf("Could not load configuration: %s", err.Error())
 	}
@@ -161,3 +162,129 @@ func TestUDPRouter(t *testing.T) {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
 }
+
+// TestUDPConnID verifies that connection IDs are validated, and expire two minutes after they are issued
+func TestUDPConnID(t *testing.T) {
+	log.Println("TestUDPConnID()")
+
+	// Fix the clock, restoring it afterwards
+	now := time.Unix(1400000000, 0)
+	common.Now = func() time.Time { return now }
+	defer func() {
+		common.Now = time.Now
+	}()
+
+	// Issue a connection ID
+	id, err := issueConnID("10.0.0.1:6881")
+	if err != nil {
+		t.Fatalf("Failed to issue connection ID: %s", err.Error())
+	}
+
+	// Verify IDs issued within the same second differ
+	id2, err := issueConnID("10.0.0.3:6881")
+	if err != nil {
+		t.Fatalf("Failed to issue connection ID: %s", err.Error())
+	}
+	if id2 == id {
+		t.Fatalf("Connection IDs issued in the same second are identical: %d", id)
+	}
+
+	// Verify issued ID is accepted, and unknown or mismatched IDs are rejected
+	if msg := checkConnID("10.0.0.1:6881", id); msg != "" {
+		t.Fatalf("Issued connection ID rejected: %s", msg)
+	}
+	if msg := checkConnID("10.0.0.1:6881", id+1); msg != "Invalid UDP connection ID" {
+		t.Fatalf("Mismatched connection ID, expected rejection, got %q", msg)
+	}
+	if msg := checkConnID("10.0.0.2:6881", id); msg != "Client must properly handshake before announce" {
+		t.Fatalf("Unknown client, expected rejection, got %q", msg)
+	}
+
+	// Verify ID is still accepted just before expiry, and rejected after
+	now = now.Add(udpConnTTL - time.Second)
+	if msg := checkConnID("10.0.0.1:6881", id); msg != "" {
+		t.Fatalf("Connection ID rejected before expiry: %s", msg)
+	}
+	now = now.Add(time.Second)
+	if msg := checkConnID("10.0.0.1:6881", id); msg != "Expired UDP connection ID" {
+		t.Fatalf("Expired connection ID, expected rejection, got %q", msg)
+	}
+
+	// Verify expired IDs are discarded when a new ID is issued
+	if _, err := issueConnID("10.0.0.2:6881"); err != nil {
+		t.Fatalf("Failed to issue connection ID: %s", err.Error())
+	}
+	if msg := checkConnID("10.0.0.1:6881", id); msg != "Client must properly handshake before announce" {
+		t.Fatalf("Expired connection ID was not discarded, got %q", msg)
+	}
+}
+
+// TestUDPMaintenance verifies that maintenance mode returns an announce response with an extended
+// interval and no peers, without accessing storage
+func TestUDPMaintenance(t *testing.T) {
+	log.Println("TestUDPMaintenance()")
+
+	// Load config
+	config, err := common.LoadConfig("")
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	config.Interval = 3600
+	config.Maintenance.Interval = 7200
+	common.Static.Config = config
+
+	// Enable maintenance mode, and make any database access panic
+	common.Static.Maintenance.Set(true, "testing")
+	dbConnect := data.DBConnectFunc
+	data.DBConnectFunc = nil
+	defer func() {
+		common.Static.Maintenance.Set(false, "")
+		data.DBConnectFunc = dbConnect
+	}()
+
+	// Fake UDP address
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:6881")
+	if err != nil {
+		t.Fatalf("Failed to create fake UDP address")
+	}
+
+	// Perform connection handshake
+	connectBuf, err := udp.Packet{udpInitID, 0, 1234}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to create UDP connect packet")
+	}
+	res, err := parseUDP(connectBuf, addr)
+	if err != nil {
+		t.Fatalf("Failed to connect during maintenance: %s", err.Error())
+	}
+	connRes := new(udp.ConnectResponse)
+	if err := connRes.UnmarshalBinary(res); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// Announce during maintenance
+	announceBuf, err := udp.AnnounceRequest{
+		ConnID:   connRes.ConnID,
+		Action:   1,
+		TransID:  1234,
+		InfoHash: []byte("deadbeef000000000000"),
+		PeerID:   []byte("00001111222233334444"),
+		Left:     10,
+		Port:     5000,
+	}.MarshalBinary()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res, err = parseUDP(announceBuf, addr); err != nil {
+		t.Fatalf("Failed to announce during maintenance: %s", err.Error())
+	}
+
+	// Verify response is a valid announce, with extended interval and no peers
+	announceRes := new(udp.AnnounceResponse)
+	if err := announceRes.UnmarshalBinary(res); err != nil {
+		t.Fatalf("Maintenance announce was not an announce response: %s", err.Error())
+	}
+	if announceRes.TransID != 1234 || announceRes.Interval != 7200 || len(announceRes.PeerList) != 0 {
+		t.Fatalf("Unexpected maintenance announce: %+v", announceRes)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -25,6 +27,27 @@ var qlDBPath = flag.String("qldb", "", "Override ql database file location with
 // test is a flag which causes goat to start, and exit shortly after
 var test = flag.Bool("test", false, "Make goat start, and exit shortly after. Used for testing.")
 
+// replay is a flag which causes goat to replay a file of captured announces, and exit
+var replay = flag.String("replay", "", "Replay captured announces from the specified file, and exit.")
+
+// replayTarget is a flag which sets the tracker which captured announces are replayed against
+var replayTarget = flag.String("replaytarget", "http://localhost:8080", "Tracker URL to replay captured announces against.")
+
+// replayPasskey is a flag which sets the passkey substituted for redacted passkeys during replay
+var replayPasskey = flag.String("replaypasskey", "", "Passkey to use when replaying captured announces.")
+
+// migrate is a flag which causes goat to apply pending schema migrations, and exit
+var migrate = flag.Bool("migrate", false, "Apply pending database schema migrations, and exit.")
+
+// export is a flag which causes goat to write a snapshot of all user and swarm state to a file, and exit
+var export = flag.String("export", "", "Export a snapshot of all user and swarm state to the specified file, and exit.")
+
+// importFile is a flag which causes goat to restore a snapshot of user and swarm state from a file, and exit
+var importFile = flag.String("import", "", "Import a snapshot of user and swarm state from the specified file, and exit.")
+
+// importForce is a flag which allows a snapshot import to replace existing user and swarm state
+var importForce = flag.Bool("importforce", false, "Allow snapshot import to replace existing user and swarm state.")
+
 func main() {
 	// Set up command line options
 	flag.Parse()
@@ -34,6 +57,26 @@ func main() {
 	data.MySQLDSN = mySQLDSN
 	data.QLDBPath = qlDBPath
 
+	// If replay mode, replay captured announces and exit
+	if *replay != "" {
+		os.Exit(runReplay())
+	}
+
+	// If migrate mode, apply pending schema migrations and exit
+	if *migrate {
+		os.Exit(runMigrations())
+	}
+
+	// If export mode, write a snapshot of tracker state and exit
+	if *export != "" {
+		os.Exit(runExport())
+	}
+
+	// If import mode, restore a snapshot of tracker state and exit
+	if *importFile != "" {
+		os.Exit(runImport())
+	}
+
 	// If test mode, trigger quit shortly after startup
 	// Used for CI tests, so that we ensure goat starts up and is able to stop gracefully
 	if *test {
@@ -55,6 +98,15 @@ func main() {
 	exitChan := make(chan int)
 	go goat.Manager(killChan, exitChan)
 
+	// Toggle maintenance mode via SIGUSR1
+	usrChan := make(chan os.Signal, 1)
+	signal.Notify(usrChan, syscall.SIGUSR1)
+	go func(usrChan chan os.Signal) {
+		for _ = range usrChan {
+			fmt.Println(goat.App, ": caught SIGUSR1, maintenance mode:", common.Static.Maintenance.Toggle())
+		}
+	}(usrChan)
+
 	// Gracefully handle termination via UNIX signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
@@ -80,3 +132,134 @@ func main() {
 	fmt.Println(goat.App, ": graceful shutdown complete")
 	os.Exit(code)
 }
+
+// runMigrations loads configuration and applies pending schema migrations, returning an exit code
+func runMigrations() int {
+	if !loadConfig() {
+		return 1
+	}
+
+	// Apply migrations
+	count, err := data.Migrate()
+	if err != nil {
+		fmt.Println(goat.App, ": failed to apply schema migrations:", err)
+		return 1
+	}
+
+	fmt.Println(goat.App, ": applied", count, "schema migration(s) to", data.DBName())
+	return 0
+}
+
+// loadConfig loads configuration, so database settings are available, returning false on failure
+func loadConfig() bool {
+	conf, err := common.LoadConfig(*common.ConfigPath)
+	if err != nil || conf == (common.Conf{}) {
+		fmt.Println(goat.App, ": cannot load configuration")
+		return false
+	}
+	common.Static.Config = conf
+
+	return true
+}
+
+// runExport writes a snapshot of all user and swarm state to a file, returning an exit code
+func runExport() int {
+	if !loadConfig() {
+		return 1
+	}
+
+	// Create temporary snapshot file alongside the destination, so a failed export never leaves
+	// a partial snapshot in its place
+	file, err := ioutil.TempFile(filepath.Dir(*export), filepath.Base(*export)+".tmp")
+	if err != nil {
+		fmt.Println(goat.App, ": cannot create snapshot file:", err)
+		return 1
+	}
+
+	// Write snapshot, flushing it to disk
+	if err := data.ExportSnapshot(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		fmt.Println(goat.App, ": export failed:", err)
+		return 1
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		fmt.Println(goat.App, ": cannot write snapshot file:", err)
+		return 1
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		fmt.Println(goat.App, ": cannot close snapshot file:", err)
+		return 1
+	}
+
+	// Atomically replace the destination with the complete snapshot
+	if err := os.Rename(file.Name(), *export); err != nil {
+		os.Remove(file.Name())
+		fmt.Println(goat.App, ": cannot rename snapshot file:", err)
+		return 1
+	}
+
+	fmt.Println(goat.App, ": exported snapshot from", data.DBName(), "to", *export)
+	return 0
+}
+
+// runImport restores a snapshot of user and swarm state from a file, returning an exit code
+func runImport() int {
+	if !loadConfig() {
+		return 1
+	}
+
+	// Open snapshot file
+	file, err := os.Open(*importFile)
+	if err != nil {
+		fmt.Println(goat.App, ": cannot open snapshot file:", err)
+		return 1
+	}
+	defer file.Close()
+
+	// Restore snapshot
+	if err := data.ImportSnapshot(file, *importForce); err != nil {
+		fmt.Println(goat.App, ": import failed:", err)
+		if err == data.ErrSnapshotNotEmpty {
+			fmt.Println(goat.App, ": use -importforce to replace existing state")
+		}
+		return 1
+	}
+
+	fmt.Println(goat.App, ": imported snapshot from", *importFile, "to", data.DBName())
+	return 0
+}
+
+// runReplay replays a file of captured announces against a tracker, returning an exit code
+func runReplay() int {
+	// Open capture file
+	file, err := os.Open(*replay)
+	if err != nil {
+		fmt.Println(goat.App, ": cannot open capture file:", err)
+		return 1
+	}
+
+	// Parse captured announces
+	entries, err := goat.ReadCapture(file)
+	if err != nil {
+		fmt.Println(goat.App, ": cannot parse capture file:", err)
+		return 1
+	}
+	if err := file.Close(); err != nil {
+		fmt.Println(goat.App, ": cannot close capture file:", err)
+		return 1
+	}
+
+	// Replay announces
+	count, err := goat.ReplayCapture(entries, *replayTarget, *replayPasskey, nil)
+	if err != nil {
+		fmt.Println(goat.App, ": replay failed:", err)
+		return 1
+	}
+
+	fmt.Println(goat.App, ": replayed", count, "announce(s) against", *replayTarget)
+	return 0
+}
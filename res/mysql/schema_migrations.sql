@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	`version` int(11) NOT NULL
+	, `description` varchar(255) NOT NULL
+	, `time` int(11) NOT NULL
+	, PRIMARY KEY (`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin